@@ -2,33 +2,139 @@ package config
 
 import (
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Server   ServerConfig   `yaml:"server"`
-	Auth     AuthConfig     `yaml:"auth"`
-	Lark     LarkConfig     `yaml:"lark"`
-	Database DatabaseConfig `yaml:"database"`
-	Log      LogConfig      `yaml:"log"`
+	Server    ServerConfig    `yaml:"server"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Lark      LarkConfig      `yaml:"lark"`
+	UserCache UserCacheConfig `yaml:"user_cache"`
+	Contact   ContactConfig   `yaml:"contact"`
+	Jobs      JobsConfig      `yaml:"jobs"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Log       LogConfig       `yaml:"log"`
 }
 
 type AuthConfig struct {
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
-	Secret   string `yaml:"secret"`
+	Username             string              `yaml:"username"` // initial admin, created when there are no admin users
+	Password             string              `yaml:"password"`
+	Secret               string              `yaml:"secret"`                 // signs login tokens
+	PreviousSecrets      []string            `yaml:"previous_secrets"`       // old secrets still accepted while rotating
+	AccessTTL            time.Duration       `yaml:"access_ttl"`             // lifetime of access tokens
+	RefreshTTL           time.Duration       `yaml:"refresh_ttl"`            // idle lifetime of a session
+	StreamTTL            time.Duration       `yaml:"stream_ttl"`             // lifetime of ?token= stream tokens
+	DisablePasswordLogin bool                `yaml:"disable_password_login"` // only allow Lark SSO
+	LarkSSO              LarkSSOConfig       `yaml:"lark_sso"`
+	LoginThrottle        LoginThrottleConfig `yaml:"login_throttle"`
+	AllowedCIDRs         []string            `yaml:"allowed_cidrs"` // client networks allowed to call /api; empty = all
+	TOTP                 TOTPConfig          `yaml:"totp"`
+}
+
+// LoginThrottleConfig locks out usernames and IPs after repeated failed logins.
+// A limit of 0 disables that check.
+type LoginThrottleConfig struct {
+	MaxAttemptsPerUser int           `yaml:"max_attempts_per_user"`
+	MaxAttemptsPerIP   int           `yaml:"max_attempts_per_ip"`
+	Window             time.Duration `yaml:"window"`  // failures older than this are forgotten
+	Lockout            time.Duration `yaml:"lockout"` // how long a username or IP stays locked
+}
+
+// TOTPConfig configures the optional authenticator-app second factor.
+type TOTPConfig struct {
+	Issuer string `yaml:"issuer"` // shown in the authenticator app
+}
+
+// LarkSSOConfig enables logging in to the admin console with Lark OAuth.
+type LarkSSOConfig struct {
+	Enabled            bool     `yaml:"enabled"`
+	RedirectURL        string   `yaml:"redirect_url"`        // public URL of /api/auth/lark/callback
+	AllowedUsers       []string `yaml:"allowed_users"`       // open_ids allowed to log in
+	AllowedDepartments []string `yaml:"allowed_departments"` // open_department_ids whose direct members may log in
+	DefaultRole        string   `yaml:"default_role"`        // role of accounts created on first SSO login
 }
 
 type ServerConfig struct {
-	Port int    `yaml:"port"`
-	Mode string `yaml:"mode"` // "debug" or "release"
+	Port            int                   `yaml:"port"`
+	Mode            string                `yaml:"mode"`            // "debug" or "release"
+	TrustedProxies  []string              `yaml:"trusted_proxies"` // proxies whose X-Forwarded-For is believed
+	CORS            CORSConfig            `yaml:"cors"`
+	SecurityHeaders SecurityHeadersConfig `yaml:"security_headers"`
+	TLS             TLSConfig             `yaml:"tls"`
+}
+
+// CORSConfig controls which other origins may call the API from a browser.
+// With no allowed origins only the embedded frontend (same origin) can.
+type CORSConfig struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins"` // e.g. "https://ops.example.com"; "*" allows any origin
+	AllowedMethods   []string      `yaml:"allowed_methods"`
+	AllowedHeaders   []string      `yaml:"allowed_headers"`
+	AllowCredentials bool          `yaml:"allow_credentials"` // not allowed together with "*"
+	MaxAge           time.Duration `yaml:"max_age"`           // how long browsers cache a preflight
+}
+
+// SecurityHeadersConfig tunes the security headers sent with every response.
+type SecurityHeadersConfig struct {
+	CSP        string        `yaml:"csp"`          // Content-Security-Policy of the frontend; empty = built-in policy
+	HSTSMaxAge time.Duration `yaml:"hsts_max_age"` // sent only when TLS is on; 0 disables HSTS
+}
+
+// TLSConfig enables HTTPS, with a certificate from files or from an ACME CA.
+type TLSConfig struct {
+	CertFile     string     `yaml:"cert_file"`
+	KeyFile      string     `yaml:"key_file"`
+	ACME         ACMEConfig `yaml:"acme"`
+	RedirectPort int        `yaml:"redirect_port"` // plain HTTP port redirecting to HTTPS (and answering ACME challenges); 0 = off
+}
+
+// ACMEConfig obtains and renews certificates automatically, from Let's
+// Encrypt or any ACME server such as a local step-ca or Pebble.
+type ACMEConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	Domains      []string `yaml:"domains"`       // host names to request certificates for
+	Email        string   `yaml:"email"`         // contact for the CA
+	CacheDir     string   `yaml:"cache_dir"`     // where account keys and certificates are kept
+	DirectoryURL string   `yaml:"directory_url"` // empty = Let's Encrypt
+}
+
+// Enabled reports whether the server listens with HTTPS.
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" || t.ACME.Enabled
 }
 
 type LarkConfig struct {
-	AppID     string `yaml:"app_id"`
-	AppSecret string `yaml:"app_secret"`
-	BaseURL   string `yaml:"base_url"`
+	AppID     string          `yaml:"app_id"`
+	AppSecret string          `yaml:"app_secret"`
+	BaseURL   string          `yaml:"base_url"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+}
+
+// RateLimitConfig throttles outbound Lark API calls.
+type RateLimitConfig struct {
+	AppQPS     float64 `yaml:"app_qps"`     // sends per second across the whole app
+	ChatQPS    float64 `yaml:"chat_qps"`    // sends per second to a single chat or user
+	MaxRetries int     `yaml:"max_retries"` // retries for rate-limited responses, at most 10
+}
+
+// UserCacheConfig bounds the in-memory cache of user names and profiles.
+type UserCacheConfig struct {
+	Size int           `yaml:"size"` // maximum number of users kept; 0 disables the cache
+	TTL  time.Duration `yaml:"ttl"`  // how long a cached user is used before it is read again
+}
+
+// ContactConfig controls how Lark contact changes are applied.
+type ContactConfig struct {
+	// DisableTargetsOnLeave disables the scheduled tasks and auto-reply rules
+	// aimed at users who leave or are suspended
+	DisableTargetsOnLeave bool `yaml:"disable_targets_on_leave"`
+}
+
+// JobsConfig controls background jobs such as syncs and exports.
+type JobsConfig struct {
+	FileDir   string        `yaml:"file_dir"`  // where files produced by export jobs are kept
+	Retention time.Duration `yaml:"retention"` // finished jobs and their files are deleted after this long; 0 keeps them
 }
 
 type DatabaseConfig struct {
@@ -49,15 +155,58 @@ func Load(path string) (*Config, error) {
 		Server: ServerConfig{
 			Port: 8080,
 			Mode: "debug",
+			CORS: CORSConfig{
+				AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+				AllowedHeaders: []string{"Content-Type", "Authorization", "X-API-Key", "Last-Event-ID"},
+				MaxAge:         24 * time.Hour,
+			},
+			SecurityHeaders: SecurityHeadersConfig{
+				HSTSMaxAge: 180 * 24 * time.Hour,
+			},
+			TLS: TLSConfig{
+				ACME: ACMEConfig{
+					CacheDir: "./data/acme",
+				},
+			},
 		},
 		Database: DatabaseConfig{
 			Path: "./data/lark-robot.db",
 		},
+		UserCache: UserCacheConfig{
+			Size: 10000,
+			TTL:  30 * time.Minute,
+		},
+		Jobs: JobsConfig{
+			FileDir:   "./data/jobs",
+			Retention: 7 * 24 * time.Hour,
+		},
 		Log: LogConfig{
 			Level: "info",
 		},
+		Auth: AuthConfig{
+			AccessTTL:  15 * time.Minute,
+			RefreshTTL: 7 * 24 * time.Hour,
+			StreamTTL:  2 * time.Minute,
+			LarkSSO: LarkSSOConfig{
+				DefaultRole: "viewer",
+			},
+			LoginThrottle: LoginThrottleConfig{
+				MaxAttemptsPerUser: 5,
+				MaxAttemptsPerIP:   20,
+				Window:             15 * time.Minute,
+				Lockout:            15 * time.Minute,
+			},
+			TOTP: TOTPConfig{
+				Issuer: "Lark Robot",
+			},
+		},
 		Lark: LarkConfig{
 			BaseURL: "https://open.feishu.cn",
+			RateLimit: RateLimitConfig{
+				AppQPS:     50,
+				ChatQPS:    5,
+				MaxRetries: 3,
+			},
 		},
 	}
 	if err := yaml.Unmarshal(data, cfg); err != nil {
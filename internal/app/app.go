@@ -2,7 +2,6 @@ package app
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
@@ -11,6 +10,7 @@ import (
 
 	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
 	"github.com/larksuite/oapi-sdk-go/v3/event/dispatcher"
+	larkcontact "github.com/larksuite/oapi-sdk-go/v3/service/contact/v3"
 	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
 	larkws "github.com/larksuite/oapi-sdk-go/v3/ws"
 	"go.uber.org/zap"
@@ -21,6 +21,7 @@ import (
 	"lark-robot/internal/database"
 	"lark-robot/internal/handler"
 	"lark-robot/internal/larkbot"
+	msgcontent "lark-robot/internal/larkbot/content"
 	"lark-robot/internal/repository"
 	"lark-robot/internal/scheduler"
 	"lark-robot/internal/server"
@@ -38,13 +39,21 @@ type App struct {
 	sched          *scheduler.Scheduler
 	router         *server.Router
 	httpServer     *http.Server
-
-	Broadcaster      *broadcast.MessageBroadcaster
-	messageService   *service.MessageService
-	replyService     *service.ReplyService
-	chatService      *service.ChatService
-	schedulerService *service.SchedulerService
-	userService      *service.UserService
+	tls            *tlsSetup
+	redirectServer *http.Server
+
+	Bus               *broadcast.Bus
+	messageService    *service.MessageService
+	replyService      *service.ReplyService
+	chatService       *service.ChatService
+	schedulerService  *service.SchedulerService
+	userService       *service.UserService
+	departmentService *service.DepartmentService
+	campaignService   *service.CampaignService
+	activityService   *service.ActivityService
+	importService     *service.DirectoryImportService
+	jobService        *service.JobService
+	sessionService    *service.SessionService
 }
 
 func New(cfg *config.Config) (*App, error) {
@@ -66,30 +75,86 @@ func New(cfg *config.Config) (*App, error) {
 	logRepo := repository.NewMessageLogRepo(db)
 	groupRepo := repository.NewGroupRepo(db)
 	userRepo := repository.NewUserRepo(db)
+	departmentRepo := repository.NewDepartmentRepo(db)
+	activityRepo := repository.NewActivityRepo(db)
+	importRepo := repository.NewDirectoryImportRepo(db)
+	jobRepo := repository.NewJobRepo(db)
+	campaignRepo := repository.NewCampaignRepo(db)
+	adminUserRepo := repository.NewAdminUserRepo(db)
+	sessionRepo := repository.NewSessionRepo(db)
+	apiKeyRepo := repository.NewAPIKeyRepo(db)
+	auditRepo := repository.NewAuditRepo(db)
 
 	// 4. Create Lark client and fetch bot info
-	larkClient := larkbot.NewLarkClient(cfg.Lark.AppID, cfg.Lark.AppSecret, cfg.Lark.BaseURL)
+	larkClient := larkbot.NewLarkClient(cfg.Lark.AppID, cfg.Lark.AppSecret, cfg.Lark.BaseURL, larkbot.RateLimitConfig{
+		AppQPS:     cfg.Lark.RateLimit.AppQPS,
+		ChatQPS:    cfg.Lark.RateLimit.ChatQPS,
+		MaxRetries: cfg.Lark.RateLimit.MaxRetries,
+	})
 	if err := larkClient.FetchBotInfo(context.Background()); err != nil {
 		logger.Warn("failed to fetch bot info", zap.Error(err))
 	} else {
 		logger.Info("bot info loaded", zap.String("open_id", larkClient.BotOpenID), zap.String("name", larkClient.BotName))
 	}
 
-	// 5. Create services
-	msgService := service.NewMessageService(larkClient, logRepo, logger)
-	userService := service.NewUserService(larkClient, userRepo, logger)
+	// 5. Create event bus for SSE and internal consumers, then services
+	bus := broadcast.NewBus(broadcast.DefaultHistorySize)
+	msgService := service.NewMessageService(larkClient, logRepo, bus, logger)
+	departmentService := service.NewDepartmentService(larkClient, departmentRepo, logger)
+	userService := service.NewUserService(larkClient, userRepo, departmentService, bus, service.UserCacheConfig{
+		Size: cfg.UserCache.Size,
+		TTL:  cfg.UserCache.TTL,
+	}, logger)
 
 	// 6. Build handler chain
 	keywordHandler := handler.NewKeywordHandler(nil)
 	handlerChain := handler.NewHandlerChain(logger, keywordHandler, handler.NewDefaultHandler())
 
 	// 7. Create services
-	replyService := service.NewReplyService(ruleRepo, keywordHandler, logger)
-	chatService := service.NewChatService(larkClient, groupRepo, logger)
+	replyService := service.NewReplyService(ruleRepo, groupRepo, keywordHandler, logger)
+	activityService := service.NewActivityService(activityRepo, logger)
+	jobService := service.NewJobService(jobRepo, bus, service.JobConfig{
+		FileDir:   cfg.Jobs.FileDir,
+		Retention: cfg.Jobs.Retention,
+	}, logger)
+	importService := service.NewDirectoryImportService(larkClient, importRepo, userRepo, userService, departmentService, jobService, bus, logger)
+	chatService := service.NewChatService(larkClient, groupRepo, bus, logger)
+	campaignService := service.NewCampaignService(campaignRepo, groupRepo, departmentRepo, msgService, jobService, logger)
+	adminUserService := service.NewAdminUserService(adminUserRepo, logger)
+	sessionService := service.NewSessionService(sessionRepo, service.TokenConfig{
+		Secret:          cfg.Auth.Secret,
+		PreviousSecrets: cfg.Auth.PreviousSecrets,
+		AccessTTL:       cfg.Auth.AccessTTL,
+		RefreshTTL:      cfg.Auth.RefreshTTL,
+		StreamTTL:       cfg.Auth.StreamTTL,
+	}, logger)
+	apiKeyService := service.NewAPIKeyService(apiKeyRepo, logger)
+	auditService := service.NewAuditService(auditRepo, logger)
+
+	if err := adminUserService.Bootstrap(cfg.Auth.Username, cfg.Auth.Password); err != nil {
+		return nil, fmt.Errorf("bootstrap admin user: %w", err)
+	}
+	if cfg.Auth.DisablePasswordLogin && !cfg.Auth.LarkSSO.Enabled {
+		logger.Warn("password login is disabled but lark sso is not enabled; nobody can log in")
+	}
+	var ssoService *service.SSOService
+	if cfg.Auth.LarkSSO.Enabled {
+		ssoService = service.NewSSOService(larkClient, adminUserRepo, service.LarkSSOPolicy{
+			AllowedUsers:       cfg.Auth.LarkSSO.AllowedUsers,
+			AllowedDepartments: cfg.Auth.LarkSSO.AllowedDepartments,
+			DefaultRole:        cfg.Auth.LarkSSO.DefaultRole,
+		}, logger)
+	}
 
 	if err := replyService.ReloadRules(); err != nil {
 		logger.Warn("failed to load auto-reply rules", zap.Error(err))
 	}
+	// Rules with a group selector follow the groups it picks
+	bus.Listen("auto-reply rules", broadcast.Filter{Topics: []string{broadcast.TopicGroupChange}}, func(broadcast.Event) {
+		if err := replyService.ReloadRules(); err != nil {
+			logger.Error("failed to reload auto-reply rules", zap.Error(err))
+		}
+	})
 
 	// 8. Create scheduler
 	scheduledSendFunc := func(ctx context.Context, chatID, msgType, content, source string) (string, error) {
@@ -101,10 +166,24 @@ func New(cfg *config.Config) (*App, error) {
 		taskRepo.UpdateNextRunAt,
 		logger,
 	)
+	sched.SetRunHook(func(taskID uint, chatID, source, messageID string, err error) {
+		event := broadcast.TaskRunEvent{
+			TaskID:    taskID,
+			ChatID:    chatID,
+			Source:    source,
+			Success:   err == nil,
+			MessageID: messageID,
+			RanAt:     time.Now(),
+		}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		bus.Publish(broadcast.TopicTaskRun, chatID, event)
+	})
+	sched.SetResolver(chatService.ResolveSelector)
 	schedulerService := service.NewSchedulerService(taskRepo, sched, logger)
-
-	// 9. Create message broadcaster for SSE
-	broadcaster := broadcast.NewMessageBroadcaster()
+	contactService := service.NewContactService(userService, userRepo, logRepo, schedulerService, replyService, bus,
+		cfg.Contact.DisableTargetsOnLeave, logger)
 
 	// 10. Set up Lark event dispatcher (WebSocket long connection)
 	eventDispatcher := dispatcher.NewEventDispatcher("", "").
@@ -133,11 +212,12 @@ func New(cfg *config.Config) (*App, error) {
 			logger.Info("received message",
 				zap.String("chat_id", msg.ChatID),
 				zap.String("sender", senderName),
-				zap.String("text", msg.TextContent),
+				zap.String("msg_type", msg.MsgType),
+				zap.String("text", msg.Payload.Summary()),
 			)
 
 			// Broadcast incoming message to SSE subscribers
-			broadcaster.Publish(broadcast.MessageEvent{
+			bus.PublishMessage(broadcast.MessageEvent{
 				ID:         msg.MessageID,
 				ChatID:     msg.ChatID,
 				ChatType:   msg.ChatType,
@@ -146,6 +226,7 @@ func New(cfg *config.Config) (*App, error) {
 				Direction:  "in",
 				MsgType:    msg.MsgType,
 				Content:    msg.Content,
+				Mentions:   msgcontent.MarshalMentions(msg.Mentions),
 				CreatedAt:  time.Now(),
 			})
 
@@ -156,12 +237,12 @@ func New(cfg *config.Config) (*App, error) {
 			}
 
 			if result.Handled && result.Reply != nil {
-				_, sendErr := larkClient.ReplyMessage(ctx, msg.MessageID, result.Reply.MsgType, result.Reply.Content)
+				_, sendErr := larkClient.ReplyMessage(ctx, msg.MessageID, result.Reply.MsgType, result.Reply.Content, result.Reply.InThread)
 				if sendErr != nil {
 					logger.Error("failed to send reply", zap.Error(sendErr))
 				}
 				// Broadcast auto-reply to SSE subscribers
-				broadcaster.Publish(broadcast.MessageEvent{
+				bus.PublishMessage(broadcast.MessageEvent{
 					ChatID:    msg.ChatID,
 					ChatType:  msg.ChatType,
 					Direction: "out",
@@ -193,13 +274,39 @@ func New(cfg *config.Config) (*App, error) {
 			_ = logRepo.RecallByMessageID(messageID)
 
 			// Broadcast recall event to SSE subscribers
-			broadcaster.Publish(broadcast.MessageEvent{
+			bus.PublishMessage(broadcast.MessageEvent{
 				ChatID:    chatID,
 				Recalled:  true,
 				MessageID: messageID,
 				CreatedAt: time.Now(),
 			})
 
+			return nil
+		}).
+		// Contact changes refresh or retire known users, so renamed or
+		// departed users are not shown with stale info
+		OnP2UserUpdatedV3(func(ctx context.Context, event *larkcontact.P2UserUpdatedV3) error {
+			if event.Event == nil || event.Event.Object == nil || event.Event.Object.OpenId == nil {
+				return nil
+			}
+			openID := *event.Event.Object.OpenId
+			go func() {
+				if err := contactService.OnUserUpdated(context.Background(), openID); err != nil {
+					logger.Warn("failed to apply user update", zap.String("open_id", openID), zap.Error(err))
+				}
+			}()
+			return nil
+		}).
+		OnP2UserDeletedV3(func(ctx context.Context, event *larkcontact.P2UserDeletedV3) error {
+			if event.Event == nil || event.Event.Object == nil || event.Event.Object.OpenId == nil {
+				return nil
+			}
+			openID := *event.Event.Object.OpenId
+			go func() {
+				if err := contactService.OnUserDeleted(context.Background(), openID); err != nil {
+					logger.Warn("failed to apply user deletion", zap.String("open_id", openID), zap.Error(err))
+				}
+			}()
 			return nil
 		})
 
@@ -213,55 +320,153 @@ func New(cfg *config.Config) (*App, error) {
 	distFS := static.DistFS()
 	frontendFS := server.TryLoadFrontendFS(distFS)
 
+	allowedNets, err := server.ParseCIDRs(cfg.Auth.AllowedCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("auth.allowed_cidrs: %w", err)
+	}
+	if _, err := server.ParseCIDRs(cfg.Server.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("server.trusted_proxies: %w", err)
+	}
+	corsConfig := server.CORSConfig{
+		AllowedOrigins:   cfg.Server.CORS.AllowedOrigins,
+		AllowedMethods:   cfg.Server.CORS.AllowedMethods,
+		AllowedHeaders:   cfg.Server.CORS.AllowedHeaders,
+		AllowCredentials: cfg.Server.CORS.AllowCredentials,
+		MaxAge:           cfg.Server.CORS.MaxAge,
+	}
+	if err := corsConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("server.cors: %w", err)
+	}
+	tlsSetup, err := newTLSSetup(cfg.Server.TLS, cfg.Server.Port)
+	if err != nil {
+		return nil, fmt.Errorf("server.tls: %w", err)
+	}
+
 	router := server.NewRouter(server.RouterConfig{
 		Mode:             cfg.Server.Mode,
 		Logger:           logger,
-		AuthUsername:      cfg.Auth.Username,
-		AuthPassword:      cfg.Auth.Password,
-		AuthSecret:        cfg.Auth.Secret,
-		LarkClient:       larkClient,
-		ChatService:      chatService,
-		MessageService:   msgService,
-		SchedulerService: schedulerService,
-		ReplyService:     replyService,
-		UserService:      userService,
-		Broadcaster:      broadcaster,
-		FrontendFS:       frontendFS,
-		EmbeddedFS:       distFS,
+		SessionService:   sessionService,
+		APIKeyService:    apiKeyService,
+		AuditService:     auditService,
+		AdminUserService: adminUserService,
+		SSOService:       ssoService,
+		SSORedirectURL:   cfg.Auth.LarkSSO.RedirectURL,
+		PasswordLogin:    !cfg.Auth.DisablePasswordLogin,
+		TOTPIssuer:       cfg.Auth.TOTP.Issuer,
+		LoginThrottle: server.LoginThrottleConfig{
+			MaxAttemptsPerUser: cfg.Auth.LoginThrottle.MaxAttemptsPerUser,
+			MaxAttemptsPerIP:   cfg.Auth.LoginThrottle.MaxAttemptsPerIP,
+			Window:             cfg.Auth.LoginThrottle.Window,
+			Lockout:            cfg.Auth.LoginThrottle.Lockout,
+		},
+		AllowedNets:    allowedNets,
+		TrustedProxies: cfg.Server.TrustedProxies,
+		CORS:           corsConfig,
+		SecurityHeaders: server.SecurityHeadersConfig{
+			CSP:        cfg.Server.SecurityHeaders.CSP,
+			HSTSMaxAge: cfg.Server.SecurityHeaders.HSTSMaxAge,
+			TLS:        tlsSetup != nil,
+		},
+		LarkClient:             larkClient,
+		ChatService:            chatService,
+		MessageService:         msgService,
+		SchedulerService:       schedulerService,
+		ReplyService:           replyService,
+		UserService:            userService,
+		DepartmentService:      departmentService,
+		ActivityService:        activityService,
+		DirectoryImportService: importService,
+		JobService:             jobService,
+		CampaignService:        campaignService,
+		Bus:                    bus,
+		FrontendFS:             frontendFS,
+		EmbeddedFS:             distFS,
 	})
 
 	return &App{
-		config:           cfg,
-		logger:           logger,
-		larkClient:       larkClient,
-		wsClient:         wsClient,
-		handlerChain:     handlerChain,
-		keywordHandler:   keywordHandler,
-		sched:            sched,
-		Broadcaster:      broadcaster,
-		router:           router,
-		messageService:   msgService,
-		replyService:     replyService,
-		chatService:      chatService,
-		schedulerService: schedulerService,
-		userService:      userService,
+		config:            cfg,
+		logger:            logger,
+		larkClient:        larkClient,
+		wsClient:          wsClient,
+		handlerChain:      handlerChain,
+		keywordHandler:    keywordHandler,
+		sched:             sched,
+		Bus:               bus,
+		router:            router,
+		tls:               tlsSetup,
+		messageService:    msgService,
+		replyService:      replyService,
+		chatService:       chatService,
+		schedulerService:  schedulerService,
+		userService:       userService,
+		departmentService: departmentService,
+		activityService:   activityService,
+		importService:     importService,
+		jobService:        jobService,
+		campaignService:   campaignService,
+		sessionService:    sessionService,
 	}, nil
 }
 
+// aggregateActivity rolls up new message logs into user activity statistics.
+func (a *App) aggregateActivity() {
+	if _, err := a.activityService.Aggregate(); err != nil {
+		a.logger.Warn("user activity rollup failed", zap.Error(err))
+	}
+}
+
 func (a *App) Start() error {
+	// Jobs still marked running were cut off by the last shutdown; campaigns
+	// and imports below resume as new jobs
+	if err := a.jobService.FailInterrupted(); err != nil {
+		a.logger.Warn("failed to mark interrupted jobs", zap.Error(err))
+	}
+
 	// Load and start scheduled tasks
 	if err := a.schedulerService.LoadAndStartAll(); err != nil {
 		a.logger.Warn("failed to load scheduled tasks", zap.Error(err))
 	}
 	a.sched.Start()
 
-	// Register daily cleanup: delete group chat logs older than 7 days (runs at 02:00 every day)
+	// Register daily cleanup: delete group chat logs older than 7 days, expired sessions and old jobs (runs at 02:00 every day)
 	if err := a.sched.AddCleanupJob("0 0 2 * * *", func() {
+		// Roll up activity first, so cleaned-up logs are already counted
+		a.aggregateActivity()
 		a.messageService.CleanupGroupLogs(7)
+		a.sessionService.Cleanup()
+		a.jobService.Cleanup()
 	}); err != nil {
 		a.logger.Error("failed to register cleanup job", zap.Error(err))
 	}
 
+	// Refresh the org chart every night; it can also be synced from the console
+	if err := a.sched.AddCleanupJob("0 30 2 * * *", func() {
+		if _, err := a.departmentService.Sync(context.Background()); err != nil {
+			a.logger.Warn("department sync failed", zap.Error(err))
+		}
+	}); err != nil {
+		a.logger.Error("failed to register department sync job", zap.Error(err))
+	}
+
+	// Give logs stored before message summaries were kept a preview
+	go a.messageService.BackfillSummaries()
+
+	// Roll message logs up into user activity statistics, catching up on
+	// logs written while the app was down
+	go a.aggregateActivity()
+	a.sched.AddPeriodicJob(time.Minute, a.aggregateActivity)
+
+	// Resume campaigns interrupted by a restart, and start scheduled ones when due
+	if err := a.campaignService.ResumeInterrupted(); err != nil {
+		a.logger.Warn("failed to resume campaigns", zap.Error(err))
+	}
+	a.sched.AddPeriodicJob(30*time.Second, a.campaignService.DispatchDue)
+
+	// Continue a directory import interrupted by a restart
+	if err := a.importService.ResumeInterrupted(); err != nil {
+		a.logger.Warn("failed to resume directory import", zap.Error(err))
+	}
+
 	// Start Lark WebSocket long connection in background
 	go func() {
 		a.logger.Info("starting lark websocket connection")
@@ -277,9 +482,30 @@ func (a *App) Start() error {
 		Handler: a.router.Engine,
 	}
 
+	if a.tls != nil {
+		a.httpServer.TLSConfig = a.tls.config
+		if a.tls.redirect != nil {
+			redirectAddr := fmt.Sprintf(":%d", a.config.Server.TLS.RedirectPort)
+			a.redirectServer = &http.Server{Addr: redirectAddr, Handler: a.tls.redirect}
+			go func() {
+				a.logger.Info("http to https redirect started", zap.String("addr", redirectAddr))
+				if err := a.redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					a.logger.Fatal("redirect server error", zap.Error(err))
+				}
+			}()
+		}
+	}
+
 	go func() {
-		a.logger.Info("admin dashboard started", zap.String("addr", addr))
-		if err := a.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		a.logger.Info("admin dashboard started", zap.String("addr", addr), zap.Bool("tls", a.tls != nil))
+		var err error
+		if a.tls != nil {
+			// Certificates come from TLSConfig
+			err = a.httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = a.httpServer.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			a.logger.Fatal("server error", zap.Error(err))
 		}
 	}()
@@ -289,6 +515,13 @@ func (a *App) Start() error {
 
 func (a *App) Shutdown(ctx context.Context) error {
 	a.sched.Stop()
+	// Stopping the jobs first records them as interrupted rather than cancelled
+	a.jobService.Stop()
+	a.campaignService.Stop()
+	a.importService.Stop()
+	if a.redirectServer != nil {
+		a.redirectServer.Shutdown(ctx)
+	}
 	if a.httpServer != nil {
 		if err := a.httpServer.Shutdown(ctx); err != nil {
 			return fmt.Errorf("http server shutdown: %w", err)
@@ -307,9 +540,18 @@ func parseIncomingMessage(event *larkim.P2MessageReceiveV1, botOpenID string) *h
 
 	content := deref(msg.Content)
 
-	// Replace @mention placeholders with real names in both content and text
-	content = replaceMentions(content, msg.Mentions)
-	textContent := extractText(content)
+	// Content keeps Lark's @_user_N placeholders; the text gets @[Name] in their place
+	mentions := parseMentions(content, msg.Mentions, botOpenID)
+	payload := msgcontent.Parse(deref(msg.MessageType), content)
+	payload.Text = msgcontent.ResolveMentions(payload.Text, mentions)
+	payload.Title = msgcontent.ResolveMentions(payload.Title, mentions)
+
+	mentionBot := false
+	for _, m := range mentions {
+		if m.IsBot {
+			mentionBot = true
+		}
+	}
 
 	return &handler.IncomingMessage{
 		MessageID:   deref(msg.MessageId),
@@ -318,81 +560,38 @@ func parseIncomingMessage(event *larkim.P2MessageReceiveV1, botOpenID string) *h
 		SenderID:    senderID,
 		MsgType:     deref(msg.MessageType),
 		Content:     content,
-		TextContent: textContent,
-		MentionBot:  containsBotMention(msg.Mentions, botOpenID),
+		TextContent: payload.Text,
+		Payload:     payload,
+		Mentions:    mentions,
+		MentionBot:  mentionBot,
+		RootID:      deref(msg.RootId),
+		ParentID:    deref(msg.ParentId),
+		ThreadID:    deref(msg.ThreadId),
 	}
 }
 
-func extractText(content string) string {
-	var m map[string]interface{}
-	if err := json.Unmarshal([]byte(content), &m); err != nil {
-		return content
-	}
-	// Plain text message: {"text":"hello"}
-	if text, ok := m["text"].(string); ok {
-		return strings.TrimSpace(text)
-	}
-	// Rich text (post) message: {"title":"","content":[[{"tag":"text","text":"hello"},...]]}
-	if contentArr, ok := m["content"].([]interface{}); ok {
-		return extractPostText(contentArr)
-	}
-	return ""
-}
-
-// extractPostText extracts plain text from a post message's content array.
-func extractPostText(content []interface{}) string {
-	var sb strings.Builder
-	for _, line := range content {
-		lineArr, ok := line.([]interface{})
-		if !ok {
-			continue
-		}
-		for _, elem := range lineArr {
-			elemMap, ok := elem.(map[string]interface{})
-			if !ok {
-				continue
-			}
-			tag, _ := elemMap["tag"].(string)
-			switch tag {
-			case "text":
-				if text, ok := elemMap["text"].(string); ok {
-					sb.WriteString(text)
-				}
-			case "at":
-				if name, ok := elemMap["user_name"].(string); ok && name != "" {
-					sb.WriteString("@[" + name + "]")
-				}
-			}
-		}
-	}
-	return strings.TrimSpace(sb.String())
-}
-
-// replaceMentions replaces @_user_1 placeholders with actual names from the mentions list.
-// Names are wrapped as @[Name] so the frontend can identify the full mention boundary.
-func replaceMentions(text string, mentions []*larkim.MentionEvent) string {
+// parseMentions converts the event's mention list into structured mentions.
+// Lark does not list @all in mentions, so it is detected from the content.
+func parseMentions(content string, mentions []*larkim.MentionEvent, botOpenID string) []msgcontent.Mention {
+	var result []msgcontent.Mention
 	for _, m := range mentions {
-		if m.Key != nil && m.Name != nil {
-			text = strings.ReplaceAll(text, *m.Key, "@["+*m.Name+"]")
+		mention := msgcontent.Mention{Key: deref(m.Key), Name: deref(m.Name)}
+		if m.Id != nil {
+			mention.OpenID = deref(m.Id.OpenId)
 		}
+		mention.IsBot = botOpenID != "" && mention.OpenID == botOpenID
+		mention.IsAll = mention.Key == msgcontent.AllKey
+		result = append(result, mention)
 	}
-	// Fallback: replace @_all if not already handled by mentions list
-	if strings.Contains(text, "@_all") {
-		text = strings.ReplaceAll(text, "@_all", "@[所有人]")
-	}
-	return text
-}
-
-func containsBotMention(mentions []*larkim.MentionEvent, botOpenID string) bool {
-	if botOpenID == "" {
-		return false
-	}
-	for _, m := range mentions {
-		if m.Id != nil && m.Id.OpenId != nil && *m.Id.OpenId == botOpenID {
-			return true
+	if strings.Contains(content, msgcontent.AllKey) {
+		for _, m := range result {
+			if m.IsAll {
+				return result
+			}
 		}
+		result = append(result, msgcontent.Mention{Key: msgcontent.AllKey, Name: "所有人", IsAll: true})
 	}
-	return false
+	return result
 }
 
 func deref(s *string) string {
@@ -0,0 +1,82 @@
+package app
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"lark-robot/config"
+)
+
+// tlsSetup is the HTTPS side of the admin server: its certificates and the
+// plain HTTP handler for the redirect port.
+type tlsSetup struct {
+	config   *tls.Config
+	redirect http.Handler // nil when there is no redirect port
+}
+
+// newTLSSetup loads the configured certificate, or prepares an ACME manager
+// that obtains one on the first handshake. It returns nil when TLS is off.
+func newTLSSetup(cfg config.TLSConfig, httpsPort int) (*tlsSetup, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	setup := &tlsSetup{}
+	var acmeHandler func(http.Handler) http.Handler
+	switch {
+	case cfg.ACME.Enabled && cfg.CertFile != "":
+		return nil, errors.New("use either cert_file/key_file or acme, not both")
+	case cfg.ACME.Enabled:
+		if len(cfg.ACME.Domains) == 0 {
+			return nil, errors.New("acme.domains is required")
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.ACME.Domains...),
+			Cache:      autocert.DirCache(cfg.ACME.CacheDir),
+			Email:      cfg.ACME.Email,
+		}
+		if cfg.ACME.DirectoryURL != "" {
+			m.Client = &acme.Client{DirectoryURL: cfg.ACME.DirectoryURL}
+		}
+		// Answers tls-alpn-01 challenges on the HTTPS port itself
+		setup.config = m.TLSConfig()
+		acmeHandler = m.HTTPHandler
+	default:
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load certificate: %w", err)
+		}
+		setup.config = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	setup.config.MinVersion = tls.VersionTLS12
+
+	if cfg.RedirectPort > 0 {
+		setup.redirect = httpsRedirect(httpsPort)
+		if acmeHandler != nil {
+			// Also answers http-01 challenges
+			setup.redirect = acmeHandler(setup.redirect)
+		}
+	}
+	return setup, nil
+}
+
+// httpsRedirect sends plain HTTP requests to the same URL on the HTTPS port.
+func httpsRedirect(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(httpsPort))
+		host = strings.TrimSuffix(host, ":443")
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,270 @@
+package broadcast
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultHistorySize is the number of recent events kept for replay.
+const DefaultHistorySize = 1000
+
+// Event is a single published event. IDs increase monotonically across all
+// topics. They are derived from the publish time (milliseconds × 1000), so they
+// keep increasing across restarts and can be mapped back to a point in time.
+type Event struct {
+	ID        uint64      `json:"id"`
+	Topic     string      `json:"topic"`
+	ChatID    string      `json:"chat_id,omitempty"` // empty for events not tied to a chat
+	Payload   interface{} `json:"payload"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// Filter selects which events a subscriber receives.
+type Filter struct {
+	Topics  []string // empty = all topics
+	ChatID  string   // empty = all chats
+	ChatIDs []string // if non-nil, only these chats (events without a chat are excluded)
+}
+
+// Match reports whether the filter selects e.
+func (f Filter) Match(e Event) bool {
+	if f.ChatID != "" && e.ChatID != f.ChatID {
+		return false
+	}
+	if f.ChatIDs != nil && !contains(f.ChatIDs, e.ChatID) {
+		return false
+	}
+	if len(f.Topics) == 0 {
+		return true
+	}
+	return contains(f.Topics, e.Topic)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription is a registered listener. Events that do not fit in its buffer
+// are dropped and counted rather than blocking the publisher.
+type Subscription struct {
+	C <-chan Event
+
+	ch      chan Event
+	filter  Filter
+	name    string
+	since   time.Time
+	dropped atomic.Uint64
+}
+
+// Dropped returns the number of events this subscriber missed because its buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// SubscriberStats describes one subscriber.
+type SubscriberStats struct {
+	Name    string    `json:"name"`
+	Topics  []string  `json:"topics,omitempty"`
+	ChatID  string    `json:"chat_id,omitempty"`
+	Pending int       `json:"pending"`
+	Dropped uint64    `json:"dropped"`
+	Since   time.Time `json:"since"`
+}
+
+// BusStats is a snapshot of the bus state.
+type BusStats struct {
+	LastID      uint64            `json:"last_id"`
+	OldestID    uint64            `json:"oldest_id"` // oldest event still available for replay
+	HistorySize int               `json:"history_size"`
+	Published   uint64            `json:"published"`
+	Dropped     uint64            `json:"dropped"`
+	Subscribers []SubscriberStats `json:"subscribers"`
+}
+
+// Bus fans out events to subscribers by topic and chat, and keeps a ring
+// buffer of recent events so reconnecting clients can catch up.
+type Bus struct {
+	mu        sync.RWMutex
+	startID   uint64 // events before this ID were published by an earlier process
+	lastID    uint64
+	evictedID uint64 // ID of the newest event dropped from history
+	published uint64
+	subs      map[*Subscription]struct{}
+	history   []Event // ring buffer
+	next      int     // next write position in history
+	full      bool
+	dropped   uint64
+}
+
+func NewBus(historySize int) *Bus {
+	if historySize <= 0 {
+		historySize = DefaultHistorySize
+	}
+	start := EventIDAt(time.Now())
+	return &Bus{
+		startID: start,
+		lastID:  start,
+		subs:    make(map[*Subscription]struct{}),
+		history: make([]Event, historySize),
+	}
+}
+
+// EventIDAt returns the smallest event ID that can be assigned at time t.
+func EventIDAt(t time.Time) uint64 {
+	return uint64(t.UnixMilli()) * 1000
+}
+
+// EventTime returns the approximate publish time of an event ID.
+func EventTime(id uint64) time.Time {
+	return time.UnixMilli(int64(id / 1000))
+}
+
+// Publish assigns the event an ID, records it in history and delivers it to
+// matching subscribers without blocking.
+func (b *Bus) Publish(topic, chatID string, payload interface{}) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	id := EventIDAt(now)
+	if id <= b.lastID {
+		id = b.lastID + 1
+	}
+	b.lastID = id
+	b.published++
+	event := Event{ID: id, Topic: topic, ChatID: chatID, Payload: payload, CreatedAt: now}
+
+	if b.full {
+		b.evictedID = b.history[b.next].ID
+	}
+	b.history[b.next] = event
+	b.next = (b.next + 1) % len(b.history)
+	if b.next == 0 {
+		b.full = true
+	}
+
+	for sub := range b.subs {
+		if !sub.filter.Match(event) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			sub.dropped.Add(1)
+			b.dropped++
+		}
+	}
+	return event
+}
+
+// Subscribe registers a listener. name identifies it in stats.
+func (b *Bus) Subscribe(name string, f Filter, buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = 32
+	}
+	ch := make(chan Event, buffer)
+	sub := &Subscription{C: ch, ch: ch, filter: f, name: name, since: time.Now()}
+
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Unsubscribe removes a listener and closes its channel.
+func (b *Bus) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Listen runs fn for every matching event on its own goroutine, for in-process
+// consumers. Calling the returned function stops it.
+func (b *Bus) Listen(name string, f Filter, fn func(Event)) (stop func()) {
+	sub := b.Subscribe(name, f, 256)
+	go func() {
+		for event := range sub.C {
+			fn(event)
+		}
+	}()
+	return func() { b.Unsubscribe(sub) }
+}
+
+// Replay returns the retained events after afterID that match f, oldest first.
+// complete is false when events after afterID are no longer available, either
+// evicted from history or published before this process started.
+func (b *Bus) Replay(afterID uint64, f Filter) (events []Event, complete bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	complete = afterID >= b.startID && afterID >= b.evictedID
+	for _, e := range b.ordered() {
+		if e.ID > afterID && f.Match(e) {
+			events = append(events, e)
+		}
+	}
+	return events, complete
+}
+
+// LastID returns the ID of the most recently published event.
+func (b *Bus) LastID() uint64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.lastID
+}
+
+// Stats returns a snapshot of the bus and its subscribers.
+func (b *Bus) Stats() BusStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	stats := BusStats{
+		LastID:      b.lastID,
+		OldestID:    b.oldestID(),
+		HistorySize: len(b.history),
+		Published:   b.published,
+		Dropped:     b.dropped,
+		Subscribers: make([]SubscriberStats, 0, len(b.subs)),
+	}
+	for sub := range b.subs {
+		stats.Subscribers = append(stats.Subscribers, SubscriberStats{
+			Name:    sub.name,
+			Topics:  sub.filter.Topics,
+			ChatID:  sub.filter.ChatID,
+			Pending: len(sub.ch),
+			Dropped: sub.Dropped(),
+			Since:   sub.since,
+		})
+	}
+	return stats
+}
+
+// oldestID returns the ID of the oldest retained event. Callers hold b.mu.
+func (b *Bus) oldestID() uint64 {
+	if !b.full {
+		if b.next == 0 {
+			return 0
+		}
+		return b.history[0].ID
+	}
+	return b.history[b.next].ID
+}
+
+// ordered returns retained events oldest first. Callers hold b.mu.
+func (b *Bus) ordered() []Event {
+	if !b.full {
+		return b.history[:b.next]
+	}
+	out := make([]Event, 0, len(b.history))
+	out = append(out, b.history[b.next:]...)
+	return append(out, b.history[:b.next]...)
+}
@@ -0,0 +1,117 @@
+package broadcast
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Event topics.
+const (
+	TopicMessage         = "message"          // message received or sent (MessageEvent)
+	TopicRecall          = "recall"           // message recalled (MessageEvent)
+	TopicEdit            = "edit"             // sent message edited (MessageEvent)
+	TopicHandled         = "handled"          // received message marked as handled by an operator (MessageEvent)
+	TopicTaskRun         = "task_run"         // scheduled task executed (TaskRunEvent)
+	TopicGroupChange     = "group_change"     // group joined, synced, left or its metadata updated (GroupChangeEvent)
+	TopicUserSync        = "user_sync"        // user info synced from Lark (UserSyncEvent)
+	TopicUserChange      = "user_change"      // user changed, suspended or left in the Lark directory (UserChangeEvent)
+	TopicDirectoryImport = "directory_import" // directory import progressed, paused or finished (DirectoryImportEvent)
+	TopicJob             = "job"              // background job started, progressed or finished (JobEvent)
+)
+
+// MessageTopics are the topics carrying MessageEvent payloads.
+var MessageTopics = []string{TopicMessage, TopicRecall, TopicEdit, TopicHandled}
+
+// MessageEvent is sent to SSE subscribers when a message is received or sent.
+type MessageEvent struct {
+	ID         string    `json:"id"`
+	ChatID     string    `json:"chat_id"`
+	ChatType   string    `json:"chat_type"` // "p2p" or "group"
+	SenderID   string    `json:"sender_id"`
+	SenderName string    `json:"sender_name"`
+	Direction  string    `json:"direction"` // "in" or "out"
+	MsgType    string    `json:"msg_type"`
+	Content    string    `json:"content"`
+	Mentions   string    `json:"mentions,omitempty"` // JSON array of mentioned users, as in message logs
+	Recalled   bool      `json:"recalled,omitempty"`
+	Edited     bool      `json:"edited,omitempty"`
+	Revision   int       `json:"revision,omitempty"`
+	HandledBy  string    `json:"handled_by,omitempty"`
+	MessageID  string    `json:"message_id,omitempty"` // target message_id for recall and edit events
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// TaskRunEvent reports the outcome of a scheduled task run.
+type TaskRunEvent struct {
+	TaskID    uint      `json:"task_id"`
+	ChatID    string    `json:"chat_id"`
+	Source    string    `json:"source"` // "scheduled" or "manual"
+	Success   bool      `json:"success"`
+	Error     string    `json:"error,omitempty"`
+	MessageID string    `json:"message_id,omitempty"`
+	RanAt     time.Time `json:"ran_at"`
+}
+
+// GroupChangeEvent reports a change to the bot's groups.
+type GroupChangeEvent struct {
+	ChatID string `json:"chat_id,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Action string `json:"action"`          // "joined", "left", "removed", "updated" or "synced"
+	Count  int    `json:"count,omitempty"` // number of groups, for "synced"
+}
+
+// UserSyncEvent reports a finished user sync.
+type UserSyncEvent struct {
+	OpenID string `json:"open_id,omitempty"` // set for single-user syncs
+	Total  int    `json:"total"`
+	Synced int    `json:"synced"`
+	Failed int    `json:"failed"`
+}
+
+// UserChangeEvent reports a Lark contact change to a known user.
+type UserChangeEvent struct {
+	OpenID        string `json:"open_id"`
+	Name          string `json:"name,omitempty"`
+	Action        string `json:"action"`                   // "updated", "deactivated", "reactivated", "left" or "rejoined"
+	DisabledTasks []uint `json:"disabled_tasks,omitempty"` // scheduled tasks disabled because they targeted the user
+	DisabledRules []uint `json:"disabled_rules,omitempty"` // auto-reply rules disabled because they targeted the user
+}
+
+// DirectoryImportEvent reports the progress of a directory import.
+type DirectoryImportEvent struct {
+	ID              uint   `json:"id"`
+	Status          string `json:"status"`
+	DepartmentTotal int    `json:"department_total"`
+	DepartmentDone  int    `json:"department_done"`
+	Imported        int    `json:"imported"`
+	Skipped         int    `json:"skipped"`
+	Failed          int    `json:"failed"`
+	Error           string `json:"error,omitempty"`
+}
+
+// JobEvent reports the progress of a background job. Result is set once the
+// job has ended.
+type JobEvent struct {
+	ID        uint            `json:"id"`
+	Kind      string          `json:"kind"`
+	Status    string          `json:"status"`
+	Total     int             `json:"total"`
+	Done      int             `json:"done"`
+	Message   string          `json:"message,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	CreatedBy string          `json:"created_by,omitempty"`
+}
+
+// PublishMessage publishes a MessageEvent under the recall, edit or message
+// topic according to its flags.
+func (b *Bus) PublishMessage(event MessageEvent) Event {
+	topic := TopicMessage
+	switch {
+	case event.Recalled:
+		topic = TopicRecall
+	case event.Edited:
+		topic = TopicEdit
+	}
+	return b.Publish(topic, event.ChatID, event)
+}
@@ -30,7 +30,20 @@ func Init(dbPath string, zapLogger *zap.Logger) (*gorm.DB, error) {
 		&model.ScheduledTask{},
 		&model.MessageLog{},
 		&model.Group{},
+		&model.GroupHistory{},
 		&model.User{},
+		&model.Department{},
+		&model.UserDepartment{},
+		&model.UserActivity{},
+		&model.ActivityCheckpoint{},
+		&model.DirectoryImport{},
+		&model.Job{},
+		&model.Campaign{},
+		&model.CampaignRecipient{},
+		&model.AdminUser{},
+		&model.Session{},
+		&model.APIKey{},
+		&model.AuditEntry{},
 	); err != nil {
 		return nil, err
 	}
@@ -4,6 +4,8 @@ import (
 	"context"
 
 	"go.uber.org/zap"
+
+	"lark-robot/internal/larkbot/content"
 )
 
 // IncomingMessage is a normalized representation of a received Lark message.
@@ -13,16 +15,22 @@ type IncomingMessage struct {
 	ChatType    string // "p2p" or "group"
 	SenderID    string
 	SenderName  string
-	MsgType     string // "text", "image", etc.
-	Content     string // raw JSON content from Lark
-	TextContent string // extracted plain text (for text messages)
-	MentionBot  bool   // whether the bot was @mentioned
+	MsgType     string            // "text", "image", etc.
+	Content     string            // raw JSON content from Lark
+	TextContent string            // extracted plain text (text, post and card messages)
+	Payload     *content.Payload  // parsed content, including typed attachments
+	Mentions    []content.Mention // users (and @all) mentioned in the message
+	MentionBot  bool              // whether the bot was @mentioned
+	RootID      string            // root message of the reply chain, empty if not a reply
+	ParentID    string            // message this one replies to, empty if not a reply
+	ThreadID    string            // thread (topic) the message belongs to, if any
 }
 
 // Reply is what a handler wants to send back.
 type Reply struct {
-	MsgType string // "text", "interactive", etc.
-	Content string // JSON content string
+	MsgType  string // "text", "interactive", etc.
+	Content  string // JSON content string
+	InThread bool   // reply inside the message's thread instead of the main chat
 }
 
 // Result is the outcome of a handler's processing.
@@ -2,20 +2,26 @@ package handler
 
 import (
 	"context"
-	"encoding/json"
+	"regexp"
 	"strings"
 	"sync"
+
+	"lark-robot/internal/larkbot/content"
+	larkmsg "lark-robot/internal/larkbot/msg"
 )
 
 // KeywordRule defines a single keyword-to-reply mapping.
 type KeywordRule struct {
-	ID          uint
-	Keyword     string
-	ReplyText   string
-	MatchMode   string // "exact", "contains", "prefix"
-	ChatID      string // empty = all chats
-	TriggerMode string // "any", "at_bot", "p2p_only"
-	Enabled     bool
+	ID            uint
+	Keyword       string
+	ReplyText     string
+	MatchMode     string          // "exact", "contains", "prefix"
+	ChatID        string          // empty = all chats
+	Chats         map[string]bool // non-nil limits the rule to these chats, the groups its group selector picks
+	TriggerMode   string          // "any", "at_bot", "p2p_only"
+	MentionUser   string          // comma-separated open_ids; the message must mention one of them
+	ReplyInThread bool            // reply inside the triggering message's thread
+	Enabled       bool
 }
 
 // KeywordHandler checks incoming text against a set of keyword rules.
@@ -55,6 +61,9 @@ func (h *KeywordHandler) Handle(ctx context.Context, msg *IncomingMessage) (*Res
 		if rule.ChatID != "" && !matchChatID(rule.ChatID, msg.ChatID) {
 			continue
 		}
+		if rule.Chats != nil && !rule.Chats[msg.ChatID] {
+			continue
+		}
 		// Check trigger mode
 		switch rule.TriggerMode {
 		case "at_bot":
@@ -66,14 +75,22 @@ func (h *KeywordHandler) Handle(ctx context.Context, msg *IncomingMessage) (*Res
 				continue
 			}
 		}
+		if rule.MentionUser != "" && !matchMention(rule.MentionUser, msg.Mentions) {
+			continue
+		}
 		if matchKeyword(msg.TextContent, rule.Keyword, rule.MatchMode) {
 			replyText := renderTemplate(rule.ReplyText, msg)
-			content, _ := json.Marshal(map[string]string{"text": replyText})
+			body, err := larkmsg.Text(replyText).Build()
+			if err != nil {
+				// The template rendered to nothing; claim the message without replying
+				return &Result{Handled: true}, nil
+			}
 			return &Result{
 				Handled: true,
 				Reply: &Reply{
-					MsgType: "text",
-					Content: string(content),
+					MsgType:  "text",
+					Content:  body,
+					InThread: rule.ReplyInThread,
 				},
 			}, nil
 		}
@@ -81,18 +98,50 @@ func (h *KeywordHandler) Handle(ctx context.Context, msg *IncomingMessage) (*Res
 	return &Result{Handled: false}, nil
 }
 
+// templateVar matches reply template variables, including {{at:ou_xxx}}.
+var templateVar = regexp.MustCompile(`\{\{(at:[^}\s]+|[a-z_]+)\}\}`)
+
 // renderTemplate replaces template variables in reply text with actual message values.
-// Supported variables: {{chat_id}}, {{chat_type}}, {{sender_id}}, {{sender_name}}, {{message_id}}, {{content}}
+// Supported variables: {{chat_id}}, {{chat_type}}, {{sender_id}}, {{sender_name}}, {{message_id}}, {{content}},
+// {{at_sender}} (@-mentions the sender), {{at_all}} and {{at:ou_xxx}} (@-mentions a specific user).
+// Variables are expanded in one pass over the rule's template, so values taken
+// from the message, such as a sender typing "{{at_all}}", are never expanded themselves.
 func renderTemplate(text string, msg *IncomingMessage) string {
-	r := strings.NewReplacer(
-		"{{chat_id}}", msg.ChatID,
-		"{{chat_type}}", msg.ChatType,
-		"{{sender_id}}", msg.SenderID,
-		"{{sender_name}}", msg.SenderName,
-		"{{message_id}}", msg.MessageID,
-		"{{content}}", msg.TextContent,
-	)
-	return r.Replace(text)
+	return templateVar.ReplaceAllStringFunc(text, func(m string) string {
+		name := m[2 : len(m)-2]
+		if id, ok := strings.CutPrefix(name, "at:"); ok {
+			return content.AtUser(id, "")
+		}
+		switch name {
+		case "chat_id":
+			return msg.ChatID
+		case "chat_type":
+			return msg.ChatType
+		case "sender_id":
+			return msg.SenderID
+		case "sender_name":
+			return msg.SenderName
+		case "message_id":
+			return msg.MessageID
+		case "content":
+			return msg.TextContent
+		case "at_sender":
+			return content.AtUser(msg.SenderID, msg.SenderName)
+		case "at_all":
+			return content.AtAll()
+		}
+		return m
+	})
+}
+
+// matchMention checks if any open_id in the comma-separated list is mentioned.
+func matchMention(openIDs string, mentions []content.Mention) bool {
+	for _, id := range strings.Split(openIDs, ",") {
+		if content.HasMention(mentions, strings.TrimSpace(id)) {
+			return true
+		}
+	}
+	return false
 }
 
 // matchChatID checks if msgChatID is in the comma-separated ruleChatID list.
@@ -0,0 +1,37 @@
+package handler
+
+import "testing"
+
+func TestRenderTemplate(t *testing.T) {
+	msg := &IncomingMessage{
+		ChatID:      "oc_1",
+		SenderID:    "ou_sender",
+		SenderName:  "张三",
+		TextContent: "hello",
+	}
+	tests := []struct {
+		name     string
+		template string
+		content  string
+		want     string
+	}{
+		{"plain", "收到", "hello", "收到"},
+		{"variables", "{{sender_name}}: {{content}} ({{chat_id}})", "hello", "张三: hello (oc_1)"},
+		{"at sender", "{{at_sender}} 你好", "hello", `<at user_id="ou_sender">张三</at> 你好`},
+		{"at all", "{{at_all}}", "hello", `<at user_id="all">所有人</at>`},
+		{"at user", "{{at:ou_x}}", "hello", `<at user_id="ou_x"></at>`},
+		{"unknown variable kept", "{{nope}}", "hello", "{{nope}}"},
+		{"content cannot mention all", "你说：{{content}}", "{{at_all}}", "你说：{{at_all}}"},
+		{"content cannot mention a user", "你说：{{content}}", "{{at:ou_victim}}", "你说：{{at:ou_victim}}"},
+		{"content cannot expand variables", "{{content}}", "{{sender_id}}", "{{sender_id}}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := *msg
+			m.TextContent = tt.content
+			if got := renderTemplate(tt.template, &m); got != tt.want {
+				t.Errorf("renderTemplate(%q) = %q, want %q", tt.template, got, tt.want)
+			}
+		})
+	}
+}
@@ -40,7 +40,7 @@ func (c *LarkClient) ListChats(ctx context.Context) ([]ChatInfo, error) {
 			return nil, fmt.Errorf("list chats failed: %w", err)
 		}
 		if !resp.Success() {
-			return nil, fmt.Errorf("list chats error: code=%d, msg=%s", resp.Code, resp.Msg)
+			return nil, newAPIError("list chats", resp.ApiResp, resp.Code, resp.Msg)
 		}
 
 		for _, item := range resp.Data.Items {
@@ -87,7 +87,7 @@ func (c *LarkClient) LeaveChat(ctx context.Context, chatID string) error {
 		return fmt.Errorf("leave chat failed: %w", err)
 	}
 	if !resp.Success() {
-		return fmt.Errorf("leave chat error: code=%d, msg=%s", resp.Code, resp.Msg)
+		return newAPIError("leave chat", resp.ApiResp, resp.Code, resp.Msg)
 	}
 	return nil
 }
@@ -103,7 +103,7 @@ func (c *LarkClient) GetChatInfo(ctx context.Context, chatID string) (*ChatInfo,
 		return nil, fmt.Errorf("get chat info failed: %w", err)
 	}
 	if !resp.Success() {
-		return nil, fmt.Errorf("get chat info error: code=%d, msg=%s", resp.Code, resp.Msg)
+		return nil, newAPIError("get chat info", resp.ApiResp, resp.Code, resp.Msg)
 	}
 
 	memberCount := 0
@@ -169,7 +169,7 @@ func (c *LarkClient) GetChatMembersPage(ctx context.Context, chatID string, page
 		return nil, fmt.Errorf("get chat members failed: %w", err)
 	}
 	if !resp.Success() {
-		return nil, fmt.Errorf("get chat members error: code=%d, msg=%s", resp.Code, resp.Msg)
+		return nil, newAPIError("get chat members", resp.ApiResp, resp.Code, resp.Msg)
 	}
 
 	page := &ChatMembersPage{}
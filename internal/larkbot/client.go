@@ -15,9 +15,12 @@ type LarkClient struct {
 	BotOpenID    string // Bot's own open_id, fetched at startup
 	BotName      string // Bot's display name (app_name)
 	BotAvatarURL string // Bot's avatar URL
+
+	baseURL string
+	queue   *SendQueue
 }
 
-func NewLarkClient(appID, appSecret, baseURL string) *LarkClient {
+func NewLarkClient(appID, appSecret, baseURL string, limits RateLimitConfig) *LarkClient {
 	opts := []lark.ClientOptionFunc{
 		lark.WithEnableTokenCache(true),
 		lark.WithLogLevel(larkcore.LogLevelInfo),
@@ -25,8 +28,16 @@ func NewLarkClient(appID, appSecret, baseURL string) *LarkClient {
 	if baseURL != "" {
 		opts = append(opts, lark.WithOpenBaseUrl(baseURL))
 	}
+	if baseURL == "" {
+		baseURL = lark.FeishuBaseUrl
+	}
 	client := lark.NewClient(appID, appSecret, opts...)
-	return &LarkClient{Client: client, AppID: appID}
+	return &LarkClient{Client: client, AppID: appID, baseURL: baseURL, queue: NewSendQueue(limits)}
+}
+
+// QueueStats returns the depth and counters of the outbound send queue.
+func (c *LarkClient) QueueStats() QueueStats {
+	return c.queue.Stats()
 }
 
 // FetchBotInfo retrieves the bot's own open_id via the Lark REST API.
@@ -37,7 +48,9 @@ func (c *LarkClient) FetchBotInfo(ctx context.Context) error {
 	}
 
 	var result struct {
-		Bot struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+		Bot  struct {
 			OpenID    string `json:"open_id"`
 			AppName   string `json:"app_name"`
 			AvatarURL string `json:"avatar_url"`
@@ -46,6 +59,9 @@ func (c *LarkClient) FetchBotInfo(ctx context.Context) error {
 	if err := json.Unmarshal(resp.RawBody, &result); err != nil {
 		return fmt.Errorf("parse bot info failed: %w", err)
 	}
+	if result.Code != 0 {
+		return newAPIError("get bot info", resp, result.Code, result.Msg)
+	}
 	if result.Bot.OpenID != "" {
 		c.BotOpenID = result.Bot.OpenID
 	}
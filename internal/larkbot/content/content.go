@@ -0,0 +1,271 @@
+// Package content parses the JSON content of received Lark messages into
+// structured payloads with plain text and typed attachments.
+package content
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Attachment types.
+const (
+	AttachImage    = "image"
+	AttachFile     = "file"
+	AttachAudio    = "audio"
+	AttachMedia    = "media"
+	AttachSticker  = "sticker"
+	AttachChat     = "share_chat"
+	AttachUser     = "share_user"
+	AttachLocation = "location"
+)
+
+// Attachment is a non-text element of a message.
+type Attachment struct {
+	Type      string `json:"type"`
+	Key       string `json:"key,omitempty"`       // image_key or file_key
+	ImageKey  string `json:"image_key,omitempty"` // cover image of a media message
+	Name      string `json:"name,omitempty"`      // file name, location name
+	Size      int64  `json:"size,omitempty"`      // bytes, when Lark provides it
+	Duration  int    `json:"duration,omitempty"`  // milliseconds, for audio and media
+	ID        string `json:"id,omitempty"`        // chat_id or user_id for shares
+	Latitude  string `json:"latitude,omitempty"`
+	Longitude string `json:"longitude,omitempty"`
+}
+
+// Payload is the structured form of a message's content.
+type Payload struct {
+	MsgType     string       `json:"msg_type"`
+	Text        string       `json:"text"`            // plain-text rendering
+	Title       string       `json:"title,omitempty"` // post or card title
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// Parse decodes raw message content of the given type. It never fails: content
+// it cannot decode is returned as plain text.
+func Parse(msgType, raw string) *Payload {
+	p := &Payload{MsgType: msgType}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &m); err != nil {
+		p.Text = strings.TrimSpace(raw)
+		return p
+	}
+
+	switch msgType {
+	case "text":
+		p.Text = strings.TrimSpace(str(m, "text"))
+	case "post":
+		parsePost(p, m)
+	case "image":
+		p.add(Attachment{Type: AttachImage, Key: str(m, "image_key")})
+	case "file":
+		p.add(Attachment{Type: AttachFile, Key: str(m, "file_key"), Name: str(m, "file_name"), Size: num(m, "file_size")})
+	case "audio":
+		p.add(Attachment{Type: AttachAudio, Key: str(m, "file_key"), Duration: int(num(m, "duration"))})
+	case "media":
+		p.add(Attachment{
+			Type:     AttachMedia,
+			Key:      str(m, "file_key"),
+			ImageKey: str(m, "image_key"),
+			Name:     str(m, "file_name"),
+			Size:     num(m, "file_size"),
+			Duration: int(num(m, "duration")),
+		})
+	case "sticker":
+		p.add(Attachment{Type: AttachSticker, Key: str(m, "file_key")})
+	case "share_chat":
+		p.add(Attachment{Type: AttachChat, ID: str(m, "chat_id")})
+	case "share_user":
+		p.add(Attachment{Type: AttachUser, ID: str(m, "user_id")})
+	case "location":
+		p.add(Attachment{
+			Type:      AttachLocation,
+			Name:      str(m, "name"),
+			Latitude:  str(m, "latitude"),
+			Longitude: str(m, "longitude"),
+		})
+	case "merge_forward":
+		// The event only carries a placeholder; the forwarded messages must be
+		// fetched separately through the message API.
+		p.Text = strings.TrimSpace(str(m, "content"))
+	case "interactive":
+		p.Title = str(m, "title")
+		if header, ok := m["header"].(map[string]interface{}); ok {
+			if title, ok := header["title"].(map[string]interface{}); ok && p.Title == "" {
+				p.Title = str(title, "content")
+			}
+		}
+		var sb strings.Builder
+		collectText(&sb, m["elements"])
+		p.Text = strings.TrimSpace(sb.String())
+	default:
+		p.Text = strings.TrimSpace(str(m, "text"))
+	}
+	return p
+}
+
+// HasAttachment reports whether the payload contains an attachment of the given type.
+func (p *Payload) HasAttachment(attachType string) bool {
+	for _, a := range p.Attachments {
+		if a.Type == attachType {
+			return true
+		}
+	}
+	return false
+}
+
+// Summary returns a one-line, human-readable description of the message,
+// e.g. "[文件] report.pdf" or the message text.
+func (p *Payload) Summary() string {
+	var parts []string
+	if p.Title != "" {
+		parts = append(parts, p.Title)
+	}
+	if p.Text != "" {
+		parts = append(parts, p.Text)
+	}
+	for _, a := range p.Attachments {
+		parts = append(parts, a.label())
+	}
+	if len(parts) == 0 {
+		switch p.MsgType {
+		case "merge_forward":
+			return "[合并转发]"
+		case "interactive":
+			return "[卡片]"
+		}
+		return ""
+	}
+	summary := strings.Join(parts, " ")
+	if r := []rune(summary); len(r) > 200 {
+		summary = string(r[:200]) + "…"
+	}
+	return summary
+}
+
+func (a Attachment) label() string {
+	switch a.Type {
+	case AttachImage:
+		return "[图片]"
+	case AttachFile:
+		return strings.TrimSpace("[文件] " + a.Name)
+	case AttachAudio:
+		return fmt.Sprintf("[语音] %ds", a.Duration/1000)
+	case AttachMedia:
+		return strings.TrimSpace(fmt.Sprintf("[视频] %s %ds", a.Name, a.Duration/1000))
+	case AttachSticker:
+		return "[表情]"
+	case AttachChat:
+		return "[群名片]"
+	case AttachUser:
+		return "[个人名片]"
+	case AttachLocation:
+		return strings.TrimSpace("[位置] " + a.Name)
+	}
+	return "[" + a.Type + "]"
+}
+
+func (p *Payload) add(a Attachment) {
+	p.Attachments = append(p.Attachments, a)
+}
+
+// parsePost extracts text and inline images/media from a rich text message:
+// {"title":"","content":[[{"tag":"text","text":"hello"},...]]}
+func parsePost(p *Payload, m map[string]interface{}) {
+	p.Title = str(m, "title")
+	lines, _ := m["content"].([]interface{})
+
+	var sb strings.Builder
+	for i, line := range lines {
+		lineArr, ok := line.([]interface{})
+		if !ok {
+			continue
+		}
+		if i > 0 && sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		for _, elem := range lineArr {
+			e, ok := elem.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			switch str(e, "tag") {
+			case "text", "md":
+				sb.WriteString(str(e, "text"))
+			case "a":
+				sb.WriteString(str(e, "text"))
+			case "at":
+				if name := str(e, "user_name"); name != "" {
+					sb.WriteString("@[" + name + "]")
+				}
+			case "emotion":
+				sb.WriteString("[" + str(e, "emoji_type") + "]")
+			case "img":
+				p.add(Attachment{Type: AttachImage, Key: str(e, "image_key")})
+			case "media":
+				p.add(Attachment{Type: AttachMedia, Key: str(e, "file_key"), ImageKey: str(e, "image_key")})
+			case "code_block":
+				sb.WriteString(str(e, "text"))
+			}
+		}
+	}
+	p.Text = strings.TrimSpace(sb.String())
+}
+
+// collectText walks card elements and concatenates every "text"/"content" string.
+func collectText(sb *strings.Builder, v interface{}) {
+	switch t := v.(type) {
+	case []interface{}:
+		for _, item := range t {
+			collectText(sb, item)
+		}
+	case map[string]interface{}:
+		for _, key := range []string{"text", "content"} {
+			if s, ok := t[key].(string); ok && s != "" {
+				if sb.Len() > 0 {
+					sb.WriteString(" ")
+				}
+				sb.WriteString(s)
+			}
+		}
+		// Walk keys in sorted order so the text comes out the same every time.
+		keys := make([]string, 0, len(t))
+		for key := range t {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := t[key]
+			if key == "text" || key == "content" {
+				if _, isStr := child.(string); isStr {
+					continue
+				}
+			}
+			collectText(sb, child)
+		}
+	}
+}
+
+func str(m map[string]interface{}, key string) string {
+	switch v := m[key].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
+
+func num(m map[string]interface{}, key string) int64 {
+	switch v := m[key].(type) {
+	case float64:
+		return int64(v)
+	case string:
+		n, _ := strconv.ParseInt(v, 10, 64)
+		return n
+	}
+	return 0
+}
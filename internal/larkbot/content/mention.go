@@ -0,0 +1,71 @@
+package content
+
+import (
+	"encoding/json"
+	"html"
+	"sort"
+	"strings"
+)
+
+// AllKey is the placeholder Lark uses for @all in message content.
+const AllKey = "@_all"
+
+// Mention is a user (or @all) mentioned in a received message. Key is the
+// placeholder that appears in the raw content, e.g. "@_user_1".
+type Mention struct {
+	Key    string `json:"key"`
+	OpenID string `json:"open_id,omitempty"`
+	Name   string `json:"name"`
+	IsBot  bool   `json:"is_bot,omitempty"` // the mention targets this bot
+	IsAll  bool   `json:"is_all,omitempty"`
+}
+
+// ResolveMentions replaces mention placeholders in text with @[Name] so the
+// mention boundary stays identifiable in plain text.
+func ResolveMentions(text string, mentions []Mention) string {
+	if len(mentions) == 0 {
+		return text
+	}
+	// Longer keys first so "@_user_1" does not clobber "@_user_10".
+	sorted := make([]Mention, len(mentions))
+	copy(sorted, mentions)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i].Key) > len(sorted[j].Key) })
+
+	pairs := make([]string, 0, len(sorted)*2)
+	for _, m := range sorted {
+		if m.Key != "" {
+			pairs = append(pairs, m.Key, "@["+m.Name+"]")
+		}
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// MarshalMentions encodes mentions as a JSON array for storage, or "" when there are none.
+func MarshalMentions(mentions []Mention) string {
+	if len(mentions) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(mentions)
+	return string(b)
+}
+
+// HasMention reports whether openID is among the mentioned users.
+func HasMention(mentions []Mention, openID string) bool {
+	for _, m := range mentions {
+		if openID != "" && m.OpenID == openID {
+			return true
+		}
+	}
+	return false
+}
+
+// AtUser returns the text-message markup that @-mentions a user by open_id.
+// name may be empty; Lark fills in the display name.
+func AtUser(openID, name string) string {
+	return `<at user_id="` + html.EscapeString(openID) + `">` + html.EscapeString(name) + `</at>`
+}
+
+// AtAll returns the text-message markup that @-mentions everyone in the chat.
+func AtAll() string {
+	return `<at user_id="all">所有人</at>`
+}
@@ -0,0 +1,121 @@
+package larkbot
+
+import (
+	"context"
+	"fmt"
+
+	larkcontact "github.com/larksuite/oapi-sdk-go/v3/service/contact/v3"
+)
+
+// RootDepartmentID is the parent ID of top-level departments.
+const RootDepartmentID = "0"
+
+// DepartmentInfo holds department information from the Lark API.
+type DepartmentInfo struct {
+	OpenDepartmentID string
+	DepartmentID     string // custom ID set by the tenant
+	ParentID         string // open_department_id of the parent; RootDepartmentID for top-level
+	Name             string
+	LeaderUserID     string // open_id
+	ChatID           string // department group chat
+	MemberCount      int
+	Order            string
+	Deleted          bool
+}
+
+func departmentInfo(d *larkcontact.Department) DepartmentInfo {
+	info := DepartmentInfo{
+		OpenDepartmentID: deref(d.OpenDepartmentId),
+		DepartmentID:     deref(d.DepartmentId),
+		ParentID:         deref(d.ParentDepartmentId),
+		Name:             deref(d.Name),
+		LeaderUserID:     deref(d.LeaderUserId),
+		ChatID:           deref(d.ChatId),
+		Order:            deref(d.Order),
+	}
+	if d.MemberCount != nil {
+		info.MemberCount = *d.MemberCount
+	}
+	if d.Status != nil && d.Status.IsDeleted != nil {
+		info.Deleted = *d.Status.IsDeleted
+	}
+	return info
+}
+
+// GetDepartment retrieves a department by open_department_id.
+func (c *LarkClient) GetDepartment(ctx context.Context, deptID string) (*DepartmentInfo, error) {
+	req := larkcontact.NewGetDepartmentReqBuilder().
+		DepartmentId(deptID).
+		DepartmentIdType("open_department_id").
+		UserIdType("open_id").
+		Build()
+
+	var resp *larkcontact.GetDepartmentResp
+	err := c.queue.Retry(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = c.Client.Contact.Department.Get(ctx, req)
+		if err != nil {
+			return fmt.Errorf("get department failed: %w", err)
+		}
+		if !resp.Success() {
+			return newAPIError("get department", resp.ApiResp, resp.Code, resp.Msg)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	info := departmentInfo(resp.Data.Department)
+	if info.OpenDepartmentID == "" {
+		info.OpenDepartmentID = deptID
+	}
+	return &info, nil
+}
+
+// ListDepartments returns the sub-departments of parentID visible to the app,
+// all descendants when recursive is set. Use RootDepartmentID for the whole
+// organization.
+func (c *LarkClient) ListDepartments(ctx context.Context, parentID string, recursive bool) ([]DepartmentInfo, error) {
+	var depts []DepartmentInfo
+	pageToken := ""
+
+	for {
+		reqBuilder := larkcontact.NewChildrenDepartmentReqBuilder().
+			DepartmentId(parentID).
+			DepartmentIdType("open_department_id").
+			UserIdType("open_id").
+			FetchChild(recursive).
+			PageSize(50)
+		if pageToken != "" {
+			reqBuilder.PageToken(pageToken)
+		}
+		req := reqBuilder.Build()
+
+		var resp *larkcontact.ChildrenDepartmentResp
+		err := c.queue.Retry(ctx, func(ctx context.Context) error {
+			var err error
+			resp, err = c.Client.Contact.Department.Children(ctx, req)
+			if err != nil {
+				return fmt.Errorf("list departments failed: %w", err)
+			}
+			if !resp.Success() {
+				return newAPIError("list departments", resp.ApiResp, resp.Code, resp.Msg)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range resp.Data.Items {
+			depts = append(depts, departmentInfo(item))
+		}
+
+		if resp.Data.HasMore == nil || !*resp.Data.HasMore {
+			break
+		}
+		pageToken = deref(resp.Data.PageToken)
+	}
+
+	return depts, nil
+}
@@ -0,0 +1,136 @@
+package larkbot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+)
+
+// APIError is returned when the Lark open platform answers a request with a
+// non-zero code or an unexpected HTTP status.
+type APIError struct {
+	Op         string `json:"op"`
+	Code       int    `json:"code"`
+	Msg        string `json:"msg"`
+	LogID      string `json:"log_id,omitempty"`
+	HTTPStatus int    `json:"http_status,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	if e.LogID != "" {
+		return fmt.Sprintf("%s error: code=%d, msg=%s, log_id=%s", e.Op, e.Code, e.Msg, e.LogID)
+	}
+	return fmt.Sprintf("%s error: code=%d, msg=%s", e.Op, e.Code, e.Msg)
+}
+
+// Lark response codes grouped by how callers are expected to react.
+var (
+	rateLimitCodes = map[int]bool{
+		99991400: true, // app-level request frequency limit
+		230020:   true, // per-chat / per-user send frequency limit
+		11232:    true, // legacy message create frequency limit
+		11233:    true, // legacy per-chat trigger frequency limit
+	}
+	notInChatCodes = map[int]bool{
+		230002: true, // bot is not in the chat
+		232011: true, // operator is not in the chat
+	}
+	permissionCodes = map[int]bool{
+		99991672: true, // app lacks the required scope
+		99991679: true, // user has not granted the required scope
+		230013:   true, // bot has no availability to this user
+		230027:   true, // lack of permission for this operation
+		40004:    true, // no permission for this department or user
+	}
+	tokenCodes = map[int]bool{
+		99991661: true, // access token missing
+		99991663: true, // tenant access token invalid
+		99991664: true, // app access token invalid
+		99991668: true, // user access token invalid
+		99991677: true, // user access token expired
+	}
+	notFoundCodes = map[int]bool{
+		230011: true, // message has been recalled
+		231003: true, // message not found
+	}
+)
+
+// newAPIError builds an APIError from an SDK response. resp may be nil.
+func newAPIError(op string, resp *larkcore.ApiResp, code int, msg string) *APIError {
+	e := &APIError{Op: op, Code: code, Msg: msg}
+	if resp != nil {
+		e.LogID = resp.LogId()
+		e.HTTPStatus = resp.StatusCode
+	}
+	return e
+}
+
+// rawAPIError builds an APIError from a raw (non-typed) SDK response,
+// extracting code and msg from the JSON body when present.
+func rawAPIError(op string, resp *larkcore.ApiResp) *APIError {
+	var body struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+	_ = json.Unmarshal(resp.RawBody, &body)
+	if body.Msg == "" {
+		body.Msg = http.StatusText(resp.StatusCode)
+	}
+	return newAPIError(op, resp, body.Code, body.Msg)
+}
+
+// AsAPIError extracts an *APIError from err's chain.
+func AsAPIError(err error) (*APIError, bool) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr, true
+	}
+	return nil, false
+}
+
+// IsRateLimited reports whether err is a Lark frequency-limit response.
+func IsRateLimited(err error) bool {
+	e, ok := AsAPIError(err)
+	return ok && (rateLimitCodes[e.Code] || e.HTTPStatus == http.StatusTooManyRequests)
+}
+
+// IsTransient reports whether err is likely to go away on retry: a frequency
+// limit, a 5xx response, or a network error before Lark answered.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	e, ok := AsAPIError(err)
+	if !ok {
+		return true
+	}
+	return IsRateLimited(err) || e.HTTPStatus >= http.StatusInternalServerError
+}
+
+// IsNotInChat reports whether the bot (or operator) is not a member of the target chat.
+func IsNotInChat(err error) bool {
+	e, ok := AsAPIError(err)
+	return ok && notInChatCodes[e.Code]
+}
+
+// IsPermissionDenied reports whether the app or user lacks permission for the call.
+func IsPermissionDenied(err error) bool {
+	e, ok := AsAPIError(err)
+	return ok && (permissionCodes[e.Code] || e.HTTPStatus == http.StatusForbidden)
+}
+
+// IsTokenExpired reports whether the access token used for the call was missing or invalid.
+func IsTokenExpired(err error) bool {
+	e, ok := AsAPIError(err)
+	return ok && (tokenCodes[e.Code] || e.HTTPStatus == http.StatusUnauthorized)
+}
+
+// IsNotFound reports whether the target resource does not exist (or was recalled).
+func IsNotFound(err error) bool {
+	e, ok := AsAPIError(err)
+	return ok && (notFoundCodes[e.Code] || e.HTTPStatus == http.StatusNotFound)
+}
@@ -0,0 +1,76 @@
+package larkbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestAPIErrorClassification(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		rateLimited bool
+		transient   bool
+		notInChat   bool
+		permission  bool
+		token       bool
+		notFound    bool
+	}{
+		{"nil", nil, false, false, false, false, false, false},
+		{"network error", errors.New("connection reset"), false, true, false, false, false, false},
+		{"context canceled", fmt.Errorf("send: %w", context.Canceled), false, false, false, false, false, false},
+		{"app rate limit", &APIError{Code: 99991400}, true, true, false, false, false, false},
+		{"chat rate limit", &APIError{Code: 230020}, true, true, false, false, false, false},
+		{"http 429", &APIError{HTTPStatus: http.StatusTooManyRequests}, true, true, false, false, false, false},
+		{"http 502", &APIError{HTTPStatus: http.StatusBadGateway}, false, true, false, false, false, false},
+		{"bot not in chat", &APIError{Code: 230002, HTTPStatus: http.StatusBadRequest}, false, false, true, false, false, false},
+		{"missing scope", &APIError{Code: 99991672}, false, false, false, true, false, false},
+		{"http 403", &APIError{HTTPStatus: http.StatusForbidden}, false, false, false, true, false, false},
+		{"token invalid", &APIError{Code: 99991663}, false, false, false, false, true, false},
+		{"http 401", &APIError{HTTPStatus: http.StatusUnauthorized}, false, false, false, false, true, false},
+		{"recalled", &APIError{Code: 230011}, false, false, false, false, false, true},
+		{"message not found", &APIError{Code: 231003}, false, false, false, false, false, true},
+		{"http 404", &APIError{HTTPStatus: http.StatusNotFound}, false, false, false, false, false, true},
+		{"wrapped", fmt.Errorf("recall: %w", &APIError{Code: 231003}), false, false, false, false, false, true},
+		{"unknown code", &APIError{Code: 1, HTTPStatus: http.StatusBadRequest}, false, false, false, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checks := []struct {
+				fn   func(error) bool
+				name string
+				want bool
+			}{
+				{IsRateLimited, "IsRateLimited", tt.rateLimited},
+				{IsTransient, "IsTransient", tt.transient},
+				{IsNotInChat, "IsNotInChat", tt.notInChat},
+				{IsPermissionDenied, "IsPermissionDenied", tt.permission},
+				{IsTokenExpired, "IsTokenExpired", tt.token},
+				{IsNotFound, "IsNotFound", tt.notFound},
+			}
+			for _, c := range checks {
+				if got := c.fn(tt.err); got != c.want {
+					t.Errorf("%s(%v) = %v, want %v", c.name, tt.err, got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAPIErrorMessage(t *testing.T) {
+	tests := []struct {
+		err  *APIError
+		want string
+	}{
+		{&APIError{Op: "send message", Code: 230002, Msg: "bot not in chat"}, "send message error: code=230002, msg=bot not in chat"},
+		{&APIError{Op: "send message", Code: 230002, Msg: "bot not in chat", LogID: "log_1"}, "send message error: code=230002, msg=bot not in chat, log_id=log_1"},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("Error() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -9,9 +9,12 @@ import (
 
 	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
 	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+
+	"lark-robot/internal/larkbot/msg"
 )
 
 // SendMessage sends a message to a chat or user.
+// The call goes through the send queue and is throttled per app and per receiver.
 func (c *LarkClient) SendMessage(ctx context.Context, receiveID, receiveIDType, msgType, content string) (string, error) {
 	req := larkim.NewCreateMessageReqBuilder().
 		ReceiveIdType(receiveIDType).
@@ -22,34 +25,105 @@ func (c *LarkClient) SendMessage(ctx context.Context, receiveID, receiveIDType,
 			Build()).
 		Build()
 
-	resp, err := c.Client.Im.Message.Create(ctx, req)
-	if err != nil {
-		return "", fmt.Errorf("send message failed: %w", err)
-	}
-	if !resp.Success() {
-		return "", fmt.Errorf("send message error: code=%d, msg=%s", resp.Code, resp.Msg)
-	}
-	return *resp.Data.MessageId, nil
+	var messageID string
+	err := c.queue.Do(ctx, receiveID, func(ctx context.Context) error {
+		resp, err := c.Client.Im.Message.Create(ctx, req)
+		if err != nil {
+			return fmt.Errorf("send message failed: %w", err)
+		}
+		if !resp.Success() {
+			return newAPIError("send message", resp.ApiResp, resp.Code, resp.Msg)
+		}
+		messageID = *resp.Data.MessageId
+		return nil
+	})
+	return messageID, err
+}
+
+// SentMessage identifies a message created by a reply, including its thread placement.
+type SentMessage struct {
+	MessageID string
+	ChatID    string
+	RootID    string
+	ParentID  string
+	ThreadID  string
 }
 
-// ReplyMessage replies to a specific message.
-func (c *LarkClient) ReplyMessage(ctx context.Context, messageID, msgType, content string) (string, error) {
+// ReplyMessage replies to a specific message. When replyInThread is true the
+// reply is posted in the message's thread (creating one if needed).
+// The target chat is unknown here, so only the per-app limit applies.
+func (c *LarkClient) ReplyMessage(ctx context.Context, messageID, msgType, content string, replyInThread bool) (*SentMessage, error) {
 	req := larkim.NewReplyMessageReqBuilder().
 		MessageId(messageID).
 		Body(larkim.NewReplyMessageReqBodyBuilder().
 			MsgType(msgType).
 			Content(content).
+			ReplyInThread(replyInThread).
 			Build()).
 		Build()
 
-	resp, err := c.Client.Im.Message.Reply(ctx, req)
-	if err != nil {
-		return "", fmt.Errorf("reply message failed: %w", err)
-	}
-	if !resp.Success() {
-		return "", fmt.Errorf("reply message error: code=%d, msg=%s", resp.Code, resp.Msg)
-	}
-	return *resp.Data.MessageId, nil
+	var sent *SentMessage
+	err := c.queue.Do(ctx, "", func(ctx context.Context) error {
+		resp, err := c.Client.Im.Message.Reply(ctx, req)
+		if err != nil {
+			return fmt.Errorf("reply message failed: %w", err)
+		}
+		if !resp.Success() {
+			return newAPIError("reply message", resp.ApiResp, resp.Code, resp.Msg)
+		}
+		sent = &SentMessage{
+			MessageID: deref(resp.Data.MessageId),
+			ChatID:    deref(resp.Data.ChatId),
+			RootID:    deref(resp.Data.RootId),
+			ParentID:  deref(resp.Data.ParentId),
+			ThreadID:  deref(resp.Data.ThreadId),
+		}
+		return nil
+	})
+	return sent, err
+}
+
+// UpdateMessage edits the content of a sent text or post message.
+func (c *LarkClient) UpdateMessage(ctx context.Context, messageID, msgType, content string) error {
+	req := larkim.NewUpdateMessageReqBuilder().
+		MessageId(messageID).
+		Body(larkim.NewUpdateMessageReqBodyBuilder().
+			MsgType(msgType).
+			Content(content).
+			Build()).
+		Build()
+
+	return c.queue.Do(ctx, "", func(ctx context.Context) error {
+		resp, err := c.Client.Im.Message.Update(ctx, req)
+		if err != nil {
+			return fmt.Errorf("update message failed: %w", err)
+		}
+		if !resp.Success() {
+			return newAPIError("update message", resp.ApiResp, resp.Code, resp.Msg)
+		}
+		return nil
+	})
+}
+
+// PatchCard replaces the content of a sent interactive card message.
+func (c *LarkClient) PatchCard(ctx context.Context, messageID, content string) error {
+	req := larkim.NewPatchMessageReqBuilder().
+		MessageId(messageID).
+		Body(larkim.NewPatchMessageReqBodyBuilder().
+			Content(content).
+			Build()).
+		Build()
+
+	return c.queue.Do(ctx, "", func(ctx context.Context) error {
+		resp, err := c.Client.Im.Message.Patch(ctx, req)
+		if err != nil {
+			return fmt.Errorf("patch card failed: %w", err)
+		}
+		if !resp.Success() {
+			return newAPIError("patch card", resp.ApiResp, resp.Code, resp.Msg)
+		}
+		return nil
+	})
 }
 
 // GetMessageResource downloads a resource (image/file) from a message.
@@ -64,7 +138,7 @@ func (c *LarkClient) GetMessageResource(ctx context.Context, messageID, fileKey,
 		return nil, fmt.Errorf("get message resource failed: %w", err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("get message resource error: status=%d, body=%s", resp.StatusCode, string(resp.RawBody))
+		return nil, rawAPIError("get message resource", resp)
 	}
 	if len(resp.RawBody) == 0 {
 		return nil, fmt.Errorf("get message resource error: empty response")
@@ -78,14 +152,16 @@ func (c *LarkClient) DeleteMessage(ctx context.Context, messageID string) error
 		MessageId(messageID).
 		Build()
 
-	resp, err := c.Client.Im.Message.Delete(ctx, req)
-	if err != nil {
-		return fmt.Errorf("delete message failed: %w", err)
-	}
-	if !resp.Success() {
-		return fmt.Errorf("delete message error: code=%d, msg=%s", resp.Code, resp.Msg)
-	}
-	return nil
+	return c.queue.Do(ctx, "", func(ctx context.Context) error {
+		resp, err := c.Client.Im.Message.Delete(ctx, req)
+		if err != nil {
+			return fmt.Errorf("delete message failed: %w", err)
+		}
+		if !resp.Success() {
+			return newAPIError("delete message", resp.ApiResp, resp.Code, resp.Msg)
+		}
+		return nil
+	})
 }
 
 // UploadImage uploads an image to Lark and returns the image_key.
@@ -102,7 +178,7 @@ func (c *LarkClient) UploadImage(ctx context.Context, imageReader io.Reader) (st
 		return "", fmt.Errorf("upload image failed: %w", err)
 	}
 	if !resp.Success() {
-		return "", fmt.Errorf("upload image error: code=%d, msg=%s", resp.Code, resp.Msg)
+		return "", newAPIError("upload image", resp.ApiResp, resp.Code, resp.Msg)
 	}
 	return *resp.Data.ImageKey, nil
 }
@@ -123,13 +199,16 @@ func (c *LarkClient) UploadFile(ctx context.Context, fileType, fileName string,
 		return "", fmt.Errorf("upload file failed: %w", err)
 	}
 	if !resp.Success() {
-		return "", fmt.Errorf("upload file error: code=%d, msg=%s", resp.Code, resp.Msg)
+		return "", newAPIError("upload file", resp.ApiResp, resp.Code, resp.Msg)
 	}
 	return *resp.Data.FileKey, nil
 }
 
 // SendTextMessage is a convenience method for sending plain text.
 func (c *LarkClient) SendTextMessage(ctx context.Context, receiveID, receiveIDType, text string) (string, error) {
-	content := fmt.Sprintf(`{"text":"%s"}`, text)
+	content, err := msg.Text(text).Build()
+	if err != nil {
+		return "", err
+	}
 	return c.SendMessage(ctx, receiveID, receiveIDType, "text", content)
 }
@@ -0,0 +1,139 @@
+package msg
+
+// Element is a single card element, e.g. a markdown block or a button row.
+type Element map[string]interface{}
+
+// Button is an action button in a card.
+type Button struct {
+	Text  string
+	Type  string                 // "default", "primary" or "danger"
+	URL   string                 // opened on click, if set
+	Value map[string]interface{} // sent back in the card action callback
+}
+
+// Column is one column of a column set.
+type Column struct {
+	Weight   int // relative width, defaults to 1
+	Elements []Element
+}
+
+// Markdown returns a markdown element. Mentions use <at id=ou_xxx></at>.
+func Markdown(text string) Element {
+	return Element{"tag": "markdown", "content": text}
+}
+
+// Div returns a plain text block.
+func Div(text string) Element {
+	return Element{"tag": "div", "text": Element{"tag": "plain_text", "content": text}}
+}
+
+// Divider returns a horizontal rule.
+func Divider() Element {
+	return Element{"tag": "hr"}
+}
+
+// Image returns an image element for an uploaded image key.
+func Image(imageKey, alt string) Element {
+	return Element{"tag": "img", "img_key": imageKey, "alt": Element{"tag": "plain_text", "content": alt}}
+}
+
+// Note returns a small grey footnote.
+func Note(text string) Element {
+	return Element{"tag": "note", "elements": []Element{{"tag": "plain_text", "content": text}}}
+}
+
+// Actions returns a row of buttons.
+func Actions(buttons ...Button) Element {
+	actions := make([]Element, 0, len(buttons))
+	for _, btn := range buttons {
+		e := Element{
+			"tag":  "button",
+			"text": Element{"tag": "plain_text", "content": btn.Text},
+			"type": btn.Type,
+		}
+		if btn.Type == "" {
+			e["type"] = "default"
+		}
+		if btn.URL != "" {
+			e["url"] = btn.URL
+		}
+		if btn.Value != nil {
+			e["value"] = btn.Value
+		}
+		actions = append(actions, e)
+	}
+	return Element{"tag": "action", "actions": actions}
+}
+
+// Columns returns a column set laying out its columns side by side.
+func Columns(columns ...Column) Element {
+	cols := make([]Element, 0, len(columns))
+	for _, c := range columns {
+		weight := c.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		cols = append(cols, Element{
+			"tag":      "column",
+			"width":    "weighted",
+			"weight":   weight,
+			"elements": c.Elements,
+		})
+	}
+	return Element{"tag": "column_set", "flex_mode": "none", "columns": cols}
+}
+
+// CardBuilder builds an interactive card message.
+type CardBuilder struct {
+	title    string
+	template string
+	wide     bool
+	elements []Element
+}
+
+// Card starts a card with an optional header title.
+func Card(title string) *CardBuilder {
+	return &CardBuilder{title: title}
+}
+
+// Template sets the header color, e.g. "blue", "green", "red".
+func (b *CardBuilder) Template(color string) *CardBuilder {
+	b.template = color
+	return b
+}
+
+// WideScreen lets the card use the full message width.
+func (b *CardBuilder) WideScreen() *CardBuilder {
+	b.wide = true
+	return b
+}
+
+// Add appends elements to the card body.
+func (b *CardBuilder) Add(elements ...Element) *CardBuilder {
+	b.elements = append(b.elements, elements...)
+	return b
+}
+
+// Build returns the message content JSON.
+func (b *CardBuilder) Build() (string, error) {
+	if len(b.elements) == 0 && b.title == "" {
+		return "", ErrEmpty
+	}
+	card := map[string]interface{}{
+		"config":   map[string]interface{}{"wide_screen_mode": b.wide},
+		"elements": b.elements,
+	}
+	if b.elements == nil {
+		card["elements"] = []Element{}
+	}
+	if b.title != "" {
+		header := map[string]interface{}{
+			"title": Element{"tag": "plain_text", "content": b.title},
+		}
+		if b.template != "" {
+			header["template"] = b.template
+		}
+		card["header"] = header
+	}
+	return marshal(card)
+}
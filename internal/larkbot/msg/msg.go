@@ -0,0 +1,138 @@
+// Package msg builds the JSON content of outgoing Lark messages. Builders
+// escape their input and validate the result, so callers never hand-assemble
+// content strings.
+package msg
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"lark-robot/internal/larkbot/content"
+)
+
+// ErrEmpty is returned when a builder has nothing to send.
+var ErrEmpty = errors.New("message content is empty")
+
+// TextBuilder builds a "text" message.
+type TextBuilder struct {
+	sb strings.Builder
+}
+
+// Text starts a text message with the given text.
+func Text(text string) *TextBuilder {
+	b := &TextBuilder{}
+	b.sb.WriteString(text)
+	return b
+}
+
+// Text appends plain text.
+func (b *TextBuilder) Text(text string) *TextBuilder {
+	b.sb.WriteString(text)
+	return b
+}
+
+// At appends an @-mention of the user with the given open_id.
+func (b *TextBuilder) At(openID, name string) *TextBuilder {
+	b.sb.WriteString(content.AtUser(openID, name))
+	return b
+}
+
+// AtAll appends an @-mention of everyone in the chat.
+func (b *TextBuilder) AtAll() *TextBuilder {
+	b.sb.WriteString(content.AtAll())
+	return b
+}
+
+// Line appends a line break.
+func (b *TextBuilder) Line() *TextBuilder {
+	b.sb.WriteString("\n")
+	return b
+}
+
+// Build returns the message content JSON.
+func (b *TextBuilder) Build() (string, error) {
+	if strings.TrimSpace(b.sb.String()) == "" {
+		return "", ErrEmpty
+	}
+	return marshal(map[string]string{"text": b.sb.String()})
+}
+
+// PostBuilder builds a multi-paragraph rich text ("post") message.
+type PostBuilder struct {
+	title      string
+	paragraphs [][]map[string]interface{}
+}
+
+// Post starts a rich text message with an optional title.
+func Post(title string) *PostBuilder {
+	return &PostBuilder{title: title}
+}
+
+// Paragraph starts a new paragraph; subsequent elements are appended to it.
+func (b *PostBuilder) Paragraph() *PostBuilder {
+	b.paragraphs = append(b.paragraphs, []map[string]interface{}{})
+	return b
+}
+
+// Text appends plain text to the current paragraph.
+func (b *PostBuilder) Text(text string) *PostBuilder {
+	return b.add(map[string]interface{}{"tag": "text", "text": text})
+}
+
+// Link appends a hyperlink to the current paragraph.
+func (b *PostBuilder) Link(text, href string) *PostBuilder {
+	return b.add(map[string]interface{}{"tag": "a", "text": text, "href": href})
+}
+
+// At appends an @-mention of the user with the given open_id.
+func (b *PostBuilder) At(openID string) *PostBuilder {
+	return b.add(map[string]interface{}{"tag": "at", "user_id": openID})
+}
+
+// AtAll appends an @-mention of everyone in the chat.
+func (b *PostBuilder) AtAll() *PostBuilder {
+	return b.add(map[string]interface{}{"tag": "at", "user_id": "all"})
+}
+
+// Image appends an uploaded image (see LarkClient.UploadImage) as its own paragraph.
+func (b *PostBuilder) Image(imageKey string) *PostBuilder {
+	b.paragraphs = append(b.paragraphs, []map[string]interface{}{{"tag": "img", "image_key": imageKey}})
+	return b.Paragraph()
+}
+
+func (b *PostBuilder) add(elem map[string]interface{}) *PostBuilder {
+	if len(b.paragraphs) == 0 {
+		b.Paragraph()
+	}
+	last := len(b.paragraphs) - 1
+	b.paragraphs[last] = append(b.paragraphs[last], elem)
+	return b
+}
+
+// Build returns the message content JSON.
+func (b *PostBuilder) Build() (string, error) {
+	var paragraphs [][]map[string]interface{}
+	for _, p := range b.paragraphs {
+		if len(p) > 0 {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+	if len(paragraphs) == 0 {
+		return "", ErrEmpty
+	}
+	return marshal(map[string]interface{}{
+		"zh_cn": map[string]interface{}{
+			"title":   b.title,
+			"content": paragraphs,
+		},
+	})
+}
+
+func marshal(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,154 @@
+package msg
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestBuilders(t *testing.T) {
+	tests := []struct {
+		name    string
+		build   func() (string, error)
+		want    string
+		wantErr error
+	}{
+		{
+			name:  "text",
+			build: Text("hello").Build,
+			want:  `{"text":"hello"}`,
+		},
+		{
+			name:    "empty text",
+			build:   Text("  ").Line().Build,
+			wantErr: ErrEmpty,
+		},
+		{
+			name:  "post",
+			build: Post("标题").Text("a").Link("b", "https://x").Paragraph().At("ou_1").Build,
+			want:  `{"zh_cn":{"content":[[{"tag":"text","text":"a"},{"href":"https://x","tag":"a","text":"b"}],[{"tag":"at","user_id":"ou_1"}]],"title":"标题"}}`,
+		},
+		{
+			name:  "post image gets its own paragraph",
+			build: Post("").Text("a").Image("img_1").Text("b").Build,
+			want:  `{"zh_cn":{"content":[[{"tag":"text","text":"a"}],[{"image_key":"img_1","tag":"img"}],[{"tag":"text","text":"b"}]],"title":""}}`,
+		},
+		{
+			name:    "post without elements",
+			build:   Post("标题").Paragraph().Build,
+			wantErr: ErrEmpty,
+		},
+		{
+			name:  "card",
+			build: Card("通知").Template("blue").Add(Markdown("**hi**"), Divider()).Build,
+			want:  `{"config":{"wide_screen_mode":false},"elements":[{"content":"**hi**","tag":"markdown"},{"tag":"hr"}],"header":{"template":"blue","title":{"content":"通知","tag":"plain_text"}}}`,
+		},
+		{
+			name:  "card with only a title",
+			build: Card("通知").WideScreen().Build,
+			want:  `{"config":{"wide_screen_mode":true},"elements":[],"header":{"title":{"content":"通知","tag":"plain_text"}}}`,
+		},
+		{
+			name:  "card buttons default their type",
+			build: Card("").Add(Actions(Button{Text: "ok", URL: "https://x"})).Build,
+			want:  `{"config":{"wide_screen_mode":false},"elements":[{"actions":[{"tag":"button","text":{"content":"ok","tag":"plain_text"},"type":"default","url":"https://x"}],"tag":"action"}]}`,
+		},
+		{
+			name:    "empty card",
+			build:   Card("").Build,
+			wantErr: ErrEmpty,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.build()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Build() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Build() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextBuilderMentions(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder *TextBuilder
+		want    string
+	}{
+		{"mentions", Text("hi ").At("ou_1", "张三").Line().AtAll(), "hi <at user_id=\"ou_1\">张三</at>\n<at user_id=\"all\">所有人</at>"},
+		{"escaped markup", Text("").At(`ou_1"><at user_id="all`, "<b>"), `<at user_id="ou_1&#34;&gt;&lt;at user_id=&#34;all">&lt;b&gt;</at>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := tt.builder.Build()
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+			var got map[string]string
+			if err := json.Unmarshal([]byte(content), &got); err != nil {
+				t.Fatalf("Build() = %s: %v", content, err)
+			}
+			if got["text"] != tt.want {
+				t.Errorf("text = %q, want %q", got["text"], tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		msgType string
+		content string
+		wantErr bool
+	}{
+		{"text", `{"text":"hi"}`, false},
+		{"text", `{"text":"  "}`, true},
+		{"text", `hi`, true},
+		{"post", `{"zh_cn":{"title":"t","content":[[{"tag":"text","text":"a"}]]}}`, false},
+		{"post", `{"title":"t","content":[[{"tag":"text","text":"a"}]]}`, false},
+		{"post", `{"zh_cn":{"title":"t"}}`, true},
+		{"interactive", `{"elements":[]}`, false},
+		{"interactive", `{"header":{}}`, false},
+		{"interactive", `{"type":"template","data":{}}`, false},
+		{"interactive", `{"config":{}}`, true},
+		{"image", `{"image_key":"img_1"}`, false},
+		{"image", `{}`, true},
+		{"file", `{"file_key":"f_1"}`, false},
+		{"sticker", `{"image_key":"img_1"}`, true},
+		{"share_chat", `{"chat_id":"oc_1"}`, false},
+		{"share_user", `{"chat_id":"oc_1"}`, true},
+		{"", `{}`, true},
+		{"unknown", `{}`, false},
+	}
+	for _, tt := range tests {
+		if err := Validate(tt.msgType, tt.content); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%q, %s) error = %v, wantErr %v", tt.msgType, tt.content, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		msgType string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{"text", `hello`, `{"text":"hello"}`, false},
+		{"text", `{{sender_name}} 你好`, `{"text":"{{sender_name}} 你好"}`, false},
+		{"text", `{"text":"hi"}`, `{"text":"hi"}`, false},
+		{"text", `{"text":""}`, "", true},
+		{"text", ``, "", true},
+		{"post", `not json`, "", true},
+		{"interactive", `{"elements":[]}`, `{"elements":[]}`, false},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.msgType, tt.content)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Normalize(%q, %q) = %q, %v; want %q, wantErr %v", tt.msgType, tt.content, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package msg
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Validate checks that content is well-formed JSON with the fields Lark
+// requires for msgType.
+func Validate(msgType, content string) error {
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(content), &m); err != nil {
+		return fmt.Errorf("invalid %s content: %w", msgType, err)
+	}
+
+	require := func(keys ...string) error {
+		for _, k := range keys {
+			if s, _ := m[k].(string); strings.TrimSpace(s) == "" {
+				return fmt.Errorf("invalid %s content: missing %q", msgType, k)
+			}
+		}
+		return nil
+	}
+
+	switch msgType {
+	case "text":
+		return require("text")
+	case "post":
+		// Either a locale map ({"zh_cn":{...}}) or a bare post body
+		if _, ok := m["content"].([]interface{}); ok {
+			return nil
+		}
+		for _, v := range m {
+			if locale, ok := v.(map[string]interface{}); ok {
+				if _, ok := locale["content"].([]interface{}); ok {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("invalid post content: no paragraphs")
+	case "interactive":
+		if _, ok := m["elements"]; ok {
+			return nil
+		}
+		if _, ok := m["header"]; ok {
+			return nil
+		}
+		if m["type"] == "template" {
+			return nil
+		}
+		return fmt.Errorf("invalid interactive content: card has no header or elements")
+	case "image":
+		return require("image_key")
+	case "file", "audio", "media", "sticker":
+		return require("file_key")
+	case "share_chat":
+		return require("chat_id")
+	case "share_user":
+		return require("user_id")
+	case "":
+		return fmt.Errorf("message type is required")
+	}
+	return nil
+}
+
+// Normalize returns content ready to send. Text that is not already a JSON
+// object with a "text" key is wrapped as a text message, so plain strings,
+// including ones starting with "{" such as "{{sender_name}} 你好", can be
+// stored for text messages; everything is then validated.
+func Normalize(msgType, content string) (string, error) {
+	if msgType == "text" && !isTextContent(content) {
+		return Text(content).Build()
+	}
+	if err := Validate(msgType, content); err != nil {
+		return "", err
+	}
+	return content, nil
+}
+
+// isTextContent reports whether content is an already built text message.
+func isTextContent(content string) bool {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(content), &m); err != nil {
+		return false
+	}
+	_, ok := m["text"]
+	return ok
+}
@@ -0,0 +1,78 @@
+package larkbot
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	larkauthen "github.com/larksuite/oapi-sdk-go/v3/service/authen/v1"
+)
+
+// OAuthUser is the identity of a user who logged in through Lark OAuth.
+type OAuthUser struct {
+	OpenID    string
+	UnionID   string
+	UserID    string
+	Name      string
+	Email     string
+	AvatarURL string
+}
+
+// AuthorizeURL returns the Lark page where the user approves the login.
+// Lark redirects back to redirectURI with code and state query params.
+func (c *LarkClient) AuthorizeURL(redirectURI, state string) string {
+	q := url.Values{}
+	q.Set("app_id", c.AppID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("state", state)
+	return strings.TrimRight(c.baseURL, "/") + "/open-apis/authen/v1/authorize?" + q.Encode()
+}
+
+// ExchangeOAuthCode exchanges an authorization code for a user access token
+// and returns the user it belongs to.
+func (c *LarkClient) ExchangeOAuthCode(ctx context.Context, code string) (*OAuthUser, error) {
+	req := larkauthen.NewCreateOidcAccessTokenReqBuilder().
+		Body(larkauthen.NewCreateOidcAccessTokenReqBodyBuilder().
+			GrantType("authorization_code").
+			Code(code).
+			Build()).
+		Build()
+
+	tokenResp, err := c.Client.Authen.OidcAccessToken.Create(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("exchange oauth code failed: %w", err)
+	}
+	if !tokenResp.Success() {
+		return nil, newAPIError("exchange oauth code", tokenResp.ApiResp, tokenResp.Code, tokenResp.Msg)
+	}
+	if tokenResp.Data == nil || tokenResp.Data.AccessToken == nil {
+		return nil, fmt.Errorf("exchange oauth code: empty access token")
+	}
+
+	infoResp, err := c.Client.Authen.UserInfo.Get(ctx, larkcore.WithUserAccessToken(*tokenResp.Data.AccessToken))
+	if err != nil {
+		return nil, fmt.Errorf("get oauth user info failed: %w", err)
+	}
+	if !infoResp.Success() {
+		return nil, newAPIError("get oauth user info", infoResp.ApiResp, infoResp.Code, infoResp.Msg)
+	}
+	d := infoResp.Data
+	if d == nil || d.OpenId == nil {
+		return nil, fmt.Errorf("get oauth user info: empty open_id")
+	}
+
+	user := &OAuthUser{
+		OpenID:    *d.OpenId,
+		UnionID:   deref(d.UnionId),
+		UserID:    deref(d.UserId),
+		Name:      deref(d.Name),
+		Email:     deref(d.EnterpriseEmail),
+		AvatarURL: deref(d.AvatarUrl),
+	}
+	if user.Email == "" {
+		user.Email = deref(d.Email)
+	}
+	return user, nil
+}
@@ -0,0 +1,305 @@
+package larkbot
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	maxRetries = 10               // upper bound on RateLimitConfig.MaxRetries
+	maxBackoff = 30 * time.Second // longest wait between retries, before jitter
+)
+
+// RateLimitConfig controls outbound throttling. Zero values fall back to
+// Lark's documented defaults (50 QPS per app, 5 QPS per chat).
+type RateLimitConfig struct {
+	AppQPS      float64
+	ChatQPS     float64
+	MaxRetries  int
+	BaseBackoff time.Duration
+}
+
+func (c RateLimitConfig) withDefaults() RateLimitConfig {
+	if c.AppQPS <= 0 {
+		c.AppQPS = 50
+	}
+	if c.ChatQPS <= 0 {
+		c.ChatQPS = 5
+	}
+	if c.MaxRetries < 0 {
+		c.MaxRetries = 0
+	}
+	if c.MaxRetries > maxRetries {
+		c.MaxRetries = maxRetries
+	}
+	if c.BaseBackoff <= 0 {
+		c.BaseBackoff = 500 * time.Millisecond
+	}
+	return c
+}
+
+// tokenBucket is a reservation-based token bucket: callers take a token
+// immediately and wait out any deficit, which keeps waiters in FIFO order.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve takes one token and returns how long the caller must wait for it.
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel returns a token whose reservation was abandoned.
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	b.tokens++
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.mu.Unlock()
+}
+
+// idle reports whether the bucket has refilled completely.
+func (b *tokenBucket) idle() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tokens+time.Since(b.last).Seconds()*b.rate >= b.burst
+}
+
+type chatLane struct {
+	bucket  *tokenBucket
+	pending int
+}
+
+// QueueStats is a snapshot of the outbound send queue.
+type QueueStats struct {
+	Pending     int            `json:"pending"`
+	InFlight    int            `json:"in_flight"`
+	PerChat     map[string]int `json:"per_chat"`
+	RateLimited uint64         `json:"rate_limited"`
+	Retries     uint64         `json:"retries"`
+	Failed      uint64         `json:"failed"`
+	Sent        uint64         `json:"sent"`
+}
+
+// SendQueue throttles outbound API calls with a per-app token bucket and a
+// per-chat token bucket, and retries throttled responses with backoff.
+type SendQueue struct {
+	cfg RateLimitConfig
+	app *tokenBucket
+
+	mu          sync.Mutex
+	lanes       map[string]*chatLane
+	pending     int
+	inFlight    int
+	rateLimited uint64
+	retries     uint64
+	failed      uint64
+	sent        uint64
+}
+
+func NewSendQueue(cfg RateLimitConfig) *SendQueue {
+	cfg = cfg.withDefaults()
+	return &SendQueue{
+		cfg:   cfg,
+		app:   newTokenBucket(cfg.AppQPS),
+		lanes: make(map[string]*chatLane),
+	}
+}
+
+// Do runs fn once both the app and chat buckets allow it. chatKey is the
+// receive ID of the target chat; an empty key applies only the app limit.
+// Throttled responses are retried up to MaxRetries times.
+func (q *SendQueue) Do(ctx context.Context, chatKey string, fn func(ctx context.Context) error) error {
+	lane := q.enter(chatKey)
+	defer q.leave(lane)
+
+	for attempt := 0; ; attempt++ {
+		if err := q.wait(ctx, lane); err != nil {
+			q.count(&q.failed)
+			return err
+		}
+
+		q.mu.Lock()
+		q.inFlight++
+		q.mu.Unlock()
+		err := fn(ctx)
+		q.mu.Lock()
+		q.inFlight--
+		q.mu.Unlock()
+
+		if err == nil {
+			q.count(&q.sent)
+			return nil
+		}
+		if !IsRateLimited(err) {
+			q.count(&q.failed)
+			return err
+		}
+		q.count(&q.rateLimited)
+		if attempt >= q.cfg.MaxRetries {
+			q.count(&q.failed)
+			return err
+		}
+		q.count(&q.retries)
+		if err := sleepCtx(ctx, q.backoff(attempt)); err != nil {
+			q.count(&q.failed)
+			return err
+		}
+	}
+}
+
+// Retry runs fn without token-bucket throttling, retrying throttled
+// responses with backoff. It is used for read APIs such as contact lookups.
+func (q *SendQueue) Retry(ctx context.Context, fn func(ctx context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || !IsRateLimited(err) {
+			return err
+		}
+		q.count(&q.rateLimited)
+		if attempt >= q.cfg.MaxRetries {
+			return err
+		}
+		q.count(&q.retries)
+		if err := sleepCtx(ctx, q.backoff(attempt)); err != nil {
+			return err
+		}
+	}
+}
+
+// Stats returns the current queue depth and counters.
+func (q *SendQueue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := QueueStats{
+		Pending:     q.pending,
+		InFlight:    q.inFlight,
+		PerChat:     make(map[string]int),
+		RateLimited: q.rateLimited,
+		Retries:     q.retries,
+		Failed:      q.failed,
+		Sent:        q.sent,
+	}
+	for key, lane := range q.lanes {
+		if lane.pending > 0 {
+			stats.PerChat[key] = lane.pending
+		}
+	}
+	return stats
+}
+
+func (q *SendQueue) enter(chatKey string) *chatLane {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pending++
+	if chatKey == "" {
+		return nil
+	}
+	lane, ok := q.lanes[chatKey]
+	if !ok {
+		lane = &chatLane{bucket: newTokenBucket(q.cfg.ChatQPS)}
+		q.lanes[chatKey] = lane
+	}
+	lane.pending++
+	return lane
+}
+
+func (q *SendQueue) leave(lane *chatLane) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pending--
+	if lane == nil {
+		return
+	}
+	lane.pending--
+	// Drop lanes once they have nothing queued and their bucket has refilled,
+	// so the map does not grow with every chat ever addressed.
+	for key, l := range q.lanes {
+		if l.pending == 0 && l.bucket.idle() {
+			delete(q.lanes, key)
+		}
+	}
+}
+
+// wait reserves the chat lane first and takes an app token only once the
+// chat's own delay has passed, so a busy chat does not hold app tokens that
+// sends to other chats could use.
+func (q *SendQueue) wait(ctx context.Context, lane *chatLane) error {
+	if lane != nil {
+		if err := sleepCtx(ctx, lane.bucket.reserve()); err != nil {
+			lane.bucket.cancel()
+			return err
+		}
+	}
+	if err := sleepCtx(ctx, q.app.reserve()); err != nil {
+		q.app.cancel()
+		if lane != nil {
+			lane.bucket.cancel()
+		}
+		return err
+	}
+	return nil
+}
+
+func (q *SendQueue) backoff(attempt int) time.Duration {
+	d := q.cfg.BaseBackoff
+	for i := 0; i < attempt && d < maxBackoff; i++ {
+		d <<= 1
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	// Add up to 20% jitter so concurrent senders don't retry in lockstep.
+	return d + time.Duration(rand.Int63n(int64(d)/5+1))
+}
+
+func (q *SendQueue) count(counter *uint64) {
+	q.mu.Lock()
+	*counter++
+	q.mu.Unlock()
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
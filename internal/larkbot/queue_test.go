@@ -0,0 +1,141 @@
+package larkbot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketReserve(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     float64
+		reserves int
+		cancels  int
+		wantLast time.Duration // wait returned by the last reserve, within 10ms
+	}{
+		{"within burst", 5, 5, 0, 0},
+		{"first over burst", 5, 6, 0, 200 * time.Millisecond},
+		{"second over burst", 5, 7, 0, 400 * time.Millisecond},
+		{"cancel returns the token", 5, 6, 1, 0},
+		{"fractional rate has burst one", 0.5, 2, 0, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTokenBucket(tt.rate)
+			for i := 0; i < tt.reserves-1; i++ {
+				b.reserve()
+			}
+			for i := 0; i < tt.cancels; i++ {
+				b.cancel()
+			}
+			got := b.reserve()
+			if diff := got - tt.wantLast; diff < -10*time.Millisecond || diff > 10*time.Millisecond {
+				t.Errorf("reserve() = %v, want %v", got, tt.wantLast)
+			}
+		})
+	}
+}
+
+func TestTokenBucketCancelCapsAtBurst(t *testing.T) {
+	b := newTokenBucket(2)
+	b.cancel()
+	b.cancel()
+	b.reserve()
+	b.reserve()
+	if got := b.reserve(); got <= 0 {
+		t.Errorf("reserve() after burst = %v, want a wait", got)
+	}
+}
+
+func TestSendQueueWaitCancelReturnsTokens(t *testing.T) {
+	q := NewSendQueue(RateLimitConfig{AppQPS: 100, ChatQPS: 1})
+	lane := q.enter("oc_1")
+	defer q.leave(lane)
+	lane.bucket.reserve() // chat is now busy for a second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := q.wait(ctx, lane); !errors.Is(err, context.Canceled) {
+		t.Fatalf("wait() = %v, want context.Canceled", err)
+	}
+	// The app token must not have been taken while the chat was busy.
+	if !q.app.idle() {
+		t.Error("app bucket lost a token to a cancelled wait on a busy chat")
+	}
+}
+
+func TestSendQueueRetry(t *testing.T) {
+	rateLimited := &APIError{Op: "send", Code: 230020}
+	tests := []struct {
+		name       string
+		maxRetries int
+		errs       []error // returned by successive calls; nil after the list ends
+		wantCalls  int
+		wantErr    error
+	}{
+		{"success", 3, nil, 1, nil},
+		{"retried then sent", 3, []error{rateLimited, rateLimited}, 3, nil},
+		{"retries exhausted", 2, []error{rateLimited, rateLimited, rateLimited}, 3, rateLimited},
+		{"no retries", 0, []error{rateLimited}, 1, rateLimited},
+		{"other errors are not retried", 3, []error{&APIError{Op: "send", Code: 230002}}, 1, &APIError{Op: "send", Code: 230002}},
+		{"429 is retried", 3, []error{&APIError{Op: "send", HTTPStatus: http.StatusTooManyRequests}}, 2, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewSendQueue(RateLimitConfig{MaxRetries: tt.maxRetries, BaseBackoff: time.Millisecond})
+			calls := 0
+			err := q.Do(context.Background(), "oc_1", func(ctx context.Context) error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if (err == nil) != (tt.wantErr == nil) || (err != nil && err.Error() != tt.wantErr.Error()) {
+				t.Errorf("Do() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSendQueueBackoff(t *testing.T) {
+	q := NewSendQueue(RateLimitConfig{BaseBackoff: time.Second})
+	tests := []struct {
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{0, time.Second, 1200 * time.Millisecond},
+		{2, 4 * time.Second, 4800 * time.Millisecond},
+		{5, maxBackoff, maxBackoff * 6 / 5},
+		{40, maxBackoff, maxBackoff * 6 / 5},
+		{100, maxBackoff, maxBackoff * 6 / 5},
+	}
+	for _, tt := range tests {
+		if got := q.backoff(tt.attempt); got < tt.min || got > tt.max {
+			t.Errorf("backoff(%d) = %v, want within [%v, %v]", tt.attempt, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestRateLimitConfigMaxRetries(t *testing.T) {
+	tests := []struct {
+		in, want int
+	}{
+		{-1, 0},
+		{0, 0},
+		{3, 3},
+		{1000, maxRetries},
+	}
+	for _, tt := range tests {
+		if got := (RateLimitConfig{MaxRetries: tt.in}).withDefaults().MaxRetries; got != tt.want {
+			t.Errorf("MaxRetries %d => %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
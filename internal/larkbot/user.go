@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"sync"
 
 	larkcontact "github.com/larksuite/oapi-sdk-go/v3/service/contact/v3"
 )
@@ -26,60 +25,11 @@ type UserInfo struct {
 	Gender          int
 	LeaderUserID    string
 	DepartmentIDs   string // JSON array string of department IDs
-	DepartmentNames string // JSON array string of department names
+	DepartmentNames string // JSON array string of department names, filled in from the local directory
 	CustomAttrs     string // JSON string
 	JoinTime        int64
-}
-
-// department name cache
-var (
-	deptCache   = make(map[string]string)
-	deptCacheMu sync.RWMutex
-)
-
-// GetDepartmentName returns the department name for a given department ID.
-func (c *LarkClient) GetDepartmentName(ctx context.Context, deptID string) (string, error) {
-	if deptID == "" || deptID == "0" {
-		return "", nil
-	}
-
-	deptCacheMu.RLock()
-	if name, ok := deptCache[deptID]; ok {
-		deptCacheMu.RUnlock()
-		return name, nil
-	}
-	deptCacheMu.RUnlock()
-
-	req := larkcontact.NewGetDepartmentReqBuilder().
-		DepartmentId(deptID).
-		DepartmentIdType("open_department_id").
-		Build()
-
-	resp, err := c.Client.Contact.Department.Get(ctx, req)
-	if err != nil {
-		// Cache the fallback to avoid repeated failed API calls
-		deptCacheMu.Lock()
-		deptCache[deptID] = deptID
-		deptCacheMu.Unlock()
-		return deptID, fmt.Errorf("get department failed: %w", err)
-	}
-	if !resp.Success() {
-		deptCacheMu.Lock()
-		deptCache[deptID] = deptID
-		deptCacheMu.Unlock()
-		return deptID, fmt.Errorf("get department error: code=%d, msg=%s", resp.Code, resp.Msg)
-	}
-
-	name := deref(resp.Data.Department.Name)
-	if name == "" {
-		name = deptID
-	}
-
-	deptCacheMu.Lock()
-	deptCache[deptID] = name
-	deptCacheMu.Unlock()
-
-	return name, nil
+	Frozen          bool // account suspended
+	Resigned        bool // left the organization
 }
 
 // GetUserInfo retrieves user info by open_id via the Lark API.
@@ -94,17 +44,73 @@ func (c *LarkClient) GetUserInfo(ctx context.Context, openID string) (*UserInfo,
 		UserIdType("open_id").
 		Build()
 
-	resp, err := c.Client.Contact.User.Get(ctx, req)
+	var resp *larkcontact.GetUserResp
+	err := c.queue.Retry(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = c.Client.Contact.User.Get(ctx, req)
+		if err != nil {
+			return fmt.Errorf("get user info failed: %w", err)
+		}
+		if !resp.Success() {
+			return newAPIError("get user info", resp.ApiResp, resp.Code, resp.Msg)
+		}
+		return nil
+	})
+	if err != nil {
+		return &UserInfo{OpenID: openID, Name: openID}, err
+	}
+
+	info := userInfo(resp.Data.User)
+	info.OpenID = openID
+	return info, nil
+}
+
+// ListDepartmentUsers returns one page of the users directly in a
+// department, with the token of the next page, empty on the last page.
+func (c *LarkClient) ListDepartmentUsers(ctx context.Context, deptID, pageToken string) ([]*UserInfo, string, error) {
+	builder := larkcontact.NewFindByDepartmentUserReqBuilder().
+		DepartmentId(deptID).
+		DepartmentIdType("open_department_id").
+		UserIdType("open_id").
+		PageSize(50)
+	if pageToken != "" {
+		builder.PageToken(pageToken)
+	}
+	req := builder.Build()
+
+	var resp *larkcontact.FindByDepartmentUserResp
+	err := c.queue.Retry(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = c.Client.Contact.User.FindByDepartment(ctx, req)
+		if err != nil {
+			return fmt.Errorf("list department users failed: %w", err)
+		}
+		if !resp.Success() {
+			return newAPIError("list department users", resp.ApiResp, resp.Code, resp.Msg)
+		}
+		return nil
+	})
 	if err != nil {
-		return &UserInfo{OpenID: openID, Name: openID}, fmt.Errorf("get user info failed: %w", err)
+		return nil, "", err
 	}
-	if !resp.Success() {
-		return &UserInfo{OpenID: openID, Name: openID}, fmt.Errorf("get user info error: code=%d, msg=%s", resp.Code, resp.Msg)
+
+	users := make([]*UserInfo, 0, len(resp.Data.Items))
+	for _, item := range resp.Data.Items {
+		if info := userInfo(item); info.OpenID != "" {
+			users = append(users, info)
+		}
 	}
+	next := ""
+	if resp.Data.HasMore != nil && *resp.Data.HasMore {
+		next = deref(resp.Data.PageToken)
+	}
+	return users, next, nil
+}
 
-	user := resp.Data.User
+// userInfo converts a Lark contact user.
+func userInfo(user *larkcontact.User) *UserInfo {
 	info := &UserInfo{
-		OpenID: openID,
+		OpenID: deref(user.OpenId),
 		Name:   deref(user.Name),
 		EnName: deref(user.EnName),
 	}
@@ -146,19 +152,6 @@ func (c *LarkClient) GetUserInfo(ctx context.Context, openID string) (*UserInfo,
 		if b, err := json.Marshal(user.DepartmentIds); err == nil {
 			info.DepartmentIDs = string(b)
 		}
-		// Resolve department names
-		var names []string
-		for _, deptID := range user.DepartmentIds {
-			name, err := c.GetDepartmentName(ctx, deptID)
-			if err != nil {
-				names = append(names, deptID)
-			} else {
-				names = append(names, name)
-			}
-		}
-		if b, err := json.Marshal(names); err == nil {
-			info.DepartmentNames = string(b)
-		}
 	}
 	if len(user.CustomAttrs) > 0 {
 		if b, err := json.Marshal(user.CustomAttrs); err == nil {
@@ -168,6 +161,9 @@ func (c *LarkClient) GetUserInfo(ctx context.Context, openID string) (*UserInfo,
 	if user.JoinTime != nil {
 		info.JoinTime = int64(*user.JoinTime)
 	}
-
-	return info, nil
+	if user.Status != nil {
+		info.Frozen = user.Status.IsFrozen != nil && *user.Status.IsFrozen
+		info.Resigned = user.Status.IsResigned != nil && *user.Status.IsResigned
+	}
+	return info
 }
@@ -0,0 +1,81 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Account sources.
+const (
+	SourceLocal = "local"
+	SourceLark  = "lark"
+)
+
+// Admin console roles, in increasing order of privilege.
+const (
+	RoleViewer   = "viewer"   // read-only access
+	RoleOperator = "operator" // can send messages and manage rules, tasks and campaigns
+	RoleAdmin    = "admin"    // full access, including admin user management
+)
+
+var roleLevel = map[string]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+// ValidRole reports whether role is a known role.
+func ValidRole(role string) bool {
+	_, ok := roleLevel[role]
+	return ok
+}
+
+// AdminUser is an account of the admin console.
+type AdminUser struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	Username     string     `gorm:"size:100;uniqueIndex;not null" json:"username"`
+	DisplayName  string     `gorm:"size:255" json:"display_name"`
+	PasswordHash string     `gorm:"size:255;not null" json:"-"`
+	Role         string     `gorm:"size:20;not null;default:viewer" json:"role"`  // viewer, operator, admin
+	ChatScopes   string     `gorm:"type:text" json:"chat_scopes"`                 // JSON array of chat_ids; empty = all chats
+	LarkOpenID   string     `gorm:"size:100;index" json:"lark_open_id"`           // Lark account that may log in as this user via SSO
+	Source       string     `gorm:"size:20;not null;default:local" json:"source"` // "local", or "lark" for accounts created by SSO
+	Enabled      bool       `gorm:"default:true" json:"enabled"`
+	TOTPSecret   string     `gorm:"size:64" json:"-"` // base32; set on setup, in use once TOTPEnabled
+	TOTPEnabled  bool       `json:"totp_enabled"`     // logins also need a TOTP code
+	TOTPLastStep int64      `json:"-"`                // time step of the last accepted code, against replay
+	LastLoginAt  *time.Time `json:"last_login_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// HasRole reports whether the user's role is at least min.
+func (u *AdminUser) HasRole(min string) bool {
+	return roleLevel[u.Role] >= roleLevel[min]
+}
+
+// Scopes returns the chat_ids the user is limited to. nil means all chats.
+// Admins are never limited.
+func (u *AdminUser) Scopes() []string {
+	if u.Role == RoleAdmin || u.ChatScopes == "" {
+		return nil
+	}
+	ids := []string{}
+	if err := json.Unmarshal([]byte(u.ChatScopes), &ids); err != nil {
+		return []string{} // malformed scopes grant no chats rather than all of them
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return ids
+}
+
+// CanAccessChat reports whether the user may read or send to chatID.
+func (u *AdminUser) CanAccessChat(chatID string) bool {
+	scopes := u.Scopes()
+	if scopes == nil {
+		return true
+	}
+	for _, id := range scopes {
+		if id == chatID {
+			return true
+		}
+	}
+	return false
+}
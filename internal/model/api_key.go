@@ -0,0 +1,71 @@
+package model
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// SourceAPIKey marks the AdminUser stand-in of a request authenticated by an API key.
+const SourceAPIKey = "api_key"
+
+// APIKey grants machine clients access to the REST API. Only the SHA-256 hash
+// of the key is stored; the key itself is shown once when it is created.
+type APIKey struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	Name       string     `gorm:"size:100;not null" json:"name"`
+	Prefix     string     `gorm:"size:20" json:"prefix"` // first characters of the key, to recognize it
+	KeyHash    string     `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	Role       string     `gorm:"size:20;not null;default:operator" json:"role"` // viewer or operator
+	Endpoints  string     `gorm:"type:text" json:"endpoints"`                    // JSON array of "METHOD /api/path" patterns
+	ChatScopes string     `gorm:"type:text" json:"chat_scopes"`                  // JSON array of chat_ids; empty = all chats
+	CreatedBy  string     `gorm:"size:100" json:"created_by"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Active reports whether the key is neither revoked nor expired.
+func (k *APIKey) Active() bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || time.Now().Before(*k.ExpiresAt))
+}
+
+// EndpointPatterns returns the key's endpoint patterns.
+func (k *APIKey) EndpointPatterns() []string {
+	var patterns []string
+	json.Unmarshal([]byte(k.Endpoints), &patterns)
+	return patterns
+}
+
+// AllowsEndpoint reports whether the key may call method on route, the
+// registered gin route such as "/api/scheduled-tasks/:id/run". A pattern is
+// "METHOD /path" or just "/path" for any method; "*" as the method also
+// matches any, and a path ending in "/*" matches everything below it.
+func (k *APIKey) AllowsEndpoint(method, route string) bool {
+	for _, pattern := range k.EndpointPatterns() {
+		patternMethod, path := "*", pattern
+		if i := strings.IndexByte(pattern, ' '); i >= 0 {
+			patternMethod, path = pattern[:i], strings.TrimSpace(pattern[i+1:])
+		}
+		if patternMethod != "*" && !strings.EqualFold(patternMethod, method) {
+			continue
+		}
+		if path == route || (strings.HasSuffix(path, "/*") && strings.HasPrefix(route, path[:len(path)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// Principal returns the AdminUser the key acts as, so role and chat scope
+// checks apply to keys the same way as to console users.
+func (k *APIKey) Principal() *AdminUser {
+	return &AdminUser{
+		Username:   "api-key:" + k.Name,
+		Role:       k.Role,
+		ChatScopes: k.ChatScopes,
+		Source:     SourceAPIKey,
+		Enabled:    true,
+	}
+}
@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+// AuditEntry records one change made through the admin console or API.
+type AuditEntry struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	Actor      string    `gorm:"size:100;index" json:"actor"` // username, or "api-key:<name>"
+	ActorType  string    `gorm:"size:20" json:"actor_type"`   // "user" or "api_key"
+	Action     string    `gorm:"size:50;index" json:"action"` // e.g. "message.recall", "auto_reply_rule.update"
+	Method     string    `gorm:"size:10" json:"method"`       // HTTP method, or "WS" for WebSocket actions
+	Route      string    `gorm:"size:200" json:"route"`       // e.g. "/api/auto-reply-rules/:id"
+	TargetType string    `gorm:"size:50;index" json:"target_type"`
+	TargetID   string    `gorm:"size:100;index" json:"target_id"`
+	Status     int       `json:"status"`                  // HTTP status of the response
+	Before     string    `gorm:"type:text" json:"before"` // JSON of the target before the change
+	After      string    `gorm:"type:text" json:"after"`  // JSON of the target after the change
+	Diff       string    `gorm:"type:text" json:"diff"`   // JSON object of changed fields: {"field": {"from": x, "to": y}}
+	IP         string    `gorm:"size:64" json:"ip"`
+	UserAgent  string    `gorm:"size:500" json:"user_agent"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+}
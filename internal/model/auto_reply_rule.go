@@ -12,7 +12,10 @@ type AutoReplyRule struct {
 	ReplyText string         `gorm:"type:text;not null" json:"reply_text"`
 	MatchMode string         `gorm:"size:20;not null;default:contains" json:"match_mode"` // exact, contains, prefix
 	ChatID      string         `gorm:"size:100;index" json:"chat_id"`                         // empty = all chats
+	GroupSelector string       `gorm:"type:text" json:"group_selector"`                       // JSON GroupSelector; limits the rule to matching groups
 	TriggerMode string         `gorm:"size:20;not null;default:any" json:"trigger_mode"`       // any, at_bot, p2p_only
+	MentionUser string         `gorm:"size:500" json:"mention_user"`                           // comma-separated open_ids that must be mentioned
+	ReplyInThread bool         `gorm:"default:false" json:"reply_in_thread"`
 	Enabled     bool           `gorm:"default:true" json:"enabled"`
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
@@ -0,0 +1,46 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Campaign is a broadcast of one message to many chats or users.
+type Campaign struct {
+	ID             uint           `gorm:"primaryKey" json:"id"`
+	Name           string         `gorm:"size:255;not null" json:"name"`
+	MsgType        string         `gorm:"size:20;not null;default:text" json:"msg_type"`
+	Content        string         `gorm:"type:text;not null" json:"content"`
+	TargetType     string         `gorm:"size:20;not null" json:"target_type"` // chats, all_groups, users, departments, group_selector
+	TargetIDs      string         `gorm:"type:text" json:"target_ids"`         // JSON array of chat_ids or open_ids
+	TargetSelector string         `gorm:"type:text" json:"target_selector"`    // JSON GroupSelector, for group_selector
+	ThrottleMs     int            `gorm:"default:1000" json:"throttle_ms"`     // delay between two sends
+	ScheduledAt    *time.Time     `json:"scheduled_at"`
+	Status         string         `gorm:"size:20;not null;default:draft;index" json:"status"` // draft, scheduled, running, paused, completed, cancelled, recalling, recalled
+	Total          int            `json:"total"`
+	SentCount      int            `json:"sent_count"`
+	FailedCount    int            `json:"failed_count"`
+	RecalledCount  int            `json:"recalled_count"`
+	StartedAt      *time.Time     `json:"started_at"`
+	StartedBy      string         `gorm:"size:100" json:"started_by"` // admin username, or "scheduler" for scheduled campaigns
+	FinishedAt     *time.Time     `json:"finished_at"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// CampaignRecipient tracks delivery of a campaign to a single chat or user.
+type CampaignRecipient struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	CampaignID    uint       `gorm:"not null;index" json:"campaign_id"`
+	ReceiveID     string     `gorm:"size:100;not null" json:"receive_id"`
+	ReceiveIDType string     `gorm:"size:20;not null" json:"receive_id_type"` // chat_id or open_id
+	Name          string     `gorm:"size:255" json:"name"`
+	Status        string     `gorm:"size:20;not null;default:pending;index" json:"status"` // pending, sent, failed, recalled
+	MessageID     string     `gorm:"size:100" json:"message_id"`
+	Error         string     `gorm:"type:text" json:"error"`
+	SentAt        *time.Time `json:"sent_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
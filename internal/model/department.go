@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// Department is a node of the organization chart synced from the Lark contact API.
+type Department struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	OpenDepartmentID string    `gorm:"size:100;uniqueIndex;not null" json:"open_department_id"`
+	DepartmentID     string    `gorm:"size:100" json:"department_id"`
+	ParentID         string    `gorm:"size:100;index" json:"parent_id"` // open_department_id of the parent; "0" for top-level
+	Name             string    `gorm:"size:255" json:"name"`
+	LeaderUserID     string    `gorm:"size:100" json:"leader_user_id"`
+	ChatID           string    `gorm:"size:100" json:"chat_id"`
+	MemberCount      int       `json:"member_count"` // as reported by Lark, including sub-departments
+	SortOrder        int64     `json:"sort_order"`
+	Path             string    `gorm:"size:2000;index" json:"path"` // "/<top>/.../<self>/", for subtree queries
+	SyncedAt         time.Time `json:"synced_at"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// UserDepartment links a user to each department they belong to.
+type UserDepartment struct {
+	OpenID           string `gorm:"primaryKey;size:100" json:"open_id"`
+	OpenDepartmentID string `gorm:"primaryKey;size:100;index" json:"open_department_id"`
+}
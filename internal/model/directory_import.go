@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+// DirectoryImport is a walk over every department of the Lark directory that
+// imports its users. Progress is saved after each page so an interrupted
+// import continues where it stopped.
+type DirectoryImport struct {
+	ID                uint       `gorm:"primaryKey" json:"id"`
+	Status            string     `gorm:"size:20;not null;index" json:"status"` // running, paused, completed, failed
+	Departments       string     `gorm:"type:text" json:"-"`                   // JSON array of open_department_ids to walk, fixed when the walk starts
+	DepartmentTotal   int        `json:"department_total"`
+	DepartmentDone    int        `json:"department_done"`   // departments walked; the next one is Departments[DepartmentDone]
+	PageToken         string     `gorm:"size:500" json:"-"` // next page of the current department
+	Imported          int        `json:"imported"`
+	Skipped           int        `json:"skipped"` // already imported from another department
+	Failed            int        `json:"failed"`
+	FailedIDs         string     `gorm:"type:text" json:"failed_ids"`         // JSON array of open_ids that could not be saved
+	FailedDepartments string     `gorm:"type:text" json:"failed_departments"` // JSON array of open_department_ids whose users could not be listed
+	Error             string     `gorm:"type:text" json:"error"`
+	StartedBy         string     `gorm:"size:100" json:"started_by"`
+	StartedAt         time.Time  `json:"started_at"`
+	FinishedAt        *time.Time `json:"finished_at"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
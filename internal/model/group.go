@@ -1,6 +1,11 @@
 package model
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type Group struct {
 	ID          uint      `gorm:"primaryKey" json:"id"`
@@ -16,6 +21,114 @@ type Group struct {
 	BotCount    int       `json:"bot_count"`
 	External    bool      `json:"external"`
 	SyncedAt    time.Time `json:"synced_at"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+
+	// Managed locally and kept across syncs.
+	Tags        string `gorm:"type:text" json:"tags"`            // JSON array
+	Team        string `gorm:"size:100;index" json:"team"`       // owning team
+	Environment string `gorm:"size:20;index" json:"environment"` // e.g. prod, staging
+	Notes       string `gorm:"type:text" json:"notes"`
+	Attributes  string `gorm:"type:text" json:"attributes"` // JSON object of custom key/values
+
+	LeftAt    *time.Time     `json:"left_at"` // when the bot left or was removed
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"` // set with LeftAt; cleared when the bot rejoins
+}
+
+// TagList returns the group's tags.
+func (g *Group) TagList() []string {
+	var tags []string
+	if g.Tags != "" {
+		_ = json.Unmarshal([]byte(g.Tags), &tags)
+	}
+	return tags
+}
+
+// AttributeMap returns the group's custom attributes.
+func (g *Group) AttributeMap() map[string]string {
+	attrs := map[string]string{}
+	if g.Attributes != "" {
+		_ = json.Unmarshal([]byte(g.Attributes), &attrs)
+	}
+	return attrs
+}
+
+// GroupHistory records the bot joining or leaving a group.
+type GroupHistory struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	ChatID    string    `gorm:"size:100;index;not null" json:"chat_id"`
+	Name      string    `gorm:"size:255" json:"name"`
+	Action    string    `gorm:"size:20;not null" json:"action"` // joined, left, removed
+	Actor     string    `gorm:"size:100" json:"actor"`          // who made the bot leave; empty for syncs and events
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GroupSelector picks groups by their locally managed attributes, for tasks,
+// rules and campaigns that target "every prod group of team X" rather than
+// fixed chats. A group must match every field that is set.
+type GroupSelector struct {
+	Tags        []string          `json:"tags,omitempty"` // the group carries all of these tags
+	Team        string            `json:"team,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"` // custom attributes with these values
+}
+
+// ParseGroupSelector decodes a stored selector. An empty string is no selector.
+func ParseGroupSelector(raw string) (*GroupSelector, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var sel GroupSelector
+	if err := json.Unmarshal([]byte(raw), &sel); err != nil {
+		return nil, err
+	}
+	return &sel, nil
+}
+
+// IsEmpty reports whether the selector sets no condition.
+func (s *GroupSelector) IsEmpty() bool {
+	return s == nil || (len(s.Tags) == 0 && s.Team == "" && s.Environment == "" && len(s.Attributes) == 0)
+}
+
+// Matches reports whether g meets every condition. An empty selector matches
+// nothing, so a cleared selector never widens to all groups.
+func (s *GroupSelector) Matches(g *Group) bool {
+	if s.IsEmpty() {
+		return false
+	}
+	if s.Team != "" && s.Team != g.Team {
+		return false
+	}
+	if s.Environment != "" && s.Environment != g.Environment {
+		return false
+	}
+	if len(s.Tags) > 0 {
+		have := make(map[string]bool)
+		for _, t := range g.TagList() {
+			have[t] = true
+		}
+		for _, t := range s.Tags {
+			if !have[t] {
+				return false
+			}
+		}
+	}
+	if len(s.Attributes) > 0 {
+		attrs := g.AttributeMap()
+		for k, v := range s.Attributes {
+			if got, ok := attrs[k]; !ok || got != v {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// String encodes the selector for storage; nil encodes as "".
+func (s *GroupSelector) String() string {
+	if s == nil {
+		return ""
+	}
+	b, _ := json.Marshal(s)
+	return string(b)
 }
@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+// Job is a long-running operation started from the console or the API, such
+// as a sync or an export. Its progress is saved while it runs so it can be
+// followed after the request that started it has returned.
+type Job struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	Kind       string     `gorm:"size:50;not null;index" json:"kind"`   // user_sync, chat_sync, audit_export, campaign_send, campaign_recall, directory_import
+	Status     string     `gorm:"size:20;not null;index" json:"status"` // running, succeeded, failed, cancelled
+	Params     string     `gorm:"type:text" json:"params"`              // JSON of what the job was started with
+	Total      int        `json:"total"`                                // 0 while unknown
+	Done       int        `json:"done"`
+	Message    string     `gorm:"size:500" json:"message"` // current step
+	Result     string     `gorm:"type:text" json:"result"` // JSON, set when the job ends
+	Error      string     `gorm:"type:text" json:"error"`
+	File       string     `gorm:"size:500" json:"-"` // path of the file produced by an export
+	CreatedBy  string     `gorm:"size:100;index" json:"created_by"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
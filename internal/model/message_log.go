@@ -12,8 +12,15 @@ type MessageLog struct {
 	Direction string    `gorm:"size:10;not null" json:"direction"` // "in" or "out"
 	MsgType   string    `gorm:"size:20" json:"msg_type"`
 	Content   string    `gorm:"type:text" json:"content"`
+	Summary   string    `gorm:"size:500" json:"summary"`         // readable one-line rendering of the content
+	Mentions  string    `gorm:"type:text" json:"mentions"`       // JSON array of mentioned users (key, open_id, name, is_bot, is_all)
+	RootID    string    `gorm:"size:100;index" json:"root_id"`   // root of the reply chain
+	ParentID  string    `gorm:"size:100" json:"parent_id"`       // message this one replies to
+	ThreadID  string    `gorm:"size:100;index" json:"thread_id"` // thread (topic) ID
 	HandledBy string    `gorm:"size:50" json:"handled_by"`
-	Source    string    `gorm:"size:20" json:"source"` // "event", "scheduled", "manual"
+	Source    string    `gorm:"size:20" json:"source"` // "event", "scheduled", "manual", "campaign", "edit"
 	Recalled bool      `gorm:"default:false" json:"recalled"`
+	Revision  int       `gorm:"default:0" json:"revision"` // number of edits; on "edit" rows, the revision they record
+	EditedAt  *time.Time `json:"edited_at"`
 	CreatedAt time.Time `json:"created_at"`
 }
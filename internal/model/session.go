@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+// Session is a login of an admin user. It lives as long as its refresh token
+// keeps being used; access tokens issued for it are rejected once it is revoked.
+type Session struct {
+	ID          string     `gorm:"primaryKey;size:64" json:"id"`
+	AdminUserID uint       `gorm:"not null;index" json:"admin_user_id"`
+	Username    string     `gorm:"size:100" json:"username"`
+	Method      string     `gorm:"size:20" json:"method"` // "password" or "lark"
+	IP          string     `gorm:"size:64" json:"ip"`
+	UserAgent   string     `gorm:"size:500" json:"user_agent"`
+	RefreshJTI  string     `gorm:"size:64" json:"-"` // ID of the only refresh token currently valid
+	LastUsedAt  time.Time  `json:"last_used_at"`
+	ExpiresAt   time.Time  `gorm:"index" json:"expires_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Active reports whether the session is neither revoked nor expired.
+func (s *Session) Active() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
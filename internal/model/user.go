@@ -1,6 +1,10 @@
 package model
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type User struct {
 	ID             uint      `gorm:"primaryKey" json:"id"`
@@ -22,9 +26,12 @@ type User struct {
 	DepartmentNames string    `gorm:"type:text" json:"department_names"`
 	CustomAttrs     string    `gorm:"type:text" json:"custom_attrs"`
 	JoinTime       int64     `json:"join_time"`
+	Frozen         bool       `gorm:"default:false" json:"frozen"`   // account suspended in Lark
+	LeftAt         *time.Time `json:"left_at"`                       // when the user left the organization
 	FirstSeen      time.Time `json:"first_seen"`
 	LastSeen       time.Time `json:"last_seen"`
 	MsgCount       int64     `json:"msg_count"`
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"` // set with LeftAt; departed users are hidden from lists and targeting
 }
@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+// UserActivity counts a user's messages in one chat during one hour, rolled
+// up from message logs so statistics survive log cleanup. Day and Hour are
+// in the server's time zone.
+type UserActivity struct {
+	OpenID       string  `gorm:"size:100;primaryKey" json:"open_id"`
+	Day          string  `gorm:"size:10;primaryKey;index" json:"day"` // 2006-01-02
+	Hour         int     `gorm:"primaryKey;autoIncrement:false" json:"hour"`
+	ChatID       string  `gorm:"size:100;primaryKey" json:"chat_id"`
+	Messages     int64   `json:"messages"`
+	Responses    int64   `json:"responses"`     // messages answering a message of the bot
+	ResponseSecs float64 `json:"response_secs"` // total time those answers took
+}
+
+// ActivityCheckpoint records the last message log rolled up into
+// UserActivity.
+type ActivityCheckpoint struct {
+	Name      string    `gorm:"size:50;primaryKey" json:"name"`
+	LastLogID uint      `json:"last_log_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
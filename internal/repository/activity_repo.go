@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"time"
+
+	"lark-robot/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// activityCheckpoint names the checkpoint of the user activity rollup.
+const activityCheckpoint = "user_activity"
+
+type ActivityRepo struct {
+	db *gorm.DB
+}
+
+func NewActivityRepo(db *gorm.DB) *ActivityRepo {
+	return &ActivityRepo{db: db}
+}
+
+// Checkpoint returns the rollup checkpoint, zero if nothing was rolled up yet.
+func (r *ActivityRepo) Checkpoint() (model.ActivityCheckpoint, error) {
+	cp := model.ActivityCheckpoint{Name: activityCheckpoint}
+	err := r.db.Where("name = ?", activityCheckpoint).Limit(1).Find(&cp).Error
+	return cp, err
+}
+
+// LogsAfter returns up to limit message logs with IDs above afterID, oldest
+// first. Edit revisions are skipped.
+func (r *ActivityRepo) LogsAfter(afterID uint, limit int) ([]model.MessageLog, error) {
+	var logs []model.MessageLog
+	err := r.db.Select("id", "chat_id", "sender_id", "direction", "source", "created_at").
+		Where("id > ?", afterID).
+		Order("id").Limit(limit).Find(&logs).Error
+	return logs, err
+}
+
+// PreviousInChat returns the message before beforeID in a chat, nil if
+// there is none.
+func (r *ActivityRepo) PreviousInChat(chatID string, beforeID uint) (*model.MessageLog, error) {
+	var logs []model.MessageLog
+	err := r.db.Select("id", "direction", "created_at").
+		Where("chat_id = ? AND id < ? AND source <> ?", chatID, beforeID, "edit").
+		Order("id desc").Limit(1).Find(&logs).Error
+	if err != nil || len(logs) == 0 {
+		return nil, err
+	}
+	return &logs[0], nil
+}
+
+// Apply adds rows to the rollup and moves the checkpoint to lastLogID in
+// one transaction, so a batch is never counted twice.
+func (r *ActivityRepo) Apply(rows []model.UserActivity, lastLogID uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if len(rows) > 0 {
+			err := tx.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "open_id"}, {Name: "day"}, {Name: "hour"}, {Name: "chat_id"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{
+					"messages":      gorm.Expr("user_activities.messages + excluded.messages"),
+					"responses":     gorm.Expr("user_activities.responses + excluded.responses"),
+					"response_secs": gorm.Expr("user_activities.response_secs + excluded.response_secs"),
+				}),
+			}).CreateInBatches(rows, 200).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Save(&model.ActivityCheckpoint{
+			Name:      activityCheckpoint,
+			LastLogID: lastLogID,
+			UpdatedAt: time.Now(),
+		}).Error
+	})
+}
+
+// ActivityFilter selects rollup rows.
+type ActivityFilter struct {
+	OpenID  string   // empty = all users
+	Since   string   // first day included, 2006-01-02
+	ChatIDs []string // if non-nil, only these chats
+}
+
+func (r *ActivityRepo) filtered(f ActivityFilter) *gorm.DB {
+	tx := r.db.Model(&model.UserActivity{}).Where("user_activities.day >= ?", f.Since)
+	if f.OpenID != "" {
+		tx = tx.Where("user_activities.open_id = ?", f.OpenID)
+	}
+	if f.ChatIDs != nil {
+		tx = tx.Where("user_activities.chat_id IN ?", f.ChatIDs)
+	}
+	return tx
+}
+
+// DayCount is the number of messages on one day.
+type DayCount struct {
+	Day      string `json:"day"`
+	Messages int64  `json:"messages"`
+}
+
+// Daily returns message counts per day, for days with messages.
+func (r *ActivityRepo) Daily(f ActivityFilter) ([]DayCount, error) {
+	var rows []DayCount
+	err := r.filtered(f).Select("day, SUM(messages) AS messages").
+		Group("day").Order("day").Scan(&rows).Error
+	return rows, err
+}
+
+// HourCount is the number of messages in one hour of the day.
+type HourCount struct {
+	Hour     int   `json:"hour"`
+	Messages int64 `json:"messages"`
+}
+
+// Hours returns message counts per hour of the day, for hours with messages.
+func (r *ActivityRepo) Hours(f ActivityFilter) ([]HourCount, error) {
+	var rows []HourCount
+	err := r.filtered(f).Select("hour, SUM(messages) AS messages").
+		Group("hour").Order("hour").Scan(&rows).Error
+	return rows, err
+}
+
+// ChatCount is the number of messages in one chat.
+type ChatCount struct {
+	ChatID   string `json:"chat_id"`
+	Name     string `json:"name"` // group name; empty for direct chats
+	Messages int64  `json:"messages"`
+}
+
+// Chats returns message counts per chat, busiest first.
+func (r *ActivityRepo) Chats(f ActivityFilter, limit int) ([]ChatCount, error) {
+	var rows []ChatCount
+	err := r.filtered(f).
+		Select("user_activities.chat_id, MAX(groups.name) AS name, SUM(user_activities.messages) AS messages").
+		Joins("LEFT JOIN groups ON groups.chat_id = user_activities.chat_id").
+		Group("user_activities.chat_id").Order("messages desc").Limit(limit).Scan(&rows).Error
+	return rows, err
+}
+
+// ResponseTotals sums the answers to the bot and the time they took.
+func (r *ActivityRepo) ResponseTotals(f ActivityFilter) (responses int64, seconds float64, err error) {
+	var row struct {
+		Responses    int64
+		ResponseSecs float64
+	}
+	err = r.filtered(f).
+		Select("COALESCE(SUM(responses), 0) AS responses, COALESCE(SUM(response_secs), 0) AS response_secs").
+		Scan(&row).Error
+	return row.Responses, row.ResponseSecs, err
+}
+
+// LeaderboardEntry is a user's activity over a period.
+type LeaderboardEntry struct {
+	OpenID     string `json:"open_id"`
+	Name       string `json:"name"`
+	Avatar     string `json:"avatar"`
+	Messages   int64  `json:"messages"`
+	Chats      int64  `json:"chats"`
+	ActiveDays int64  `json:"active_days"`
+}
+
+// leaderboardOrders maps the leaderboard ranking to its ORDER BY.
+var leaderboardOrders = map[string]string{
+	"messages":    "messages desc, active_days desc",
+	"chats":       "chats desc, messages desc",
+	"active_days": "active_days desc, messages desc",
+}
+
+// Leaderboard ranks current users by "messages", "chats" or "active_days".
+func (r *ActivityRepo) Leaderboard(f ActivityFilter, by string, limit int) ([]LeaderboardEntry, error) {
+	order, ok := leaderboardOrders[by]
+	if !ok {
+		order = leaderboardOrders["messages"]
+	}
+	var rows []LeaderboardEntry
+	err := r.filtered(f).
+		Select("user_activities.open_id, users.name, users.avatar, " +
+			"SUM(user_activities.messages) AS messages, " +
+			"COUNT(DISTINCT user_activities.chat_id) AS chats, " +
+			"COUNT(DISTINCT user_activities.day) AS active_days").
+		Joins("JOIN users ON users.open_id = user_activities.open_id AND users.deleted_at IS NULL").
+		Group("user_activities.open_id, users.name, users.avatar").
+		Order(order).Limit(limit).Scan(&rows).Error
+	return rows, err
+}
@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"time"
+
+	"lark-robot/internal/model"
+
+	"gorm.io/gorm"
+)
+
+type AdminUserRepo struct {
+	db *gorm.DB
+}
+
+func NewAdminUserRepo(db *gorm.DB) *AdminUserRepo {
+	return &AdminUserRepo{db: db}
+}
+
+func (r *AdminUserRepo) List() ([]model.AdminUser, error) {
+	var users []model.AdminUser
+	err := r.db.Order("id asc").Find(&users).Error
+	return users, err
+}
+
+func (r *AdminUserRepo) GetByID(id uint) (*model.AdminUser, error) {
+	var user model.AdminUser
+	err := r.db.First(&user, id).Error
+	return &user, err
+}
+
+func (r *AdminUserRepo) GetByUsername(username string) (*model.AdminUser, error) {
+	var user model.AdminUser
+	err := r.db.Where("username = ?", username).First(&user).Error
+	return &user, err
+}
+
+func (r *AdminUserRepo) GetByLarkOpenID(openID string) (*model.AdminUser, error) {
+	var user model.AdminUser
+	err := r.db.Where("lark_open_id = ?", openID).First(&user).Error
+	return &user, err
+}
+
+// UsernameExists reports whether username is taken.
+func (r *AdminUserRepo) UsernameExists(username string) bool {
+	var count int64
+	r.db.Model(&model.AdminUser{}).Where("username = ?", username).Count(&count)
+	return count > 0
+}
+
+func (r *AdminUserRepo) Create(user *model.AdminUser) error {
+	return r.db.Create(user).Error
+}
+
+func (r *AdminUserRepo) Update(user *model.AdminUser) error {
+	return r.db.Save(user).Error
+}
+
+func (r *AdminUserRepo) Delete(id uint) error {
+	return r.db.Delete(&model.AdminUser{}, id).Error
+}
+
+func (r *AdminUserRepo) Count() (int64, error) {
+	var count int64
+	err := r.db.Model(&model.AdminUser{}).Count(&count).Error
+	return count, err
+}
+
+// CountEnabledAdmins counts enabled users with the admin role.
+func (r *AdminUserRepo) CountEnabledAdmins() (int64, error) {
+	var count int64
+	err := r.db.Model(&model.AdminUser{}).
+		Where("role = ? AND enabled = ?", model.RoleAdmin, true).
+		Count(&count).Error
+	return count, err
+}
+
+func (r *AdminUserRepo) UpdateLastLogin(id uint, at time.Time) error {
+	return r.db.Model(&model.AdminUser{}).Where("id = ?", id).Update("last_login_at", at).Error
+}
+
+// UpdateTOTPStep records the time step of an accepted TOTP code, but only if
+// the stored step is still oldStep.
+func (r *AdminUserRepo) UpdateTOTPStep(id uint, oldStep, newStep int64) (bool, error) {
+	res := r.db.Model(&model.AdminUser{}).
+		Where("id = ? AND totp_last_step = ?", id, oldStep).
+		Update("totp_last_step", newStep)
+	return res.RowsAffected == 1, res.Error
+}
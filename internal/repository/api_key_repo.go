@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"time"
+
+	"lark-robot/internal/model"
+
+	"gorm.io/gorm"
+)
+
+type APIKeyRepo struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepo(db *gorm.DB) *APIKeyRepo {
+	return &APIKeyRepo{db: db}
+}
+
+// List returns all keys, newest first, including revoked ones.
+func (r *APIKeyRepo) List() ([]model.APIKey, error) {
+	var keys []model.APIKey
+	err := r.db.Order("id desc").Find(&keys).Error
+	return keys, err
+}
+
+func (r *APIKeyRepo) GetByID(id uint) (*model.APIKey, error) {
+	var key model.APIKey
+	err := r.db.First(&key, id).Error
+	return &key, err
+}
+
+func (r *APIKeyRepo) GetByHash(hash string) (*model.APIKey, error) {
+	var key model.APIKey
+	err := r.db.Where("key_hash = ?", hash).First(&key).Error
+	return &key, err
+}
+
+func (r *APIKeyRepo) Create(key *model.APIKey) error {
+	return r.db.Create(key).Error
+}
+
+func (r *APIKeyRepo) Revoke(id uint, at time.Time) error {
+	return r.db.Model(&model.APIKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", at).Error
+}
+
+func (r *APIKeyRepo) UpdateLastUsed(id uint, at time.Time) error {
+	return r.db.Model(&model.APIKey{}).Where("id = ?", id).Update("last_used_at", at).Error
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"time"
+
+	"lark-robot/internal/model"
+
+	"gorm.io/gorm"
+)
+
+type AuditRepo struct {
+	db *gorm.DB
+}
+
+func NewAuditRepo(db *gorm.DB) *AuditRepo {
+	return &AuditRepo{db: db}
+}
+
+type AuditQuery struct {
+	Actor      string
+	Action     string // exact action, or a prefix ending in "." such as "message."
+	TargetType string
+	TargetID   string
+	From       *time.Time
+	To         *time.Time
+	Page       int
+	PageSize   int
+}
+
+func (r *AuditRepo) Create(entry *model.AuditEntry) error {
+	return r.db.Create(entry).Error
+}
+
+func (r *AuditRepo) filter(q AuditQuery) *gorm.DB {
+	tx := r.db.Model(&model.AuditEntry{})
+	if q.Actor != "" {
+		tx = tx.Where("actor = ?", q.Actor)
+	}
+	if q.Action != "" {
+		if q.Action[len(q.Action)-1] == '.' {
+			tx = tx.Where("action LIKE ?", q.Action+"%")
+		} else {
+			tx = tx.Where("action = ?", q.Action)
+		}
+	}
+	if q.TargetType != "" {
+		tx = tx.Where("target_type = ?", q.TargetType)
+	}
+	if q.TargetID != "" {
+		tx = tx.Where("target_id = ?", q.TargetID)
+	}
+	if q.From != nil {
+		tx = tx.Where("created_at >= ?", *q.From)
+	}
+	if q.To != nil {
+		tx = tx.Where("created_at < ?", *q.To)
+	}
+	return tx
+}
+
+// List returns one page of entries matching q, newest first.
+func (r *AuditRepo) List(q AuditQuery) ([]model.AuditEntry, int64, error) {
+	if q.Page <= 0 {
+		q.Page = 1
+	}
+	if q.PageSize <= 0 {
+		q.PageSize = 20
+	}
+
+	var total int64
+	if err := r.filter(q).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var entries []model.AuditEntry
+	err := r.filter(q).Order("id desc").
+		Offset((q.Page - 1) * q.PageSize).Limit(q.PageSize).
+		Find(&entries).Error
+	return entries, total, err
+}
+
+// Each calls fn for every entry matching q in ID order, loading batchSize
+// rows at a time. It stops at the first error fn returns.
+func (r *AuditRepo) Each(q AuditQuery, batchSize int, fn func(*model.AuditEntry) error) error {
+	var batch []model.AuditEntry
+	var fnErr error
+	err := r.filter(q).FindInBatches(&batch, batchSize, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			if fnErr = fn(&batch[i]); fnErr != nil {
+				return fnErr
+			}
+		}
+		return nil
+	}).Error
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"strings"
+
 	"lark-robot/internal/model"
 
 	"gorm.io/gorm"
@@ -14,10 +16,26 @@ func NewAutoReplyRuleRepo(db *gorm.DB) *AutoReplyRuleRepo {
 	return &AutoReplyRuleRepo{db: db}
 }
 
-func (r *AutoReplyRuleRepo) List(page, pageSize int) ([]model.AutoReplyRule, int64, error) {
+// List returns a page of rules. A non-nil chatIDs limits it to rules whose
+// chats all lie within chatIDs, leaving out rules for all chats or a group selector.
+func (r *AutoReplyRuleRepo) List(page, pageSize int, chatIDs []string) ([]model.AutoReplyRule, int64, error) {
 	var rules []model.AutoReplyRule
 	var total int64
 
+	if chatIDs != nil {
+		var candidates []model.AutoReplyRule
+		err := r.db.Where("chat_id <> ? AND group_selector = ?", "", "").Order("id desc").Find(&candidates).Error
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, rule := range candidates {
+			if withinChats(strings.Split(rule.ChatID, ","), chatIDs) {
+				rules = append(rules, rule)
+			}
+		}
+		return paginate(rules, page, pageSize), int64(len(rules)), nil
+	}
+
 	r.db.Model(&model.AutoReplyRule{}).Count(&total)
 
 	offset := (page - 1) * pageSize
@@ -54,3 +72,71 @@ func (r *AutoReplyRuleRepo) ToggleEnabled(id uint) error {
 		Where("id = ?", id).
 		Update("enabled", gorm.Expr("NOT enabled")).Error
 }
+
+// DisableForUser disables the enabled rules limited to any of chatIDs or
+// requiring openID to be mentioned, and returns them.
+func (r *AutoReplyRuleRepo) DisableForUser(openID string, chatIDs []string) ([]model.AutoReplyRule, error) {
+	tx := r.db.Where("enabled = ?", true)
+	if len(chatIDs) > 0 {
+		tx = tx.Where(`chat_id IN ? OR mention_user LIKE ? ESCAPE '\'`, chatIDs, "%"+escapeLike(openID)+"%")
+	} else {
+		tx = tx.Where(`mention_user LIKE ? ESCAPE '\'`, "%"+escapeLike(openID)+"%")
+	}
+	var candidates []model.AutoReplyRule
+	if err := tx.Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+	inChats := make(map[string]bool, len(chatIDs))
+	for _, id := range chatIDs {
+		inChats[id] = true
+	}
+	var rules []model.AutoReplyRule
+	for _, rule := range candidates {
+		if !inChats[rule.ChatID] && !mentionsUser(rule.MentionUser, openID) {
+			continue
+		}
+		if err := r.db.Model(&rule).Update("enabled", false).Error; err != nil {
+			return nil, err
+		}
+		rule.Enabled = false
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// mentionsUser reports whether a comma-separated open_id list holds openID.
+func mentionsUser(list, openID string) bool {
+	for _, id := range strings.Split(list, ",") {
+		if strings.TrimSpace(id) == openID {
+			return true
+		}
+	}
+	return false
+}
+
+// withinChats reports whether every one of ids is in chatIDs.
+func withinChats(ids []string, chatIDs []string) bool {
+	allowed := make(map[string]bool, len(chatIDs))
+	for _, id := range chatIDs {
+		allowed[id] = true
+	}
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); id != "" && !allowed[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// paginate returns the given page of items.
+func paginate[T any](items []T, page, pageSize int) []T {
+	start := (page - 1) * pageSize
+	if start >= len(items) {
+		return nil
+	}
+	end := start + pageSize
+	if end > len(items) {
+		end = len(items)
+	}
+	return items[start:end]
+}
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+
+	"lark-robot/internal/model"
+)
+
+type CampaignRepo struct {
+	db *gorm.DB
+}
+
+func NewCampaignRepo(db *gorm.DB) *CampaignRepo {
+	return &CampaignRepo{db: db}
+}
+
+// List returns a page of campaigns. A non-nil chatIDs limits it to campaigns
+// targeting chats that all lie within chatIDs.
+func (r *CampaignRepo) List(page, pageSize int, chatIDs []string) ([]model.Campaign, int64, error) {
+	var campaigns []model.Campaign
+	var total int64
+
+	if chatIDs != nil {
+		var candidates []model.Campaign
+		if err := r.db.Where("target_type = ?", "chats").Order("id desc").Find(&candidates).Error; err != nil {
+			return nil, 0, err
+		}
+		for _, campaign := range candidates {
+			var ids []string
+			json.Unmarshal([]byte(campaign.TargetIDs), &ids)
+			if len(ids) > 0 && withinChats(ids, chatIDs) {
+				campaigns = append(campaigns, campaign)
+			}
+		}
+		return paginate(campaigns, page, pageSize), int64(len(campaigns)), nil
+	}
+
+	r.db.Model(&model.Campaign{}).Count(&total)
+
+	offset := (page - 1) * pageSize
+	err := r.db.Order("id desc").Offset(offset).Limit(pageSize).Find(&campaigns).Error
+	return campaigns, total, err
+}
+
+// ListByStatus returns all campaigns in the given status.
+func (r *CampaignRepo) ListByStatus(status string) ([]model.Campaign, error) {
+	var campaigns []model.Campaign
+	err := r.db.Where("status = ?", status).Find(&campaigns).Error
+	return campaigns, err
+}
+
+// ListDue returns scheduled campaigns whose start time has passed.
+func (r *CampaignRepo) ListDue(now time.Time) ([]model.Campaign, error) {
+	var campaigns []model.Campaign
+	err := r.db.Where("status = ? AND scheduled_at <= ?", "scheduled", now).Find(&campaigns).Error
+	return campaigns, err
+}
+
+func (r *CampaignRepo) GetByID(id uint) (*model.Campaign, error) {
+	var campaign model.Campaign
+	err := r.db.First(&campaign, id).Error
+	return &campaign, err
+}
+
+func (r *CampaignRepo) Create(campaign *model.Campaign) error {
+	return r.db.Create(campaign).Error
+}
+
+func (r *CampaignRepo) Delete(id uint) error {
+	if err := r.db.Where("campaign_id = ?", id).Delete(&model.CampaignRecipient{}).Error; err != nil {
+		return err
+	}
+	return r.db.Delete(&model.Campaign{}, id).Error
+}
+
+// UpdateFields updates selected columns of a campaign.
+func (r *CampaignRepo) UpdateFields(id uint, fields map[string]interface{}) error {
+	return r.db.Model(&model.Campaign{}).Where("id = ?", id).Updates(fields).Error
+}
+
+// UpdateFieldsInStatus updates selected columns of a campaign only if it is in
+// one of statuses, reporting whether it was.
+func (r *CampaignRepo) UpdateFieldsInStatus(id uint, statuses []string, fields map[string]interface{}) (bool, error) {
+	res := r.db.Model(&model.Campaign{}).Where("id = ? AND status IN ?", id, statuses).Updates(fields)
+	return res.RowsAffected > 0, res.Error
+}
+
+// Claim applies fields to a campaign in one of statuses and, if it has no
+// recipients yet, inserts recipients, in one transaction. It returns the
+// number of recipients and false if the campaign was not in one of statuses,
+// e.g. because another caller claimed it first.
+func (r *CampaignRepo) Claim(id uint, statuses []string, fields map[string]interface{}, recipients []model.CampaignRecipient) (int64, bool, error) {
+	var count int64
+	claimed := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&model.Campaign{}).Where("id = ? AND status IN ?", id, statuses).Updates(fields)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		claimed = true
+		if err := tx.Model(&model.CampaignRecipient{}).Where("campaign_id = ?", id).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 && len(recipients) > 0 {
+			if err := tx.CreateInBatches(recipients, 100).Error; err != nil {
+				return err
+			}
+			count = int64(len(recipients))
+		}
+		return tx.Model(&model.Campaign{}).Where("id = ?", id).Update("total", count).Error
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return count, claimed, nil
+}
+
+// IncrementCounter atomically increments a campaign counter column.
+func (r *CampaignRepo) IncrementCounter(id uint, column string) error {
+	return r.db.Model(&model.Campaign{}).
+		Where("id = ?", id).
+		UpdateColumn(column, gorm.Expr(column+" + 1")).Error
+}
+
+// CountRecipients returns the number of recipients of a campaign.
+func (r *CampaignRepo) CountRecipients(campaignID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&model.CampaignRecipient{}).Where("campaign_id = ?", campaignID).Count(&count).Error
+	return count, err
+}
+
+// ListRecipients returns paginated recipients, optionally filtered by status.
+func (r *CampaignRepo) ListRecipients(campaignID uint, status string, page, pageSize int) ([]model.CampaignRecipient, int64, error) {
+	tx := r.db.Model(&model.CampaignRecipient{}).Where("campaign_id = ?", campaignID)
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+
+	var total int64
+	tx.Count(&total)
+
+	var recipients []model.CampaignRecipient
+	err := tx.Order("id asc").Offset((page - 1) * pageSize).Limit(pageSize).Find(&recipients).Error
+	return recipients, total, err
+}
+
+// NextRecipients returns up to limit recipients in the given status with an ID above afterID, oldest first.
+func (r *CampaignRepo) NextRecipients(campaignID uint, status string, afterID uint, limit int) ([]model.CampaignRecipient, error) {
+	var recipients []model.CampaignRecipient
+	err := r.db.Where("campaign_id = ? AND status = ? AND id > ?", campaignID, status, afterID).
+		Order("id asc").Limit(limit).Find(&recipients).Error
+	return recipients, err
+}
+
+func (r *CampaignRepo) UpdateRecipient(recipient *model.CampaignRecipient) error {
+	return r.db.Save(recipient).Error
+}
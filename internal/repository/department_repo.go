@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"lark-robot/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type DepartmentRepo struct {
+	db *gorm.DB
+}
+
+func NewDepartmentRepo(db *gorm.DB) *DepartmentRepo {
+	return &DepartmentRepo{db: db}
+}
+
+// List returns all departments in display order.
+func (r *DepartmentRepo) List() ([]model.Department, error) {
+	var depts []model.Department
+	err := r.db.Order("sort_order asc, name asc").Find(&depts).Error
+	return depts, err
+}
+
+func (r *DepartmentRepo) GetByOpenID(openDepartmentID string) (*model.Department, error) {
+	var dept model.Department
+	err := r.db.Where("open_department_id = ?", openDepartmentID).First(&dept).Error
+	return &dept, err
+}
+
+// GetByOpenIDs returns the known departments among ids.
+func (r *DepartmentRepo) GetByOpenIDs(ids []string) ([]model.Department, error) {
+	var depts []model.Department
+	if len(ids) == 0 {
+		return depts, nil
+	}
+	err := r.db.Where("open_department_id IN ?", ids).Find(&depts).Error
+	return depts, err
+}
+
+// Upsert creates or updates a department by open_department_id.
+func (r *DepartmentRepo) Upsert(dept *model.Department) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "open_department_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"department_id", "parent_id", "name", "leader_user_id", "chat_id",
+			"member_count", "sort_order", "path", "synced_at", "updated_at",
+		}),
+	}).Create(dept).Error
+}
+
+// DeleteNotSyncedSince removes departments missing from a full sync that
+// started at t, with their memberships.
+func (r *DepartmentRepo) DeleteNotSyncedSince(t time.Time) (int64, error) {
+	var removed int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		stale := tx.Model(&model.Department{}).Select("open_department_id").Where("synced_at < ?", t)
+		if err := tx.Where("open_department_id IN (?)", stale).Delete(&model.UserDepartment{}).Error; err != nil {
+			return err
+		}
+		res := tx.Where("synced_at < ?", t).Delete(&model.Department{})
+		removed = res.RowsAffected
+		return res.Error
+	})
+	return removed, err
+}
+
+// SetUserDepartments replaces the departments a user belongs to.
+func (r *DepartmentRepo) SetUserDepartments(openID string, deptIDs []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("open_id = ?", openID).Delete(&model.UserDepartment{}).Error; err != nil {
+			return err
+		}
+		if len(deptIDs) == 0 {
+			return nil
+		}
+		links := make([]model.UserDepartment, 0, len(deptIDs))
+		for _, id := range deptIDs {
+			links = append(links, model.UserDepartment{OpenID: openID, OpenDepartmentID: id})
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&links).Error
+	})
+}
+
+// BackfillMemberships links users that have department IDs but no
+// memberships yet, e.g. users stored before departments were tracked.
+func (r *DepartmentRepo) BackfillMemberships() (int, error) {
+	var users []model.User
+	err := r.db.Select("open_id", "department_ids").
+		Where("department_ids <> '' AND open_id NOT IN (?)", r.db.Model(&model.UserDepartment{}).Select("open_id")).
+		Find(&users).Error
+	if err != nil {
+		return 0, err
+	}
+	linked := 0
+	for _, u := range users {
+		var ids []string
+		if json.Unmarshal([]byte(u.DepartmentIDs), &ids) != nil || len(ids) == 0 {
+			continue
+		}
+		if err := r.SetUserDepartments(u.OpenID, ids); err != nil {
+			return linked, err
+		}
+		linked++
+	}
+	return linked, nil
+}
+
+// CountMembers returns the number of known users directly in each department.
+func (r *DepartmentRepo) CountMembers() (map[string]int64, error) {
+	var rows []struct {
+		OpenDepartmentID string
+		Count            int64
+	}
+	err := r.db.Model(&model.UserDepartment{}).
+		Select("open_department_id, COUNT(*) AS count").
+		Group("open_department_id").
+		Scan(&rows).Error
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.OpenDepartmentID] = row.Count
+	}
+	return counts, err
+}
+
+// Members returns the known users in the given departments, and in their
+// sub-departments when recursive is set.
+func (r *DepartmentRepo) Members(deptIDs []string, recursive bool) ([]model.User, error) {
+	var users []model.User
+	err := r.db.Where("open_id IN (?)", departmentMembers(r.db, deptIDs, recursive)).
+		Order("name asc").Find(&users).Error
+	return users, err
+}
+
+// departmentMembers is a subquery selecting the open_ids of users in deptIDs,
+// including sub-departments when recursive is set.
+func departmentMembers(db *gorm.DB, deptIDs []string, recursive bool) *gorm.DB {
+	q := db.Model(&model.UserDepartment{}).Select("open_id")
+	if !recursive {
+		return q.Where("open_department_id IN ?", deptIDs)
+	}
+	// A department's path lists its ancestors and itself, so the subtree of X
+	// is every department whose path contains "/X/"
+	subtree := db.Model(&model.Department{}).Select("open_department_id")
+	conds := make([]string, 0, len(deptIDs))
+	args := make([]interface{}, 0, len(deptIDs))
+	for _, id := range deptIDs {
+		conds = append(conds, `path LIKE ? ESCAPE '\'`)
+		args = append(args, "%/"+escapeLike(id)+"/%")
+	}
+	if len(conds) == 0 {
+		conds, args = []string{"1 = 0"}, nil
+	}
+	subtree = subtree.Where(strings.Join(conds, " OR "), args...)
+	// Memberships in departments not synced yet still count for the selected ones
+	return q.Where("open_department_id IN (?) OR open_department_id IN ?", subtree, deptIDs)
+}
+
+// escapeLike escapes LIKE wildcards, for use with ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
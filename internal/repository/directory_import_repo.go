@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"lark-robot/internal/model"
+
+	"gorm.io/gorm"
+)
+
+type DirectoryImportRepo struct {
+	db *gorm.DB
+}
+
+func NewDirectoryImportRepo(db *gorm.DB) *DirectoryImportRepo {
+	return &DirectoryImportRepo{db: db}
+}
+
+func (r *DirectoryImportRepo) Create(imp *model.DirectoryImport) error {
+	return r.db.Create(imp).Error
+}
+
+func (r *DirectoryImportRepo) GetByID(id uint) (*model.DirectoryImport, error) {
+	var imp model.DirectoryImport
+	err := r.db.First(&imp, id).Error
+	return &imp, err
+}
+
+// Latest returns the most recent import.
+func (r *DirectoryImportRepo) Latest() (*model.DirectoryImport, error) {
+	var imp model.DirectoryImport
+	err := r.db.Order("id desc").First(&imp).Error
+	return &imp, err
+}
+
+func (r *DirectoryImportRepo) ListByStatus(status string) ([]model.DirectoryImport, error) {
+	var imports []model.DirectoryImport
+	err := r.db.Where("status = ?", status).Order("id").Find(&imports).Error
+	return imports, err
+}
+
+// UpdateFields updates the given columns of an import.
+func (r *DirectoryImportRepo) UpdateFields(id uint, fields map[string]interface{}) error {
+	return r.db.Model(&model.DirectoryImport{}).Where("id = ?", id).Updates(fields).Error
+}
+
+// UpdateFieldsInStatus updates the given columns of an import only if it is
+// in one of statuses, reporting whether it was.
+func (r *DirectoryImportRepo) UpdateFieldsInStatus(id uint, statuses []string, fields map[string]interface{}) (bool, error) {
+	res := r.db.Model(&model.DirectoryImport{}).Where("id = ? AND status IN ?", id, statuses).Updates(fields)
+	return res.RowsAffected > 0, res.Error
+}
+
+// SaveProgress stores the checkpoint and counters of a running import.
+func (r *DirectoryImportRepo) SaveProgress(imp *model.DirectoryImport) error {
+	return r.db.Model(imp).Select(
+		"departments", "department_total", "department_done", "page_token",
+		"imported", "skipped", "failed", "failed_ids", "failed_departments",
+	).Updates(imp).Error
+}
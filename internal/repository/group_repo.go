@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"encoding/json"
 	"time"
 
 	"lark-robot/internal/model"
@@ -17,28 +18,70 @@ func NewGroupRepo(db *gorm.DB) *GroupRepo {
 	return &GroupRepo{db: db}
 }
 
-func (r *GroupRepo) List(page, pageSize int) ([]model.Group, int64, error) {
+// GroupQuery filters the group list.
+type GroupQuery struct {
+	Page        int
+	PageSize    int
+	ChatIDs     []string // non-nil limits the result to these chats
+	Status      string   // "" for groups the bot is in, "left" for groups it left, "all" for both
+	Tag         string
+	Team        string
+	Environment string
+}
+
+// List returns a page of groups, most recently active first.
+func (r *GroupRepo) List(q GroupQuery) ([]model.Group, int64, error) {
 	var groups []model.Group
 	var total int64
 
-	r.db.Model(&model.Group{}).Count(&total)
+	tx := r.db.Model(&model.Group{})
+	switch q.Status {
+	case "left":
+		tx = tx.Unscoped().Where("groups.deleted_at IS NOT NULL")
+	case "all":
+		tx = tx.Unscoped()
+	}
+	if q.ChatIDs != nil {
+		tx = tx.Where("groups.chat_id IN ?", q.ChatIDs)
+	}
+	if q.Tag != "" {
+		tag, _ := json.Marshal(q.Tag)
+		tx = tx.Where(`groups.tags LIKE ? ESCAPE '\'`, "%"+escapeLike(string(tag))+"%")
+	}
+	if q.Team != "" {
+		tx = tx.Where("groups.team = ?", q.Team)
+	}
+	if q.Environment != "" {
+		tx = tx.Where("groups.environment = ?", q.Environment)
+	}
+	tx.Count(&total)
 
-	offset := (page - 1) * pageSize
-	err := r.db.
+	offset := (q.Page - 1) * q.PageSize
+	err := tx.
 		Select("groups.*").
 		Joins("LEFT JOIN (SELECT chat_id, MAX(created_at) as last_msg_at FROM message_logs GROUP BY chat_id) ml ON ml.chat_id = groups.chat_id").
 		Order("CASE WHEN ml.last_msg_at IS NULL THEN 1 ELSE 0 END, ml.last_msg_at DESC, groups.name ASC").
-		Offset(offset).Limit(pageSize).
+		Offset(offset).Limit(q.PageSize).
 		Find(&groups).Error
 	return groups, total, err
 }
 
+// ListAll returns every group without pagination.
+func (r *GroupRepo) ListAll() ([]model.Group, error) {
+	var groups []model.Group
+	err := r.db.Order("name asc").Find(&groups).Error
+	return groups, err
+}
+
+// GetByChatID returns a group, including one the bot has left.
 func (r *GroupRepo) GetByChatID(chatID string) (*model.Group, error) {
 	var group model.Group
-	err := r.db.Where("chat_id = ?", chatID).First(&group).Error
+	err := r.db.Unscoped().Where("chat_id = ?", chatID).First(&group).Error
 	return &group, err
 }
 
+// Upsert saves the Lark fields of a group, keeping its local metadata. A
+// group the bot had left is restored.
 func (r *GroupRepo) Upsert(group *model.Group) error {
 	group.SyncedAt = time.Now()
 	return r.db.Clauses(clause.OnConflict{
@@ -46,12 +89,27 @@ func (r *GroupRepo) Upsert(group *model.Group) error {
 		DoUpdates: clause.AssignmentColumns([]string{
 			"name", "avatar", "description", "chat_mode", "chat_type", "chat_tag",
 			"owner_id", "member_count", "bot_count", "external", "synced_at", "updated_at",
+			"left_at", "deleted_at",
 		}),
 	}).Create(group).Error
 }
 
-func (r *GroupRepo) DeleteByChatID(chatID string) error {
-	return r.db.Where("chat_id = ?", chatID).Delete(&model.Group{}).Error
+// UpdateMeta sets the locally managed fields of a group the bot is in.
+func (r *GroupRepo) UpdateMeta(chatID string, fields map[string]interface{}) error {
+	res := r.db.Model(&model.Group{}).Where("chat_id = ?", chatID).Updates(fields)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// MarkLeft soft-deletes a group, keeping its metadata for when the bot rejoins.
+func (r *GroupRepo) MarkLeft(chatID string, at time.Time) error {
+	return r.db.Model(&model.Group{}).Where("chat_id = ?", chatID).
+		Updates(map[string]interface{}{"left_at": at, "deleted_at": at}).Error
 }
 
 func (r *GroupRepo) Count() (int64, error) {
@@ -60,10 +118,34 @@ func (r *GroupRepo) Count() (int64, error) {
 	return count, err
 }
 
-// DeleteNotIn removes groups whose chat_id is not in the given list.
-func (r *GroupRepo) DeleteNotIn(chatIDs []string) error {
-	if len(chatIDs) == 0 {
-		return r.db.Where("1=1").Delete(&model.Group{}).Error
+// MarkLeftNotIn soft-deletes the groups whose chat_id is not in the given
+// list and returns them.
+func (r *GroupRepo) MarkLeftNotIn(chatIDs []string, at time.Time) ([]model.Group, error) {
+	var groups []model.Group
+	tx := r.db.Model(&model.Group{})
+	if len(chatIDs) > 0 {
+		tx = tx.Where("chat_id NOT IN ?", chatIDs)
+	}
+	if err := tx.Find(&groups).Error; err != nil || len(groups) == 0 {
+		return groups, err
 	}
-	return r.db.Where("chat_id NOT IN ?", chatIDs).Delete(&model.Group{}).Error
+	ids := make([]string, len(groups))
+	for i, g := range groups {
+		ids[i] = g.ChatID
+	}
+	err := r.db.Model(&model.Group{}).Where("chat_id IN ?", ids).
+		Updates(map[string]interface{}{"left_at": at, "deleted_at": at}).Error
+	return groups, err
+}
+
+// AddHistory records the bot joining or leaving a group.
+func (r *GroupRepo) AddHistory(h *model.GroupHistory) error {
+	return r.db.Create(h).Error
+}
+
+// ListHistory returns a group's history, newest first.
+func (r *GroupRepo) ListHistory(chatID string) ([]model.GroupHistory, error) {
+	var history []model.GroupHistory
+	err := r.db.Where("chat_id = ?", chatID).Order("id desc").Find(&history).Error
+	return history, err
 }
@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"time"
+
+	"lark-robot/internal/model"
+
+	"gorm.io/gorm"
+)
+
+type JobRepo struct {
+	db *gorm.DB
+}
+
+func NewJobRepo(db *gorm.DB) *JobRepo {
+	return &JobRepo{db: db}
+}
+
+// JobQuery filters the job list.
+type JobQuery struct {
+	Page      int
+	PageSize  int
+	Kind      string
+	Status    string
+	CreatedBy string // empty for all users
+}
+
+func (r *JobRepo) Create(job *model.Job) error {
+	return r.db.Create(job).Error
+}
+
+func (r *JobRepo) GetByID(id uint) (*model.Job, error) {
+	var job model.Job
+	err := r.db.First(&job, id).Error
+	return &job, err
+}
+
+func (r *JobRepo) List(q JobQuery) ([]model.Job, int64, error) {
+	var jobs []model.Job
+	var total int64
+
+	db := r.db.Model(&model.Job{})
+	if q.Kind != "" {
+		db = db.Where("kind = ?", q.Kind)
+	}
+	if q.Status != "" {
+		db = db.Where("status = ?", q.Status)
+	}
+	if q.CreatedBy != "" {
+		db = db.Where("created_by = ?", q.CreatedBy)
+	}
+	db.Count(&total)
+
+	offset := (q.Page - 1) * q.PageSize
+	err := db.Order("id desc").Offset(offset).Limit(q.PageSize).Find(&jobs).Error
+	return jobs, total, err
+}
+
+// UpdateFields updates the given columns of a job.
+func (r *JobRepo) UpdateFields(id uint, fields map[string]interface{}) error {
+	return r.db.Model(&model.Job{}).Where("id = ?", id).Updates(fields).Error
+}
+
+// FailRunning marks jobs still running, left over from a previous process, as
+// failed with the given error. It returns how many were marked.
+func (r *JobRepo) FailRunning(message string, at time.Time) (int64, error) {
+	result := r.db.Model(&model.Job{}).Where("status = ?", "running").Updates(map[string]interface{}{
+		"status":      "failed",
+		"error":       message,
+		"finished_at": at,
+	})
+	return result.RowsAffected, result.Error
+}
+
+// ListFinishedBefore returns jobs that ended before t.
+func (r *JobRepo) ListFinishedBefore(t time.Time) ([]model.Job, error) {
+	var jobs []model.Job
+	err := r.db.Where("finished_at < ?", t).Find(&jobs).Error
+	return jobs, err
+}
+
+// DeleteFinishedBefore deletes jobs that ended before t.
+func (r *JobRepo) DeleteFinishedBefore(t time.Time) (int64, error) {
+	result := r.db.Where("finished_at < ?", t).Delete(&model.Job{})
+	return result.RowsAffected, result.Error
+}
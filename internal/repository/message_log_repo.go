@@ -1,6 +1,9 @@
 package repository
 
 import (
+	"time"
+
+	msgcontent "lark-robot/internal/larkbot/content"
 	"lark-robot/internal/model"
 
 	"gorm.io/gorm"
@@ -16,11 +19,14 @@ func NewMessageLogRepo(db *gorm.DB) *MessageLogRepo {
 
 type MessageLogQuery struct {
 	ChatID    string
+	ChatIDs   []string // if non-nil, only messages of these chats
 	ChatType  string
 	Direction string
 	Source    string
-	Page     int
-	PageSize int
+	RootID    string // only messages of this reply thread (including the root)
+	ThreadID  string
+	Page      int
+	PageSize  int
 }
 
 func (r *MessageLogRepo) List(q MessageLogQuery) ([]model.MessageLog, int64, error) {
@@ -35,14 +41,26 @@ func (r *MessageLogRepo) List(q MessageLogQuery) ([]model.MessageLog, int64, err
 	if q.ChatID != "" {
 		tx = tx.Where("chat_id = ?", q.ChatID)
 	}
+	if q.ChatIDs != nil {
+		tx = tx.Where("chat_id IN ?", q.ChatIDs)
+	}
 	if q.ChatType != "" {
 		tx = tx.Where("chat_type = ?", q.ChatType)
 	}
 	if q.Direction != "" {
 		tx = tx.Where("direction = ?", q.Direction)
 	}
+	if q.RootID != "" {
+		tx = tx.Where("root_id = ? OR message_id = ?", q.RootID, q.RootID)
+	}
+	if q.ThreadID != "" {
+		tx = tx.Where("thread_id = ?", q.ThreadID)
+	}
 	if q.Source != "" {
 		tx = tx.Where("source = ?", q.Source)
+	} else {
+		// Edit revisions are only listed when asked for explicitly
+		tx = tx.Where("source != ?", "edit")
 	}
 
 	var total int64
@@ -58,16 +76,120 @@ func (r *MessageLogRepo) List(q MessageLogQuery) ([]model.MessageLog, int64, err
 }
 
 func (r *MessageLogRepo) Create(log *model.MessageLog) error {
+	if log.Summary == "" {
+		log.Summary = msgcontent.Parse(log.MsgType, log.Content).Summary()
+	}
 	return r.db.Create(log).Error
 }
 
+// BackfillSummaries fills in the summary of logs stored before summaries were
+// kept, and returns how many it filled.
+func (r *MessageLogRepo) BackfillSummaries() (int, error) {
+	filled := 0
+	var cursor uint
+	for {
+		var logs []model.MessageLog
+		err := r.db.Select("id", "msg_type", "content").
+			Where("id > ? AND summary = ? AND content <> ?", cursor, "", "").
+			Order("id asc").Limit(500).Find(&logs).Error
+		if err != nil || len(logs) == 0 {
+			return filled, err
+		}
+		for _, log := range logs {
+			cursor = log.ID
+			summary := msgcontent.Parse(log.MsgType, log.Content).Summary()
+			if summary == "" {
+				continue
+			}
+			if err := r.db.Model(&model.MessageLog{}).Where("id = ?", log.ID).UpdateColumn("summary", summary).Error; err != nil {
+				return filled, err
+			}
+			filled++
+		}
+	}
+}
+
+// ListSince returns messages of a chat (all chats if chatID is empty) created
+// after since, oldest first, up to limit rows.
+func (r *MessageLogRepo) ListSince(chatID string, since time.Time, limit int) ([]model.MessageLog, error) {
+	tx := r.db.Where("created_at > ? AND source != ?", since, "edit")
+	if chatID != "" {
+		tx = tx.Where("chat_id = ?", chatID)
+	}
+	var logs []model.MessageLog
+	err := tx.Order("created_at asc").Limit(limit).Find(&logs).Error
+	return logs, err
+}
+
 // GetByMessageID finds a message log by its Lark message_id.
 func (r *MessageLogRepo) GetByMessageID(messageID string) (*model.MessageLog, error) {
 	var log model.MessageLog
-	err := r.db.Where("message_id = ?", messageID).First(&log).Error
+	err := r.db.Where("message_id = ? AND source != ?", messageID, "edit").First(&log).Error
 	return &log, err
 }
 
+// ApplyEdit records an edit of a sent message: the original log row takes the new
+// content and revision number, and a separate "edit" row keeps the revision history.
+// The first edit also stores the content as sent, as revision 0.
+func (r *MessageLogRepo) ApplyEdit(messageID, msgType, content string) (*model.MessageLog, error) {
+	var original model.MessageLog
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("message_id = ? AND source != ?", messageID, "edit").First(&original).Error; err != nil {
+			return err
+		}
+		if original.Revision == 0 {
+			if err := tx.Create(&model.MessageLog{
+				MessageID: messageID,
+				ChatID:    original.ChatID,
+				ChatType:  original.ChatType,
+				Direction: original.Direction,
+				MsgType:   original.MsgType,
+				Content:   original.Content,
+				Summary:   original.Summary,
+				Source:    "edit",
+				CreatedAt: original.CreatedAt,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		now := time.Now()
+		original.Revision++
+		original.MsgType = msgType
+		original.Content = content
+		original.Summary = msgcontent.Parse(msgType, content).Summary()
+		original.EditedAt = &now
+		if err := tx.Save(&original).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.MessageLog{
+			MessageID: messageID,
+			ChatID:    original.ChatID,
+			ChatType:  original.ChatType,
+			Direction: original.Direction,
+			MsgType:   msgType,
+			Content:   content,
+			Summary:   original.Summary,
+			Source:    "edit",
+			Revision:  original.Revision,
+		}).Error
+	})
+	return &original, err
+}
+
+// ListRevisions returns the edit history of a message, oldest first.
+func (r *MessageLogRepo) ListRevisions(messageID string) ([]model.MessageLog, error) {
+	var logs []model.MessageLog
+	err := r.db.Where("message_id = ? AND source = ?", messageID, "edit").Order("id asc").Find(&logs).Error
+	return logs, err
+}
+
+// SetHandledBy records who handled a received message.
+func (r *MessageLogRepo) SetHandledBy(messageID, handledBy string) error {
+	return r.db.Model(&model.MessageLog{}).
+		Where("message_id = ? AND direction = ? AND source != ?", messageID, "in", "edit").
+		Update("handled_by", handledBy).Error
+}
+
 // RecallByMessageID marks a message as recalled by its Lark message_id.
 func (r *MessageLogRepo) RecallByMessageID(messageID string) error {
 	return r.db.Model(&model.MessageLog{}).Where("message_id = ?", messageID).Update("recalled", true).Error
@@ -88,13 +210,89 @@ type Conversation struct {
 func (r *MessageLogRepo) ListConversations() ([]Conversation, error) {
 	var results []Conversation
 	err := r.db.Model(&model.MessageLog{}).
-		Select("chat_id, MAX(chat_type) as chat_type, MAX(sender_id) as sender_id, MAX(sender_name) as sender_name, MAX(content) as last_content, MAX(created_at) as last_time, COUNT(*) as msg_count").
+		Select("chat_id, MAX(chat_type) as chat_type, MAX(sender_id) as sender_id, MAX(sender_name) as sender_name, MAX(summary) as last_content, MAX(created_at) as last_time, COUNT(*) as msg_count").
+		Where("source != ?", "edit").
 		Group("chat_id").
 		Order("last_time desc").
 		Find(&results).Error
 	return results, err
 }
 
+// Thread is a root message together with the replies in its thread.
+type Thread struct {
+	RootID     string             `json:"root_id"`
+	ThreadID   string             `json:"thread_id"`
+	ChatID     string             `json:"chat_id"`
+	ReplyCount int64              `json:"reply_count"`
+	LastTime   string             `json:"last_time"`
+	Messages   []model.MessageLog `gorm:"-" json:"messages"`
+}
+
+// threadKey groups a message under its root: replies by root_id, everything else by its own message_id.
+const threadKey = "CASE WHEN root_id != '' THEN root_id ELSE message_id END"
+
+// ListThreads returns a chat's messages grouped by reply thread, most recently active thread first.
+func (r *MessageLogRepo) ListThreads(chatID string, page, pageSize int) ([]Thread, int64, error) {
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	base := r.db.Model(&model.MessageLog{}).
+		Where("chat_id = ? AND source != ?", chatID, "edit").
+		Where("message_id != '' OR root_id != ''")
+
+	var total int64
+	if err := r.db.Table("(?) as t", base.Session(&gorm.Session{}).Select(threadKey+" as root_key").Group("root_key")).
+		Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var threads []Thread
+	err := base.Session(&gorm.Session{}).
+		Select(threadKey + " as root_id, MAX(thread_id) as thread_id, MAX(chat_id) as chat_id, COUNT(*) - 1 as reply_count, MAX(created_at) as last_time").
+		Group(threadKey).
+		Order("last_time desc").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Scan(&threads).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(threads) == 0 {
+		return threads, total, nil
+	}
+
+	// Load the messages of every thread on the page in one query
+	roots := make([]string, len(threads))
+	byRoot := make(map[string]*Thread, len(threads))
+	for i := range threads {
+		roots[i] = threads[i].RootID
+		byRoot[threads[i].RootID] = &threads[i]
+	}
+	var logs []model.MessageLog
+	err = r.db.Where("chat_id = ? AND source != ?", chatID, "edit").
+		Where("message_id IN ? OR root_id IN ?", roots, roots).
+		Order("id asc").
+		Find(&logs).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, log := range logs {
+		key := log.RootID
+		if key == "" {
+			key = log.MessageID
+		}
+		if t, ok := byRoot[key]; ok {
+			t.Messages = append(t.Messages, log)
+		}
+	}
+	return threads, total, nil
+}
+
 // DeleteGroupLogsBefore deletes group chat logs older than the given time. Private chats are not affected.
 func (r *MessageLogRepo) DeleteGroupLogsBefore(before string) (int64, error) {
 	result := r.db.Where("chat_type = 'group' AND created_at < ?", before).Delete(&model.MessageLog{})
@@ -115,7 +313,16 @@ func (r *MessageLogRepo) GetChatType(chatID string) string {
 func (r *MessageLogRepo) CountToday() (int64, error) {
 	var count int64
 	err := r.db.Model(&model.MessageLog{}).
-		Where("created_at >= date('now')").
+		Where("created_at >= date('now') AND source != ?", "edit").
 		Count(&count).Error
 	return count, err
 }
+
+// P2PChatIDs returns the direct chats a user has written to the bot from.
+func (r *MessageLogRepo) P2PChatIDs(openID string) ([]string, error) {
+	var ids []string
+	err := r.db.Model(&model.MessageLog{}).
+		Where("chat_type = ? AND direction = ? AND sender_id = ?", "p2p", "in", openID).
+		Distinct().Pluck("chat_id", &ids).Error
+	return ids, err
+}
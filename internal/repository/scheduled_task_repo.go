@@ -16,14 +16,20 @@ func NewScheduledTaskRepo(db *gorm.DB) *ScheduledTaskRepo {
 	return &ScheduledTaskRepo{db: db}
 }
 
-func (r *ScheduledTaskRepo) List(page, pageSize int) ([]model.ScheduledTask, int64, error) {
+// List returns a page of tasks. A non-nil chatIDs limits it to tasks sending
+// to those chats, leaving out group selector tasks.
+func (r *ScheduledTaskRepo) List(page, pageSize int, chatIDs []string) ([]model.ScheduledTask, int64, error) {
 	var tasks []model.ScheduledTask
 	var total int64
 
-	r.db.Model(&model.ScheduledTask{}).Count(&total)
+	tx := r.db.Model(&model.ScheduledTask{})
+	if chatIDs != nil {
+		tx = tx.Where("chat_id IN ? AND group_selector = ?", chatIDs, "")
+	}
+	tx.Count(&total)
 
 	offset := (page - 1) * pageSize
-	err := r.db.Order("id desc").Offset(offset).Limit(pageSize).Find(&tasks).Error
+	err := tx.Order("id desc").Offset(offset).Limit(pageSize).Find(&tasks).Error
 	return tasks, total, err
 }
 
@@ -68,3 +74,22 @@ func (r *ScheduledTaskRepo) UpdateNextRunAt(id uint, t time.Time) error {
 		Where("id = ?", id).
 		Update("next_run_at", t).Error
 }
+
+// DisableByChatIDs disables the enabled tasks that send to any of chatIDs
+// and returns them.
+func (r *ScheduledTaskRepo) DisableByChatIDs(chatIDs []string) ([]model.ScheduledTask, error) {
+	var tasks []model.ScheduledTask
+	if len(chatIDs) == 0 {
+		return tasks, nil
+	}
+	if err := r.db.Where("enabled = ? AND chat_id IN ?", true, chatIDs).Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	for i := range tasks {
+		if err := r.db.Model(&tasks[i]).Update("enabled", false).Error; err != nil {
+			return nil, err
+		}
+		tasks[i].Enabled = false
+	}
+	return tasks, nil
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"time"
+
+	"lark-robot/internal/model"
+
+	"gorm.io/gorm"
+)
+
+type SessionRepo struct {
+	db *gorm.DB
+}
+
+func NewSessionRepo(db *gorm.DB) *SessionRepo {
+	return &SessionRepo{db: db}
+}
+
+func (r *SessionRepo) Create(session *model.Session) error {
+	return r.db.Create(session).Error
+}
+
+func (r *SessionRepo) GetByID(id string) (*model.Session, error) {
+	var session model.Session
+	err := r.db.Where("id = ?", id).First(&session).Error
+	return &session, err
+}
+
+// Rotate replaces the session's refresh token ID, but only if it still is
+// oldJTI, so two concurrent refreshes with the same token cannot both succeed.
+func (r *SessionRepo) Rotate(id, oldJTI, newJTI string, lastUsed, expires time.Time) (bool, error) {
+	res := r.db.Model(&model.Session{}).
+		Where("id = ? AND refresh_jti = ? AND revoked_at IS NULL", id, oldJTI).
+		Updates(map[string]interface{}{"refresh_jti": newJTI, "last_used_at": lastUsed, "expires_at": expires})
+	return res.RowsAffected == 1, res.Error
+}
+
+// ListActive returns unrevoked, unexpired sessions, newest first. userID 0 lists all users.
+func (r *SessionRepo) ListActive(userID uint) ([]model.Session, error) {
+	var sessions []model.Session
+	tx := r.db.Where("revoked_at IS NULL AND expires_at > ?", time.Now())
+	if userID != 0 {
+		tx = tx.Where("admin_user_id = ?", userID)
+	}
+	err := tx.Order("last_used_at desc").Find(&sessions).Error
+	return sessions, err
+}
+
+// ListRevoked returns revoked sessions that have not expired yet.
+func (r *SessionRepo) ListRevoked() ([]model.Session, error) {
+	var sessions []model.Session
+	err := r.db.Where("revoked_at IS NOT NULL AND expires_at > ?", time.Now()).Find(&sessions).Error
+	return sessions, err
+}
+
+func (r *SessionRepo) Revoke(id string, at time.Time) error {
+	return r.db.Model(&model.Session{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", at).Error
+}
+
+// RevokeUser revokes all active sessions of a user and returns their IDs.
+func (r *SessionRepo) RevokeUser(userID uint, at time.Time) ([]string, error) {
+	var ids []string
+	if err := r.db.Model(&model.Session{}).
+		Where("admin_user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, at).
+		Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	err := r.db.Model(&model.Session{}).Where("id IN ?", ids).Update("revoked_at", at).Error
+	return ids, err
+}
+
+// DeleteExpiredBefore removes sessions that expired before t.
+func (r *SessionRepo) DeleteExpiredBefore(t time.Time) (int64, error) {
+	res := r.db.Where("expires_at < ?", t).Delete(&model.Session{})
+	return res.RowsAffected, res.Error
+}
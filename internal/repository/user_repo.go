@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"time"
+
 	"lark-robot/internal/model"
 
 	"gorm.io/gorm"
@@ -24,11 +26,38 @@ func (r *UserRepo) Upsert(user *model.User) error {
 			"union_id", "user_id", "name", "en_name", "avatar", "description",
 			"email", "city", "job_title", "work_station", "employee_no",
 			"gender", "leader_user_id", "department_ids", "department_names", "custom_attrs", "join_time",
-			"last_seen", "updated_at",
+			"frozen", "last_seen", "updated_at",
 		}),
 	}).Create(user).Error
 }
 
+// UpsertProfile creates or updates a user's directory profile without
+// touching first_seen and last_seen of known users.
+func (r *UserRepo) UpsertProfile(user *model.User) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "open_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"union_id", "user_id", "name", "en_name", "avatar", "description",
+			"email", "city", "job_title", "work_station", "employee_no",
+			"gender", "leader_user_id", "department_ids", "department_names", "custom_attrs", "join_time",
+			"frozen", "updated_at",
+		}),
+	}).Create(user).Error
+}
+
+// UpdatedSince returns which of openIDs were updated at or after t.
+func (r *UserRepo) UpdatedSince(openIDs []string, t time.Time) (map[string]bool, error) {
+	var ids []string
+	err := r.db.Unscoped().Model(&model.User{}).
+		Where("open_id IN ? AND updated_at >= ?", openIDs, t).
+		Pluck("open_id", &ids).Error
+	found := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		found[id] = true
+	}
+	return found, err
+}
+
 // IncrementMsgCount atomically increments the message count for a user.
 func (r *UserRepo) IncrementMsgCount(openID string) error {
 	return r.db.Model(&model.User{}).
@@ -43,12 +72,38 @@ func (r *UserRepo) GetByOpenID(openID string) (*model.User, error) {
 	return &user, err
 }
 
+// GetIncludingLeft returns a user by open_id, including users who left.
+func (r *UserRepo) GetIncludingLeft(openID string) (*model.User, error) {
+	var user model.User
+	err := r.db.Unscoped().Where("open_id = ?", openID).First(&user).Error
+	return &user, err
+}
+
+// MarkLeft records that a user left and soft-deletes the row. It reports
+// false if the user is unknown or already marked.
+func (r *UserRepo) MarkLeft(openID string, at time.Time) (bool, error) {
+	res := r.db.Model(&model.User{}).
+		Where("open_id = ?", openID).
+		Updates(map[string]interface{}{"left_at": at, "deleted_at": at})
+	return res.RowsAffected > 0, res.Error
+}
+
+// Restore undoes MarkLeft for a user who is back in the organization. It
+// reports false if the user was not marked as left.
+func (r *UserRepo) Restore(openID string) (bool, error) {
+	res := r.db.Unscoped().Model(&model.User{}).
+		Where("open_id = ? AND deleted_at IS NOT NULL", openID).
+		Updates(map[string]interface{}{"left_at": nil, "deleted_at": nil})
+	return res.RowsAffected > 0, res.Error
+}
+
 // allowedSortColumns prevents SQL injection in ORDER BY.
 var allowedSortColumns = map[string]bool{
 	"name": true, "en_name": true, "employee_no": true,
 	"job_title": true, "email": true, "work_station": true,
 	"gender": true, "msg_count": true, "join_time": true,
 	"last_seen": true, "first_seen": true, "created_at": true,
+	"left_at": true,
 }
 
 // UserQuery holds query parameters for listing users.
@@ -58,6 +113,12 @@ type UserQuery struct {
 	Keyword  string
 	SortBy   string // column name
 	SortDir  string // "asc" or "desc"
+	// DepartmentID limits the list to members of an open_department_id,
+	// including its sub-departments when Recursive is set
+	DepartmentID string
+	Recursive    bool
+	// Status is "" for current users, "left" for departed users or "all"
+	Status string
 }
 
 // List returns paginated users with optional keyword search and sorting.
@@ -66,10 +127,19 @@ func (r *UserRepo) List(q UserQuery) ([]model.User, int64, error) {
 	var total int64
 
 	tx := r.db.Model(&model.User{})
+	switch q.Status {
+	case "left":
+		tx = tx.Unscoped().Where("deleted_at IS NOT NULL")
+	case "all":
+		tx = tx.Unscoped()
+	}
 	if q.Keyword != "" {
 		tx = tx.Where("name LIKE ? OR en_name LIKE ? OR open_id LIKE ? OR employee_no LIKE ? OR email LIKE ?",
 			"%"+q.Keyword+"%", "%"+q.Keyword+"%", "%"+q.Keyword+"%", "%"+q.Keyword+"%", "%"+q.Keyword+"%")
 	}
+	if q.DepartmentID != "" {
+		tx = tx.Where("open_id IN (?)", departmentMembers(r.db, []string{q.DepartmentID}, q.Recursive))
+	}
 	tx.Count(&total)
 
 	orderClause := "last_seen desc"
@@ -2,6 +2,7 @@ package scheduler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -10,6 +11,7 @@ import (
 	"github.com/robfig/cron/v3"
 	"go.uber.org/zap"
 
+	"lark-robot/internal/larkbot/msg"
 	"lark-robot/internal/model"
 )
 
@@ -22,6 +24,15 @@ type UpdateLastRunFunc func(id uint) error
 // UpdateNextRunFunc updates the next scheduled run time for a task.
 type UpdateNextRunFunc func(id uint, t time.Time) error
 
+// RunHookFunc is called after every task run with its outcome.
+type RunHookFunc func(taskID uint, chatID, source, messageID string, err error)
+
+// ResolveFunc returns the chats a task's group selector picks.
+type ResolveFunc func(selector string) ([]string, error)
+
+// sendTimeout bounds a single send of a task run.
+const sendTimeout = 30 * time.Second
+
 type Scheduler struct {
 	cron          *cron.Cron
 	entries       map[uint]cron.EntryID
@@ -29,6 +40,8 @@ type Scheduler struct {
 	sendFunc      SendFunc
 	updateLastRun UpdateLastRunFunc
 	updateNextRun UpdateNextRunFunc
+	runHook       RunHookFunc
+	resolve       ResolveFunc
 	logger        *zap.Logger
 }
 
@@ -48,6 +61,71 @@ func New(sendFunc SendFunc, updateLastRun UpdateLastRunFunc, updateNextRun Updat
 	}
 }
 
+// SetRunHook registers a function called after every task run.
+func (s *Scheduler) SetRunHook(hook RunHookFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runHook = hook
+}
+
+// SetResolver registers the function that resolves group selectors. Tasks
+// with a selector fail until one is set.
+func (s *Scheduler) SetResolver(resolve ResolveFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resolve = resolve
+}
+
+// deliver sends a task's message to its chat, or to every group its selector
+// picks at this moment, reporting each send to the run hook. It returns the
+// number of messages sent and the send errors; a selector that picks no group
+// is an error.
+func (s *Scheduler) deliver(ctx context.Context, taskID uint, chatID, selector, msgType, content, source string) (int, error) {
+	chatIDs := []string{chatID}
+	if selector != "" {
+		s.mu.Lock()
+		resolve := s.resolve
+		s.mu.Unlock()
+		var err error
+		if resolve == nil {
+			err = errors.New("group selectors are not supported")
+		} else if chatIDs, err = resolve(selector); err == nil && len(chatIDs) == 0 {
+			err = errors.New("group selector matched no groups")
+		}
+		if err != nil {
+			s.notifyRun(taskID, "", source, "", err)
+			return 0, err
+		}
+	}
+
+	sent := 0
+	var errs []error
+	for _, id := range chatIDs {
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		messageID, err := s.sendFunc(sendCtx, id, msgType, content, source)
+		cancel()
+		s.notifyRun(taskID, id, source, messageID, err)
+		if err != nil {
+			if selector != "" {
+				err = fmt.Errorf("%s: %w", id, err)
+			}
+			errs = append(errs, err)
+			continue
+		}
+		sent++
+	}
+	return sent, errors.Join(errs...)
+}
+
+func (s *Scheduler) notifyRun(taskID uint, chatID, source, messageID string, err error) {
+	s.mu.Lock()
+	hook := s.runHook
+	s.mu.Unlock()
+	if hook != nil {
+		hook(taskID, chatID, source, messageID, err)
+	}
+}
+
 // normalizeCronExpr converts Quartz-style cron expressions to robfig/cron format.
 // Replaces "?" (Quartz day-of-week/day-of-month wildcard) with "*".
 func normalizeCronExpr(expr string) string {
@@ -60,6 +138,12 @@ func (s *Scheduler) AddCleanupJob(cronExpr string, fn func()) error {
 	return err
 }
 
+// AddPeriodicJob runs fn every interval, skipping a tick while the previous
+// run is still going.
+func (s *Scheduler) AddPeriodicJob(interval time.Duration, fn func()) {
+	s.cron.Schedule(cron.Every(interval), cron.SkipIfStillRunning(cron.DiscardLogger)(cron.FuncJob(fn)))
+}
+
 func (s *Scheduler) Start() {
 	s.cron.Start()
 	s.logger.Info("scheduler started")
@@ -76,20 +160,23 @@ func (s *Scheduler) AddTask(task *model.ScheduledTask) error {
 
 	taskID := task.ID
 	chatID := task.ChatID
+	selector := task.GroupSelector
 	msgType := task.MsgType
-	content := task.Content
+	content, err := msg.Normalize(msgType, task.Content)
+	if err != nil {
+		return fmt.Errorf("task %d: %w", task.ID, err)
+	}
 
 	cronExpr := normalizeCronExpr(task.CronExpr)
 	entryID, err := s.cron.AddFunc(cronExpr, func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
-		_, err := s.sendFunc(ctx, chatID, msgType, content, "scheduled")
+		sent, err := s.deliver(context.Background(), taskID, chatID, selector, msgType, content, "scheduled")
 		if err != nil {
 			s.logger.Error("scheduled task send failed",
 				zap.Uint("task_id", taskID),
 				zap.Error(err),
 			)
+		}
+		if sent == 0 {
 			return
 		}
 
@@ -155,9 +242,15 @@ func (s *Scheduler) ReloadTask(task *model.ScheduledTask) error {
 
 // RunTaskNow executes a task immediately, bypassing the schedule.
 func (s *Scheduler) RunTaskNow(ctx context.Context, task *model.ScheduledTask) error {
-	_, err := s.sendFunc(ctx, task.ChatID, task.MsgType, task.Content, "manual")
+	content, err := msg.Normalize(task.MsgType, task.Content)
 	if err != nil {
 		return err
 	}
-	return s.updateLastRun(task.ID)
+	sent, err := s.deliver(ctx, task.ID, task.ChatID, task.GroupSelector, task.MsgType, content, "manual")
+	if sent > 0 {
+		if err := s.updateLastRun(task.ID); err != nil {
+			return err
+		}
+	}
+	return err
 }
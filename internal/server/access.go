@@ -0,0 +1,122 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"lark-robot/internal/model"
+)
+
+// ctxAdminUser is the gin context key of the authenticated *model.AdminUser.
+const ctxAdminUser = "admin_user"
+
+// ctxSessionID is the gin context key of the current login session's ID.
+const ctxSessionID = "session_id"
+
+// ctxStreamToken is the gin context key set to true for requests authenticated with a stream token.
+const ctxStreamToken = "stream_token"
+
+// ctxAPIKey is the gin context key of the *model.APIKey of requests made with an API key.
+const ctxAPIKey = "api_key"
+
+// currentUser returns the admin user set by AuthMiddleware.
+func currentUser(c *gin.Context) *model.AdminUser {
+	if v, ok := c.Get(ctxAdminUser); ok {
+		if user, ok := v.(*model.AdminUser); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// actorName identifies the current user in message logs (handled_by and the like).
+func actorName(c *gin.Context) string {
+	if user := currentUser(c); user != nil {
+		return user.Username
+	}
+	return "manual"
+}
+
+// RequireRole rejects users whose role is below role.
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := currentUser(c)
+		if user == nil || !user.HasRole(role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "权限不足", "kind": "forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireRoleForWrites applies RequireRole to every request except GET and HEAD.
+func RequireRoleForWrites(role string) gin.HandlerFunc {
+	require := RequireRole(role)
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+		require(c)
+	}
+}
+
+// allowChat reports whether the current user may access chatID, writing a
+// 403 response if not.
+func allowChat(c *gin.Context, chatID string) bool {
+	if user := currentUser(c); user != nil && user.CanAccessChat(chatID) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "无权访问该会话", "kind": "forbidden"})
+	return false
+}
+
+// chatScopes returns the chat_ids the current user is limited to, nil for all chats.
+func chatScopes(c *gin.Context) []string {
+	if user := currentUser(c); user != nil {
+		return user.Scopes()
+	}
+	return []string{}
+}
+
+// allowChats is allowChat for a list of chats. An empty list stands for all
+// chats, which only unscoped users may target.
+func allowChats(c *gin.Context, chatIDs []string) bool {
+	if chatScopes(c) == nil {
+		return true
+	}
+	if len(chatIDs) == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "受限账号必须指定可访问的会话", "kind": "forbidden"})
+		return false
+	}
+	for _, id := range chatIDs {
+		if !allowChat(c, id) {
+			return false
+		}
+	}
+	return true
+}
+
+// allowSelector reports whether the current user may target groups by a
+// group selector, writing a 403 response if not. A selector can match any
+// group, so like "all chats" it is only open to unscoped users.
+func allowSelector(c *gin.Context) bool {
+	if chatScopes(c) == nil {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "受限账号不能按群组属性选择会话", "kind": "forbidden"})
+	return false
+}
+
+// splitChatIDs splits a comma-separated chat_id list.
+func splitChatIDs(s string) []string {
+	var ids []string
+	for _, id := range strings.Split(s, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"lark-robot/internal/service"
+)
+
+type ActivityAPI struct {
+	activityService *service.ActivityService
+}
+
+func NewActivityAPI(as *service.ActivityService) *ActivityAPI {
+	return &ActivityAPI{activityService: as}
+}
+
+// User returns a user's activity over the last ?days= days (default 30).
+// Scoped accounts only see messages in their chats.
+func (api *ActivityAPI) User(c *gin.Context) {
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "30"))
+	report, err := api.activityService.UserActivity(c.Param("open_id"), days, chatScopes(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": report})
+}
+
+// Leaderboard ranks the most active users over the last ?days= days
+// (default 7) by ?by=messages|chats|active_days.
+func (api *ActivityAPI) Leaderboard(c *gin.Context) {
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "7"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+	entries, asOf, err := api.activityService.Leaderboard(days, c.DefaultQuery("by", "messages"), limit, chatScopes(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": entries, "as_of": asOf})
+}
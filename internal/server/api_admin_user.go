@@ -0,0 +1,161 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"lark-robot/internal/model"
+	"lark-robot/internal/service"
+)
+
+type AdminUserAPI struct {
+	adminUserService *service.AdminUserService
+	sessionService   *service.SessionService
+}
+
+func NewAdminUserAPI(aus *service.AdminUserService, ss *service.SessionService) *AdminUserAPI {
+	return &AdminUserAPI{adminUserService: aus, sessionService: ss}
+}
+
+func (api *AdminUserAPI) List(c *gin.Context) {
+	users, err := api.adminUserService.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": users, "total": len(users)})
+}
+
+func (api *AdminUserAPI) GetByID(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	user, err := api.adminUserService.GetByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "admin user not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": user})
+}
+
+type AdminUserRequest struct {
+	Username    string   `json:"username"`
+	DisplayName string   `json:"display_name"`
+	Password    string   `json:"password"` // required on create unless lark_open_id is set; empty on update keeps the current password
+	Role        string   `json:"role" binding:"required"`
+	LarkOpenID  string   `json:"lark_open_id"` // allows this Lark user to log in as the account via SSO
+	ChatScopes  []string `json:"chat_scopes"`  // empty = all chats
+	Enabled     *bool    `json:"enabled"`
+}
+
+func (req *AdminUserRequest) apply(user *model.AdminUser) {
+	user.DisplayName = req.DisplayName
+	user.Role = req.Role
+	user.LarkOpenID = req.LarkOpenID
+	user.ChatScopes = service.EncodeChatScopes(req.ChatScopes)
+	if req.Enabled != nil {
+		user.Enabled = *req.Enabled
+	}
+}
+
+func (api *AdminUserAPI) Create(c *gin.Context) {
+	var req AdminUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := &model.AdminUser{Username: req.Username, Source: model.SourceLocal, Enabled: true}
+	req.apply(user)
+	if err := api.adminUserService.Create(user, req.Password); err != nil {
+		api.respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": user})
+}
+
+// Update changes a user's profile, role, scopes or password. The username is fixed.
+// Changing the password or disabling the account ends all of the user's sessions.
+func (api *AdminUserAPI) Update(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	user, err := api.adminUserService.GetByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "admin user not found"})
+		return
+	}
+
+	var req AdminUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.apply(user)
+	if err := api.adminUserService.Update(user, req.Password); err != nil {
+		api.respondError(c, err)
+		return
+	}
+	if req.Password != "" || !user.Enabled {
+		if err := api.sessionService.RevokeUser(user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": user})
+}
+
+func (api *AdminUserAPI) Delete(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if user := currentUser(c); user != nil && user.ID == uint(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot delete the current user"})
+		return
+	}
+	if err := api.adminUserService.Delete(uint(id)); err != nil {
+		api.respondError(c, err)
+		return
+	}
+	if err := api.sessionService.RevokeUser(uint(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
+
+// ResetTOTP turns off a user's second factor, for users who lost their device.
+func (api *AdminUserAPI) ResetTOTP(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if err := api.adminUserService.ResetTOTP(uint(id)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "admin user not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "totp reset"})
+}
+
+func (api *AdminUserAPI) respondError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidAdminUser):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrLastAdmin):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
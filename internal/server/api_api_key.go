@@ -0,0 +1,85 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"lark-robot/internal/model"
+	"lark-robot/internal/service"
+)
+
+type APIKeyAPI struct {
+	apiKeyService *service.APIKeyService
+}
+
+func NewAPIKeyAPI(ks *service.APIKeyService) *APIKeyAPI {
+	return &APIKeyAPI{apiKeyService: ks}
+}
+
+func (api *APIKeyAPI) List(c *gin.Context) {
+	keys, err := api.apiKeyService.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": keys, "total": len(keys)})
+}
+
+type CreateAPIKeyRequest struct {
+	Name       string     `json:"name" binding:"required"`
+	Role       string     `json:"role"`                         // viewer or operator, default operator
+	Endpoints  []string   `json:"endpoints" binding:"required"` // e.g. "POST /api/messages/send"
+	ChatScopes []string   `json:"chat_scopes"`                  // empty = all chats
+	ExpiresAt  *time.Time `json:"expires_at"`                   // nil = never expires
+}
+
+// Create returns the new key's secret in "key". It is not stored and cannot be shown again.
+func (api *APIKeyAPI) Create(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key := &model.APIKey{
+		Name:      req.Name,
+		Role:      req.Role,
+		ExpiresAt: req.ExpiresAt,
+		CreatedBy: actorName(c),
+	}
+	if key.Role == "" {
+		key.Role = model.RoleOperator
+	}
+	secret, err := api.apiKeyService.Create(key, req.Endpoints, req.ChatScopes)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidAPIKey) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": key, "key": secret})
+}
+
+// Revoke disables a key immediately.
+func (api *APIKeyAPI) Revoke(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if _, err := api.apiKeyService.GetByID(uint(id)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "api key not found"})
+		return
+	}
+	if err := api.apiKeyService.Revoke(uint(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "revoked"})
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"lark-robot/internal/model"
+	"lark-robot/internal/repository"
+	"lark-robot/internal/service"
+)
+
+type AuditAPI struct {
+	auditService *service.AuditService
+	jobService   *service.JobService
+}
+
+func NewAuditAPI(as *service.AuditService, js *service.JobService) *AuditAPI {
+	return &AuditAPI{auditService: as, jobService: js}
+}
+
+// auditQuery reads the filters shared by List and Export. from and to accept
+// RFC 3339 times or dates; a date as to includes that whole day.
+func auditQuery(c *gin.Context) (repository.AuditQuery, error) {
+	q := repository.AuditQuery{
+		Actor:      c.Query("actor"),
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+	}
+	for _, f := range []struct {
+		name string
+		dst  **time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		v := c.Query(f.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			t, err = time.ParseInLocation("2006-01-02", v, time.Local)
+			if err != nil {
+				return q, fmt.Errorf("invalid %s: want RFC 3339 time or YYYY-MM-DD", f.name)
+			}
+			if f.name == "to" {
+				t = t.AddDate(0, 0, 1)
+			}
+		}
+		*f.dst = &t
+	}
+	return q, nil
+}
+
+func (api *AuditAPI) List(c *gin.Context) {
+	q, err := auditQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	q.Page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	q.PageSize, _ = strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.PageSize < 1 || q.PageSize > 100 {
+		q.PageSize = 20
+	}
+
+	entries, total, err := api.auditService.List(q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": entries, "total": total})
+}
+
+// Export streams all entries matching the filters as CSV (format=csv, the
+// default) or JSON Lines (format=jsonl).
+func (api *AuditAPI) Export(c *gin.Context) {
+	q, format, err := auditExportQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="`+auditExportName(format)+`"`)
+	if format == "jsonl" {
+		c.Header("Content-Type", "application/x-ndjson; charset=utf-8")
+	} else {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+	}
+	if err := api.writeExport(c.Writer, q, format, nil); err != nil {
+		// Headers are already sent; all we can do is cut the file short
+		c.Error(err)
+	}
+}
+
+// auditExportResult is the result of an export job.
+type auditExportResult struct {
+	Rows int    `json:"rows"`
+	File string `json:"file"`
+	Size int64  `json:"size"`
+}
+
+// ExportJob writes the same export as Export to a file in a background job,
+// for large exports. The file is downloaded from /api/jobs/:id/file.
+func (api *AuditAPI) ExportJob(c *gin.Context) {
+	q, format, err := auditExportQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	job, err := api.jobService.Submit(service.JobSpec{
+		Kind:      service.JobAuditExport,
+		CreatedBy: actorName(c),
+		Params:    c.Request.URL.Query(),
+	}, func(ctx context.Context, p *service.JobProgress) (interface{}, error) {
+		count := q
+		count.Page, count.PageSize = 1, 1
+		if _, total, err := api.auditService.List(count); err == nil {
+			p.SetTotal(int(total))
+		}
+
+		name := auditExportName(format)
+		f, err := p.CreateFile(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		result := &auditExportResult{File: name}
+		err = api.writeExport(f, q, format, func() error {
+			result.Rows++
+			p.Add(1)
+			return ctx.Err()
+		})
+		if err == nil {
+			err = f.Close()
+		}
+		if info, serr := os.Stat(f.Name()); serr == nil {
+			result.Size = info.Size()
+		}
+		return result, err
+	})
+	respondJob(c, job, err)
+}
+
+// auditExportQuery reads the filters and format of an export.
+func auditExportQuery(c *gin.Context) (repository.AuditQuery, string, error) {
+	q, err := auditQuery(c)
+	if err != nil {
+		return q, "", err
+	}
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "jsonl" {
+		return q, "", errors.New("format must be csv or jsonl")
+	}
+	return q, format, nil
+}
+
+func auditExportName(format string) string {
+	return fmt.Sprintf("audit-%s.%s", time.Now().Format("20060102-150405"), format)
+}
+
+// writeExport writes the entries matching q to w. row, if set, is called
+// after each entry and stops the export when it returns an error.
+func (api *AuditAPI) writeExport(w io.Writer, q repository.AuditQuery, format string, row func() error) error {
+	var write func(e *model.AuditEntry) error
+	var flush func()
+	if format == "jsonl" {
+		enc := json.NewEncoder(w)
+		write = func(e *model.AuditEntry) error { return enc.Encode(e) }
+	} else {
+		cw := csv.NewWriter(w)
+		flush = cw.Flush
+		cw.Write([]string{"id", "time", "actor", "actor_type", "action", "method", "route",
+			"target_type", "target_id", "status", "ip", "user_agent", "diff", "before", "after"})
+		write = func(e *model.AuditEntry) error {
+			return cw.Write([]string{
+				strconv.FormatUint(uint64(e.ID), 10), e.CreatedAt.Format(time.RFC3339), e.Actor, e.ActorType,
+				e.Action, e.Method, e.Route, e.TargetType, e.TargetID, strconv.Itoa(e.Status),
+				e.IP, e.UserAgent, e.Diff, e.Before, e.After,
+			})
+		}
+	}
+	err := api.auditService.Each(q, func(e *model.AuditEntry) error {
+		if err := write(e); err != nil {
+			return err
+		}
+		if row != nil {
+			return row()
+		}
+		return nil
+	})
+	if flush != nil {
+		flush()
+	}
+	return err
+}
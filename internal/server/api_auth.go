@@ -1,100 +1,210 @@
 package server
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/base64"
-	"fmt"
+	"errors"
 	"net/http"
-	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"lark-robot/internal/service"
 )
 
 type AuthAPI struct {
-	username string
-	password string
-	secret   string
+	adminUserService *service.AdminUserService
+	sessionService   *service.SessionService
+	passwordLogin    bool
+	larkSSO          bool
+	totpIssuer       string
+}
+
+func NewAuthAPI(aus *service.AdminUserService, ss *service.SessionService, passwordLogin, larkSSO bool, totpIssuer string) *AuthAPI {
+	return &AuthAPI{adminUserService: aus, sessionService: ss, passwordLogin: passwordLogin, larkSSO: larkSSO, totpIssuer: totpIssuer}
 }
 
-func NewAuthAPI(username, password, secret string) *AuthAPI {
-	return &AuthAPI{username: username, password: password, secret: secret}
+// Options tells the login page which login methods are available.
+func (api *AuthAPI) Options(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"password_login": api.passwordLogin, "lark_sso": api.larkSSO})
 }
 
 type LoginRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
+	TOTPCode string `json:"totp_code"` // required once the account has TOTP enabled
 }
 
 func (api *AuthAPI) Login(c *gin.Context) {
+	if !api.passwordLogin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "密码登录已关闭，请使用飞书登录"})
+		return
+	}
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "用户名和密码不能为空"})
 		return
 	}
 
-	if req.Username != api.username || req.Password != api.password {
+	user, err := api.adminUserService.Authenticate(req.Username, req.Password)
+	if err != nil {
+		if errors.Is(err, service.ErrAccountDisabled) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "账号已停用"})
+			return
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户名或密码错误"})
 		return
 	}
+	if user.TOTPEnabled {
+		if req.TOTPCode == "" {
+			// The password was right; ask for the code without counting a failure
+			c.Set(ctxTOTPPending, true)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "请输入动态验证码", "totp_required": true})
+			return
+		}
+		if !api.adminUserService.VerifyTOTP(user, req.TOTPCode) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "动态验证码错误", "totp_required": true})
+			return
+		}
+	}
 
-	token := generateToken(api.secret, req.Username)
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	pair, err := api.sessionService.Create(user, "password", c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"token":         pair.AccessToken,
+		"refresh_token": pair.RefreshToken,
+		"expires_in":    pair.ExpiresIn,
+		"user":          user,
+	})
 }
 
-// generateToken creates a simple HMAC-based token: base64(username|expiry|signature)
-func generateToken(secret, username string) string {
-	expiry := time.Now().Add(24 * time.Hour).Unix()
-	payload := fmt.Sprintf("%s|%d", username, expiry)
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write([]byte(payload))
-	sig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
-	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s|%s", payload, sig)))
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
 }
 
-// ValidateToken checks if a token is valid and not expired.
-func ValidateToken(secret, token string) bool {
-	raw, err := base64.RawURLEncoding.DecodeString(token)
+// Refresh exchanges a refresh token for a new access and refresh token.
+// The old refresh token stops working.
+func (api *AuthAPI) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims, err := api.sessionService.Parse(req.RefreshToken, service.TokenRefresh)
 	if err != nil {
-		return false
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录或登录已过期"})
+		return
+	}
+	user, err := api.adminUserService.GetActive(claims.Subject)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "账号不存在或已停用"})
+		return
 	}
+	pair, err := api.sessionService.Refresh(req.RefreshToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录或登录已过期"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"token":         pair.AccessToken,
+		"refresh_token": pair.RefreshToken,
+		"expires_in":    pair.ExpiresIn,
+		"user":          user,
+	})
+}
 
-	parts := splitLast(string(raw), "|")
-	if len(parts) != 2 {
-		return false
+// Logout revokes the current session.
+func (api *AuthAPI) Logout(c *gin.Context) {
+	if err := api.sessionService.Revoke(c.GetString(ctxSessionID)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
-	payload, sig := parts[0], parts[1]
+	c.JSON(http.StatusOK, gin.H{"message": "logged out"})
+}
 
-	// Verify signature
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write([]byte(payload))
-	expectedSig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
-	if sig != expectedSig {
-		return false
+// StreamToken issues a short-lived token for the SSE stream, WebSocket and
+// image URLs, which cannot send an Authorization header.
+func (api *AuthAPI) StreamToken(c *gin.Context) {
+	if c.GetBool(ctxStreamToken) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "该接口需要登录会话", "kind": "forbidden"})
+		return
+	}
+	token, expiresIn, err := api.sessionService.StreamToken(currentUser(c).Username, c.GetString(ctxSessionID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
+	c.JSON(http.StatusOK, gin.H{"token": token, "expires_in": expiresIn})
+}
 
-	// Check expiry
-	var username string
-	var expiry int64
-	fmt.Sscanf(payload, "%s|%d", &username, &expiry)
+// Me returns the logged-in admin user, and the key for requests made with an API key.
+func (api *AuthAPI) Me(c *gin.Context) {
+	if key, ok := c.Get(ctxAPIKey); ok {
+		c.JSON(http.StatusOK, gin.H{"data": currentUser(c), "api_key": key})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": currentUser(c)})
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
 
-	// Parse expiry from payload
-	for i := len(payload) - 1; i >= 0; i-- {
-		if payload[i] == '|' {
-			fmt.Sscanf(payload[i+1:], "%d", &expiry)
-			break
+// SetupTOTP creates a new TOTP secret for the current user. It is returned
+// once, to be added to an authenticator app, and takes effect after
+// EnableTOTP confirms a code from it.
+func (api *AuthAPI) SetupTOTP(c *gin.Context) {
+	secret, url, err := api.adminUserService.SetupTOTP(currentUser(c), api.totpIssuer)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidAdminUser) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "已启用两步验证，请先关闭"})
+			return
 		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"secret": secret, "url": url})
+}
+
+// EnableTOTP turns on the second factor for the current user.
+func (api *AuthAPI) EnableTOTP(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请输入动态验证码"})
+		return
 	}
+	user := currentUser(c)
+	if err := api.adminUserService.EnableTOTP(user, req.Code); err != nil {
+		api.totpError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": user})
+}
 
-	return time.Now().Unix() < expiry
+// DisableTOTP turns off the second factor; the user confirms with a current code.
+func (api *AuthAPI) DisableTOTP(c *gin.Context) {
+	var req TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请输入动态验证码"})
+		return
+	}
+	user := currentUser(c)
+	if err := api.adminUserService.DisableTOTP(user, req.Code); err != nil {
+		api.totpError(c, err)
+		return
+	}
+	user.TOTPEnabled = false
+	c.JSON(http.StatusOK, gin.H{"data": user})
 }
 
-// splitLast splits s by the last occurrence of sep.
-func splitLast(s, sep string) []string {
-	for i := len(s) - 1; i >= 0; i-- {
-		if string(s[i]) == sep {
-			return []string{s[:i], s[i+1:]}
-		}
+func (api *AuthAPI) totpError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidTOTP):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "动态验证码错误"})
+	case errors.Is(err, service.ErrInvalidAdminUser):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 	}
-	return []string{s}
 }
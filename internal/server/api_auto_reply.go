@@ -28,7 +28,7 @@ func (api *AutoReplyAPI) List(c *gin.Context) {
 		pageSize = 10
 	}
 
-	rules, total, err := api.replyService.List(page, pageSize)
+	rules, total, err := api.replyService.List(page, pageSize, chatScopes(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -37,26 +37,23 @@ func (api *AutoReplyAPI) List(c *gin.Context) {
 }
 
 func (api *AutoReplyAPI) GetByID(c *gin.Context) {
-	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
-		return
-	}
-	rule, err := api.replyService.GetByID(uint(id))
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
+	rule, ok := api.scopedRule(c)
+	if !ok {
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"data": rule})
 }
 
 type CreateAutoReplyRequest struct {
-	Keyword     string `json:"keyword" binding:"required"`
-	ReplyText   string `json:"reply_text" binding:"required"`
-	MatchMode   string `json:"match_mode"`
-	TriggerMode string `json:"trigger_mode"`
-	ChatID      string `json:"chat_id"`
-	Enabled     *bool  `json:"enabled"`
+	Keyword       string               `json:"keyword" binding:"required"`
+	ReplyText     string               `json:"reply_text" binding:"required"`
+	MatchMode     string               `json:"match_mode"`
+	TriggerMode   string               `json:"trigger_mode"`
+	ChatID        string               `json:"chat_id"`
+	GroupSelector *model.GroupSelector `json:"group_selector"` // limits the rule to matching groups
+	MentionUser   string               `json:"mention_user"`
+	ReplyInThread bool                 `json:"reply_in_thread"`
+	Enabled       *bool                `json:"enabled"`
 }
 
 func (api *AutoReplyAPI) Create(c *gin.Context) {
@@ -66,13 +63,20 @@ func (api *AutoReplyAPI) Create(c *gin.Context) {
 		return
 	}
 
+	if !req.allowTarget(c) {
+		return
+	}
+
 	rule := &model.AutoReplyRule{
-		Keyword:     req.Keyword,
-		ReplyText:   req.ReplyText,
-		MatchMode:   req.MatchMode,
-		TriggerMode: req.TriggerMode,
-		ChatID:      req.ChatID,
-		Enabled:     true,
+		Keyword:       req.Keyword,
+		ReplyText:     req.ReplyText,
+		MatchMode:     req.MatchMode,
+		TriggerMode:   req.TriggerMode,
+		ChatID:        req.ChatID,
+		GroupSelector: req.GroupSelector.String(),
+		MentionUser:   req.MentionUser,
+		ReplyInThread: req.ReplyInThread,
+		Enabled:       true,
 	}
 	if rule.MatchMode == "" {
 		rule.MatchMode = "contains"
@@ -92,15 +96,8 @@ func (api *AutoReplyAPI) Create(c *gin.Context) {
 }
 
 func (api *AutoReplyAPI) Update(c *gin.Context) {
-	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
-		return
-	}
-
-	rule, err := api.replyService.GetByID(uint(id))
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
+	rule, ok := api.scopedRule(c)
+	if !ok {
 		return
 	}
 
@@ -109,6 +106,9 @@ func (api *AutoReplyAPI) Update(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !req.allowTarget(c) {
+		return
+	}
 
 	rule.Keyword = req.Keyword
 	rule.ReplyText = req.ReplyText
@@ -119,6 +119,9 @@ func (api *AutoReplyAPI) Update(c *gin.Context) {
 		rule.TriggerMode = req.TriggerMode
 	}
 	rule.ChatID = req.ChatID
+	rule.GroupSelector = req.GroupSelector.String()
+	rule.MentionUser = req.MentionUser
+	rule.ReplyInThread = req.ReplyInThread
 	if req.Enabled != nil {
 		rule.Enabled = *req.Enabled
 	}
@@ -136,6 +139,9 @@ func (api *AutoReplyAPI) Delete(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
+	if _, ok := api.scopedRule(c); !ok {
+		return
+	}
 	if err := api.replyService.Delete(uint(id)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -149,9 +155,49 @@ func (api *AutoReplyAPI) Toggle(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
+	if _, ok := api.scopedRule(c); !ok {
+		return
+	}
 	if err := api.replyService.Toggle(uint(id)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "toggled"})
 }
+
+// allowTarget checks that the current user may limit a rule to the requested
+// chats and group selector. It writes the error response when ok is false.
+func (req *CreateAutoReplyRequest) allowTarget(c *gin.Context) bool {
+	if req.GroupSelector != nil {
+		if !allowSelector(c) {
+			return false
+		}
+		if err := service.ValidateSelector(req.GroupSelector); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return false
+		}
+	}
+	return allowChats(c, splitChatIDs(req.ChatID))
+}
+
+// scopedRule loads the rule named by the id param and checks that the current
+// user may access its chats. It writes the error response when ok is false.
+func (api *AutoReplyAPI) scopedRule(c *gin.Context) (*model.AutoReplyRule, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return nil, false
+	}
+	rule, err := api.replyService.GetByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
+		return nil, false
+	}
+	if rule.GroupSelector != "" && !allowSelector(c) {
+		return nil, false
+	}
+	if !allowChats(c, splitChatIDs(rule.ChatID)) {
+		return nil, false
+	}
+	return rule, true
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"lark-robot/internal/model"
+	"lark-robot/internal/service"
+)
+
+type CampaignAPI struct {
+	campaignService *service.CampaignService
+}
+
+func NewCampaignAPI(cs *service.CampaignService) *CampaignAPI {
+	return &CampaignAPI{campaignService: cs}
+}
+
+func (api *CampaignAPI) List(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 10
+	}
+
+	campaigns, total, err := api.campaignService.List(page, pageSize, chatScopes(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": campaigns, "total": total})
+}
+
+func (api *CampaignAPI) GetByID(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	campaign, err := api.campaignService.GetByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "campaign not found"})
+		return
+	}
+	if !allowChats(c, campaignChats(campaign)) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": campaign})
+}
+
+type CreateCampaignRequest struct {
+	Name           string               `json:"name" binding:"required"`
+	MsgType        string               `json:"msg_type"`
+	Content        string               `json:"content" binding:"required"`
+	TargetType     string               `json:"target_type" binding:"required"` // chats, all_groups, users, departments, group_selector
+	TargetIDs      []string             `json:"target_ids"`
+	TargetSelector *model.GroupSelector `json:"target_selector"` // for target_type group_selector
+	ThrottleMs     *int                 `json:"throttle_ms"`
+	ScheduledAt    *time.Time           `json:"scheduled_at"`
+}
+
+func (req *CreateCampaignRequest) apply(campaign *model.Campaign) {
+	campaign.Name = req.Name
+	campaign.MsgType = req.MsgType
+	campaign.Content = req.Content
+	campaign.TargetType = req.TargetType
+	campaign.TargetIDs = ""
+	if len(req.TargetIDs) > 0 {
+		b, _ := json.Marshal(req.TargetIDs)
+		campaign.TargetIDs = string(b)
+	}
+	campaign.TargetSelector = ""
+	if req.TargetType == service.TargetGroupSelector {
+		campaign.TargetSelector = req.TargetSelector.String()
+	}
+	if req.ThrottleMs != nil {
+		campaign.ThrottleMs = *req.ThrottleMs
+	}
+	campaign.ScheduledAt = req.ScheduledAt
+}
+
+func (api *CampaignAPI) Create(c *gin.Context) {
+	var req CreateCampaignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	campaign := &model.Campaign{ThrottleMs: 1000}
+	req.apply(campaign)
+	if !allowChats(c, campaignChats(campaign)) {
+		return
+	}
+	if err := api.campaignService.Create(campaign); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": campaign})
+}
+
+func (api *CampaignAPI) Update(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+
+	campaign, err := api.campaignService.GetByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "campaign not found"})
+		return
+	}
+	if !allowChats(c, campaignChats(campaign)) {
+		return
+	}
+
+	var req CreateCampaignRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.apply(campaign)
+	if !allowChats(c, campaignChats(campaign)) {
+		return
+	}
+	if err := api.campaignService.Update(campaign); err != nil {
+		api.respondStateError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": campaign})
+}
+
+func (api *CampaignAPI) Delete(c *gin.Context) {
+	api.action(c, api.campaignService.Delete, "deleted")
+}
+
+func (api *CampaignAPI) Start(c *gin.Context) {
+	api.action(c, func(id uint) error { return api.campaignService.Start(id, actorName(c)) }, "started")
+}
+
+func (api *CampaignAPI) Pause(c *gin.Context) {
+	api.action(c, api.campaignService.Pause, "paused")
+}
+
+func (api *CampaignAPI) Resume(c *gin.Context) {
+	api.action(c, func(id uint) error { return api.campaignService.Resume(id, actorName(c)) }, "resumed")
+}
+
+func (api *CampaignAPI) Cancel(c *gin.Context) {
+	api.action(c, api.campaignService.Cancel, "cancelled")
+}
+
+// Recall recalls every message the campaign has sent. It runs in the background.
+func (api *CampaignAPI) Recall(c *gin.Context) {
+	api.action(c, func(id uint) error { return api.campaignService.Recall(id, actorName(c)) }, "recall started")
+}
+
+// Recipients returns the per-recipient delivery status of a campaign.
+func (api *CampaignAPI) Recipients(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	campaign, err := api.campaignService.GetByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "campaign not found"})
+		return
+	}
+	if !allowChats(c, campaignChats(campaign)) {
+		return
+	}
+
+	recipients, total, err := api.campaignService.ListRecipients(uint(id), c.Query("status"), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": recipients, "total": total})
+}
+
+func (api *CampaignAPI) action(c *gin.Context, fn func(id uint) error, message string) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	campaign, err := api.campaignService.GetByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "campaign not found"})
+		return
+	}
+	if !allowChats(c, campaignChats(campaign)) {
+		return
+	}
+	if err := fn(uint(id)); err != nil {
+		api.respondStateError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+func (api *CampaignAPI) respondStateError(c *gin.Context, err error) {
+	if errors.Is(err, service.ErrCampaignState) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	respondError(c, err)
+}
+
+// campaignChats returns the chats a campaign targets. Campaigns to all groups,
+// a group selector, or users and departments return nil, which scoped users
+// may not target.
+func campaignChats(campaign *model.Campaign) []string {
+	if campaign.TargetType != "chats" {
+		return nil
+	}
+	var ids []string
+	json.Unmarshal([]byte(campaign.TargetIDs), &ids)
+	return ids
+}
@@ -1,20 +1,26 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 
+	"lark-robot/internal/model"
+	"lark-robot/internal/repository"
 	"lark-robot/internal/service"
 )
 
 type ChatAPI struct {
 	chatService *service.ChatService
+	jobService  *service.JobService
 }
 
-func NewChatAPI(cs *service.ChatService) *ChatAPI {
-	return &ChatAPI{chatService: cs}
+func NewChatAPI(cs *service.ChatService, js *service.JobService) *ChatAPI {
+	return &ChatAPI{chatService: cs, jobService: js}
 }
 
 func (api *ChatAPI) List(c *gin.Context) {
@@ -27,7 +33,16 @@ func (api *ChatAPI) List(c *gin.Context) {
 		pageSize = 10
 	}
 
-	groups, total, err := api.chatService.ListGroups(page, pageSize)
+	groups, total, err := api.chatService.ListGroups(repository.GroupQuery{
+		Page:        page,
+		PageSize:    pageSize,
+		ChatIDs:     chatScopes(c),
+		Tag:         c.Query("tag"),
+		Team:        c.Query("team"),
+		Environment: c.Query("environment"),
+		// "left" lists groups the bot has left, "all" lists both
+		Status: c.Query("status"),
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -35,19 +50,23 @@ func (api *ChatAPI) List(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": groups, "total": total})
 }
 
+// Sync re-fetches the bot's groups from Lark as a background job and
+// returns the job. Only one group sync runs at a time.
 func (api *ChatAPI) Sync(c *gin.Context) {
-	groups, err := api.chatService.SyncChats(c.Request.Context())
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"data": groups, "message": "sync completed"})
+	job, err := api.jobService.Submit(service.JobSpec{
+		Kind:      service.JobChatSync,
+		CreatedBy: actorName(c),
+		Exclusive: true,
+	}, func(ctx context.Context, p *service.JobProgress) (interface{}, error) {
+		return api.chatService.SyncChats(ctx, p)
+	})
+	respondJob(c, job, err)
 }
 
 func (api *ChatAPI) Leave(c *gin.Context) {
 	chatID := c.Param("chat_id")
-	if err := api.chatService.LeaveChat(c.Request.Context(), chatID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := api.chatService.LeaveChat(c.Request.Context(), chatID, actorName(c)); err != nil {
+		respondError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "left chat successfully"})
@@ -55,6 +74,9 @@ func (api *ChatAPI) Leave(c *gin.Context) {
 
 func (api *ChatAPI) Members(c *gin.Context) {
 	chatID := c.Param("chat_id")
+	if !allowChat(c, chatID) {
+		return
+	}
 	pageToken := c.Query("page_token")
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "50"))
 	if pageSize < 1 || pageSize > 100 {
@@ -63,7 +85,7 @@ func (api *ChatAPI) Members(c *gin.Context) {
 
 	page, err := api.chatService.GetChatMembersPage(c.Request.Context(), chatID, pageToken, pageSize)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{
@@ -73,3 +95,88 @@ func (api *ChatAPI) Members(c *gin.Context) {
 		"total":      page.Total,
 	})
 }
+
+// GetByChatID returns a group, including one the bot has left.
+func (api *ChatAPI) GetByChatID(c *gin.Context) {
+	chatID := c.Param("chat_id")
+	if !allowChat(c, chatID) {
+		return
+	}
+	group, err := api.chatService.GetGroup(chatID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "group not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": group})
+}
+
+// UpdateMeta replaces the locally managed tags, team, environment, notes and
+// custom attributes of a group.
+func (api *ChatAPI) UpdateMeta(c *gin.Context) {
+	chatID := c.Param("chat_id")
+	if !allowChat(c, chatID) {
+		return
+	}
+	var req service.GroupMeta
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	group, err := api.chatService.UpdateMeta(chatID, req)
+	switch {
+	case errors.Is(err, service.ErrInvalidGroupMeta):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "group not found or the bot has left it"})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, gin.H{"data": group})
+	}
+}
+
+// History lists when the bot joined and left a group.
+func (api *ChatAPI) History(c *gin.Context) {
+	chatID := c.Param("chat_id")
+	if !allowChat(c, chatID) {
+		return
+	}
+	history, err := api.chatService.History(chatID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": history})
+}
+
+// Labels lists the tags, teams, environments and attribute keys in use.
+func (api *ChatAPI) Labels(c *gin.Context) {
+	labels, err := api.chatService.Labels()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": labels})
+}
+
+// Match previews the groups a selector picks. The selector is passed as
+// JSON in the selector query param. Restricted accounts cannot use selectors.
+func (api *ChatAPI) Match(c *gin.Context) {
+	if !allowSelector(c) {
+		return
+	}
+	sel, err := model.ParseGroupSelector(c.Query("selector"))
+	if err == nil {
+		err = service.ValidateSelector(sel)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	groups, err := api.chatService.MatchGroups(sel)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": groups, "total": len(groups)})
+}
@@ -32,7 +32,8 @@ func (api *DashboardAPI) GetStats(c *gin.Context) {
 	taskCount, _ := api.schedulerService.TaskCount()
 	userCount, _ := api.userService.UserCount()
 
-	_, ruleCount, _ := api.replyService.List(1, 1)
+	_, ruleCount, _ := api.replyService.List(1, 1, nil)
+	queue := api.messageService.QueueStats()
 
 	c.JSON(http.StatusOK, gin.H{
 		"group_count":    groupCount,
@@ -40,5 +41,6 @@ func (api *DashboardAPI) GetStats(c *gin.Context) {
 		"task_count":     taskCount,
 		"rule_count":     ruleCount,
 		"user_count":     userCount,
+		"queue_depth":    queue.Pending,
 	})
 }
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"lark-robot/internal/repository"
+	"lark-robot/internal/service"
+)
+
+type DepartmentAPI struct {
+	departmentService *service.DepartmentService
+	userService       *service.UserService
+}
+
+func NewDepartmentAPI(ds *service.DepartmentService, us *service.UserService) *DepartmentAPI {
+	return &DepartmentAPI{departmentService: ds, userService: us}
+}
+
+// List returns the department tree, or a flat list with flat=1.
+func (api *DepartmentAPI) List(c *gin.Context) {
+	if c.Query("flat") == "1" {
+		depts, err := api.departmentService.List()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": depts, "total": len(depts)})
+		return
+	}
+	tree, err := api.departmentService.Tree()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": tree})
+}
+
+// GetByID returns a department with the chain of departments above it.
+func (api *DepartmentAPI) GetByID(c *gin.Context) {
+	dept, err := api.departmentService.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "department not found"})
+		return
+	}
+	ancestors, err := api.departmentService.Ancestors(dept)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": dept, "ancestors": ancestors})
+}
+
+// Users returns a page of the known users in a department;
+// recursive=1 includes sub-departments.
+func (api *DepartmentAPI) Users(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	users, total, err := api.userService.ListUsers(repository.UserQuery{
+		Page:         page,
+		PageSize:     pageSize,
+		Keyword:      c.Query("keyword"),
+		DepartmentID: c.Param("id"),
+		Recursive:    c.Query("recursive") == "1",
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": users, "total": total})
+}
+
+// Sync re-fetches the department tree from Lark.
+func (api *DepartmentAPI) Sync(c *gin.Context) {
+	result, err := api.departmentService.Sync(c.Request.Context())
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"lark-robot/internal/model"
+	"lark-robot/internal/service"
+)
+
+type DirectoryImportAPI struct {
+	importService *service.DirectoryImportService
+}
+
+func NewDirectoryImportAPI(is *service.DirectoryImportService) *DirectoryImportAPI {
+	return &DirectoryImportAPI{importService: is}
+}
+
+// Latest returns the most recent directory import, or null if there is none.
+func (api *DirectoryImportAPI) Latest(c *gin.Context) {
+	imp, err := api.importService.Latest()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"data": nil})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": imp})
+}
+
+// Start begins importing the whole Lark directory. It runs in the background;
+// follow it with Latest or the directory_import event.
+func (api *DirectoryImportAPI) Start(c *gin.Context) {
+	imp, err := api.importService.Start(actorName(c))
+	if err != nil {
+		api.respondStateError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"data": imp})
+}
+
+func (api *DirectoryImportAPI) Pause(c *gin.Context) {
+	api.action(c, api.importService.Pause)
+}
+
+func (api *DirectoryImportAPI) Resume(c *gin.Context) {
+	api.action(c, api.importService.Resume)
+}
+
+func (api *DirectoryImportAPI) action(c *gin.Context, fn func(id uint) (*model.DirectoryImport, error)) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if _, err := api.importService.Get(uint(id)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "import not found"})
+		return
+	}
+	imp, err := fn(uint(id))
+	if err != nil {
+		api.respondStateError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": imp})
+}
+
+func (api *DirectoryImportAPI) respondStateError(c *gin.Context, err error) {
+	if errors.Is(err, service.ErrImportState) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	respondError(c, err)
+}
@@ -0,0 +1,22 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"lark-robot/internal/broadcast"
+)
+
+type EventAPI struct {
+	bus *broadcast.Bus
+}
+
+func NewEventAPI(bus *broadcast.Bus) *EventAPI {
+	return &EventAPI{bus: bus}
+}
+
+// Stats returns event bus counters and per-subscriber drop counts.
+func (api *EventAPI) Stats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": api.bus.Stats()})
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"lark-robot/internal/model"
+	"lark-robot/internal/repository"
+	"lark-robot/internal/service"
+)
+
+type JobAPI struct {
+	jobService *service.JobService
+}
+
+func NewJobAPI(js *service.JobService) *JobAPI {
+	return &JobAPI{jobService: js}
+}
+
+// List returns a page of jobs, filtered by kind and status. Admins see
+// everyone's jobs, other users only their own.
+func (api *JobAPI) List(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	q := repository.JobQuery{
+		Page:     page,
+		PageSize: pageSize,
+		Kind:     c.Query("kind"),
+		Status:   c.Query("status"),
+	}
+	if !isAdmin(c) {
+		q.CreatedBy = actorName(c)
+	}
+	jobs, total, err := api.jobService.List(q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": jobs, "total": total})
+}
+
+func (api *JobAPI) GetByID(c *gin.Context) {
+	job, ok := api.job(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": job})
+}
+
+// Cancel stops a running job. Campaigns and directory imports are paused
+// rather than cancelled, so they can be resumed.
+func (api *JobAPI) Cancel(c *gin.Context) {
+	job, ok := api.job(c)
+	if !ok {
+		return
+	}
+	job, err := api.jobService.Cancel(job.ID)
+	if err != nil {
+		respondJobError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": job})
+}
+
+// File downloads the file produced by a finished export job.
+func (api *JobAPI) File(c *gin.Context) {
+	job, ok := api.job(c)
+	if !ok {
+		return
+	}
+	_, path, err := api.jobService.File(job.ID)
+	if err != nil {
+		respondJobError(c, err)
+		return
+	}
+	c.FileAttachment(path, filepath.Base(path))
+}
+
+// job loads the job named by the id param, answering 404 for jobs of other
+// users unless the current user is an admin.
+func (api *JobAPI) job(c *gin.Context) (*model.Job, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return nil, false
+	}
+	job, err := api.jobService.Get(uint(id))
+	if err != nil || (!isAdmin(c) && job.CreatedBy != actorName(c)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return nil, false
+	}
+	return job, true
+}
+
+func isAdmin(c *gin.Context) bool {
+	user := currentUser(c)
+	return user != nil && user.HasRole(model.RoleAdmin)
+}
+
+// respondJob answers a request that started a job with the job, or with 409
+// if it could not start now.
+func respondJob(c *gin.Context, job *model.Job, err error) {
+	if err != nil {
+		respondJobError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"data": job})
+}
+
+func respondJobError(c *gin.Context, err error) {
+	if errors.Is(err, service.ErrJobState) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	respondError(c, err)
+}
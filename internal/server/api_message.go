@@ -2,6 +2,7 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,17 +12,18 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"lark-robot/internal/broadcast"
+	"lark-robot/internal/larkbot/msg"
 	"lark-robot/internal/repository"
 	"lark-robot/internal/service"
 )
 
 type MessageAPI struct {
 	messageService *service.MessageService
-	broadcaster    *broadcast.MessageBroadcaster
+	bus            *broadcast.Bus
 }
 
-func NewMessageAPI(ms *service.MessageService, b *broadcast.MessageBroadcaster) *MessageAPI {
-	return &MessageAPI{messageService: ms, broadcaster: b}
+func NewMessageAPI(ms *service.MessageService, bus *broadcast.Bus) *MessageAPI {
+	return &MessageAPI{messageService: ms, bus: bus}
 }
 
 type SendMessageRequest struct {
@@ -37,10 +39,13 @@ func (api *MessageAPI) Send(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !allowChats(c, sendTargets(req)) {
+		return
+	}
 
 	msgID, err := api.messageService.SendMessage(c.Request.Context(), req.ReceiveID, req.ReceiveIDType, req.MsgType, req.Content, "manual")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
@@ -48,9 +53,10 @@ func (api *MessageAPI) Send(c *gin.Context) {
 }
 
 type ReplyMessageRequest struct {
-	MessageID string `json:"message_id" binding:"required"`
-	MsgType   string `json:"msg_type" binding:"required"`
-	Content   string `json:"content" binding:"required"`
+	MessageID     string `json:"message_id" binding:"required"`
+	MsgType       string `json:"msg_type" binding:"required"`
+	Content       string `json:"content" binding:"required"`
+	ReplyInThread bool   `json:"reply_in_thread"`
 }
 
 func (api *MessageAPI) Reply(c *gin.Context) {
@@ -59,31 +65,103 @@ func (api *MessageAPI) Reply(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !api.allowMessage(c, req.MessageID) {
+		return
+	}
 
-	msgID, err := api.messageService.ReplyMessage(c.Request.Context(), req.MessageID, req.MsgType, req.Content)
+	msgID, err := api.messageService.ReplyMessage(c.Request.Context(), req.MessageID, req.MsgType, req.Content, req.ReplyInThread)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message_id": msgID})
 }
 
+type UpdateMessageRequest struct {
+	MsgType string `json:"msg_type" binding:"required"` // text, post or interactive
+	Content string `json:"content" binding:"required"`
+}
+
+// Update edits a sent message in place.
+func (api *MessageAPI) Update(c *gin.Context) {
+	messageID := c.Param("message_id")
+	var req UpdateMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	switch req.MsgType {
+	case "text", "post", "interactive":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only text, post and interactive messages can be edited"})
+		return
+	}
+	content, err := msg.Normalize(req.MsgType, req.Content)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !api.allowMessage(c, messageID) {
+		return
+	}
+
+	log, err := api.messageService.UpdateMessage(c.Request.Context(), messageID, req.MsgType, content)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": log})
+}
+
+// Revisions returns the edit history of a message.
+func (api *MessageAPI) Revisions(c *gin.Context) {
+	if !api.allowMessage(c, c.Param("message_id")) {
+		return
+	}
+	revisions, err := api.messageService.ListRevisions(c.Param("message_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": revisions})
+}
+
 func (api *MessageAPI) Delete(c *gin.Context) {
 	messageID := c.Param("message_id")
 	if messageID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "message_id is required"})
 		return
 	}
+	if !api.allowMessage(c, messageID) {
+		return
+	}
 
 	if err := api.messageService.DeleteMessage(c.Request.Context(), messageID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
+// MarkHandled marks a received message as handled by an operator.
+func (api *MessageAPI) MarkHandled(c *gin.Context) {
+	if !api.allowMessage(c, c.Param("message_id")) {
+		return
+	}
+	log, err := api.messageService.MarkHandled(c.Param("message_id"), actorName(c))
+	if err != nil {
+		if errors.Is(err, service.ErrNotIncoming) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "message not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": log})
+}
+
 func (api *MessageAPI) GetImage(c *gin.Context) {
 	messageID := c.Param("message_id")
 	fileKey := c.Param("file_key")
@@ -91,12 +169,15 @@ func (api *MessageAPI) GetImage(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "message_id and file_key are required"})
 		return
 	}
+	if !api.allowMessage(c, messageID) {
+		return
+	}
 
 	resType := c.DefaultQuery("type", "image")
 	reader, err := api.messageService.GetMessageResource(c.Request.Context(), messageID, fileKey, resType)
 	if err != nil {
 		fmt.Printf("[GetImage] error for msg=%s key=%s: %v\n", messageID, fileKey, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
@@ -127,18 +208,34 @@ func (api *MessageAPI) GetImage(c *gin.Context) {
 	}
 }
 
+// GetLogs returns paginated message logs. With view=thread and a chat_id,
+// logs are grouped by reply thread instead.
 func (api *MessageAPI) GetLogs(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
 
+	if c.Query("view") == "thread" {
+		api.threads(c, page, pageSize)
+		return
+	}
+
 	q := repository.MessageLogQuery{
 		ChatID:    c.Query("chat_id"),
 		ChatType:  c.Query("chat_type"),
 		Direction: c.Query("direction"),
 		Source:    c.Query("source"),
+		RootID:    c.Query("root_id"),
+		ThreadID:  c.Query("thread_id"),
 		Page:     page,
 		PageSize: pageSize,
 	}
+	if q.ChatID != "" {
+		if !allowChat(c, q.ChatID) {
+			return
+		}
+	} else {
+		q.ChatIDs = chatScopes(c)
+	}
 
 	logs, total, err := api.messageService.GetLogs(q)
 	if err != nil {
@@ -153,6 +250,45 @@ func (api *MessageAPI) GetLogs(c *gin.Context) {
 	})
 }
 
+// Threads returns a chat's messages grouped by reply thread.
+func (api *MessageAPI) Threads(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	api.threads(c, page, pageSize)
+}
+
+func (api *MessageAPI) threads(c *gin.Context, page, pageSize int) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	chatID := c.Query("chat_id")
+	if chatID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "chat_id is required for the thread view"})
+		return
+	}
+	if !allowChat(c, chatID) {
+		return
+	}
+	threads, total, err := api.messageService.ListThreads(chatID, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data":  threads,
+		"total": total,
+		"page":  page,
+	})
+}
+
+// QueueStats returns the depth and counters of the outbound send queue.
+func (api *MessageAPI) QueueStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": api.messageService.QueueStats()})
+}
+
 // Conversations returns distinct chat_ids from message logs for the chat sidebar.
 func (api *MessageAPI) Conversations(c *gin.Context) {
 	conversations, err := api.messageService.ListConversations(c.Request.Context())
@@ -160,23 +296,57 @@ func (api *MessageAPI) Conversations(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if user := currentUser(c); user != nil && user.Scopes() != nil {
+		allowed := conversations[:0]
+		for _, conv := range conversations {
+			if user.CanAccessChat(conv.ChatID) {
+				allowed = append(allowed, conv)
+			}
+		}
+		conversations = allowed
+	}
 	c.JSON(http.StatusOK, gin.H{"data": conversations})
 }
 
+// maxStreamReplay bounds how many missed messages are replayed from message logs on reconnect.
+const maxStreamReplay = 500
+
 // Stream provides a Server-Sent Events endpoint for real-time message updates.
 // If chat_id is empty, subscribes to all messages (global notifications).
+// Each event carries an id: and an event: (message, recall or edit) field. A
+// client reconnecting with Last-Event-ID (or ?last_event_id=) first receives the
+// events it missed: from the event bus history when it still covers the gap,
+// otherwise the missed messages from message_logs.
 func (api *MessageAPI) Stream(c *gin.Context) {
 	chatID := c.Query("chat_id") // empty = global subscription
+	if chatID != "" && !allowChat(c, chatID) {
+		return
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	lastID, _ := strconv.ParseUint(lastEventID, 10, 64)
 
 	// Set SSE headers
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
 	c.Header("Connection", "keep-alive")
-	c.Header("Access-Control-Allow-Origin", "*")
 
-	// Subscribe (empty chatID = global)
-	ch := api.broadcaster.Subscribe(chatID)
-	defer api.broadcaster.Unsubscribe(chatID, ch)
+	// Subscribe to message, recall and edit events (empty chatID = global).
+	// Subscribe before replaying so nothing published in between is lost.
+	filter := broadcast.Filter{Topics: broadcast.MessageTopics, ChatID: chatID, ChatIDs: chatScopes(c)}
+	sub := api.bus.Subscribe("sse "+c.ClientIP(), filter, 32)
+	defer api.bus.Unsubscribe(sub)
+
+	if lastID > 0 {
+		for _, event := range missedEvents(api.bus, api.messageService, chatID, lastID, filter) {
+			writeSSE(c.Writer, event)
+			lastID = event.ID
+		}
+		c.Writer.Flush()
+	}
 
 	// Get the client gone channel
 	clientGone := c.Request.Context().Done()
@@ -187,12 +357,14 @@ func (api *MessageAPI) Stream(c *gin.Context) {
 		select {
 		case <-clientGone:
 			return false
-		case event, ok := <-ch:
+		case event, ok := <-sub.C:
 			if !ok {
 				return false
 			}
-			data, _ := json.Marshal(event)
-			fmt.Fprintf(w, "data: %s\n\n", data)
+			if event.ID <= lastID {
+				return true // already sent during replay
+			}
+			writeSSE(w, event)
 			return true
 		case <-ticker.C:
 			// Heartbeat to keep connection alive
@@ -201,3 +373,77 @@ func (api *MessageAPI) Stream(c *gin.Context) {
 		}
 	})
 }
+
+// missedEvents returns the events published after lastID. When the bus history
+// no longer reaches back that far, messages are rebuilt from message_logs
+// instead; recall and edit events from that gap are not recoverable.
+func missedEvents(bus *broadcast.Bus, ms *service.MessageService, chatID string, lastID uint64, filter broadcast.Filter) []broadcast.Event {
+	events, complete := bus.Replay(lastID, filter)
+	if complete {
+		return events
+	}
+
+	logs, err := ms.LogsSince(chatID, broadcast.EventTime(lastID), maxStreamReplay)
+	if err != nil {
+		return events
+	}
+	replayed := make([]broadcast.Event, 0, len(logs))
+	for _, log := range logs {
+		if !filter.Match(broadcast.Event{Topic: broadcast.TopicMessage, ChatID: log.ChatID}) {
+			continue // outside the user's chat scopes
+		}
+		id := broadcast.EventIDAt(log.CreatedAt)
+		if n := len(replayed); n > 0 && id <= replayed[n-1].ID {
+			id = replayed[n-1].ID + 1
+		}
+		replayed = append(replayed, broadcast.Event{
+			ID:     id,
+			Topic:  broadcast.TopicMessage,
+			ChatID: log.ChatID,
+			Payload: broadcast.MessageEvent{
+				ID:         log.MessageID,
+				ChatID:     log.ChatID,
+				ChatType:   log.ChatType,
+				SenderID:   log.SenderID,
+				SenderName: log.SenderName,
+				Direction:  log.Direction,
+				MsgType:    log.MsgType,
+				Content:    log.Content,
+				Mentions:   log.Mentions,
+				Recalled:   log.Recalled,
+				CreatedAt:  log.CreatedAt,
+			},
+			CreatedAt: log.CreatedAt,
+		})
+	}
+	return replayed
+}
+
+// writeSSE writes one event in text/event-stream format.
+func writeSSE(w io.Writer, event broadcast.Event) {
+	data, _ := json.Marshal(event.Payload)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Topic, data)
+}
+
+// sendTargets returns the chat a send request targets, or nil when it is
+// addressed to a user, which scoped users may not do.
+func sendTargets(req SendMessageRequest) []string {
+	if req.ReceiveIDType != "chat_id" {
+		return nil
+	}
+	return []string{req.ReceiveID}
+}
+
+// allowMessage checks that the current user may access the chat of a logged
+// message. Unscoped users may also act on messages that were never logged.
+func (api *MessageAPI) allowMessage(c *gin.Context, messageID string) bool {
+	if chatScopes(c) == nil {
+		return true
+	}
+	chatID, err := api.messageService.ChatOf(messageID)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无权访问该消息", "kind": "forbidden"})
+		return false
+	}
+	return allowChat(c, chatID)
+}
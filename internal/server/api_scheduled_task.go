@@ -6,6 +6,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 
+	"lark-robot/internal/larkbot/msg"
 	"lark-robot/internal/model"
 	"lark-robot/internal/service"
 )
@@ -28,7 +29,7 @@ func (api *ScheduledTaskAPI) List(c *gin.Context) {
 		pageSize = 10
 	}
 
-	tasks, total, err := api.schedulerService.List(page, pageSize)
+	tasks, total, err := api.schedulerService.List(page, pageSize, chatScopes(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -37,14 +38,8 @@ func (api *ScheduledTaskAPI) List(c *gin.Context) {
 }
 
 func (api *ScheduledTaskAPI) GetByID(c *gin.Context) {
-	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
-		return
-	}
-	task, err := api.schedulerService.GetByID(uint(id))
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+	task, ok := api.scopedTask(c)
+	if !ok {
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"data": task})
@@ -53,10 +48,42 @@ func (api *ScheduledTaskAPI) GetByID(c *gin.Context) {
 type CreateScheduledTaskRequest struct {
 	Name     string `json:"name" binding:"required"`
 	CronExpr string `json:"cron_expr" binding:"required"`
-	ChatID   string `json:"chat_id" binding:"required"`
-	MsgType  string `json:"msg_type"`
-	Content  string `json:"content" binding:"required"`
-	Enabled  *bool  `json:"enabled"`
+	ChatID   string `json:"chat_id"`
+	// GroupSelector sends to every matching group instead of chat_id
+	GroupSelector *model.GroupSelector `json:"group_selector"`
+	MsgType       string               `json:"msg_type"`
+	Content       string               `json:"content" binding:"required"`
+	Enabled       *bool                `json:"enabled"`
+}
+
+// applyTarget sets the task's chat or group selector, checking that the
+// current user may target it. It writes the error response when ok is false.
+func (req *CreateScheduledTaskRequest) applyTarget(c *gin.Context, task *model.ScheduledTask) bool {
+	switch {
+	case req.GroupSelector != nil && req.ChatID != "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "chat_id and group_selector are mutually exclusive"})
+		return false
+	case req.GroupSelector != nil:
+		if !allowSelector(c) {
+			return false
+		}
+		if err := service.ValidateSelector(req.GroupSelector); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return false
+		}
+		task.ChatID = ""
+		task.GroupSelector = req.GroupSelector.String()
+	case req.ChatID != "":
+		if !allowChat(c, req.ChatID) {
+			return false
+		}
+		task.ChatID = req.ChatID
+		task.GroupSelector = ""
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "chat_id or group_selector is required"})
+		return false
+	}
+	return true
 }
 
 func (api *ScheduledTaskAPI) Create(c *gin.Context) {
@@ -69,17 +96,25 @@ func (api *ScheduledTaskAPI) Create(c *gin.Context) {
 	task := &model.ScheduledTask{
 		Name:     req.Name,
 		CronExpr: req.CronExpr,
-		ChatID:   req.ChatID,
 		MsgType:  req.MsgType,
 		Content:  req.Content,
 		Enabled:  true,
 	}
+	if !req.applyTarget(c, task) {
+		return
+	}
 	if task.MsgType == "" {
 		task.MsgType = "text"
 	}
 	if req.Enabled != nil {
 		task.Enabled = *req.Enabled
 	}
+	content, err := msg.Normalize(task.MsgType, task.Content)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	task.Content = content
 
 	if err := api.schedulerService.Create(task); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -89,15 +124,8 @@ func (api *ScheduledTaskAPI) Create(c *gin.Context) {
 }
 
 func (api *ScheduledTaskAPI) Update(c *gin.Context) {
-	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
-		return
-	}
-
-	task, err := api.schedulerService.GetByID(uint(id))
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+	task, ok := api.scopedTask(c)
+	if !ok {
 		return
 	}
 
@@ -106,10 +134,12 @@ func (api *ScheduledTaskAPI) Update(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if !req.applyTarget(c, task) {
+		return
+	}
 
 	task.Name = req.Name
 	task.CronExpr = req.CronExpr
-	task.ChatID = req.ChatID
 	task.Content = req.Content
 	if req.MsgType != "" {
 		task.MsgType = req.MsgType
@@ -117,6 +147,12 @@ func (api *ScheduledTaskAPI) Update(c *gin.Context) {
 	if req.Enabled != nil {
 		task.Enabled = *req.Enabled
 	}
+	content, err := msg.Normalize(task.MsgType, task.Content)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	task.Content = content
 
 	if err := api.schedulerService.Update(task); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -131,6 +167,9 @@ func (api *ScheduledTaskAPI) Delete(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
+	if _, ok := api.scopedTask(c); !ok {
+		return
+	}
 	if err := api.schedulerService.Delete(uint(id)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -144,6 +183,9 @@ func (api *ScheduledTaskAPI) Toggle(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
+	if _, ok := api.scopedTask(c); !ok {
+		return
+	}
 	if err := api.schedulerService.Toggle(uint(id)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -157,9 +199,35 @@ func (api *ScheduledTaskAPI) RunNow(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
 		return
 	}
+	if _, ok := api.scopedTask(c); !ok {
+		return
+	}
 	if err := api.schedulerService.RunNow(c.Request.Context(), uint(id)); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "task executed"})
 }
+
+// scopedTask loads the task named by the id param and checks that the current
+// user may access its chat or use its group selector. It writes the error response when ok is false.
+func (api *ScheduledTaskAPI) scopedTask(c *gin.Context) (*model.ScheduledTask, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return nil, false
+	}
+	task, err := api.schedulerService.GetByID(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		return nil, false
+	}
+	if task.GroupSelector != "" {
+		if !allowSelector(c) {
+			return nil, false
+		}
+	} else if !allowChat(c, task.ChatID) {
+		return nil, false
+	}
+	return task, true
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"lark-robot/internal/model"
+	"lark-robot/internal/service"
+)
+
+// SessionAPI lists and revokes login sessions. Everyone can manage their own
+// sessions; admins can manage everyone's.
+type SessionAPI struct {
+	sessionService *service.SessionService
+}
+
+func NewSessionAPI(ss *service.SessionService) *SessionAPI {
+	return &SessionAPI{sessionService: ss}
+}
+
+// List returns the current user's active sessions, or all users' with ?all=1 (admins only).
+func (api *SessionAPI) List(c *gin.Context) {
+	user := currentUser(c)
+	userID := user.ID
+	if c.Query("all") == "1" {
+		if !user.HasRole(model.RoleAdmin) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "权限不足", "kind": "forbidden"})
+			return
+		}
+		userID = 0
+	}
+
+	sessions, err := api.sessionService.List(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": sessions, "total": len(sessions), "current": c.GetString(ctxSessionID)})
+}
+
+// Revoke ends a session; its tokens stop working immediately.
+func (api *SessionAPI) Revoke(c *gin.Context) {
+	session, err := api.sessionService.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+	user := currentUser(c)
+	if session.AdminUserID != user.ID && !user.HasRole(model.RoleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "权限不足", "kind": "forbidden"})
+		return
+	}
+	if err := api.sessionService.Revoke(session.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "revoked"})
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"lark-robot/internal/service"
+)
+
+const (
+	ssoStateCookie = "lark_oauth_state"
+	ssoStateMaxAge = 600 // seconds
+	ssoCookiePath  = "/api/auth/lark"
+)
+
+// SSOAPI implements the Lark OAuth login flow for the admin console.
+type SSOAPI struct {
+	ssoService       *service.SSOService // nil when SSO is disabled
+	sessionService   *service.SessionService
+	adminUserService *service.AdminUserService
+	redirectURL      string
+	logger           *zap.Logger
+}
+
+func NewSSOAPI(ss *service.SSOService, sessions *service.SessionService, users *service.AdminUserService, redirectURL string, logger *zap.Logger) *SSOAPI {
+	return &SSOAPI{ssoService: ss, sessionService: sessions, adminUserService: users, redirectURL: redirectURL, logger: logger}
+}
+
+// Login redirects the browser to Lark's authorization page.
+func (api *SSOAPI) Login(c *gin.Context) {
+	if api.ssoService == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "飞书登录未启用"})
+		return
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	state := hex.EncodeToString(buf)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoStateCookie, state, ssoStateMaxAge, ssoCookiePath, "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, api.ssoService.AuthorizeURL(api.callbackURL(c), state))
+}
+
+// Callback completes the login. The browser is sent back to the console's
+// login page with the tokens (or an error) in the URL fragment, which is never
+// sent to the server or written to access logs. Accounts with TOTP enabled get
+// a totp_token instead, to exchange for tokens with their code at VerifyTOTP.
+func (api *SSOAPI) Callback(c *gin.Context) {
+	if api.ssoService == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "飞书登录未启用"})
+		return
+	}
+	state, _ := c.Cookie(ssoStateCookie)
+	c.SetCookie(ssoStateCookie, "", -1, ssoCookiePath, "", c.Request.TLS != nil, true)
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+		api.finish(c, url.Values{"error": {"登录状态已失效，请重试"}})
+		return
+	}
+	code := c.Query("code")
+	if code == "" {
+		api.finish(c, url.Values{"error": {"未获得飞书授权"}})
+		return
+	}
+
+	user, err := api.ssoService.Login(c.Request.Context(), code)
+	if err != nil {
+		msg := "飞书登录失败"
+		switch {
+		case errors.Is(err, service.ErrSSODenied):
+			msg = "该飞书账号无权登录管理后台"
+		case errors.Is(err, service.ErrAccountDisabled):
+			msg = "账号已停用"
+		default:
+			api.logger.Error("lark sso login failed", zap.Error(err))
+		}
+		api.finish(c, url.Values{"error": {msg}})
+		return
+	}
+	if user.TOTPEnabled {
+		token, err := api.sessionService.TOTPToken(user.Username)
+		if err != nil {
+			api.logger.Error("failed to issue totp token", zap.Error(err))
+			api.finish(c, url.Values{"error": {"飞书登录失败"}})
+			return
+		}
+		api.finish(c, url.Values{"totp_token": {token}, "username": {user.Username}})
+		return
+	}
+	pair, err := api.sessionService.Create(user, "lark", c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		api.logger.Error("failed to create session", zap.Error(err))
+		api.finish(c, url.Values{"error": {"飞书登录失败"}})
+		return
+	}
+	api.finish(c, url.Values{"token": {pair.AccessToken}, "refresh_token": {pair.RefreshToken}})
+}
+
+type SSOTOTPRequest struct {
+	Username  string `json:"username" binding:"required"` // lets the login throttle count failures per account
+	TOTPToken string `json:"totp_token" binding:"required"`
+	TOTPCode  string `json:"totp_code" binding:"required"`
+}
+
+// VerifyTOTP finishes a Lark SSO login of an account with TOTP enabled.
+func (api *SSOAPI) VerifyTOTP(c *gin.Context) {
+	var req SSOTOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请输入动态验证码"})
+		return
+	}
+	username, err := api.sessionService.ParseTOTPToken(req.TOTPToken)
+	if err != nil || username != req.Username {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "登录状态已失效，请重新使用飞书登录"})
+		return
+	}
+	user, err := api.adminUserService.GetActive(username)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "账号已停用"})
+		return
+	}
+	if !api.adminUserService.VerifyTOTP(user, req.TOTPCode) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "动态验证码错误", "totp_required": true})
+		return
+	}
+	pair, err := api.sessionService.Create(user, "lark", c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"token":         pair.AccessToken,
+		"refresh_token": pair.RefreshToken,
+		"expires_in":    pair.ExpiresIn,
+		"user":          user,
+	})
+}
+
+func (api *SSOAPI) finish(c *gin.Context, fragment url.Values) {
+	c.Redirect(http.StatusFound, "/login#"+fragment.Encode())
+}
+
+// callbackURL is the configured redirect URL, or one derived from the request.
+func (api *SSOAPI) callbackURL(c *gin.Context) string {
+	if api.redirectURL != "" {
+		return api.redirectURL
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + ssoCookiePath + "/callback"
+}
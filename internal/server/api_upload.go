@@ -30,7 +30,7 @@ func (api *UploadAPI) UploadImage(c *gin.Context) {
 
 	imageKey, err := api.larkClient.UploadImage(c.Request.Context(), file)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
@@ -74,7 +74,7 @@ func (api *UploadAPI) UploadFile(c *gin.Context) {
 
 	fileKey, err := api.larkClient.UploadFile(c.Request.Context(), fileType, fileName, file)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
@@ -1,21 +1,24 @@
 package server
 
 import (
+	"context"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 
+	"lark-robot/internal/larkbot"
 	"lark-robot/internal/repository"
 	"lark-robot/internal/service"
 )
 
 type UserAPI struct {
 	userService *service.UserService
+	jobService  *service.JobService
 }
 
-func NewUserAPI(us *service.UserService) *UserAPI {
-	return &UserAPI{userService: us}
+func NewUserAPI(us *service.UserService, js *service.JobService) *UserAPI {
+	return &UserAPI{userService: us, jobService: js}
 }
 
 // List returns a paginated list of users.
@@ -37,6 +40,11 @@ func (api *UserAPI) List(c *gin.Context) {
 		Keyword:  keyword,
 		SortBy:   c.Query("sort_by"),
 		SortDir:  c.Query("sort_dir"),
+		// Members of an open_department_id; recursive=1 includes sub-departments
+		DepartmentID: c.Query("department_id"),
+		Recursive:    c.Query("recursive") == "1",
+		// "left" lists departed users, "all" lists everyone
+		Status: c.Query("status"),
 	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -47,27 +55,30 @@ func (api *UserAPI) List(c *gin.Context) {
 
 // Sync re-fetches all users from Lark API and updates the database.
 // If a JSON body with "open_ids" is provided, only those users are synced (for retrying).
+// It runs as a background job; the response is the job, whose result is a SyncResult.
 func (api *UserAPI) Sync(c *gin.Context) {
 	var body struct {
 		OpenIDs []string `json:"open_ids"`
 	}
 	_ = c.ShouldBindJSON(&body)
 
-	var result *service.SyncResult
-	var err error
-
+	spec := service.JobSpec{Kind: service.JobUserSync, CreatedBy: actorName(c)}
+	var run service.JobFunc
 	if len(body.OpenIDs) > 0 {
 		// Specific users: force sync (bypass 1-hour cooldown)
-		result, err = api.userService.SyncByIDs(c.Request.Context(), body.OpenIDs, true)
+		spec.Params = body
+		run = func(ctx context.Context, p *service.JobProgress) (interface{}, error) {
+			return api.userService.SyncByIDs(ctx, body.OpenIDs, true, p)
+		}
 	} else {
-		result, err = api.userService.SyncAllUsers(c.Request.Context())
-	}
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		// A full sync covers everyone, so a second one would only repeat it
+		spec.Exclusive = true
+		run = func(ctx context.Context, p *service.JobProgress) (interface{}, error) {
+			return api.userService.SyncAllUsers(ctx, p)
+		}
 	}
-
-	c.JSON(http.StatusOK, result)
+	job, err := api.jobService.Submit(spec, run)
+	respondJob(c, job, err)
 }
 
 // GetByOpenID returns a user by open_id.
@@ -79,9 +90,39 @@ func (api *UserAPI) GetByOpenID(c *gin.Context) {
 		// Not in DB yet — try fetching from Lark API
 		user, err = api.userService.SyncUser(c.Request.Context(), openID)
 		if err != nil {
+			if _, ok := larkbot.AsAPIError(err); ok && !larkbot.IsNotFound(err) {
+				respondError(c, err)
+				return
+			}
 			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
 			return
 		}
 	}
 	c.JSON(http.StatusOK, gin.H{"data": user})
 }
+
+// SyncOne re-fetches a single user from Lark API, bypassing the cooldown
+// and the cache.
+func (api *UserAPI) SyncOne(c *gin.Context) {
+	user, err := api.userService.SyncUserForce(c.Request.Context(), c.Param("open_id"))
+	if err != nil {
+		if larkbot.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": user})
+}
+
+// CacheStats returns the size and hit/miss counters of the user info cache.
+func (api *UserAPI) CacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": api.userService.CacheStats()})
+}
+
+// ClearCache drops all cached user info.
+func (api *UserAPI) ClearCache(c *gin.Context) {
+	api.userService.InvalidateAll()
+	c.JSON(http.StatusOK, gin.H{"data": api.userService.CacheStats()})
+}
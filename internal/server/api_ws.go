@@ -0,0 +1,498 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+
+	"lark-robot/internal/broadcast"
+	"lark-robot/internal/model"
+	"lark-robot/internal/service"
+)
+
+const (
+	wsWriteWait      = 10 * time.Second
+	wsPongWait       = 60 * time.Second
+	wsPingPeriod     = 50 * time.Second
+	wsMaxMessageSize = 256 << 10
+	wsActionTimeout  = 30 * time.Second
+	wsMaxInFlight    = 8 // concurrent actions per connection
+)
+
+// wsRequest is a client action. ID is echoed back in the ack.
+type wsRequest struct {
+	ID     string          `json:"id"`
+	Action string          `json:"action"` // send, reply, recall, mark_handled, subscribe, ping
+	Data   json.RawMessage `json:"data"`
+}
+
+// wsMessage is anything the server writes: an ack for a request, or a broadcast event.
+type wsMessage struct {
+	Type    string      `json:"type"` // "ack" or "event"
+	ID      string      `json:"id,omitempty"`
+	OK      bool        `json:"ok,omitempty"`
+	Error   string      `json:"error,omitempty"`
+	Kind    string      `json:"kind,omitempty"`
+	Event   string      `json:"event,omitempty"`    // event topic
+	EventID uint64      `json:"event_id,omitempty"` // same IDs as the SSE stream
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type messageIDRequest struct {
+	MessageID string `json:"message_id" binding:"required"`
+}
+
+type subscribeRequest struct {
+	ChatID string `json:"chat_id"` // empty = all chats
+}
+
+// WSAPI serves the admin console WebSocket: broadcast events out, actions in.
+type WSAPI struct {
+	messageService *service.MessageService
+	bus            *broadcast.Bus
+	auditor        *Auditor
+	sessions       *service.SessionService
+	users          *service.AdminUserService
+	apiKeys        *service.APIKeyService
+	upgrader       websocket.Upgrader
+	logger         *zap.Logger
+}
+
+func NewWSAPI(ms *service.MessageService, bus *broadcast.Bus, auditor *Auditor, sessions *service.SessionService,
+	users *service.AdminUserService, apiKeys *service.APIKeyService, cors CORSConfig, logger *zap.Logger) *WSAPI {
+	return &WSAPI{
+		messageService: ms,
+		bus:            bus,
+		auditor:        auditor,
+		sessions:       sessions,
+		users:          users,
+		apiKeys:        apiKeys,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  4096,
+			WriteBufferSize: 4096,
+			// The connection is authenticated by token, same as the REST API;
+			// the origin check keeps other sites from using a leaked stream token
+			CheckOrigin: checkWSOrigin(cors),
+		},
+		logger: logger,
+	}
+}
+
+// wsConn is one console connection. Writes are serialized through out.
+type wsConn struct {
+	api      *WSAPI
+	check    authCheck
+	scopes   []string // chat scopes the event subscription was filtered by
+	actor    auditActor
+	conn     *websocket.Conn
+	out      chan wsMessage
+	done     chan struct{}
+	once     sync.Once
+	inFlight chan struct{} // semaphore bounding concurrent actions
+	mu       sync.RWMutex
+	user     *model.AdminUser
+	chatID   string
+}
+
+// authCheck re-validates the credentials a connection was opened with and
+// returns the user as currently stored.
+type authCheck func() (*model.AdminUser, error)
+
+// errWSUnauthorized is returned once the connection's session or key is no longer valid.
+var errWSUnauthorized = errors.New("未登录或登录已过期")
+
+// authCheck returns the check for the credentials of the upgrade request: its
+// API key, or its login session and admin user.
+func (api *WSAPI) authCheck(c *gin.Context) authCheck {
+	if v, ok := c.Get(ctxAPIKey); ok {
+		id := v.(*model.APIKey).ID
+		return func() (*model.AdminUser, error) {
+			key, err := api.apiKeys.GetByID(id)
+			if err != nil || !key.Active() {
+				return nil, errWSUnauthorized
+			}
+			return key.Principal(), nil
+		}
+	}
+	sessionID, username := c.GetString(ctxSessionID), currentUser(c).Username
+	return func() (*model.AdminUser, error) {
+		session, err := api.sessions.Get(sessionID)
+		if err != nil || !session.Active() {
+			return nil, errWSUnauthorized
+		}
+		user, err := api.users.GetActive(username)
+		if err != nil {
+			return nil, errWSUnauthorized
+		}
+		return user, nil
+	}
+}
+
+// Handle upgrades the request to a WebSocket. Query params: chat_id limits
+// events to one chat, last_event_id replays missed events as in the SSE stream.
+// Events and actions are limited to the user's chat scopes.
+func (api *WSAPI) Handle(c *gin.Context) {
+	if chatID := c.Query("chat_id"); chatID != "" && !allowChat(c, chatID) {
+		return
+	}
+	conn, err := api.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		api.logger.Debug("websocket upgrade failed", zap.Error(err))
+		return
+	}
+
+	ws := &wsConn{
+		api:      api,
+		check:    api.authCheck(c),
+		scopes:   chatScopes(c),
+		actor:    auditActorOf(c),
+		conn:     conn,
+		out:      make(chan wsMessage, 64),
+		done:     make(chan struct{}),
+		inFlight: make(chan struct{}, wsMaxInFlight),
+		user:     currentUser(c),
+		chatID:   c.Query("chat_id"),
+	}
+
+	// All message topics; the per-connection chat filter is applied when writing
+	// so the console can switch chats without resubscribing.
+	filter := broadcast.Filter{Topics: broadcast.MessageTopics, ChatIDs: ws.scopes}
+	sub := api.bus.Subscribe("ws "+c.ClientIP(), filter, 64)
+
+	var lastID uint64
+	if v := c.Query("last_event_id"); v != "" {
+		lastID, _ = strconv.ParseUint(v, 10, 64)
+	}
+	if lastID > 0 {
+		// Written directly: the writer goroutine is not running yet
+		for _, event := range missedEvents(api.bus, api.messageService, ws.chatID, lastID, filter) {
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteJSON(eventMessage(event)); err != nil {
+				conn.Close()
+				api.bus.Unsubscribe(sub)
+				return
+			}
+			lastID = event.ID
+		}
+	}
+
+	go ws.writeLoop(sub, lastID)
+	ws.readLoop()
+
+	ws.close()
+	api.bus.Unsubscribe(sub)
+}
+
+func eventMessage(event broadcast.Event) wsMessage {
+	return wsMessage{Type: "event", Event: event.Topic, EventID: event.ID, Data: event.Payload}
+}
+
+func (ws *wsConn) close() {
+	ws.once.Do(func() {
+		close(ws.done)
+		ws.conn.Close()
+	})
+}
+
+// send queues a message for the writer. Acks are never dropped silently: if
+// the queue is full the connection is too slow and is closed.
+func (ws *wsConn) send(m wsMessage) {
+	select {
+	case ws.out <- m:
+	case <-ws.done:
+	default:
+		ws.api.logger.Warn("websocket send queue full, closing connection")
+		ws.close()
+	}
+}
+
+func (ws *wsConn) currentUser() *model.AdminUser {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	return ws.user
+}
+
+// reauth re-validates the connection's credentials and refreshes its user, so
+// logout, session revocation, disabling the account and role changes apply to
+// open sockets. It closes the connection and reports false if the credentials
+// are no longer valid, or if the user's chat scopes changed, since the event
+// subscription was filtered by the old ones.
+func (ws *wsConn) reauth() bool {
+	user, err := ws.check()
+	if err == nil && !sameScopes(user.Scopes(), ws.scopes) {
+		err = errWSUnauthorized
+	}
+	if err != nil {
+		ws.api.logger.Info("closing websocket: credentials no longer valid", zap.String("actor", ws.actor.name))
+		ws.close()
+		return false
+	}
+	ws.mu.Lock()
+	ws.user = user
+	ws.mu.Unlock()
+	return true
+}
+
+// sameScopes reports whether two chat scope lists are equal; nil (all chats)
+// differs from any list.
+func sameScopes(a, b []string) bool {
+	if (a == nil) != (b == nil) || len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (ws *wsConn) currentChat() string {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	return ws.chatID
+}
+
+func (ws *wsConn) writeLoop(sub *broadcast.Subscription, lastID uint64) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+	defer ws.close()
+
+	write := func(v interface{}) bool {
+		ws.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		return ws.conn.WriteJSON(v) == nil
+	}
+
+	for {
+		select {
+		case <-ws.done:
+			return
+		case m := <-ws.out:
+			if !write(m) {
+				return
+			}
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if event.ID <= lastID {
+				continue // already sent during replay
+			}
+			if chatID := ws.currentChat(); chatID != "" && event.ChatID != chatID {
+				continue
+			}
+			if !write(eventMessage(event)) {
+				return
+			}
+		case <-ticker.C:
+			if !ws.reauth() {
+				return
+			}
+			ws.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := ws.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (ws *wsConn) readLoop() {
+	ws.conn.SetReadLimit(wsMaxMessageSize)
+	ws.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	ws.conn.SetPongHandler(func(string) error {
+		return ws.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		var req wsRequest
+		if err := ws.conn.ReadJSON(&req); err != nil {
+			if _, ok := err.(*json.SyntaxError); ok {
+				ws.send(wsMessage{Type: "ack", Error: "invalid JSON", Kind: "bad_request"})
+				continue
+			}
+			return
+		}
+		// Actions call the Lark API; run them concurrently so a slow send does
+		// not hold up the rest of the socket, up to wsMaxInFlight at a time.
+		select {
+		case ws.inFlight <- struct{}{}:
+			go func() {
+				defer func() { <-ws.inFlight }()
+				ws.handle(req)
+			}()
+		default:
+			ws.send(wsMessage{Type: "ack", ID: req.ID, Error: "too many actions in flight", Kind: "rate_limited"})
+		}
+	}
+}
+
+func (ws *wsConn) handle(req wsRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), wsActionTimeout)
+	defer cancel()
+
+	// Write actions are audited like the REST routes they mirror
+	_, audited := wsAuditActions[req.Action]
+	var targetID string
+	var before interface{}
+	if audited && (req.Action == "recall" || req.Action == "mark_handled") {
+		var r messageIDRequest
+		json.Unmarshal(req.Data, &r)
+		targetID = r.MessageID
+		before = ws.api.auditor.snapshot("message", targetID)
+	}
+
+	data, err := ws.dispatch(ctx, req)
+	if audited {
+		ws.api.auditor.recordWS(ws.actor, req.Action, targetID, before, data, err)
+	}
+	if err != nil {
+		_, kind := errorStatus(err)
+		if _, ok := err.(wsBadRequest); ok {
+			kind = "bad_request"
+		}
+		if err == errWSForbidden {
+			kind = "forbidden"
+		}
+		if err == errWSUnauthorized {
+			kind = "unauthorized"
+		}
+		ws.send(wsMessage{Type: "ack", ID: req.ID, Error: err.Error(), Kind: kind})
+		return
+	}
+	ws.send(wsMessage{Type: "ack", ID: req.ID, OK: true, Data: data})
+}
+
+// wsBadRequest wraps errors caused by the request itself.
+type wsBadRequest struct{ error }
+
+// errWSForbidden is returned for actions outside the user's role or chat scopes.
+var errWSForbidden = errors.New("forbidden")
+
+// writeActions are the actions that require the operator role.
+var writeActions = map[string]bool{"send": true, "reply": true, "recall": true, "mark_handled": true}
+
+// allowChat reports whether the connection's user may access chatID.
+func (ws *wsConn) allowChat(chatID string) bool {
+	user := ws.currentUser()
+	return user != nil && user.CanAccessChat(chatID)
+}
+
+// allowMessage reports whether the connection's user may access the chat of a logged message.
+func (ws *wsConn) allowMessage(messageID string) bool {
+	if user := ws.currentUser(); user != nil && user.Scopes() == nil {
+		return true
+	}
+	chatID, err := ws.api.messageService.ChatOf(messageID)
+	return err == nil && ws.allowChat(chatID)
+}
+
+// decode unmarshals and validates action data using the same binding tags as the REST API.
+func decode(raw json.RawMessage, v interface{}) error {
+	if len(raw) == 0 {
+		raw = []byte("{}")
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return wsBadRequest{err}
+	}
+	if err := binding.Validator.ValidateStruct(v); err != nil {
+		return wsBadRequest{err}
+	}
+	return nil
+}
+
+func (ws *wsConn) dispatch(ctx context.Context, req wsRequest) (interface{}, error) {
+	ms := ws.api.messageService
+	if writeActions[req.Action] {
+		// Write actions use the credentials and role as they are now
+		if !ws.reauth() {
+			return nil, errWSUnauthorized
+		}
+		if user := ws.currentUser(); user == nil || !user.HasRole(model.RoleOperator) {
+			return nil, errWSForbidden
+		}
+	}
+	user := ws.currentUser()
+	switch req.Action {
+	case "ping":
+		return gin.H{"time": time.Now()}, nil
+
+	case "subscribe":
+		var r subscribeRequest
+		if err := decode(req.Data, &r); err != nil {
+			return nil, err
+		}
+		if r.ChatID != "" && !ws.allowChat(r.ChatID) {
+			return nil, errWSForbidden
+		}
+		ws.mu.Lock()
+		ws.chatID = r.ChatID
+		ws.mu.Unlock()
+		return gin.H{"chat_id": r.ChatID, "last_event_id": ws.api.bus.LastID()}, nil
+
+	case "send":
+		var r SendMessageRequest
+		if err := decode(req.Data, &r); err != nil {
+			return nil, err
+		}
+		if r.ReceiveIDType == "chat_id" && !ws.allowChat(r.ReceiveID) ||
+			r.ReceiveIDType != "chat_id" && user.Scopes() != nil {
+			return nil, errWSForbidden
+		}
+		msgID, err := ms.SendMessage(ctx, r.ReceiveID, r.ReceiveIDType, r.MsgType, r.Content, "manual")
+		if err != nil {
+			return nil, err
+		}
+		return gin.H{"message_id": msgID}, nil
+
+	case "reply":
+		var r ReplyMessageRequest
+		if err := decode(req.Data, &r); err != nil {
+			return nil, err
+		}
+		if !ws.allowMessage(r.MessageID) {
+			return nil, errWSForbidden
+		}
+		msgID, err := ms.ReplyMessage(ctx, r.MessageID, r.MsgType, r.Content, r.ReplyInThread)
+		if err != nil {
+			return nil, err
+		}
+		return gin.H{"message_id": msgID}, nil
+
+	case "recall":
+		var r messageIDRequest
+		if err := decode(req.Data, &r); err != nil {
+			return nil, err
+		}
+		if !ws.allowMessage(r.MessageID) {
+			return nil, errWSForbidden
+		}
+		if err := ms.DeleteMessage(ctx, r.MessageID); err != nil {
+			return nil, err
+		}
+		return gin.H{"message_id": r.MessageID}, nil
+
+	case "mark_handled":
+		var r messageIDRequest
+		if err := decode(req.Data, &r); err != nil {
+			return nil, err
+		}
+		if !ws.allowMessage(r.MessageID) {
+			return nil, errWSForbidden
+		}
+		log, err := ms.MarkHandled(r.MessageID, user.Username)
+		if err != nil {
+			return nil, wsBadRequest{err}
+		}
+		return log, nil
+	}
+	return nil, wsBadRequest{fmt.Errorf("unknown action %q", req.Action)}
+}
@@ -0,0 +1,319 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"lark-robot/internal/model"
+	"lark-robot/internal/service"
+)
+
+const (
+	// auditBodyLimit caps how much of a response is kept to find created IDs.
+	auditBodyLimit = 64 << 10
+	// auditResponseLimit caps responses stored as the "after" snapshot of
+	// targets that cannot be loaded, such as uploads.
+	auditResponseLimit = 4 << 10
+)
+
+// auditRoute describes how a mutating route is audited.
+type auditRoute struct {
+	action  string // e.g. "auto_reply_rule.update"
+	target  string // target type; also selects the snapshot loader
+	param   string // route param holding the target ID; empty when the response names it (creates)
+	removes bool   // the target is gone afterwards, so there is no "after" to load
+	skip    bool   // not an admin action
+	secret  bool   // the response holds credentials and is never stored
+}
+
+// auditRoutes lists the mutating routes of Router.setupRoutes, keyed by
+// "METHOD route". Unlisted mutating routes are still audited, with the
+// method and route as the action.
+var auditRoutes = map[string]auditRoute{
+	"POST /api/logout":                       {action: "auth.logout"},
+	"POST /api/auth/stream-token":            {skip: true},
+	"DELETE /api/sessions/:id":               {action: "session.revoke", target: "session", param: "id"},
+	"POST /api/me/totp/setup":                {action: "totp.setup", secret: true},
+	"POST /api/me/totp":                      {action: "totp.enable"},
+	"DELETE /api/me/totp":                    {action: "totp.disable"},
+	"POST /api/messages/send":                {action: "message.send", target: "message"},
+	"POST /api/messages/reply":               {action: "message.reply", target: "message"},
+	"PUT /api/messages/:message_id":          {action: "message.edit", target: "message", param: "message_id"},
+	"DELETE /api/messages/:message_id":       {action: "message.recall", target: "message", param: "message_id"},
+	"POST /api/messages/:message_id/handled": {action: "message.handle", target: "message", param: "message_id"},
+	"POST /api/upload/image":                 {action: "upload.image"},
+	"POST /api/upload/file":                  {action: "upload.file"},
+	"POST /api/chats/sync":                   {action: "chat.sync", target: "job"},
+	"POST /api/chats/:chat_id/leave":         {action: "chat.leave", target: "chat", param: "chat_id"},
+	"PUT /api/chats/:chat_id/meta":           {action: "chat.update_meta", target: "chat", param: "chat_id"},
+	"POST /api/users/sync":                   {action: "user.sync", target: "job"},
+	"DELETE /api/users/cache":                {action: "user.cache_clear"},
+	"POST /api/users/:open_id/sync":          {action: "user.sync", target: "user", param: "open_id"},
+	"POST /api/users/import":                 {action: "user.import", target: "directory_import"},
+	"POST /api/users/import/:id/pause":       {action: "user.import_pause", target: "directory_import", param: "id"},
+	"POST /api/users/import/:id/resume":      {action: "user.import_resume", target: "directory_import", param: "id"},
+	"POST /api/departments/sync":             {action: "department.sync"},
+	"POST /api/auto-reply-rules":             {action: "auto_reply_rule.create", target: "auto_reply_rule"},
+	"PUT /api/auto-reply-rules/:id":          {action: "auto_reply_rule.update", target: "auto_reply_rule", param: "id"},
+	"DELETE /api/auto-reply-rules/:id":       {action: "auto_reply_rule.delete", target: "auto_reply_rule", param: "id", removes: true},
+	"POST /api/auto-reply-rules/:id/toggle":  {action: "auto_reply_rule.toggle", target: "auto_reply_rule", param: "id"},
+	"POST /api/scheduled-tasks":              {action: "scheduled_task.create", target: "scheduled_task"},
+	"PUT /api/scheduled-tasks/:id":           {action: "scheduled_task.update", target: "scheduled_task", param: "id"},
+	"DELETE /api/scheduled-tasks/:id":        {action: "scheduled_task.delete", target: "scheduled_task", param: "id", removes: true},
+	"POST /api/scheduled-tasks/:id/toggle":   {action: "scheduled_task.toggle", target: "scheduled_task", param: "id"},
+	"POST /api/scheduled-tasks/:id/run":      {action: "scheduled_task.run", target: "scheduled_task", param: "id"},
+	"POST /api/campaigns":                    {action: "campaign.create", target: "campaign"},
+	"PUT /api/campaigns/:id":                 {action: "campaign.update", target: "campaign", param: "id"},
+	"DELETE /api/campaigns/:id":              {action: "campaign.delete", target: "campaign", param: "id", removes: true},
+	"POST /api/campaigns/:id/start":          {action: "campaign.start", target: "campaign", param: "id"},
+	"POST /api/campaigns/:id/pause":          {action: "campaign.pause", target: "campaign", param: "id"},
+	"POST /api/campaigns/:id/resume":         {action: "campaign.resume", target: "campaign", param: "id"},
+	"POST /api/campaigns/:id/cancel":         {action: "campaign.cancel", target: "campaign", param: "id"},
+	"POST /api/campaigns/:id/recall":         {action: "campaign.recall", target: "campaign", param: "id"},
+	"POST /api/admin-users":                  {action: "admin_user.create", target: "admin_user"},
+	"PUT /api/admin-users/:id":               {action: "admin_user.update", target: "admin_user", param: "id"},
+	"DELETE /api/admin-users/:id":            {action: "admin_user.delete", target: "admin_user", param: "id", removes: true},
+	"POST /api/admin-users/:id/totp/reset":   {action: "admin_user.totp_reset", target: "admin_user", param: "id"},
+	"POST /api/api-keys":                     {action: "api_key.create", target: "api_key"},
+	"DELETE /api/api-keys/:id":               {action: "api_key.revoke", target: "api_key", param: "id"},
+	"POST /api/jobs/:id/cancel":              {action: "job.cancel", target: "job", param: "id"},
+	"POST /api/audit/export":                 {action: "audit.export", target: "job"},
+}
+
+// wsAuditActions maps WebSocket write actions to audit actions.
+var wsAuditActions = map[string]string{
+	"send":         "message.send",
+	"reply":        "message.reply",
+	"recall":       "message.recall",
+	"mark_handled": "message.handle",
+}
+
+// AuditConfig holds the services used to snapshot audit targets.
+type AuditConfig struct {
+	AuditService           *service.AuditService
+	MessageService         *service.MessageService
+	ChatService            *service.ChatService
+	ReplyService           *service.ReplyService
+	SchedulerService       *service.SchedulerService
+	CampaignService        *service.CampaignService
+	AdminUserService       *service.AdminUserService
+	APIKeyService          *service.APIKeyService
+	SessionService         *service.SessionService
+	UserService            *service.UserService
+	DirectoryImportService *service.DirectoryImportService
+	JobService             *service.JobService
+}
+
+// Auditor writes the audit log for mutating requests and WebSocket actions.
+// Targets are loaded before and after the change to record what changed.
+type Auditor struct {
+	auditService *service.AuditService
+	loaders      map[string]func(id string) interface{}
+}
+
+func NewAuditor(cfg AuditConfig) *Auditor {
+	byUint := func(get func(uint) (interface{}, error)) func(string) interface{} {
+		return func(id string) interface{} {
+			n, err := strconv.ParseUint(id, 10, 32)
+			if err != nil {
+				return nil
+			}
+			v, err := get(uint(n))
+			if err != nil {
+				return nil
+			}
+			return v
+		}
+	}
+	byString := func(get func(string) (interface{}, error)) func(string) interface{} {
+		return func(id string) interface{} {
+			v, err := get(id)
+			if err != nil {
+				return nil
+			}
+			return v
+		}
+	}
+
+	return &Auditor{
+		auditService: cfg.AuditService,
+		loaders: map[string]func(string) interface{}{
+			"message":          byString(func(id string) (interface{}, error) { return cfg.MessageService.GetLog(id) }),
+			"chat":             byString(func(id string) (interface{}, error) { return cfg.ChatService.GetGroup(id) }),
+			"session":          byString(func(id string) (interface{}, error) { return cfg.SessionService.Get(id) }),
+			"user":             byString(func(id string) (interface{}, error) { return cfg.UserService.GetUser(id) }),
+			"auto_reply_rule":  byUint(func(id uint) (interface{}, error) { return cfg.ReplyService.GetByID(id) }),
+			"scheduled_task":   byUint(func(id uint) (interface{}, error) { return cfg.SchedulerService.GetByID(id) }),
+			"campaign":         byUint(func(id uint) (interface{}, error) { return cfg.CampaignService.GetByID(id) }),
+			"admin_user":       byUint(func(id uint) (interface{}, error) { return cfg.AdminUserService.GetByID(id) }),
+			"api_key":          byUint(func(id uint) (interface{}, error) { return cfg.APIKeyService.GetByID(id) }),
+			"directory_import": byUint(func(id uint) (interface{}, error) { return cfg.DirectoryImportService.Get(id) }),
+			"job":              byUint(func(id uint) (interface{}, error) { return cfg.JobService.Get(id) }),
+		},
+	}
+}
+
+// snapshot loads the current state of a target, or nil if it does not exist.
+func (a *Auditor) snapshot(target, id string) interface{} {
+	if load := a.loaders[target]; load != nil && id != "" {
+		return load(id)
+	}
+	return nil
+}
+
+// auditWriter keeps the start of the response so created IDs can be read from it.
+type auditWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if w.body.Len() < auditBodyLimit {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *auditWriter) WriteString(s string) (int, error) {
+	if w.body.Len() < auditBodyLimit {
+		w.body.WriteString(s)
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Middleware records every request that is not a GET, HEAD or OPTIONS,
+// including rejected ones, after it has been handled.
+func (a *Auditor) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		spec, ok := auditRoutes[c.Request.Method+" "+c.FullPath()]
+		if !ok {
+			spec = auditRoute{action: strings.ToLower(c.Request.Method) + " " + c.FullPath()}
+		}
+		if spec.skip {
+			c.Next()
+			return
+		}
+
+		targetID := ""
+		if spec.param != "" {
+			targetID = c.Param(spec.param)
+		}
+		before := a.snapshot(spec.target, targetID)
+
+		w := &auditWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		status := w.Status()
+		var after interface{}
+		if status < http.StatusBadRequest {
+			if targetID == "" {
+				targetID = createdID(w.body.Bytes())
+			}
+			if _, ok := a.loaders[spec.target]; ok {
+				if !spec.removes {
+					after = a.snapshot(spec.target, targetID)
+				}
+			} else if !spec.secret && w.body.Len() <= auditResponseLimit {
+				after = json.RawMessage(w.body.Bytes())
+			}
+		}
+
+		a.record(auditActorOf(c), &model.AuditEntry{
+			Action:     spec.action,
+			Method:     c.Request.Method,
+			Route:      c.FullPath(),
+			TargetType: spec.target,
+			TargetID:   targetID,
+			Status:     status,
+		}, before, after)
+	}
+}
+
+// recordWS audits a WebSocket write action once it has been dispatched.
+func (a *Auditor) recordWS(actor auditActor, action, targetID string, before, result interface{}, err error) {
+	status := http.StatusOK
+	if err != nil {
+		status, _ = errorStatus(err)
+		if _, ok := err.(wsBadRequest); ok {
+			status = http.StatusBadRequest
+		}
+		if err == errWSForbidden {
+			status = http.StatusForbidden
+		}
+	}
+	var after interface{}
+	if err == nil {
+		if targetID == "" {
+			data, _ := json.Marshal(result)
+			targetID = createdID(data)
+		}
+		after = a.snapshot("message", targetID)
+	}
+	a.record(actor, &model.AuditEntry{
+		Action:     wsAuditActions[action],
+		Method:     "WS",
+		Route:      "/api/ws",
+		TargetType: "message",
+		TargetID:   targetID,
+		Status:     status,
+	}, before, after)
+}
+
+// auditActor identifies who made a change. WebSocket connections keep one
+// for their lifetime, since actions outlive the upgrade request's context.
+type auditActor struct {
+	name      string
+	actorType string
+	ip        string
+	userAgent string
+}
+
+func auditActorOf(c *gin.Context) auditActor {
+	actor := auditActor{name: actorName(c), actorType: "user", ip: c.ClientIP(), userAgent: c.Request.UserAgent()}
+	if _, ok := c.Get(ctxAPIKey); ok {
+		actor.actorType = "api_key"
+	}
+	return actor
+}
+
+func (a *Auditor) record(actor auditActor, entry *model.AuditEntry, before, after interface{}) {
+	entry.Actor = actor.name
+	entry.ActorType = actor.actorType
+	entry.IP = actor.ip
+	entry.UserAgent = actor.userAgent
+	entry.CreatedAt = time.Now()
+	a.auditService.Record(entry, before, after)
+}
+
+// createdID finds the ID of a created object in a JSON response:
+// data.id, data.message_id or a top-level message_id.
+func createdID(body []byte) string {
+	var resp struct {
+		Data      map[string]interface{} `json:"data"`
+		MessageID string                 `json:"message_id"`
+	}
+	if json.Unmarshal(body, &resp) != nil {
+		return ""
+	}
+	for _, key := range []string{"id", "message_id"} {
+		switch v := resp.Data[key].(type) {
+		case string:
+			return v
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	return resp.MessageID
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"lark-robot/internal/larkbot"
+)
+
+// errorStatus maps an error to an HTTP status. Lark API errors are classified
+// by code; anything else is treated as an internal error.
+func errorStatus(err error) (int, string) {
+	switch {
+	case larkbot.IsRateLimited(err):
+		return http.StatusTooManyRequests, "rate_limited"
+	case larkbot.IsNotInChat(err):
+		return http.StatusConflict, "not_in_chat"
+	case larkbot.IsPermissionDenied(err):
+		return http.StatusForbidden, "permission_denied"
+	case larkbot.IsNotFound(err):
+		return http.StatusNotFound, "not_found"
+	case larkbot.IsTokenExpired(err):
+		return http.StatusBadGateway, "token_expired"
+	}
+	if _, ok := larkbot.AsAPIError(err); ok {
+		return http.StatusBadGateway, "lark_error"
+	}
+	return http.StatusInternalServerError, "internal"
+}
+
+// respondError writes err as a JSON error response. Lark API errors carry
+// their code and log_id so operators can look them up in the Lark console.
+func respondError(c *gin.Context, err error) {
+	status, kind := errorStatus(err)
+	body := gin.H{"error": err.Error(), "kind": kind}
+	if apiErr, ok := larkbot.AsAPIError(err); ok {
+		body["lark_code"] = apiErr.Code
+		if apiErr.LogID != "" {
+			body["log_id"] = apiErr.LogID
+		}
+	}
+	if status == http.StatusTooManyRequests {
+		c.Header("Retry-After", "1")
+	}
+	c.JSON(status, body)
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// ctxTOTPPending marks a login that had the right password but still needs a
+// TOTP code, so the throttle does not count it as a failure.
+const ctxTOTPPending = "totp_pending"
+
+// LoginThrottleConfig limits failed logins per username and per client IP.
+// A limit of 0 disables that check.
+type LoginThrottleConfig struct {
+	MaxAttemptsPerUser int
+	MaxAttemptsPerIP   int
+	Window             time.Duration // failures older than this are forgotten
+	Lockout            time.Duration // how long a username or IP stays locked
+}
+
+// loginThrottle counts failed logins in memory. Counters are lost on restart,
+// which only shortens a lockout.
+type loginThrottle struct {
+	cfg       LoginThrottleConfig
+	logger    *zap.Logger
+	mu        sync.Mutex
+	entries   map[string]*loginFailures
+	lastSweep time.Time
+}
+
+type loginFailures struct {
+	count       int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+func newLoginThrottle(cfg LoginThrottleConfig, logger *zap.Logger) *loginThrottle {
+	return &loginThrottle{cfg: cfg, logger: logger, entries: make(map[string]*loginFailures)}
+}
+
+// limits returns the throttle keys of a login attempt with their limits.
+func (t *loginThrottle) limits(ip, username string) map[string]int {
+	keys := make(map[string]int, 2)
+	if t.cfg.MaxAttemptsPerIP > 0 {
+		keys["ip:"+ip] = t.cfg.MaxAttemptsPerIP
+	}
+	if t.cfg.MaxAttemptsPerUser > 0 && username != "" {
+		keys["user:"+strings.ToLower(username)] = t.cfg.MaxAttemptsPerUser
+	}
+	return keys
+}
+
+// lockedFor returns how long the attempt must wait, or 0 if it may proceed.
+func (t *loginThrottle) lockedFor(ip, username string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var wait time.Duration
+	for key := range t.limits(ip, username) {
+		if e, ok := t.entries[key]; ok && e.lockedUntil.After(now) {
+			if d := e.lockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait
+}
+
+// fail records a failed attempt and locks keys that reached their limit.
+func (t *loginThrottle) fail(ip, username string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sweep(now)
+	for key, limit := range t.limits(ip, username) {
+		e, ok := t.entries[key]
+		if !ok || now.Sub(e.first) > t.cfg.Window {
+			e = &loginFailures{first: now}
+			t.entries[key] = e
+		}
+		e.count++
+		if e.count >= limit {
+			e.lockedUntil = now.Add(t.cfg.Lockout)
+			e.count = 0
+			e.first = now
+			t.logger.Warn("login locked after repeated failures",
+				zap.String("key", key),
+				zap.Int("attempts", limit),
+				zap.Duration("lockout", t.cfg.Lockout))
+		}
+	}
+}
+
+// succeed clears the username's failures. The IP's are kept, so one valid
+// account does not let a client keep guessing others.
+func (t *loginThrottle) succeed(username string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, "user:"+strings.ToLower(username))
+}
+
+// sweep drops expired entries at most once per window. Called with mu held.
+func (t *loginThrottle) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < t.cfg.Window {
+		return
+	}
+	t.lastSweep = now
+	for key, e := range t.entries {
+		if now.Sub(e.first) > t.cfg.Window && !e.lockedUntil.After(now) {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// Middleware wraps the login handler. Locked usernames and IPs get 429 with
+// Retry-After; a 401 from the handler counts as a failure.
+func (t *loginThrottle) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username := loginUsername(c)
+		ip := c.ClientIP()
+		if wait := t.lockedFor(ip, username, time.Now()); wait > 0 {
+			seconds := int(wait.Seconds()) + 1
+			c.Header("Retry-After", strconv.Itoa(seconds))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error":       fmt.Sprintf("登录失败次数过多，请 %d 秒后再试", seconds),
+				"retry_after": seconds,
+			})
+			return
+		}
+
+		c.Next()
+
+		switch c.Writer.Status() {
+		case http.StatusOK:
+			t.succeed(username)
+		case http.StatusUnauthorized:
+			if !c.GetBool(ctxTOTPPending) {
+				t.fail(ip, username, time.Now())
+			}
+		}
+	}
+}
+
+// loginUsername peeks at the username in a login request body and puts the
+// body back for the handler.
+func loginUsername(c *gin.Context) string {
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, 64<<10))
+	if err != nil {
+		return ""
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(data))
+	var req struct {
+		Username string `json:"username"`
+	}
+	json.Unmarshal(data, &req)
+	return req.Username
+}
+
+// ParseCIDRs parses a list of networks. Plain IP addresses are accepted as
+// single-host networks.
+func ParseCIDRs(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP or CIDR %q", s)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP or CIDR %q: %w", s, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// IPAllowlist rejects /api requests from clients outside nets. An empty
+// list allows everyone. The frontend itself stays reachable, so blocked
+// users see the error instead of a blank page.
+func IPAllowlist(nets []*net.IPNet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(nets) == 0 || !strings.HasPrefix(c.Request.URL.Path, "/api") {
+			c.Next()
+			return
+		}
+		if ip := net.ParseIP(c.ClientIP()); ip != nil {
+			for _, n := range nets {
+				if n.Contains(ip) {
+					c.Next()
+					return
+				}
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "IP 不在允许范围内", "kind": "forbidden"})
+	}
+}
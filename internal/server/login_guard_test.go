@@ -0,0 +1,157 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func TestLoginThrottleLockout(t *testing.T) {
+	cfg := LoginThrottleConfig{
+		MaxAttemptsPerUser: 3,
+		MaxAttemptsPerIP:   5,
+		Window:             time.Minute,
+		Lockout:            10 * time.Minute,
+	}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	type attempt struct {
+		ip, username string
+		after        time.Duration // since start
+		ok           bool          // succeeded instead of failed
+	}
+	tests := []struct {
+		name     string
+		attempts []attempt
+		ip, user string
+		at       time.Duration
+		wantWait time.Duration
+	}{
+		{
+			name:     "below the user limit",
+			attempts: []attempt{{"1.1.1.1", "alice", 0, false}, {"1.1.1.1", "alice", time.Second, false}},
+			ip:       "1.1.1.1", user: "alice", at: 2 * time.Second,
+		},
+		{
+			name: "user locked at the limit",
+			attempts: []attempt{
+				{"1.1.1.1", "alice", 0, false},
+				{"1.1.1.2", "alice", time.Second, false},
+				{"1.1.1.3", "Alice", 2 * time.Second, false},
+			},
+			ip: "9.9.9.9", user: "ALICE", at: 3 * time.Second,
+			wantWait: 10*time.Minute - time.Second,
+		},
+		{
+			name: "lock expires",
+			attempts: []attempt{
+				{"1.1.1.1", "alice", 0, false},
+				{"1.1.1.1", "alice", 0, false},
+				{"1.1.1.1", "alice", 0, false},
+			},
+			ip: "1.1.1.1", user: "alice", at: 10 * time.Minute,
+		},
+		{
+			name: "failures outside the window are forgotten",
+			attempts: []attempt{
+				{"1.1.1.1", "alice", 0, false},
+				{"1.1.1.1", "alice", 0, false},
+				{"1.1.1.1", "alice", 2 * time.Minute, false},
+			},
+			ip: "1.1.1.1", user: "alice", at: 2 * time.Minute,
+		},
+		{
+			name: "success clears the user",
+			attempts: []attempt{
+				{"1.1.1.1", "alice", 0, false},
+				{"1.1.1.1", "alice", 0, false},
+				{"1.1.1.1", "alice", 0, true},
+				{"1.1.1.1", "alice", 0, false},
+			},
+			ip: "2.2.2.2", user: "alice", at: time.Second,
+		},
+		{
+			name: "ip locked across usernames",
+			attempts: []attempt{
+				{"1.1.1.1", "a", 0, false},
+				{"1.1.1.1", "b", 0, false},
+				{"1.1.1.1", "c", 0, false},
+				{"1.1.1.1", "d", 0, false},
+				{"1.1.1.1", "e", 0, false},
+			},
+			ip: "1.1.1.1", user: "f", at: time.Minute,
+			wantWait: 9 * time.Minute,
+		},
+		{
+			name: "success keeps the ip count",
+			attempts: []attempt{
+				{"1.1.1.1", "a", 0, false},
+				{"1.1.1.1", "b", 0, false},
+				{"1.1.1.1", "c", 0, false},
+				{"1.1.1.1", "d", 0, false},
+				{"1.1.1.1", "ok", 0, true},
+				{"1.1.1.1", "e", 0, false},
+			},
+			ip: "1.1.1.1", user: "ok", at: 0,
+			wantWait: 10 * time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := newLoginThrottle(cfg, zap.NewNop())
+			for _, a := range tt.attempts {
+				if a.ok {
+					th.succeed(a.username)
+				} else {
+					th.fail(a.ip, a.username, start.Add(a.after))
+				}
+			}
+			if got := th.lockedFor(tt.ip, tt.user, start.Add(tt.at)); got != tt.wantWait {
+				t.Errorf("lockedFor() = %v, want %v", got, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestLoginThrottleMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	th := newLoginThrottle(LoginThrottleConfig{MaxAttemptsPerUser: 2, Window: time.Minute, Lockout: time.Minute}, zap.NewNop())
+	engine := gin.New()
+	engine.POST("/login", th.Middleware(), func(c *gin.Context) {
+		switch c.Query("result") {
+		case "ok":
+			c.JSON(http.StatusOK, gin.H{})
+		case "totp":
+			c.Set(ctxTOTPPending, true)
+			c.JSON(http.StatusUnauthorized, gin.H{"totp_required": true})
+		default:
+			c.JSON(http.StatusUnauthorized, gin.H{})
+		}
+	})
+
+	steps := []struct {
+		result string
+		want   int
+	}{
+		{"fail", http.StatusUnauthorized},
+		{"totp", http.StatusUnauthorized}, // a pending TOTP step is not a failure
+		{"totp", http.StatusUnauthorized},
+		{"fail", http.StatusUnauthorized}, // second failure locks the user
+		{"ok", http.StatusTooManyRequests},
+	}
+	for i, s := range steps {
+		req := httptest.NewRequest(http.MethodPost, "/login?result="+s.result, strings.NewReader(`{"username":"alice"}`))
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, req)
+		if w.Code != s.want {
+			t.Fatalf("step %d (%s): status = %d, want %d", i, s.result, w.Code, s.want)
+		}
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Errorf("step %d: missing Retry-After", i)
+		}
+	}
+}
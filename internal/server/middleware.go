@@ -6,42 +6,88 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
+
+	"lark-robot/internal/service"
 )
 
-func CORSMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+// streamTokenRoutes are the GET routes that accept a stream token in the token
+// query param: the SSE stream, the WebSocket and images, which are opened by
+// the browser and cannot send an Authorization header.
+var streamTokenRoutes = map[string]bool{
+	"/api/messages/stream":              true,
+	"/api/ws":                           true,
+	"/api/images/:message_id/:file_key": true,
+}
 
-		if c.Request.Method == http.MethodOptions {
-			c.AbortWithStatus(http.StatusNoContent)
+// AuthMiddleware checks for a valid access token in the Authorization header, or a
+// stream token in the token query param of streamTokenRoutes, and loads the admin user it belongs to.
+// Tokens of revoked sessions and of deleted or disabled users are rejected.
+// Machine clients may instead send an API key in the X-API-Key header, which
+// is limited to the endpoints it was created for.
+func AuthMiddleware(sessions *service.SessionService, users *service.AdminUserService, apiKeys *service.APIKeyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if secret := c.GetHeader("X-API-Key"); secret != "" {
+			key, err := apiKeys.Authenticate(secret)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "API Key 无效、已吊销或已过期"})
+				return
+			}
+			if !key.AllowsEndpoint(c.Request.Method, c.FullPath()) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API Key 无权访问该接口", "kind": "forbidden"})
+				return
+			}
+			c.Set(ctxAdminUser, key.Principal())
+			c.Set(ctxAPIKey, key)
+			c.Next()
 			return
 		}
-		c.Next()
-	}
-}
 
-// AuthMiddleware checks for a valid token in the Authorization header or query param.
-func AuthMiddleware(secret string) gin.HandlerFunc {
-	return func(c *gin.Context) {
 		// Try Authorization header first
-		token := ""
+		token, typ := "", service.TokenAccess
 		auth := c.GetHeader("Authorization")
 		if len(auth) > 7 && auth[:7] == "Bearer " {
 			token = auth[7:]
 		}
 
-		// Fallback to query param (for SSE EventSource which can't set headers)
-		if token == "" {
-			token = c.Query("token")
+		// Fallback to query param (for SSE EventSource, WebSocket and <img>, which can't set headers).
+		// Only short-lived stream tokens are accepted here, and only on read-only
+		// routes that need it, since URLs end up in logs and history.
+		if token == "" && c.Request.Method == http.MethodGet && streamTokenRoutes[c.FullPath()] {
+			token, typ = c.Query("token"), service.TokenStream
 		}
 
-		if token == "" || !ValidateToken(secret, token) {
+		if token == "" {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "未登录或登录已过期"})
 			return
 		}
+		claims, err := sessions.Parse(token, typ)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "未登录或登录已过期"})
+			return
+		}
+		user, err := users.GetActive(claims.Subject)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "账号不存在或已停用"})
+			return
+		}
+		c.Set(ctxAdminUser, user)
+		c.Set(ctxSessionID, claims.SessionID)
+		if typ == service.TokenStream {
+			c.Set(ctxStreamToken, true)
+		}
+		c.Next()
+	}
+}
+
+// RequireSession rejects requests that are not made with a login session's
+// access token, i.e. API keys and stream tokens, for endpoints that manage
+// the caller's own session.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString(ctxSessionID) == "" || c.GetBool(ctxStreamToken) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "该接口需要登录会话", "kind": "forbidden"})
+			return
+		}
 		c.Next()
 	}
 }
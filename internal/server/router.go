@@ -3,6 +3,7 @@ package server
 import (
 	"io"
 	"io/fs"
+	"net"
 	"net/http"
 	"strings"
 
@@ -11,6 +12,7 @@ import (
 
 	"lark-robot/internal/broadcast"
 	"lark-robot/internal/larkbot"
+	"lark-robot/internal/model"
 	"lark-robot/internal/service"
 )
 
@@ -18,34 +20,68 @@ type Router struct {
 	Engine           *gin.Engine
 	logger           *zap.Logger
 	authAPI          *AuthAPI
+	ssoAPI           *SSOAPI
+	adminUserAPI     *AdminUserAPI
+	sessionAPI       *SessionAPI
+	apiKeyAPI        *APIKeyAPI
+	auditAPI         *AuditAPI
+	auditor          *Auditor
 	dashboardAPI     *DashboardAPI
 	messageAPI       *MessageAPI
 	uploadAPI        *UploadAPI
 	chatAPI          *ChatAPI
 	userAPI          *UserAPI
+	departmentAPI    *DepartmentAPI
+	activityAPI      *ActivityAPI
+	importAPI        *DirectoryImportAPI
+	jobAPI           *JobAPI
 	autoReplyAPI     *AutoReplyAPI
 	scheduledTaskAPI *ScheduledTaskAPI
+	campaignAPI      *CampaignAPI
+	eventAPI         *EventAPI
+	wsAPI            *WSAPI
 	larkClient       *larkbot.LarkClient
-	authSecret       string
+	sessions         *service.SessionService
+	adminUsers       *service.AdminUserService
+	apiKeys          *service.APIKeyService
+	loginThrottle    *loginThrottle
+	allowedNets      []*net.IPNet
+	cors             CORSConfig
+	securityHeaders  SecurityHeadersConfig
 	frontendFS       http.FileSystem
 	embeddedFS       fs.FS
 }
 
 type RouterConfig struct {
-	Mode             string
-	Logger           *zap.Logger
-	AuthUsername      string
-	AuthPassword      string
-	AuthSecret        string
-	LarkClient       *larkbot.LarkClient
-	ChatService      *service.ChatService
-	MessageService   *service.MessageService
-	SchedulerService *service.SchedulerService
-	ReplyService     *service.ReplyService
-	UserService      *service.UserService
-	Broadcaster      *broadcast.MessageBroadcaster
-	FrontendFS       http.FileSystem
-	EmbeddedFS       fs.FS
+	Mode                   string
+	Logger                 *zap.Logger
+	SessionService         *service.SessionService
+	AdminUserService       *service.AdminUserService
+	APIKeyService          *service.APIKeyService
+	AuditService           *service.AuditService
+	SSOService             *service.SSOService // nil when Lark SSO is disabled
+	SSORedirectURL         string
+	PasswordLogin          bool
+	TOTPIssuer             string
+	LoginThrottle          LoginThrottleConfig
+	AllowedNets            []*net.IPNet // clients allowed to call /api; empty = all
+	TrustedProxies         []string     // proxies whose X-Forwarded-For is believed; empty = none
+	CORS                   CORSConfig
+	SecurityHeaders        SecurityHeadersConfig
+	LarkClient             *larkbot.LarkClient
+	ChatService            *service.ChatService
+	MessageService         *service.MessageService
+	SchedulerService       *service.SchedulerService
+	ReplyService           *service.ReplyService
+	UserService            *service.UserService
+	DepartmentService      *service.DepartmentService
+	ActivityService        *service.ActivityService
+	DirectoryImportService *service.DirectoryImportService
+	JobService             *service.JobService
+	CampaignService        *service.CampaignService
+	Bus                    *broadcast.Bus
+	FrontendFS             http.FileSystem
+	EmbeddedFS             fs.FS
 }
 
 func NewRouter(cfg RouterConfig) *Router {
@@ -53,42 +89,108 @@ func NewRouter(cfg RouterConfig) *Router {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	auditor := NewAuditor(AuditConfig{
+		AuditService:           cfg.AuditService,
+		MessageService:         cfg.MessageService,
+		ChatService:            cfg.ChatService,
+		ReplyService:           cfg.ReplyService,
+		SchedulerService:       cfg.SchedulerService,
+		CampaignService:        cfg.CampaignService,
+		AdminUserService:       cfg.AdminUserService,
+		APIKeyService:          cfg.APIKeyService,
+		SessionService:         cfg.SessionService,
+		UserService:            cfg.UserService,
+		DirectoryImportService: cfg.DirectoryImportService,
+		JobService:             cfg.JobService,
+	})
+
 	r := &Router{
 		Engine:             gin.New(),
 		logger:             cfg.Logger,
-		authAPI:            NewAuthAPI(cfg.AuthUsername, cfg.AuthPassword, cfg.AuthSecret),
+		authAPI:            NewAuthAPI(cfg.AdminUserService, cfg.SessionService, cfg.PasswordLogin, cfg.SSOService != nil, cfg.TOTPIssuer),
+		ssoAPI:             NewSSOAPI(cfg.SSOService, cfg.SessionService, cfg.AdminUserService, cfg.SSORedirectURL, cfg.Logger),
+		adminUserAPI:       NewAdminUserAPI(cfg.AdminUserService, cfg.SessionService),
+		sessionAPI:         NewSessionAPI(cfg.SessionService),
+		apiKeyAPI:          NewAPIKeyAPI(cfg.APIKeyService),
+		auditAPI:           NewAuditAPI(cfg.AuditService, cfg.JobService),
+		auditor:            auditor,
 		dashboardAPI:       NewDashboardAPI(cfg.ChatService, cfg.MessageService, cfg.SchedulerService, cfg.ReplyService, cfg.UserService),
-		messageAPI:         NewMessageAPI(cfg.MessageService, cfg.Broadcaster),
+		messageAPI:         NewMessageAPI(cfg.MessageService, cfg.Bus),
 		uploadAPI:          NewUploadAPI(cfg.LarkClient),
-		chatAPI:            NewChatAPI(cfg.ChatService),
-		userAPI:            NewUserAPI(cfg.UserService),
+		chatAPI:            NewChatAPI(cfg.ChatService, cfg.JobService),
+		userAPI:            NewUserAPI(cfg.UserService, cfg.JobService),
+		departmentAPI:      NewDepartmentAPI(cfg.DepartmentService, cfg.UserService),
+		activityAPI:        NewActivityAPI(cfg.ActivityService),
+		importAPI:          NewDirectoryImportAPI(cfg.DirectoryImportService),
+		jobAPI:             NewJobAPI(cfg.JobService),
 		autoReplyAPI:       NewAutoReplyAPI(cfg.ReplyService),
 		scheduledTaskAPI:   NewScheduledTaskAPI(cfg.SchedulerService),
+		campaignAPI:        NewCampaignAPI(cfg.CampaignService),
+		eventAPI:           NewEventAPI(cfg.Bus),
+		wsAPI:              NewWSAPI(cfg.MessageService, cfg.Bus, auditor, cfg.SessionService, cfg.AdminUserService, cfg.APIKeyService, cfg.CORS, cfg.Logger),
 		larkClient:         cfg.LarkClient,
-		authSecret:         cfg.AuthSecret,
+		sessions:           cfg.SessionService,
+		adminUsers:         cfg.AdminUserService,
+		apiKeys:            cfg.APIKeyService,
+		loginThrottle:      newLoginThrottle(cfg.LoginThrottle, cfg.Logger),
+		allowedNets:        cfg.AllowedNets,
+		cors:               cfg.CORS,
+		securityHeaders:    cfg.SecurityHeaders,
 		frontendFS:         cfg.FrontendFS,
 		embeddedFS:         cfg.EmbeddedFS,
 	}
 
+	// Client IPs feed the login throttle and the allowlist, so X-Forwarded-For
+	// is only believed from configured proxies. The list is checked by app.New.
+	r.Engine.SetTrustedProxies(cfg.TrustedProxies)
+
 	r.setupRoutes()
 	return r
 }
 
 func (r *Router) setupRoutes() {
 	r.Engine.Use(gin.Recovery())
-	r.Engine.Use(CORSMiddleware())
+	r.Engine.Use(SecurityHeaders(r.securityHeaders))
+	r.Engine.Use(CORSMiddleware(r.cors))
 	r.Engine.Use(LoggerMiddleware(r.logger))
+	r.Engine.Use(IPAllowlist(r.allowedNets))
 
 	api := r.Engine.Group("/api")
 	{
 		// Login (no auth required)
-		api.POST("/login", r.authAPI.Login)
+		api.POST("/login", r.loginThrottle.Middleware(), r.authAPI.Login)
+		api.POST("/auth/refresh", r.authAPI.Refresh)
+		api.GET("/auth/options", r.authAPI.Options)
+		api.GET("/auth/lark/login", r.ssoAPI.Login)
+		api.GET("/auth/lark/callback", r.ssoAPI.Callback)
+		api.POST("/auth/lark/totp", r.loginThrottle.Middleware(), r.ssoAPI.VerifyTOTP)
+	}
+
+	// The user's own login sessions; allowed for every role, including viewers,
+	// but not for API keys.
+	self := r.Engine.Group("/api")
+	self.Use(AuthMiddleware(r.sessions, r.adminUsers, r.apiKeys))
+	self.Use(r.auditor.Middleware())
+	{
+		self.GET("/me", r.authAPI.Me)
+		self.POST("/logout", RequireSession(), r.authAPI.Logout)
+		self.POST("/auth/stream-token", RequireSession(), r.authAPI.StreamToken)
+		self.GET("/sessions", RequireSession(), r.sessionAPI.List)
+		self.DELETE("/sessions/:id", RequireSession(), r.sessionAPI.Revoke)
+		self.POST("/me/totp/setup", RequireSession(), r.authAPI.SetupTOTP)
+		self.POST("/me/totp", RequireSession(), r.authAPI.EnableTOTP)
+		self.DELETE("/me/totp", RequireSession(), r.authAPI.DisableTOTP)
 	}
 
-	// All other API routes require authentication
+	// All other API routes require authentication. Viewers are read-only;
+	// changes need at least the operator role. Every change, including
+	// rejected attempts, goes to the audit log.
 	authed := r.Engine.Group("/api")
-	authed.Use(AuthMiddleware(r.authSecret))
+	authed.Use(AuthMiddleware(r.sessions, r.adminUsers, r.apiKeys))
+	authed.Use(r.auditor.Middleware())
+	authed.Use(RequireRoleForWrites(model.RoleOperator))
 	{
+
 		// Bot info
 		authed.GET("/bot/info", func(c *gin.Context) {
 			c.JSON(http.StatusOK, gin.H{
@@ -104,10 +206,16 @@ func (r *Router) setupRoutes() {
 		// Messages
 		authed.POST("/messages/send", r.messageAPI.Send)
 		authed.POST("/messages/reply", r.messageAPI.Reply)
+		authed.PUT("/messages/:message_id", r.messageAPI.Update)
 		authed.DELETE("/messages/:message_id", r.messageAPI.Delete)
+		authed.GET("/messages/:message_id/revisions", r.messageAPI.Revisions)
 		authed.GET("/messages/logs", r.messageAPI.GetLogs)
 		authed.GET("/messages/conversations", r.messageAPI.Conversations)
+		authed.GET("/messages/threads", r.messageAPI.Threads)
+		authed.GET("/messages/queue", r.messageAPI.QueueStats)
 		authed.GET("/messages/stream", r.messageAPI.Stream)
+		authed.POST("/messages/:message_id/handled", r.messageAPI.MarkHandled)
+		authed.GET("/ws", r.wsAPI.Handle)
 		authed.GET("/images/:message_id/:file_key", r.messageAPI.GetImage)
 
 		// Upload
@@ -117,13 +225,39 @@ func (r *Router) setupRoutes() {
 		// Chats (groups)
 		authed.GET("/chats", r.chatAPI.List)
 		authed.POST("/chats/sync", r.chatAPI.Sync)
-		authed.POST("/chats/:chat_id/leave", r.chatAPI.Leave)
+		authed.GET("/chats/labels", r.chatAPI.Labels)
+		authed.GET("/chats/match", r.chatAPI.Match)
+		authed.GET("/chats/:chat_id", r.chatAPI.GetByChatID)
+		authed.PUT("/chats/:chat_id/meta", r.chatAPI.UpdateMeta)
+		authed.GET("/chats/:chat_id/history", r.chatAPI.History)
+		authed.POST("/chats/:chat_id/leave", RequireRole(model.RoleAdmin), r.chatAPI.Leave)
 		authed.GET("/chats/:chat_id/members", r.chatAPI.Members)
 
 		// Users
 		authed.GET("/users", r.userAPI.List)
 		authed.POST("/users/sync", r.userAPI.Sync)
+		authed.GET("/users/leaderboard", r.activityAPI.Leaderboard)
+		authed.GET("/users/import", r.importAPI.Latest)
+		authed.POST("/users/import", RequireRole(model.RoleAdmin), r.importAPI.Start)
+		authed.POST("/users/import/:id/pause", RequireRole(model.RoleAdmin), r.importAPI.Pause)
+		authed.POST("/users/import/:id/resume", RequireRole(model.RoleAdmin), r.importAPI.Resume)
+		authed.GET("/users/cache", r.userAPI.CacheStats)
+		authed.DELETE("/users/cache", r.userAPI.ClearCache)
 		authed.GET("/users/:open_id", r.userAPI.GetByOpenID)
+		authed.POST("/users/:open_id/sync", r.userAPI.SyncOne)
+		authed.GET("/users/:open_id/activity", r.activityAPI.User)
+
+		// Departments (org chart)
+		authed.GET("/departments", r.departmentAPI.List)
+		authed.POST("/departments/sync", r.departmentAPI.Sync)
+		authed.GET("/departments/:id", r.departmentAPI.GetByID)
+		authed.GET("/departments/:id/users", r.departmentAPI.Users)
+
+		// Background jobs (syncs, exports, campaign runs)
+		authed.GET("/jobs", r.jobAPI.List)
+		authed.GET("/jobs/:id", r.jobAPI.GetByID)
+		authed.POST("/jobs/:id/cancel", r.jobAPI.Cancel)
+		authed.GET("/jobs/:id/file", r.jobAPI.File)
 
 		// Auto-reply rules
 		rules := authed.Group("/auto-reply-rules")
@@ -148,6 +282,52 @@ func (r *Router) setupRoutes() {
 			tasks.POST("/:id/run", r.scheduledTaskAPI.RunNow)
 		}
 
+		// Broadcast campaigns
+		campaigns := authed.Group("/campaigns")
+		{
+			campaigns.GET("", r.campaignAPI.List)
+			campaigns.POST("", r.campaignAPI.Create)
+			campaigns.GET("/:id", r.campaignAPI.GetByID)
+			campaigns.PUT("/:id", r.campaignAPI.Update)
+			campaigns.DELETE("/:id", r.campaignAPI.Delete)
+			campaigns.GET("/:id/recipients", r.campaignAPI.Recipients)
+			campaigns.POST("/:id/start", r.campaignAPI.Start)
+			campaigns.POST("/:id/pause", r.campaignAPI.Pause)
+			campaigns.POST("/:id/resume", r.campaignAPI.Resume)
+			campaigns.POST("/:id/cancel", r.campaignAPI.Cancel)
+			campaigns.POST("/:id/recall", r.campaignAPI.Recall)
+		}
+
+		// Event bus
+		authed.GET("/events/stats", r.eventAPI.Stats)
+
+		// Admin console accounts
+		adminUsers := authed.Group("/admin-users", RequireRole(model.RoleAdmin))
+		{
+			adminUsers.GET("", r.adminUserAPI.List)
+			adminUsers.POST("", r.adminUserAPI.Create)
+			adminUsers.GET("/:id", r.adminUserAPI.GetByID)
+			adminUsers.PUT("/:id", r.adminUserAPI.Update)
+			adminUsers.DELETE("/:id", r.adminUserAPI.Delete)
+			adminUsers.POST("/:id/totp/reset", r.adminUserAPI.ResetTOTP)
+		}
+
+		// API keys for machine clients
+		apiKeys := authed.Group("/api-keys", RequireRole(model.RoleAdmin))
+		{
+			apiKeys.GET("", r.apiKeyAPI.List)
+			apiKeys.POST("", r.apiKeyAPI.Create)
+			apiKeys.DELETE("/:id", r.apiKeyAPI.Revoke)
+		}
+
+		// Audit log
+		audit := authed.Group("/audit", RequireRole(model.RoleAdmin))
+		{
+			audit.GET("", r.auditAPI.List)
+			audit.GET("/export", r.auditAPI.Export)
+			audit.POST("/export", r.auditAPI.ExportJob)
+		}
+
 	}
 
 	// Serve frontend
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CORSConfig lists the origins, besides the frontend's own, that may call
+// the API from a browser.
+type CORSConfig struct {
+	AllowedOrigins   []string // "*" allows any origin
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// Validate rejects origins that browsers would never send and the
+// credentials-with-wildcard combination that browsers refuse.
+func (cfg CORSConfig) Validate() error {
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			if cfg.AllowCredentials {
+				return fmt.Errorf("allow_credentials cannot be used with allowed origin \"*\"")
+			}
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return fmt.Errorf("invalid origin %q, expected scheme://host[:port]", origin)
+		}
+	}
+	return nil
+}
+
+func (cfg CORSConfig) allowsOrigin(origin string) bool {
+	origin = strings.TrimSuffix(origin, "/")
+	for _, allowed := range cfg.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// CORSMiddleware answers cross-origin requests from allowed origins. Other
+// origins get no CORS headers, so browsers block them. Same-origin requests,
+// like those of the embedded frontend, need none.
+func CORSMiddleware(cfg CORSConfig) gin.HandlerFunc {
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if origin != "" {
+			c.Writer.Header().Add("Vary", "Origin")
+		}
+		if origin != "" && cfg.allowsOrigin(origin) {
+			h := c.Writer.Header()
+			h.Set("Access-Control-Allow-Origin", origin)
+			if cfg.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if preflight {
+				h.Set("Access-Control-Allow-Methods", methods)
+				h.Set("Access-Control-Allow-Headers", headers)
+				h.Set("Access-Control-Max-Age", maxAge)
+			}
+		}
+
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
+
+// checkWSOrigin allows WebSocket upgrades from the frontend's own origin, from
+// allowed CORS origins and from non-browser clients, which send no Origin.
+// Browsers do not apply CORS to WebSockets, so this is the only check.
+func checkWSOrigin(cfg CORSConfig) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		return cfg.allowsOrigin(origin)
+	}
+}
+
+// defaultCSP suits the embedded frontend: scripts only from the app itself,
+// inline styles for Element Plus, and images from Lark's CDNs for avatars.
+const defaultCSP = "default-src 'self'; script-src 'self'; style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data: blob: https:; font-src 'self' data:; connect-src 'self'; " +
+	"object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+// apiCSP is sent with API responses, which are never rendered as pages.
+const apiCSP = "default-src 'none'; frame-ancestors 'none'"
+
+// SecurityHeadersConfig tunes SecurityHeaders.
+type SecurityHeadersConfig struct {
+	CSP        string        // policy of the frontend; empty = defaultCSP
+	HSTSMaxAge time.Duration // 0 disables HSTS
+	TLS        bool          // HSTS is only sent when the server itself terminates TLS
+}
+
+// SecurityHeaders sets headers that keep the admin console from being framed,
+// sniffed or leaking URLs, which may carry ?token=, in the Referer header.
+func SecurityHeaders(cfg SecurityHeadersConfig) gin.HandlerFunc {
+	csp := cfg.CSP
+	if csp == "" {
+		csp = defaultCSP
+	}
+	hsts := ""
+	if cfg.TLS && cfg.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(int(cfg.HSTSMaxAge.Seconds())) + "; includeSubDomains"
+	}
+	return func(c *gin.Context) {
+		h := c.Writer.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "no-referrer")
+		if strings.HasPrefix(c.Request.URL.Path, "/api") {
+			h.Set("Content-Security-Policy", apiCSP)
+		} else {
+			h.Set("Content-Security-Policy", csp)
+		}
+		if hsts != "" {
+			h.Set("Strict-Transport-Security", hsts)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,237 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"lark-robot/internal/model"
+	"lark-robot/internal/repository"
+)
+
+const (
+	// activityBatchSize is how many message logs are rolled up per transaction.
+	activityBatchSize = 1000
+	// responseWindow is how long after a bot message a user's next message
+	// in the chat still counts as answering it.
+	responseWindow = time.Hour
+	// maxActivityDays bounds the period of activity queries.
+	maxActivityDays = 365
+)
+
+// ActivityService rolls message logs up into per-user hourly counts and
+// answers activity queries from the rollup. Each run continues from a
+// checkpoint instead of rescanning the logs.
+type ActivityService struct {
+	repo   *repository.ActivityRepo
+	logger *zap.Logger
+
+	// mu serializes rollup runs
+	mu sync.Mutex
+}
+
+func NewActivityService(repo *repository.ActivityRepo, logger *zap.Logger) *ActivityService {
+	return &ActivityService{repo: repo, logger: logger}
+}
+
+// activityKey identifies a rollup row.
+type activityKey struct {
+	openID string
+	day    string
+	hour   int
+	chatID string
+}
+
+// Aggregate rolls up the message logs written since the last run and
+// returns how many it processed.
+func (s *ActivityService) Aggregate() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cp, err := s.repo.Checkpoint()
+	if err != nil {
+		return 0, err
+	}
+	// Last message seen per chat, to time answers to the bot
+	last := make(map[string]*model.MessageLog)
+	processed := 0
+	for {
+		logs, err := s.repo.LogsAfter(cp.LastLogID, activityBatchSize)
+		if err != nil {
+			return processed, err
+		}
+		if len(logs) == 0 {
+			break
+		}
+		rows, err := s.rollup(logs, last)
+		if err != nil {
+			return processed, err
+		}
+		cp.LastLogID = logs[len(logs)-1].ID
+		if err := s.repo.Apply(rows, cp.LastLogID); err != nil {
+			return processed, err
+		}
+		processed += len(logs)
+		if len(logs) < activityBatchSize {
+			break
+		}
+	}
+	if processed > 0 {
+		s.logger.Debug("user activity rolled up", zap.Int("messages", processed), zap.Uint("last_log_id", cp.LastLogID))
+	}
+	return processed, nil
+}
+
+// rollup counts a batch of logs per user, chat and hour.
+func (s *ActivityService) rollup(logs []model.MessageLog, last map[string]*model.MessageLog) ([]model.UserActivity, error) {
+	counts := make(map[activityKey]*model.UserActivity)
+	var order []activityKey
+	for i := range logs {
+		log := &logs[i]
+		if log.Source == "edit" {
+			continue
+		}
+		prev, seen := last[log.ChatID]
+		if !seen {
+			var err error
+			if prev, err = s.repo.PreviousInChat(log.ChatID, log.ID); err != nil {
+				return nil, err
+			}
+		}
+		last[log.ChatID] = log
+		if log.Direction != "in" || log.SenderID == "" {
+			continue
+		}
+
+		at := log.CreatedAt.Local()
+		key := activityKey{openID: log.SenderID, day: at.Format("2006-01-02"), hour: at.Hour(), chatID: log.ChatID}
+		row, ok := counts[key]
+		if !ok {
+			row = &model.UserActivity{OpenID: key.openID, Day: key.day, Hour: key.hour, ChatID: key.chatID}
+			counts[key] = row
+			order = append(order, key)
+		}
+		row.Messages++
+		if prev != nil && prev.Direction == "out" {
+			if d := log.CreatedAt.Sub(prev.CreatedAt); d >= 0 && d <= responseWindow {
+				row.Responses++
+				row.ResponseSecs += d.Seconds()
+			}
+		}
+	}
+	rows := make([]model.UserActivity, 0, len(order))
+	for _, key := range order {
+		rows = append(rows, *counts[key])
+	}
+	return rows, nil
+}
+
+// UserActivityReport describes a user's activity over the last days.
+type UserActivityReport struct {
+	OpenID             string                 `json:"open_id"`
+	Since              string                 `json:"since"` // first day of the period
+	Days               int                    `json:"days"`
+	Messages           int64                  `json:"messages"`
+	ActiveDays         int                    `json:"active_days"`
+	Daily              []repository.DayCount  `json:"daily"`        // every day of the period, oldest first
+	Hours              []int64                `json:"hours"`        // messages per hour of the day, 0-23
+	BusiestHour        int                    `json:"busiest_hour"` // -1 without messages
+	Chats              []repository.ChatCount `json:"chats"`        // busiest first
+	Responses          int64                  `json:"responses"`    // messages answering the bot
+	AvgResponseSeconds float64                `json:"avg_response_seconds"`
+	AsOf               time.Time              `json:"as_of"` // when the rollup last ran
+}
+
+// UserActivity reports a user's activity over the last days, limited to
+// chatIDs if non-nil.
+func (s *ActivityService) UserActivity(openID string, days int, chatIDs []string) (*UserActivityReport, error) {
+	days = clampActivityDays(days)
+	since := activitySince(days)
+	f := repository.ActivityFilter{OpenID: openID, Since: since.Format("2006-01-02"), ChatIDs: chatIDs}
+
+	report := &UserActivityReport{OpenID: openID, Since: f.Since, Days: days, Hours: make([]int64, 24), BusiestHour: -1}
+	daily, err := s.repo.Daily(f)
+	if err != nil {
+		return nil, err
+	}
+	byDay := make(map[string]int64, len(daily))
+	for _, d := range daily {
+		byDay[d.Day] = d.Messages
+		report.Messages += d.Messages
+		if d.Messages > 0 {
+			report.ActiveDays++
+		}
+	}
+	report.Daily = make([]repository.DayCount, 0, days)
+	for i := 0; i < days; i++ {
+		day := since.AddDate(0, 0, i).Format("2006-01-02")
+		report.Daily = append(report.Daily, repository.DayCount{Day: day, Messages: byDay[day]})
+	}
+
+	hours, err := s.repo.Hours(f)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range hours {
+		if h.Hour >= 0 && h.Hour < 24 {
+			report.Hours[h.Hour] = h.Messages
+		}
+	}
+	for h, n := range report.Hours {
+		if n > 0 && (report.BusiestHour < 0 || n > report.Hours[report.BusiestHour]) {
+			report.BusiestHour = h
+		}
+	}
+
+	if report.Chats, err = s.repo.Chats(f, 20); err != nil {
+		return nil, err
+	}
+	responses, seconds, err := s.repo.ResponseTotals(f)
+	if err != nil {
+		return nil, err
+	}
+	report.Responses = responses
+	if responses > 0 {
+		report.AvgResponseSeconds = seconds / float64(responses)
+	}
+	if cp, err := s.repo.Checkpoint(); err == nil {
+		report.AsOf = cp.UpdatedAt
+	}
+	return report, nil
+}
+
+// Leaderboard ranks users by "messages", "chats" or "active_days" over the
+// last days, limited to chatIDs if non-nil. It also returns when the rollup
+// last ran.
+func (s *ActivityService) Leaderboard(days int, by string, limit int, chatIDs []string) ([]repository.LeaderboardEntry, time.Time, error) {
+	days = clampActivityDays(days)
+	f := repository.ActivityFilter{Since: activitySince(days).Format("2006-01-02"), ChatIDs: chatIDs}
+	entries, err := s.repo.Leaderboard(f, by, limit)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	cp, err := s.repo.Checkpoint()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return entries, cp.UpdatedAt, nil
+}
+
+func clampActivityDays(days int) int {
+	if days < 1 {
+		return 30
+	}
+	if days > maxActivityDays {
+		return maxActivityDays
+	}
+	return days
+}
+
+// activitySince returns the local midnight starting a period of days that
+// ends today.
+func activitySince(days int) time.Time {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return today.AddDate(0, 0, -(days - 1))
+}
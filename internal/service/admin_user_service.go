@@ -0,0 +1,304 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+
+	"lark-robot/internal/model"
+	"lark-robot/internal/repository"
+)
+
+var (
+	// ErrInvalidCredentials is returned for an unknown username or a wrong password.
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrAccountDisabled is returned when a disabled account tries to log in.
+	ErrAccountDisabled = errors.New("account is disabled")
+	// ErrInvalidAdminUser is returned when an admin user fails validation.
+	ErrInvalidAdminUser = errors.New("invalid admin user")
+	// ErrLastAdmin is returned when a change would leave no enabled admin.
+	ErrLastAdmin = errors.New("at least one enabled admin is required")
+	// ErrInvalidTOTP is returned for a wrong, expired or reused TOTP code.
+	ErrInvalidTOTP = errors.New("invalid totp code")
+)
+
+const minPasswordLength = 8
+
+// dummyHash is compared against for unknown usernames so that login takes
+// the same time whether or not the account exists.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("lark-robot"), bcrypt.DefaultCost)
+
+type AdminUserService struct {
+	repo   *repository.AdminUserRepo
+	logger *zap.Logger
+}
+
+func NewAdminUserService(repo *repository.AdminUserRepo, logger *zap.Logger) *AdminUserService {
+	return &AdminUserService{repo: repo, logger: logger}
+}
+
+// Bootstrap creates an admin from the configured credentials when there are
+// no admin users yet, so existing installs keep their login.
+func (s *AdminUserService) Bootstrap(username, password string) error {
+	count, err := s.repo.Count()
+	if err != nil || count > 0 {
+		return err
+	}
+	if username == "" || password == "" {
+		s.logger.Warn("no admin users and no auth credentials configured; nobody can log in")
+		return nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	user := &model.AdminUser{
+		Username:     username,
+		PasswordHash: string(hash),
+		Role:         model.RoleAdmin,
+		Enabled:      true,
+	}
+	if err := s.repo.Create(user); err != nil {
+		return err
+	}
+	s.logger.Info("created initial admin user from config", zap.String("username", username))
+	return nil
+}
+
+// Authenticate checks a username and password and records the login.
+func (s *AdminUserService) Authenticate(username, password string) (*model.AdminUser, error) {
+	user, err := s.repo.GetByUsername(username)
+	if err != nil {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	if !user.Enabled {
+		return nil, ErrAccountDisabled
+	}
+	now := time.Now()
+	user.LastLoginAt = &now
+	if err := s.repo.UpdateLastLogin(user.ID, now); err != nil {
+		s.logger.Warn("failed to record admin login", zap.String("username", username), zap.Error(err))
+	}
+	return user, nil
+}
+
+// GetActive returns an enabled user by username, for authenticating requests.
+func (s *AdminUserService) GetActive(username string) (*model.AdminUser, error) {
+	user, err := s.repo.GetByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	if !user.Enabled {
+		return nil, ErrAccountDisabled
+	}
+	return user, nil
+}
+
+func (s *AdminUserService) List() ([]model.AdminUser, error) {
+	return s.repo.List()
+}
+
+func (s *AdminUserService) GetByID(id uint) (*model.AdminUser, error) {
+	return s.repo.GetByID(id)
+}
+
+// Create validates and stores a new user with the given password.
+func (s *AdminUserService) Create(user *model.AdminUser, password string) error {
+	user.Username = strings.TrimSpace(user.Username)
+	if user.Username == "" {
+		return fmt.Errorf("%w: username is required", ErrInvalidAdminUser)
+	}
+	if err := s.validate(user); err != nil {
+		return err
+	}
+	if password == "" && user.LarkOpenID != "" {
+		// SSO-only account
+		hash, err := unusablePasswordHash()
+		if err != nil {
+			return err
+		}
+		user.PasswordHash = hash
+	} else if err := setPassword(user, password); err != nil {
+		return err
+	}
+	return s.repo.Create(user)
+}
+
+// Update saves changes to a user. A non-empty password replaces the current one.
+func (s *AdminUserService) Update(user *model.AdminUser, password string) error {
+	if err := s.validate(user); err != nil {
+		return err
+	}
+	if password != "" {
+		if err := setPassword(user, password); err != nil {
+			return err
+		}
+	}
+	if err := s.checkLastAdmin(user.ID, user.Role == model.RoleAdmin && user.Enabled); err != nil {
+		return err
+	}
+	return s.repo.Update(user)
+}
+
+func (s *AdminUserService) Delete(id uint) error {
+	if err := s.checkLastAdmin(id, false); err != nil {
+		return err
+	}
+	return s.repo.Delete(id)
+}
+
+// SetupTOTP generates a new TOTP secret for user and returns it with its
+// otpauth:// URL. It takes effect once confirmed with EnableTOTP; until then
+// logins do not ask for a code.
+func (s *AdminUserService) SetupTOTP(user *model.AdminUser, issuer string) (string, string, error) {
+	if user.TOTPEnabled {
+		return "", "", fmt.Errorf("%w: totp is already enabled", ErrInvalidAdminUser)
+	}
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	user.TOTPSecret = secret
+	user.TOTPLastStep = 0
+	if err := s.repo.Update(user); err != nil {
+		return "", "", err
+	}
+	return secret, totpURL(issuer, user.Username, secret), nil
+}
+
+// EnableTOTP turns on the second factor after checking a code from the
+// secret created by SetupTOTP.
+func (s *AdminUserService) EnableTOTP(user *model.AdminUser, code string) error {
+	if user.TOTPEnabled || user.TOTPSecret == "" {
+		return fmt.Errorf("%w: run totp setup first", ErrInvalidAdminUser)
+	}
+	if !s.VerifyTOTP(user, code) {
+		return ErrInvalidTOTP
+	}
+	user.TOTPEnabled = true
+	return s.repo.Update(user)
+}
+
+// DisableTOTP turns off the second factor; the user confirms with a current code.
+func (s *AdminUserService) DisableTOTP(user *model.AdminUser, code string) error {
+	if !user.TOTPEnabled {
+		return fmt.Errorf("%w: totp is not enabled", ErrInvalidAdminUser)
+	}
+	if !s.VerifyTOTP(user, code) {
+		return ErrInvalidTOTP
+	}
+	return s.ResetTOTP(user.ID)
+}
+
+// ResetTOTP removes a user's second factor, e.g. when an admin helps a user
+// who lost their device.
+func (s *AdminUserService) ResetTOTP(id uint) error {
+	user, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	return s.repo.Update(user)
+}
+
+// VerifyTOTP checks a code against the user's secret. Each code is accepted once.
+func (s *AdminUserService) VerifyTOTP(user *model.AdminUser, code string) bool {
+	step, ok := verifyTOTP(user.TOTPSecret, strings.TrimSpace(code), time.Now(), user.TOTPLastStep)
+	if !ok {
+		return false
+	}
+	// Compare-and-set, so the same code used concurrently is accepted only once
+	used, err := s.repo.UpdateTOTPStep(user.ID, user.TOTPLastStep, step)
+	if err != nil || !used {
+		return false
+	}
+	user.TOTPLastStep = step
+	return true
+}
+
+// checkLastAdmin returns ErrLastAdmin if user id is currently the only
+// enabled admin and would no longer be one.
+func (s *AdminUserService) checkLastAdmin(id uint, stillAdmin bool) error {
+	if stillAdmin {
+		return nil
+	}
+	current, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if current.Role != model.RoleAdmin || !current.Enabled {
+		return nil
+	}
+	count, err := s.repo.CountEnabledAdmins()
+	if err != nil {
+		return err
+	}
+	if count <= 1 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// EncodeChatScopes stores a list of chat_ids in the ChatScopes format.
+// An empty list means all chats.
+func EncodeChatScopes(chatIDs []string) string {
+	ids := make([]string, 0, len(chatIDs))
+	for _, id := range chatIDs {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(ids)
+	return string(data)
+}
+
+func (s *AdminUserService) validate(user *model.AdminUser) error {
+	if !model.ValidRole(user.Role) {
+		return fmt.Errorf("%w: unknown role %q", ErrInvalidAdminUser, user.Role)
+	}
+	if user.LarkOpenID != "" {
+		if other, err := s.repo.GetByLarkOpenID(user.LarkOpenID); err == nil && other.ID != user.ID {
+			return fmt.Errorf("%w: lark account is already linked to %s", ErrInvalidAdminUser, other.Username)
+		}
+	}
+	return nil
+}
+
+// unusablePasswordHash returns a hash of a random secret, for accounts that
+// only log in through SSO.
+func unusablePasswordHash() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(secret)), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+func setPassword(user *model.AdminUser, password string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("%w: password must be at least %d characters", ErrInvalidAdminUser, minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = string(hash)
+	return nil
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"lark-robot/internal/model"
+	"lark-robot/internal/repository"
+)
+
+var (
+	// ErrInvalidAPIKey is returned when an API key fails validation.
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	// ErrAPIKeyRejected is returned for unknown, revoked or expired keys.
+	ErrAPIKeyRejected = errors.New("unknown, revoked or expired api key")
+)
+
+const (
+	apiKeyPrefix = "lrk_"
+	// lastUsedInterval limits how often last_used_at is written for a busy key.
+	lastUsedInterval = time.Minute
+)
+
+var apiKeyMethods = map[string]bool{"*": true, "GET": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true}
+
+type APIKeyService struct {
+	repo   *repository.APIKeyRepo
+	logger *zap.Logger
+}
+
+func NewAPIKeyService(repo *repository.APIKeyRepo, logger *zap.Logger) *APIKeyService {
+	return &APIKeyService{repo: repo, logger: logger}
+}
+
+// Create validates and stores a key and returns its secret value, which is
+// not stored and cannot be shown again.
+func (s *APIKeyService) Create(key *model.APIKey, endpoints, chatScopes []string) (string, error) {
+	key.Name = strings.TrimSpace(key.Name)
+	if key.Name == "" {
+		return "", fmt.Errorf("%w: name is required", ErrInvalidAPIKey)
+	}
+	if key.Role != model.RoleViewer && key.Role != model.RoleOperator {
+		return "", fmt.Errorf("%w: role must be viewer or operator", ErrInvalidAPIKey)
+	}
+	if key.ExpiresAt != nil && key.ExpiresAt.Before(time.Now()) {
+		return "", fmt.Errorf("%w: expiry is in the past", ErrInvalidAPIKey)
+	}
+
+	patterns, err := normalizeEndpoints(endpoints)
+	if err != nil {
+		return "", err
+	}
+	data, _ := json.Marshal(patterns)
+	key.Endpoints = string(data)
+	key.ChatScopes = EncodeChatScopes(chatScopes)
+
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	secret := apiKeyPrefix + hex.EncodeToString(buf)
+	key.Prefix = secret[:12]
+	key.KeyHash = hashAPIKey(secret)
+	if err := s.repo.Create(key); err != nil {
+		return "", err
+	}
+	s.logger.Info("created api key", zap.String("name", key.Name), zap.String("created_by", key.CreatedBy))
+	return secret, nil
+}
+
+// Authenticate looks up a key by its secret value and records its use.
+func (s *APIKeyService) Authenticate(secret string) (*model.APIKey, error) {
+	if !strings.HasPrefix(secret, apiKeyPrefix) {
+		return nil, ErrAPIKeyRejected
+	}
+	key, err := s.repo.GetByHash(hashAPIKey(secret))
+	if err != nil || !key.Active() {
+		return nil, ErrAPIKeyRejected
+	}
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > lastUsedInterval {
+		if err := s.repo.UpdateLastUsed(key.ID, now); err != nil {
+			s.logger.Warn("failed to record api key use", zap.Uint("id", key.ID), zap.Error(err))
+		}
+		key.LastUsedAt = &now
+	}
+	return key, nil
+}
+
+func (s *APIKeyService) List() ([]model.APIKey, error) {
+	return s.repo.List()
+}
+
+func (s *APIKeyService) GetByID(id uint) (*model.APIKey, error) {
+	return s.repo.GetByID(id)
+}
+
+// Revoke disables a key. Revoked keys are kept so their history stays visible.
+func (s *APIKeyService) Revoke(id uint) error {
+	return s.repo.Revoke(id, time.Now())
+}
+
+// normalizeEndpoints checks "METHOD /api/path" patterns and upper-cases the method.
+func normalizeEndpoints(endpoints []string) ([]string, error) {
+	var patterns []string
+	for _, e := range endpoints {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		method, path := "*", e
+		if i := strings.IndexByte(e, ' '); i >= 0 {
+			method, path = strings.ToUpper(e[:i]), strings.TrimSpace(e[i+1:])
+		}
+		if !apiKeyMethods[method] || !strings.HasPrefix(path, "/api/") {
+			return nil, fmt.Errorf("%w: bad endpoint %q, want \"METHOD /api/path\"", ErrInvalidAPIKey, e)
+		}
+		patterns = append(patterns, method+" "+path)
+	}
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("%w: at least one endpoint is required", ErrInvalidAPIKey)
+	}
+	return patterns, nil
+}
+
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"go.uber.org/zap"
+
+	"lark-robot/internal/model"
+	"lark-robot/internal/repository"
+)
+
+// auditIgnoredFields change on every write and would only add noise to diffs.
+var auditIgnoredFields = map[string]bool{"updated_at": true}
+
+type AuditService struct {
+	repo   *repository.AuditRepo
+	logger *zap.Logger
+}
+
+func NewAuditService(repo *repository.AuditRepo, logger *zap.Logger) *AuditService {
+	return &AuditService{repo: repo, logger: logger}
+}
+
+// Record saves an entry, filling in Before, After and Diff from the given
+// snapshots of the target (either may be nil). Failures are logged, not
+// returned: the change has already happened.
+func (s *AuditService) Record(entry *model.AuditEntry, before, after interface{}) {
+	entry.Before, entry.After, entry.Diff = auditChange(before, after)
+	entry.UserAgent = truncate(entry.UserAgent, 500)
+	if err := s.repo.Create(entry); err != nil {
+		s.logger.Error("failed to write audit entry",
+			zap.String("actor", entry.Actor), zap.String("action", entry.Action), zap.Error(err))
+	}
+}
+
+func (s *AuditService) List(q repository.AuditQuery) ([]model.AuditEntry, int64, error) {
+	return s.repo.List(q)
+}
+
+// Each calls fn for every entry matching q, for exports.
+func (s *AuditService) Each(q repository.AuditQuery, fn func(*model.AuditEntry) error) error {
+	return s.repo.Each(q, 500, fn)
+}
+
+// auditChange marshals the snapshots and computes the fields that differ.
+// The diff is only set when both snapshots are JSON objects.
+func auditChange(before, after interface{}) (string, string, string) {
+	b, bm := auditSnapshot(before)
+	a, am := auditSnapshot(after)
+	if bm == nil || am == nil {
+		return b, a, ""
+	}
+	diff := map[string]map[string]interface{}{}
+	for k, v := range am {
+		if !auditIgnoredFields[k] && !reflect.DeepEqual(bm[k], v) {
+			diff[k] = map[string]interface{}{"from": bm[k], "to": v}
+		}
+	}
+	for k, v := range bm {
+		if _, ok := am[k]; !ok && !auditIgnoredFields[k] {
+			diff[k] = map[string]interface{}{"from": v, "to": nil}
+		}
+	}
+	data, _ := json.Marshal(diff)
+	return b, a, string(data)
+}
+
+func auditSnapshot(v interface{}) (string, map[string]interface{}) {
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil() {
+		return "", nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", nil
+	}
+	var m map[string]interface{}
+	json.Unmarshal(data, &m)
+	return string(data), m
+}
@@ -0,0 +1,631 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"lark-robot/internal/larkbot/msg"
+	"lark-robot/internal/model"
+	"lark-robot/internal/repository"
+)
+
+// Campaign statuses.
+const (
+	CampaignDraft     = "draft"
+	CampaignScheduled = "scheduled"
+	CampaignRunning   = "running"
+	CampaignPaused    = "paused"
+	CampaignCompleted = "completed"
+	CampaignCancelled = "cancelled"
+	CampaignRecalling = "recalling"
+	CampaignRecalled  = "recalled"
+)
+
+// Campaign recipient statuses.
+const (
+	RecipientPending  = "pending"
+	RecipientSent     = "sent"
+	RecipientFailed   = "failed"
+	RecipientRecalled = "recalled"
+)
+
+// Campaign target types.
+const (
+	TargetChats         = "chats"
+	TargetAllGroups     = "all_groups"
+	TargetUsers         = "users"
+	TargetDepartments   = "departments"    // known users of the departments and their sub-departments
+	TargetGroupSelector = "group_selector" // groups matching TargetSelector when the campaign starts
+)
+
+// ErrCampaignState is returned when an action is not allowed in the campaign's current status.
+var ErrCampaignState = errors.New("invalid campaign state")
+
+type CampaignService struct {
+	repo       *repository.CampaignRepo
+	groupRepo  *repository.GroupRepo
+	deptRepo   *repository.DepartmentRepo
+	msgService *MessageService
+	jobs       *JobService
+	logger     *zap.Logger
+
+	mu      sync.Mutex
+	runners map[uint]uint // campaign ID -> ID of the job sending or recalling it
+}
+
+func NewCampaignService(repo *repository.CampaignRepo, groupRepo *repository.GroupRepo, deptRepo *repository.DepartmentRepo, msgService *MessageService, jobs *JobService, logger *zap.Logger) *CampaignService {
+	return &CampaignService{
+		repo:       repo,
+		groupRepo:  groupRepo,
+		deptRepo:   deptRepo,
+		msgService: msgService,
+		jobs:       jobs,
+		logger:     logger,
+		runners:    make(map[uint]uint),
+	}
+}
+
+func (s *CampaignService) List(page, pageSize int, chatIDs []string) ([]model.Campaign, int64, error) {
+	return s.repo.List(page, pageSize, chatIDs)
+}
+
+func (s *CampaignService) GetByID(id uint) (*model.Campaign, error) {
+	return s.repo.GetByID(id)
+}
+
+func (s *CampaignService) ListRecipients(id uint, status string, page, pageSize int) ([]model.CampaignRecipient, int64, error) {
+	return s.repo.ListRecipients(id, status, page, pageSize)
+}
+
+// Create validates and stores a new campaign. Campaigns with a start time
+// are scheduled; others stay in draft until started manually.
+func (s *CampaignService) Create(c *model.Campaign) error {
+	if err := validateCampaign(c); err != nil {
+		return err
+	}
+	c.Status = CampaignDraft
+	if c.ScheduledAt != nil {
+		c.Status = CampaignScheduled
+	}
+	return s.repo.Create(c)
+}
+
+// Update modifies a campaign that has not started yet.
+func (s *CampaignService) Update(c *model.Campaign) error {
+	if c.Status != CampaignDraft && c.Status != CampaignScheduled {
+		return fmt.Errorf("%w: cannot edit a %s campaign", ErrCampaignState, c.Status)
+	}
+	if err := validateCampaign(c); err != nil {
+		return err
+	}
+	c.Status = CampaignDraft
+	if c.ScheduledAt != nil {
+		c.Status = CampaignScheduled
+	}
+	// Only write if the campaign was not started meanwhile, e.g. by DispatchDue.
+	ok, err := s.repo.UpdateFieldsInStatus(c.ID, []string{CampaignDraft, CampaignScheduled}, map[string]interface{}{
+		"name":            c.Name,
+		"msg_type":        c.MsgType,
+		"content":         c.Content,
+		"target_type":     c.TargetType,
+		"target_ids":      c.TargetIDs,
+		"target_selector": c.TargetSelector,
+		"throttle_ms":     c.ThrottleMs,
+		"scheduled_at":    c.ScheduledAt,
+		"status":          c.Status,
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: campaign was started or changed concurrently", ErrCampaignState)
+	}
+	return nil
+}
+
+// Delete removes a campaign and its recipients. Active campaigns must be cancelled first.
+func (s *CampaignService) Delete(id uint) error {
+	c, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if c.Status == CampaignRunning || c.Status == CampaignRecalling {
+		return fmt.Errorf("%w: cannot delete a %s campaign", ErrCampaignState, c.Status)
+	}
+	return s.repo.Delete(id)
+}
+
+// Start resolves the recipients of a draft or scheduled campaign and begins
+// sending in the background, as a job created by actor.
+func (s *CampaignService) Start(id uint, actor string) error {
+	c, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if c.Status != CampaignDraft && c.Status != CampaignScheduled {
+		return fmt.Errorf("%w: cannot start a %s campaign", ErrCampaignState, c.Status)
+	}
+
+	// Recipients are resolved before claiming the campaign so no transaction
+	// is held across Lark lookups; a caller that loses the claim drops them.
+	var recipients []model.CampaignRecipient
+	if existing, err := s.repo.CountRecipients(id); err != nil {
+		return err
+	} else if existing == 0 {
+		if recipients, err = s.resolveRecipients(c); err != nil {
+			return err
+		}
+	}
+
+	count, claimed, err := s.repo.Claim(id, []string{CampaignDraft, CampaignScheduled}, map[string]interface{}{
+		"status":     CampaignRunning,
+		"started_at": time.Now(),
+		"started_by": actor,
+	}, recipients)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return fmt.Errorf("%w: campaign was started or changed concurrently", ErrCampaignState)
+	}
+	s.launch(id, JobCampaignSend, actor)
+	s.logger.Info("campaign started", zap.Uint("campaign_id", id), zap.Int64("recipients", count))
+	return nil
+}
+
+// Pause stops sending after the current recipient; pending recipients are kept.
+func (s *CampaignService) Pause(id uint) error {
+	return s.transition(id, CampaignPaused, CampaignRunning)
+}
+
+// Resume continues a paused campaign, as a job created by actor.
+func (s *CampaignService) Resume(id uint, actor string) error {
+	if err := s.transition(id, CampaignRunning, CampaignPaused); err != nil {
+		return err
+	}
+	s.launch(id, JobCampaignSend, actor)
+	return nil
+}
+
+// Cancel stops a campaign for good. Recipients not yet reached stay pending.
+func (s *CampaignService) Cancel(id uint) error {
+	return s.transition(id, CampaignCancelled, CampaignDraft, CampaignScheduled, CampaignRunning, CampaignPaused)
+}
+
+// Recall stops the campaign if needed and recalls every message it has sent,
+// as a job created by actor. Recalling a recalled campaign again retries the
+// messages that failed to recall.
+func (s *CampaignService) Recall(id uint, actor string) error {
+	if err := s.transition(id, CampaignRecalling,
+		CampaignRunning, CampaignPaused, CampaignCompleted, CampaignCancelled, CampaignRecalled); err != nil {
+		return err
+	}
+	s.launch(id, JobCampaignRecall, actor)
+	return nil
+}
+
+// DispatchDue starts scheduled campaigns whose start time has passed.
+// It is registered as a periodic job with the scheduler.
+func (s *CampaignService) DispatchDue() {
+	campaigns, err := s.repo.ListDue(time.Now())
+	if err != nil {
+		s.logger.Error("failed to list due campaigns", zap.Error(err))
+		return
+	}
+	for _, c := range campaigns {
+		if err := s.Start(c.ID, "scheduler"); err != nil {
+			if errors.Is(err, ErrCampaignState) {
+				continue // started manually in the meantime
+			}
+			s.logger.Error("failed to start scheduled campaign", zap.Uint("campaign_id", c.ID), zap.Error(err))
+		}
+	}
+}
+
+// ResumeInterrupted restarts campaigns that were running or recalling when the process stopped.
+func (s *CampaignService) ResumeInterrupted() error {
+	running, err := s.repo.ListByStatus(CampaignRunning)
+	if err != nil {
+		return err
+	}
+	for _, c := range running {
+		s.launch(c.ID, JobCampaignSend, c.StartedBy)
+	}
+	recalling, err := s.repo.ListByStatus(CampaignRecalling)
+	if err != nil {
+		return err
+	}
+	for _, c := range recalling {
+		s.launch(c.ID, JobCampaignRecall, c.StartedBy)
+	}
+	if n := len(running) + len(recalling); n > 0 {
+		s.logger.Info("resumed interrupted campaigns", zap.Int("count", n))
+	}
+	return nil
+}
+
+// Stop cancels all background campaign workers. Their status is left untouched
+// so they resume on the next start.
+func (s *CampaignService) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, jobID := range s.runners {
+		s.jobs.Abort(jobID)
+		delete(s.runners, id)
+	}
+}
+
+// transition moves a campaign to status `to` if it is currently in one of `from`,
+// stopping any running worker.
+func (s *CampaignService) transition(id uint, to string, from ...string) error {
+	c, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	allowed := false
+	for _, st := range from {
+		if c.Status == st {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("%w: cannot move a %s campaign to %s", ErrCampaignState, c.Status, to)
+	}
+
+	s.stopRunner(id)
+	fields := map[string]interface{}{"status": to}
+	if to == CampaignCancelled {
+		fields["finished_at"] = time.Now()
+	}
+	// Another call may have moved the campaign since it was read, so only
+	// write if the status is still one we may leave.
+	ok, err := s.repo.UpdateFieldsInStatus(id, from, fields)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: campaign status changed concurrently", ErrCampaignState)
+	}
+	s.logger.Info("campaign status changed", zap.Uint("campaign_id", id), zap.String("from", c.Status), zap.String("to", to))
+	return nil
+}
+
+// campaignJobResult is the result of a campaign job: the campaign's
+// counters when the job ended.
+type campaignJobResult struct {
+	CampaignID    uint   `json:"campaign_id"`
+	Status        string `json:"status"`
+	SentCount     int    `json:"sent_count"`
+	FailedCount   int    `json:"failed_count"`
+	RecalledCount int    `json:"recalled_count"`
+}
+
+// launch starts sending (JobCampaignSend) or recalling (JobCampaignRecall)
+// a campaign as a background job created by actor, replacing any job already
+// working on it. Cancelling a send job pauses the campaign; a recall cannot be cancelled.
+func (s *CampaignService) launch(id uint, kind, actor string) {
+	work, cancel := s.run, func() error { return s.Pause(id) }
+	if kind == JobCampaignRecall {
+		work = s.recall
+		cancel = func() error { return fmt.Errorf("%w: a campaign recall cannot be cancelled", ErrJobState) }
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.runners[id]; ok {
+		s.jobs.Abort(prev)
+	}
+	job, err := s.jobs.Submit(JobSpec{
+		Kind:      kind,
+		Params:    map[string]uint{"campaign_id": id},
+		CreatedBy: actor,
+		Cancel:    cancel,
+	}, func(ctx context.Context, p *JobProgress) (interface{}, error) {
+		defer func() {
+			s.mu.Lock()
+			// Only remove our own entry; a newer job may have replaced it.
+			if s.runners[id] == p.ID() {
+				delete(s.runners, id)
+			}
+			s.mu.Unlock()
+		}()
+		err := work(ctx, id, p)
+		c, gerr := s.repo.GetByID(id)
+		if gerr != nil {
+			return nil, err
+		}
+		return &campaignJobResult{
+			CampaignID:    id,
+			Status:        c.Status,
+			SentCount:     c.SentCount,
+			FailedCount:   c.FailedCount,
+			RecalledCount: c.RecalledCount,
+		}, err
+	})
+	if err != nil {
+		s.logger.Error("failed to start campaign job", zap.Uint("campaign_id", id), zap.String("kind", kind), zap.Error(err))
+		return
+	}
+	s.runners[id] = job.ID
+}
+
+// stopRunner stops the job working on a campaign and waits for it to return,
+// so a send in flight is recorded before the caller moves on, e.g. to recall.
+func (s *CampaignService) stopRunner(id uint) {
+	s.mu.Lock()
+	jobID, ok := s.runners[id]
+	delete(s.runners, id)
+	s.mu.Unlock()
+	// Wait without holding mu: the job takes it when it exits.
+	if ok {
+		s.jobs.AbortAndWait(jobID)
+	}
+}
+
+// run sends the campaign to its pending recipients, honouring the throttle.
+func (s *CampaignService) run(ctx context.Context, id uint, p *JobProgress) error {
+	c, err := s.repo.GetByID(id)
+	if err != nil {
+		s.logger.Error("campaign not found", zap.Uint("campaign_id", id), zap.Error(err))
+		return err
+	}
+	throttle := time.Duration(c.ThrottleMs) * time.Millisecond
+	p.SetTotal(c.Total)
+	p.SetDone(c.SentCount + c.FailedCount)
+
+	var cursor uint
+	for {
+		batch, err := s.repo.NextRecipients(id, RecipientPending, cursor, 50)
+		if err != nil {
+			s.logger.Error("failed to load campaign recipients", zap.Uint("campaign_id", id), zap.Error(err))
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		for i := range batch {
+			if ctx.Err() != nil {
+				return nil
+			}
+			cursor = batch[i].ID
+			s.sendTo(ctx, c, &batch[i])
+			if batch[i].Status != RecipientPending {
+				p.Add(1)
+			}
+			if throttle > 0 {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(throttle):
+				}
+			}
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	// A pause, cancel or recall may land after the check above; it wins.
+	ok, err := s.repo.UpdateFieldsInStatus(id, []string{CampaignRunning}, map[string]interface{}{
+		"status":      CampaignCompleted,
+		"finished_at": time.Now(),
+	})
+	if err != nil {
+		s.logger.Error("failed to complete campaign", zap.Uint("campaign_id", id), zap.Error(err))
+		return err
+	}
+	if ok {
+		s.logger.Info("campaign completed", zap.Uint("campaign_id", id))
+	}
+	return nil
+}
+
+func (s *CampaignService) sendTo(ctx context.Context, c *model.Campaign, r *model.CampaignRecipient) {
+	sendCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	msgID, err := s.msgService.SendMessage(sendCtx, r.ReceiveID, r.ReceiveIDType, c.MsgType, c.Content, "campaign")
+	if err != nil && ctx.Err() != nil {
+		// Paused or cancelled mid-send: leave the recipient pending.
+		return
+	}
+
+	now := time.Now()
+	counter := "sent_count"
+	if err != nil {
+		r.Status = RecipientFailed
+		r.Error = err.Error()
+		counter = "failed_count"
+		s.logger.Warn("campaign send failed",
+			zap.Uint("campaign_id", c.ID),
+			zap.String("receive_id", r.ReceiveID),
+			zap.Error(err))
+	} else {
+		r.Status = RecipientSent
+		r.MessageID = msgID
+		r.Error = ""
+		r.SentAt = &now
+	}
+	if err := s.repo.UpdateRecipient(r); err != nil {
+		s.logger.Error("failed to update campaign recipient", zap.Uint("recipient_id", r.ID), zap.Error(err))
+	}
+	_ = s.repo.IncrementCounter(c.ID, counter)
+}
+
+// recall deletes every message the campaign has sent. Messages that fail to
+// recall keep their sent status with the error recorded, so a later recall can retry them.
+func (s *CampaignService) recall(ctx context.Context, id uint, p *JobProgress) error {
+	if c, err := s.repo.GetByID(id); err == nil {
+		p.SetTotal(c.SentCount - c.RecalledCount)
+	}
+	var cursor uint
+	failed := 0
+	for {
+		batch, err := s.repo.NextRecipients(id, RecipientSent, cursor, 50)
+		if err != nil {
+			s.logger.Error("failed to load campaign recipients", zap.Uint("campaign_id", id), zap.Error(err))
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		for i := range batch {
+			if ctx.Err() != nil {
+				return nil
+			}
+			r := &batch[i]
+			cursor = r.ID
+			if err := s.msgService.DeleteMessage(ctx, r.MessageID); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				s.logger.Warn("campaign recall failed",
+					zap.Uint("campaign_id", id),
+					zap.String("message_id", r.MessageID),
+					zap.Error(err))
+				r.Error = err.Error()
+				failed++
+			} else {
+				r.Status = RecipientRecalled
+				r.Error = ""
+				_ = s.repo.IncrementCounter(id, "recalled_count")
+			}
+			_ = s.repo.UpdateRecipient(r)
+			p.Add(1)
+		}
+	}
+
+	if _, err := s.repo.UpdateFieldsInStatus(id, []string{CampaignRecalling}, map[string]interface{}{
+		"status":      CampaignRecalled,
+		"finished_at": time.Now(),
+	}); err != nil {
+		s.logger.Error("failed to mark campaign recalled", zap.Uint("campaign_id", id), zap.Error(err))
+		return err
+	}
+	s.logger.Info("campaign recalled", zap.Uint("campaign_id", id), zap.Int("failed", failed))
+	if failed > 0 {
+		return fmt.Errorf("%d messages failed to recall; recall again to retry them", failed)
+	}
+	return nil
+}
+
+// resolveRecipients expands the campaign target selector into recipients.
+func (s *CampaignService) resolveRecipients(c *model.Campaign) ([]model.CampaignRecipient, error) {
+	var recipients []model.CampaignRecipient
+	seen := make(map[string]bool)
+	add := func(id, idType, name string) {
+		if id == "" || seen[id] {
+			return
+		}
+		seen[id] = true
+		recipients = append(recipients, model.CampaignRecipient{
+			CampaignID:    c.ID,
+			ReceiveID:     id,
+			ReceiveIDType: idType,
+			Name:          name,
+			Status:        RecipientPending,
+		})
+	}
+
+	switch c.TargetType {
+	case TargetAllGroups:
+		groups, err := s.groupRepo.ListAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			add(g.ChatID, "chat_id", g.Name)
+		}
+	case TargetChats:
+		ids, _ := parseTargetIDs(c.TargetIDs)
+		for _, id := range ids {
+			name := ""
+			if g, err := s.groupRepo.GetByChatID(id); err == nil {
+				name = g.Name
+			}
+			add(id, "chat_id", name)
+		}
+	case TargetGroupSelector:
+		sel, err := model.ParseGroupSelector(c.TargetSelector)
+		if err != nil {
+			return nil, err
+		}
+		groups, err := matchGroups(s.groupRepo, sel)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			add(g.ChatID, "chat_id", g.Name)
+		}
+	case TargetUsers:
+		ids, _ := parseTargetIDs(c.TargetIDs)
+		for _, id := range ids {
+			add(id, "open_id", "")
+		}
+	case TargetDepartments:
+		ids, _ := parseTargetIDs(c.TargetIDs)
+		users, err := s.deptRepo.Members(ids, true)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			add(u.OpenID, "open_id", u.Name)
+		}
+	}
+
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("campaign target matched no recipients")
+	}
+	return recipients, nil
+}
+
+func validateCampaign(c *model.Campaign) error {
+	if c.MsgType == "" {
+		c.MsgType = "text"
+	}
+	if c.ThrottleMs < 0 {
+		c.ThrottleMs = 0
+	}
+	content, err := msg.Normalize(c.MsgType, c.Content)
+	if err != nil {
+		return err
+	}
+	c.Content = content
+	switch c.TargetType {
+	case TargetAllGroups:
+		return nil
+	case TargetGroupSelector:
+		sel, err := model.ParseGroupSelector(c.TargetSelector)
+		if err != nil {
+			return fmt.Errorf("target_selector is not a valid group selector: %w", err)
+		}
+		return ValidateSelector(sel)
+	case TargetChats, TargetUsers, TargetDepartments:
+		ids, err := parseTargetIDs(c.TargetIDs)
+		if err != nil {
+			return fmt.Errorf("target_ids must be a JSON array of strings: %w", err)
+		}
+		if len(ids) == 0 {
+			return fmt.Errorf("target_ids is required for target_type %q", c.TargetType)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown target_type %q", c.TargetType)
+	}
+}
+
+func parseTargetIDs(raw string) ([]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var ids []string
+	err := json.Unmarshal([]byte(raw), &ids)
+	return ids, err
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"lark-robot/internal/broadcast"
+	"lark-robot/internal/model"
+	"lark-robot/internal/repository"
+)
+
+// newTestCampaignService returns a campaign service without a message
+// service, so only campaigns without recipients may be sent or recalled.
+func newTestCampaignService(t *testing.T) (*CampaignService, *repository.CampaignRepo) {
+	t.Helper()
+	db := newTestDB(t)
+	repo := repository.NewCampaignRepo(db)
+	jobs := NewJobService(repository.NewJobRepo(db), broadcast.NewBus(10), JobConfig{}, zap.NewNop())
+	s := NewCampaignService(repo, repository.NewGroupRepo(db), repository.NewDepartmentRepo(db), nil, jobs, zap.NewNop())
+	t.Cleanup(s.Stop)
+	return s, repo
+}
+
+func TestCampaignTransitions(t *testing.T) {
+	update := func(s *CampaignService, c *model.Campaign) error {
+		c.Name = "renamed"
+		return s.Update(c)
+	}
+	tests := []struct {
+		name       string
+		from       string
+		action     func(s *CampaignService, c *model.Campaign) error
+		wantErr    error
+		wantStatus string // after background jobs have finished; "" if deleted
+	}{
+		{"pause running", CampaignRunning, func(s *CampaignService, c *model.Campaign) error { return s.Pause(c.ID) }, nil, CampaignPaused},
+		{"pause draft", CampaignDraft, func(s *CampaignService, c *model.Campaign) error { return s.Pause(c.ID) }, ErrCampaignState, CampaignDraft},
+		{"resume paused", CampaignPaused, func(s *CampaignService, c *model.Campaign) error { return s.Resume(c.ID, "alice") }, nil, CampaignCompleted},
+		{"resume running", CampaignRunning, func(s *CampaignService, c *model.Campaign) error { return s.Resume(c.ID, "alice") }, ErrCampaignState, CampaignRunning},
+		{"cancel draft", CampaignDraft, func(s *CampaignService, c *model.Campaign) error { return s.Cancel(c.ID) }, nil, CampaignCancelled},
+		{"cancel running", CampaignRunning, func(s *CampaignService, c *model.Campaign) error { return s.Cancel(c.ID) }, nil, CampaignCancelled},
+		{"cancel completed", CampaignCompleted, func(s *CampaignService, c *model.Campaign) error { return s.Cancel(c.ID) }, ErrCampaignState, CampaignCompleted},
+		{"recall running", CampaignRunning, func(s *CampaignService, c *model.Campaign) error { return s.Recall(c.ID, "alice") }, nil, CampaignRecalled},
+		{"recall completed", CampaignCompleted, func(s *CampaignService, c *model.Campaign) error { return s.Recall(c.ID, "alice") }, nil, CampaignRecalled},
+		{"recall again", CampaignRecalled, func(s *CampaignService, c *model.Campaign) error { return s.Recall(c.ID, "alice") }, nil, CampaignRecalled},
+		{"recall draft", CampaignDraft, func(s *CampaignService, c *model.Campaign) error { return s.Recall(c.ID, "alice") }, ErrCampaignState, CampaignDraft},
+		{"recall recalling", CampaignRecalling, func(s *CampaignService, c *model.Campaign) error { return s.Recall(c.ID, "alice") }, ErrCampaignState, CampaignRecalling},
+		{"start completed", CampaignCompleted, func(s *CampaignService, c *model.Campaign) error { return s.Start(c.ID, "alice") }, ErrCampaignState, CampaignCompleted},
+		{"update draft", CampaignDraft, update, nil, CampaignDraft},
+		{"update running", CampaignRunning, update, ErrCampaignState, CampaignRunning},
+		{"update started meanwhile", CampaignRunning, func(s *CampaignService, c *model.Campaign) error {
+			c.Status = CampaignDraft // as loaded before it was started
+			return update(s, c)
+		}, ErrCampaignState, CampaignRunning},
+		{"delete completed", CampaignCompleted, func(s *CampaignService, c *model.Campaign) error { return s.Delete(c.ID) }, nil, ""},
+		{"delete recalling", CampaignRecalling, func(s *CampaignService, c *model.Campaign) error { return s.Delete(c.ID) }, ErrCampaignState, CampaignRecalling},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, repo := newTestCampaignService(t)
+			c := &model.Campaign{
+				Name:       "test",
+				MsgType:    "text",
+				Content:    `{"text":"hi"}`,
+				TargetType: TargetChats,
+				TargetIDs:  `["oc_1"]`,
+				Status:     tt.from,
+			}
+			if err := repo.Create(c); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+
+			if err := tt.action(s, c); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				got, err := repo.GetByID(c.ID)
+				status := got.Status
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					status = ""
+				} else if err != nil {
+					t.Fatalf("GetByID: %v", err)
+				}
+				if status == tt.wantStatus {
+					return
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("status = %q, want %q", status, tt.wantStatus)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
@@ -2,38 +2,86 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
 
+	"lark-robot/internal/broadcast"
 	"lark-robot/internal/larkbot"
 	"lark-robot/internal/model"
 	"lark-robot/internal/repository"
 )
 
+// Group history actions.
+const (
+	GroupJoined  = "joined"  // first seen, or seen again after leaving
+	GroupLeft    = "left"    // the bot was made to leave from the console
+	GroupRemoved = "removed" // a sync found the bot no longer in the group
+)
+
+// Limits on locally managed group metadata.
+const (
+	maxGroupTags       = 20
+	maxGroupTagLen     = 50
+	maxGroupAttributes = 50
+	maxGroupAttrKeyLen = 50
+	maxGroupAttrValLen = 500
+)
+
+// ErrInvalidGroupMeta is returned for group metadata or selectors that fail validation.
+var ErrInvalidGroupMeta = errors.New("invalid group metadata")
+
 type ChatService struct {
 	larkClient *larkbot.LarkClient
 	repo       *repository.GroupRepo
+	bus        *broadcast.Bus
 	logger     *zap.Logger
 }
 
-func NewChatService(larkClient *larkbot.LarkClient, repo *repository.GroupRepo, logger *zap.Logger) *ChatService {
+func NewChatService(larkClient *larkbot.LarkClient, repo *repository.GroupRepo, bus *broadcast.Bus, logger *zap.Logger) *ChatService {
 	return &ChatService{
 		larkClient: larkClient,
 		repo:       repo,
+		bus:        bus,
 		logger:     logger,
 	}
 }
 
-// SyncChats fetches all joined chats from Lark API and syncs to local database.
-func (s *ChatService) SyncChats(ctx context.Context) ([]model.Group, error) {
+// ChatSyncResult summarizes a group sync.
+type ChatSyncResult struct {
+	Total  int `json:"total"`  // groups the bot is in
+	Synced int `json:"synced"` // groups saved
+}
+
+// SyncChats fetches all joined chats from Lark API and syncs to local
+// database, reporting to p when run as a job. Groups are only removed when
+// the sync runs to the end.
+func (s *ChatService) SyncChats(ctx context.Context, p *JobProgress) (*ChatSyncResult, error) {
 	chats, err := s.larkClient.ListChats(ctx)
 	if err != nil {
 		return nil, err
 	}
+	result := &ChatSyncResult{Total: len(chats)}
+	p.SetTotal(len(chats))
+
+	known := make(map[string]bool)
+	if groups, err := s.repo.ListAll(); err == nil {
+		for _, g := range groups {
+			known[g.ChatID] = true
+		}
+	}
 
 	var chatIDs []string
 	for _, chat := range chats {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		p.Add(1)
 		group := &model.Group{
 			ChatID:      chat.ChatID,
 			Name:        chat.Name,
@@ -59,35 +107,254 @@ func (s *ChatService) SyncChats(ctx context.Context) ([]model.Group, error) {
 			continue
 		}
 		chatIDs = append(chatIDs, chat.ChatID)
+		if !known[chat.ChatID] {
+			s.addHistory(chat.ChatID, group.Name, GroupJoined, "")
+		}
 	}
 
-	// Remove groups that the bot is no longer in
-	if err := s.repo.DeleteNotIn(chatIDs); err != nil {
-		s.logger.Error("failed to clean stale groups", zap.Error(err))
+	result.Synced = len(chatIDs)
+
+	// Mark groups that the bot is no longer in as left, keeping their metadata
+	removed, err := s.repo.MarkLeftNotIn(chatIDs, time.Now())
+	if err != nil {
+		s.logger.Error("failed to mark stale groups as left", zap.Error(err))
+	}
+	for _, g := range removed {
+		s.addHistory(g.ChatID, g.Name, GroupRemoved, "")
+		s.bus.Publish(broadcast.TopicGroupChange, g.ChatID, broadcast.GroupChangeEvent{ChatID: g.ChatID, Name: g.Name, Action: GroupRemoved})
 	}
 
 	s.logger.Info("synced chats", zap.Int("count", len(chats)))
-	groups, _, err := s.repo.List(1, 1000)
-	return groups, err
+	s.bus.Publish(broadcast.TopicGroupChange, "", broadcast.GroupChangeEvent{Action: "synced", Count: len(chatIDs)})
+	return result, nil
 }
 
 // ListGroups returns cached groups from the database with pagination.
-func (s *ChatService) ListGroups(page, pageSize int) ([]model.Group, int64, error) {
-	return s.repo.List(page, pageSize)
+func (s *ChatService) ListGroups(q repository.GroupQuery) ([]model.Group, int64, error) {
+	return s.repo.List(q)
+}
+
+// GetGroup returns a cached group by chat_id, including one the bot has left.
+func (s *ChatService) GetGroup(chatID string) (*model.Group, error) {
+	return s.repo.GetByChatID(chatID)
+}
+
+// History returns when the bot joined and left a group, newest first.
+func (s *ChatService) History(chatID string) ([]model.GroupHistory, error) {
+	return s.repo.ListHistory(chatID)
 }
 
-// LeaveChat makes the bot leave a chat and removes it from local database.
-func (s *ChatService) LeaveChat(ctx context.Context, chatID string) error {
+// GroupMeta is the locally managed part of a group.
+type GroupMeta struct {
+	Tags        []string          `json:"tags"`
+	Team        string            `json:"team"`
+	Environment string            `json:"environment"`
+	Notes       string            `json:"notes"`
+	Attributes  map[string]string `json:"attributes"`
+}
+
+// UpdateMeta replaces the locally managed fields of a group the bot is in.
+// They are kept across syncs and while the bot is out of the group.
+func (s *ChatService) UpdateMeta(chatID string, meta GroupMeta) (*model.Group, error) {
+	tags, err := normalizeTags(meta.Tags)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := normalizeAttributes(meta.Attributes)
+	if err != nil {
+		return nil, err
+	}
+	team := strings.TrimSpace(meta.Team)
+	env := strings.TrimSpace(meta.Environment)
+	if len(team) > 100 || len(env) > 20 {
+		return nil, fmt.Errorf("%w: team or environment is too long", ErrInvalidGroupMeta)
+	}
+
+	fields := map[string]interface{}{
+		"tags":        "",
+		"team":        team,
+		"environment": env,
+		"notes":       strings.TrimSpace(meta.Notes),
+		"attributes":  "",
+	}
+	if len(tags) > 0 {
+		b, _ := json.Marshal(tags)
+		fields["tags"] = string(b)
+	}
+	if len(attrs) > 0 {
+		b, _ := json.Marshal(attrs)
+		fields["attributes"] = string(b)
+	}
+	if err := s.repo.UpdateMeta(chatID, fields); err != nil {
+		return nil, err
+	}
+	group, err := s.repo.GetByChatID(chatID)
+	if err != nil {
+		return nil, err
+	}
+	s.bus.Publish(broadcast.TopicGroupChange, chatID, broadcast.GroupChangeEvent{ChatID: chatID, Name: group.Name, Action: "updated"})
+	return group, nil
+}
+
+// ValidateSelector checks a selector before it is stored on a task, rule or campaign.
+func ValidateSelector(sel *model.GroupSelector) error {
+	if sel.IsEmpty() {
+		return fmt.Errorf("%w: group selector sets no condition", ErrInvalidGroupMeta)
+	}
+	return nil
+}
+
+// MatchGroups returns the groups the bot is in that sel selects.
+func (s *ChatService) MatchGroups(sel *model.GroupSelector) ([]model.Group, error) {
+	return matchGroups(s.repo, sel)
+}
+
+// ResolveSelector returns the chat_ids of the groups a stored selector picks.
+func (s *ChatService) ResolveSelector(raw string) ([]string, error) {
+	sel, err := model.ParseGroupSelector(raw)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := s.MatchGroups(sel)
+	if err != nil {
+		return nil, err
+	}
+	chatIDs := make([]string, len(groups))
+	for i, g := range groups {
+		chatIDs[i] = g.ChatID
+	}
+	return chatIDs, nil
+}
+
+// matchGroups is MatchGroups for services that only hold the repository.
+func matchGroups(repo *repository.GroupRepo, sel *model.GroupSelector) ([]model.Group, error) {
+	groups, err := repo.ListAll()
+	if err != nil {
+		return nil, err
+	}
+	matched := []model.Group{}
+	for i := range groups {
+		if sel.Matches(&groups[i]) {
+			matched = append(matched, groups[i])
+		}
+	}
+	return matched, nil
+}
+
+// GroupLabels lists the metadata values in use, for building selectors.
+type GroupLabels struct {
+	Tags          []string `json:"tags"`
+	Teams         []string `json:"teams"`
+	Environments  []string `json:"environments"`
+	AttributeKeys []string `json:"attribute_keys"`
+}
+
+// Labels collects the tags, teams, environments and attribute keys of the
+// groups the bot is in.
+func (s *ChatService) Labels() (*GroupLabels, error) {
+	groups, err := s.repo.ListAll()
+	if err != nil {
+		return nil, err
+	}
+	tags, teams, envs, keys := map[string]bool{}, map[string]bool{}, map[string]bool{}, map[string]bool{}
+	for i := range groups {
+		g := &groups[i]
+		for _, t := range g.TagList() {
+			tags[t] = true
+		}
+		for k := range g.AttributeMap() {
+			keys[k] = true
+		}
+		if g.Team != "" {
+			teams[g.Team] = true
+		}
+		if g.Environment != "" {
+			envs[g.Environment] = true
+		}
+	}
+	return &GroupLabels{
+		Tags:          sortedKeys(tags),
+		Teams:         sortedKeys(teams),
+		Environments:  sortedKeys(envs),
+		AttributeKeys: sortedKeys(keys),
+	}, nil
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// normalizeTags trims tags and drops blanks and duplicates.
+func normalizeTags(in []string) ([]string, error) {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, t := range in {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		if len([]rune(t)) > maxGroupTagLen {
+			return nil, fmt.Errorf("%w: tag %q is longer than %d characters", ErrInvalidGroupMeta, t, maxGroupTagLen)
+		}
+		seen[t] = true
+		tags = append(tags, t)
+	}
+	if len(tags) > maxGroupTags {
+		return nil, fmt.Errorf("%w: at most %d tags", ErrInvalidGroupMeta, maxGroupTags)
+	}
+	return tags, nil
+}
+
+// normalizeAttributes trims attribute keys and drops blank ones.
+func normalizeAttributes(in map[string]string) (map[string]string, error) {
+	attrs := make(map[string]string)
+	for k, v := range in {
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+		if len([]rune(k)) > maxGroupAttrKeyLen || len([]rune(v)) > maxGroupAttrValLen {
+			return nil, fmt.Errorf("%w: attribute %q is too long", ErrInvalidGroupMeta, k)
+		}
+		attrs[k] = strings.TrimSpace(v)
+	}
+	if len(attrs) > maxGroupAttributes {
+		return nil, fmt.Errorf("%w: at most %d attributes", ErrInvalidGroupMeta, maxGroupAttributes)
+	}
+	return attrs, nil
+}
+
+// LeaveChat makes the bot leave a chat and marks it as left locally, keeping
+// its metadata. If the bot is already out of the chat, only the local record
+// is updated. actor is recorded in the group's history.
+func (s *ChatService) LeaveChat(ctx context.Context, chatID, actor string) error {
 	if err := s.larkClient.LeaveChat(ctx, chatID); err != nil {
+		if !larkbot.IsNotInChat(err) {
+			return err
+		}
+		s.logger.Info("bot already left chat", zap.String("chat_id", chatID))
+	}
+	name := ""
+	if g, err := s.repo.GetByChatID(chatID); err == nil {
+		name = g.Name
+	}
+	if err := s.repo.MarkLeft(chatID, time.Now()); err != nil {
 		return err
 	}
-	return s.repo.DeleteByChatID(chatID)
+	s.addHistory(chatID, name, GroupLeft, actor)
+	s.bus.Publish(broadcast.TopicGroupChange, chatID, broadcast.GroupChangeEvent{ChatID: chatID, Action: "left"})
+	return nil
 }
 
-// AutoSyncGroup checks if a group exists locally, if not fetches its info and saves it.
+// AutoSyncGroup checks if a group exists locally, if not fetches its info and
+// saves it. A group the bot had left is restored with its metadata.
 func (s *ChatService) AutoSyncGroup(ctx context.Context, chatID string) {
-	_, err := s.repo.GetByChatID(chatID)
-	if err == nil {
+	if g, err := s.repo.GetByChatID(chatID); err == nil && g.LeftAt == nil {
 		return // already synced
 	}
 	chatInfo, err := s.larkClient.GetChatInfo(ctx, chatID)
@@ -95,7 +362,7 @@ func (s *ChatService) AutoSyncGroup(ctx context.Context, chatID string) {
 		s.logger.Debug("auto-sync group failed", zap.String("chat_id", chatID), zap.Error(err))
 		return
 	}
-	_ = s.repo.Upsert(&model.Group{
+	if err := s.repo.Upsert(&model.Group{
 		ChatID:      chatInfo.ChatID,
 		Name:        chatInfo.Name,
 		Avatar:      chatInfo.Avatar,
@@ -108,8 +375,13 @@ func (s *ChatService) AutoSyncGroup(ctx context.Context, chatID string) {
 		BotCount:    chatInfo.BotCount,
 		External:    chatInfo.External,
 		SyncedAt:    time.Now(),
-	})
+	}); err != nil {
+		s.logger.Error("failed to save auto-synced group", zap.String("chat_id", chatID), zap.Error(err))
+		return
+	}
+	s.addHistory(chatID, chatInfo.Name, GroupJoined, "")
 	s.logger.Info("auto-synced group", zap.String("chat_id", chatID), zap.String("name", chatInfo.Name))
+	s.bus.Publish(broadcast.TopicGroupChange, chatID, broadcast.GroupChangeEvent{ChatID: chatID, Name: chatInfo.Name, Action: "joined"})
 }
 
 // GetChatMembersPage returns one page of members for a specific chat.
@@ -121,3 +393,10 @@ func (s *ChatService) GetChatMembersPage(ctx context.Context, chatID, pageToken
 func (s *ChatService) GroupCount() (int64, error) {
 	return s.repo.Count()
 }
+
+func (s *ChatService) addHistory(chatID, name, action, actor string) {
+	h := &model.GroupHistory{ChatID: chatID, Name: name, Action: action, Actor: actor}
+	if err := s.repo.AddHistory(h); err != nil {
+		s.logger.Error("failed to record group history", zap.String("chat_id", chatID), zap.String("action", action), zap.Error(err))
+	}
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"lark-robot/internal/broadcast"
+	"lark-robot/internal/larkbot"
+	"lark-robot/internal/model"
+	"lark-robot/internal/repository"
+)
+
+// User change actions, as published in broadcast.UserChangeEvent.
+const (
+	UserChangeUpdated     = "updated"
+	UserChangeDeactivated = "deactivated"
+	UserChangeReactivated = "reactivated"
+	UserChangeLeft        = "left"
+	UserChangeRejoined    = "rejoined"
+)
+
+// ContactService applies Lark contact events to the local user directory.
+// Only users already known locally are tracked.
+type ContactService struct {
+	users    *UserService
+	userRepo *repository.UserRepo
+	logRepo  *repository.MessageLogRepo
+	tasks    *SchedulerService
+	rules    *ReplyService
+	bus      *broadcast.Bus
+	logger   *zap.Logger
+
+	// disableTargets turns off the tasks and rules aimed at users who leave
+	// or are suspended
+	disableTargets bool
+}
+
+func NewContactService(users *UserService, userRepo *repository.UserRepo, logRepo *repository.MessageLogRepo, tasks *SchedulerService, rules *ReplyService, bus *broadcast.Bus, disableTargets bool, logger *zap.Logger) *ContactService {
+	return &ContactService{
+		users:          users,
+		userRepo:       userRepo,
+		logRepo:        logRepo,
+		tasks:          tasks,
+		rules:          rules,
+		bus:            bus,
+		logger:         logger,
+		disableTargets: disableTargets,
+	}
+}
+
+// OnUserUpdated re-reads a changed user from Lark. Suspended and resigned
+// users are detected from the refreshed status.
+func (s *ContactService) OnUserUpdated(ctx context.Context, openID string) error {
+	s.users.InvalidateUser(openID)
+	prev, err := s.userRepo.GetIncludingLeft(openID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	user, err := s.users.syncUser(ctx, openID, true)
+	if larkbot.IsNotFound(err) {
+		return s.OnUserDeleted(ctx, openID)
+	}
+	if err != nil {
+		return err
+	}
+
+	action := UserChangeUpdated
+	switch {
+	case user.LeftAt != nil && prev.LeftAt == nil:
+		action = UserChangeLeft
+	case user.LeftAt == nil && prev.LeftAt != nil:
+		action = UserChangeRejoined
+	case user.Frozen && !prev.Frozen:
+		action = UserChangeDeactivated
+	case !user.Frozen && prev.Frozen:
+		action = UserChangeReactivated
+	}
+	s.changed(user, action)
+	return nil
+}
+
+// OnUserDeleted marks a user who was removed from the organization as left.
+func (s *ContactService) OnUserDeleted(ctx context.Context, openID string) error {
+	marked, err := s.users.MarkLeft(openID, time.Now())
+	if err != nil || !marked {
+		return err
+	}
+	user, err := s.userRepo.GetIncludingLeft(openID)
+	if err != nil {
+		return err
+	}
+	s.changed(user, UserChangeLeft)
+	return nil
+}
+
+// changed disables the user's tasks and rules if configured, and publishes
+// the change.
+func (s *ContactService) changed(user *model.User, action string) {
+	event := broadcast.UserChangeEvent{OpenID: user.OpenID, Name: user.Name, Action: action}
+	if s.disableTargets && (action == UserChangeLeft || action == UserChangeDeactivated) {
+		event.DisabledTasks, event.DisabledRules = s.disableTargetsOf(user.OpenID)
+	}
+	s.logger.Info("contact user changed",
+		zap.String("open_id", user.OpenID),
+		zap.String("action", action),
+		zap.Uints("disabled_tasks", event.DisabledTasks),
+		zap.Uints("disabled_rules", event.DisabledRules))
+	s.bus.Publish(broadcast.TopicUserChange, "", event)
+}
+
+// disableTargetsOf disables the scheduled tasks sending to the user's direct
+// chats and the auto-reply rules limited to them or requiring the user to be
+// mentioned.
+func (s *ContactService) disableTargetsOf(openID string) (taskIDs, ruleIDs []uint) {
+	chatIDs, err := s.logRepo.P2PChatIDs(openID)
+	if err != nil {
+		s.logger.Warn("failed to find user chats", zap.String("open_id", openID), zap.Error(err))
+		return nil, nil
+	}
+	tasks, err := s.tasks.DisableForChats(chatIDs)
+	if err != nil {
+		s.logger.Warn("failed to disable user tasks", zap.String("open_id", openID), zap.Error(err))
+	}
+	for _, t := range tasks {
+		taskIDs = append(taskIDs, t.ID)
+	}
+	rules, err := s.rules.DisableForUser(openID, chatIDs)
+	if err != nil {
+		s.logger.Warn("failed to disable user rules", zap.String("open_id", openID), zap.Error(err))
+	}
+	for _, r := range rules {
+		ruleIDs = append(ruleIDs, r.ID)
+	}
+	return taskIDs, ruleIDs
+}
@@ -0,0 +1,255 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"lark-robot/internal/larkbot"
+	"lark-robot/internal/model"
+	"lark-robot/internal/repository"
+)
+
+// DepartmentService keeps a local copy of the organization chart, used to
+// show department names, filter users and target broadcasts.
+type DepartmentService struct {
+	larkClient *larkbot.LarkClient
+	repo       *repository.DepartmentRepo
+	logger     *zap.Logger
+
+	// syncMu serializes full syncs
+	syncMu sync.Mutex
+}
+
+func NewDepartmentService(larkClient *larkbot.LarkClient, repo *repository.DepartmentRepo, logger *zap.Logger) *DepartmentService {
+	return &DepartmentService{larkClient: larkClient, repo: repo, logger: logger}
+}
+
+// DepartmentNode is a department with its sub-departments.
+type DepartmentNode struct {
+	model.Department
+	UserCount int64             `json:"user_count"` // known users directly in the department
+	Children  []*DepartmentNode `json:"children"`
+}
+
+// DepartmentSyncResult summarizes a full department sync.
+type DepartmentSyncResult struct {
+	Total   int   `json:"total"`
+	Removed int64 `json:"removed"`
+}
+
+// Sync fetches the whole department tree from Lark, replaces the local copy
+// and removes departments that no longer exist.
+func (s *DepartmentService) Sync(ctx context.Context) (*DepartmentSyncResult, error) {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+
+	start := time.Now()
+	infos, err := s.larkClient.ListDepartments(ctx, larkbot.RootDepartmentID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	parents := make(map[string]string, len(infos))
+	for _, info := range infos {
+		if !info.Deleted {
+			parents[info.OpenDepartmentID] = info.ParentID
+		}
+	}
+	result := &DepartmentSyncResult{}
+	for _, info := range infos {
+		if info.Deleted {
+			continue
+		}
+		if err := s.repo.Upsert(infoToDepartment(info, departmentPath(info.OpenDepartmentID, parents), start)); err != nil {
+			return nil, err
+		}
+		result.Total++
+	}
+
+	removed, err := s.repo.DeleteNotSyncedSince(start)
+	if err != nil {
+		return nil, err
+	}
+	result.Removed = removed
+	if linked, err := s.repo.BackfillMemberships(); err != nil {
+		s.logger.Warn("failed to backfill user departments", zap.Error(err))
+	} else if linked > 0 {
+		s.logger.Info("linked users to departments", zap.Int("users", linked))
+	}
+	s.logger.Info("department sync completed", zap.Int("total", result.Total), zap.Int64("removed", removed))
+	return result, nil
+}
+
+// List returns all departments.
+func (s *DepartmentService) List() ([]model.Department, error) {
+	return s.repo.List()
+}
+
+// Tree returns the departments as a forest of top-level departments.
+// Departments whose parent is unknown are shown at the top level.
+func (s *DepartmentService) Tree() ([]*DepartmentNode, error) {
+	depts, err := s.repo.List()
+	if err != nil {
+		return nil, err
+	}
+	counts, err := s.repo.CountMembers()
+	if err != nil {
+		return nil, err
+	}
+	nodes := make(map[string]*DepartmentNode, len(depts))
+	for _, d := range depts {
+		nodes[d.OpenDepartmentID] = &DepartmentNode{Department: d, UserCount: counts[d.OpenDepartmentID], Children: []*DepartmentNode{}}
+	}
+	roots := []*DepartmentNode{}
+	// depts is sorted, so children keep the display order
+	for _, d := range depts {
+		node := nodes[d.OpenDepartmentID]
+		if parent, ok := nodes[d.ParentID]; ok && d.ParentID != d.OpenDepartmentID {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots, nil
+}
+
+// Get returns a department by open_department_id.
+func (s *DepartmentService) Get(openDepartmentID string) (*model.Department, error) {
+	return s.repo.GetByOpenID(openDepartmentID)
+}
+
+// Ancestors returns the departments above dept, from the top level down.
+func (s *DepartmentService) Ancestors(dept *model.Department) ([]model.Department, error) {
+	ids := strings.Split(strings.Trim(dept.Path, "/"), "/")
+	if len(ids) <= 1 {
+		return []model.Department{}, nil
+	}
+	ids = ids[:len(ids)-1]
+	found, err := s.repo.GetByOpenIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]model.Department, len(found))
+	for _, d := range found {
+		byID[d.OpenDepartmentID] = d
+	}
+	ancestors := make([]model.Department, 0, len(ids))
+	for _, id := range ids {
+		if d, ok := byID[id]; ok {
+			ancestors = append(ancestors, d)
+		}
+	}
+	return ancestors, nil
+}
+
+// Members returns the known users in the given departments, including
+// sub-departments when recursive is set.
+func (s *DepartmentService) Members(deptIDs []string, recursive bool) ([]model.User, error) {
+	return s.repo.Members(deptIDs, recursive)
+}
+
+// Names resolves department IDs to names, from the local copy or, for
+// departments not synced yet, from Lark. Unknown IDs resolve to themselves.
+func (s *DepartmentService) Names(ctx context.Context, deptIDs []string) []string {
+	known, err := s.repo.GetByOpenIDs(deptIDs)
+	if err != nil {
+		s.logger.Warn("failed to load departments", zap.Error(err))
+	}
+	byID := make(map[string]string, len(known))
+	for _, d := range known {
+		byID[d.OpenDepartmentID] = d.Name
+	}
+	names := make([]string, 0, len(deptIDs))
+	for _, id := range deptIDs {
+		name, ok := byID[id]
+		if !ok {
+			name = s.fetch(ctx, id)
+			byID[id] = name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// fetch loads a single department from Lark into the local copy and returns
+// its name, or the ID if it cannot be loaded.
+func (s *DepartmentService) fetch(ctx context.Context, deptID string) string {
+	if deptID == "" || deptID == larkbot.RootDepartmentID {
+		return deptID
+	}
+	info, err := s.larkClient.GetDepartment(ctx, deptID)
+	if err != nil {
+		s.logger.Debug("failed to get department from Lark API", zap.String("department_id", deptID), zap.Error(err))
+		return deptID
+	}
+	path := "/" + deptID + "/"
+	if parent, err := s.repo.GetByOpenID(info.ParentID); err == nil {
+		path = parent.Path + deptID + "/"
+	}
+	// A zero SyncedAt marks it as not part of a full sync; the next one
+	// replaces or removes it
+	if err := s.repo.Upsert(infoToDepartment(*info, path, time.Time{})); err != nil {
+		s.logger.Warn("failed to save department", zap.String("department_id", deptID), zap.Error(err))
+	}
+	if info.Name == "" {
+		return deptID
+	}
+	return info.Name
+}
+
+// SetUserDepartments records the departments of a user, given as the JSON
+// array stored in User.DepartmentIDs.
+func (s *DepartmentService) SetUserDepartments(openID, deptIDsJSON string) {
+	var ids []string
+	if deptIDsJSON != "" {
+		if err := json.Unmarshal([]byte(deptIDsJSON), &ids); err != nil {
+			return
+		}
+	}
+	if err := s.repo.SetUserDepartments(openID, ids); err != nil {
+		s.logger.Warn("failed to save user departments", zap.String("open_id", openID), zap.Error(err))
+	}
+}
+
+// departmentPath builds "/<top>/.../<id>/" by walking up parents. Cycles
+// and unknown parents end the walk.
+func departmentPath(id string, parents map[string]string) string {
+	chain := []string{id}
+	seen := map[string]bool{id: true}
+	for cur := parents[id]; cur != "" && cur != larkbot.RootDepartmentID && !seen[cur]; cur = parents[cur] {
+		chain = append(chain, cur)
+		seen[cur] = true
+		if _, ok := parents[cur]; !ok {
+			break
+		}
+	}
+	var b strings.Builder
+	b.WriteString("/")
+	for i := len(chain) - 1; i >= 0; i-- {
+		b.WriteString(chain[i])
+		b.WriteString("/")
+	}
+	return b.String()
+}
+
+func infoToDepartment(info larkbot.DepartmentInfo, path string, syncedAt time.Time) *model.Department {
+	order, _ := strconv.ParseInt(info.Order, 10, 64)
+	return &model.Department{
+		OpenDepartmentID: info.OpenDepartmentID,
+		DepartmentID:     info.DepartmentID,
+		ParentID:         info.ParentID,
+		Name:             info.Name,
+		LeaderUserID:     info.LeaderUserID,
+		ChatID:           info.ChatID,
+		MemberCount:      info.MemberCount,
+		SortOrder:        order,
+		Path:             path,
+		SyncedAt:         syncedAt,
+	}
+}
@@ -0,0 +1,435 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"lark-robot/internal/broadcast"
+	"lark-robot/internal/larkbot"
+	"lark-robot/internal/model"
+	"lark-robot/internal/repository"
+)
+
+// Directory import statuses.
+const (
+	ImportRunning   = "running"
+	ImportPaused    = "paused"
+	ImportCompleted = "completed"
+	ImportFailed    = "failed"
+)
+
+// maxImportFailedIDs caps the failed open_ids kept on an import; the Failed
+// counter still counts all of them.
+const maxImportFailedIDs = 1000
+
+// ErrImportState is returned when an action is not allowed in the import's current status.
+var ErrImportState = errors.New("invalid import state")
+
+// DirectoryImportService imports every user of the Lark directory, not only
+// those who have messaged the bot. It walks the departments one page of
+// users at a time and saves its position after each page, so a paused,
+// failed or interrupted import continues where it stopped.
+type DirectoryImportService struct {
+	larkClient  *larkbot.LarkClient
+	repo        *repository.DirectoryImportRepo
+	userRepo    *repository.UserRepo
+	users       *UserService
+	departments *DepartmentService
+	jobs        *JobService
+	bus         *broadcast.Bus
+	logger      *zap.Logger
+
+	mu      sync.Mutex
+	runners map[uint]uint // import ID -> ID of the job running it
+}
+
+func NewDirectoryImportService(larkClient *larkbot.LarkClient, repo *repository.DirectoryImportRepo, userRepo *repository.UserRepo, users *UserService, departments *DepartmentService, jobs *JobService, bus *broadcast.Bus, logger *zap.Logger) *DirectoryImportService {
+	return &DirectoryImportService{
+		larkClient:  larkClient,
+		repo:        repo,
+		userRepo:    userRepo,
+		users:       users,
+		departments: departments,
+		jobs:        jobs,
+		bus:         bus,
+		logger:      logger,
+		runners:     make(map[uint]uint),
+	}
+}
+
+// Get returns an import by ID.
+func (s *DirectoryImportService) Get(id uint) (*model.DirectoryImport, error) {
+	return s.repo.GetByID(id)
+}
+
+// Latest returns the most recent import.
+func (s *DirectoryImportService) Latest() (*model.DirectoryImport, error) {
+	return s.repo.Latest()
+}
+
+// Start begins a new import. Only one import may run at a time.
+func (s *DirectoryImportService) Start(startedBy string) (*model.DirectoryImport, error) {
+	running, err := s.repo.ListByStatus(ImportRunning)
+	if err != nil {
+		return nil, err
+	}
+	if len(running) > 0 {
+		return nil, fmt.Errorf("%w: import %d is already running", ErrImportState, running[0].ID)
+	}
+	imp := &model.DirectoryImport{
+		Status:    ImportRunning,
+		StartedBy: startedBy,
+		StartedAt: time.Now(),
+	}
+	if err := s.repo.Create(imp); err != nil {
+		return nil, err
+	}
+	s.launch(imp)
+	s.logger.Info("directory import started", zap.Uint("import_id", imp.ID), zap.String("started_by", startedBy))
+	return imp, nil
+}
+
+// Pause stops the import after the current page; it can be resumed later.
+func (s *DirectoryImportService) Pause(id uint) (*model.DirectoryImport, error) {
+	return s.transition(id, ImportPaused, ImportRunning)
+}
+
+// Resume continues a paused or failed import from its last saved page.
+func (s *DirectoryImportService) Resume(id uint) (*model.DirectoryImport, error) {
+	running, err := s.repo.ListByStatus(ImportRunning)
+	if err != nil {
+		return nil, err
+	}
+	if len(running) > 0 {
+		return nil, fmt.Errorf("%w: import %d is already running", ErrImportState, running[0].ID)
+	}
+	imp, err := s.transition(id, ImportRunning, ImportPaused, ImportFailed)
+	if err != nil {
+		return nil, err
+	}
+	s.launch(imp)
+	return imp, nil
+}
+
+// ResumeInterrupted restarts imports that were running when the process stopped.
+func (s *DirectoryImportService) ResumeInterrupted() error {
+	running, err := s.repo.ListByStatus(ImportRunning)
+	if err != nil {
+		return err
+	}
+	for i := range running {
+		s.launch(&running[i])
+	}
+	if len(running) > 0 {
+		s.logger.Info("resumed interrupted directory imports", zap.Int("count", len(running)))
+	}
+	return nil
+}
+
+// Stop cancels the running import. Its status is left untouched so it
+// resumes on the next start.
+func (s *DirectoryImportService) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, jobID := range s.runners {
+		s.jobs.Abort(jobID)
+		delete(s.runners, id)
+	}
+}
+
+// transition moves an import to status `to` if it is currently in one of
+// `from`, stopping its worker.
+func (s *DirectoryImportService) transition(id uint, to string, from ...string) (*model.DirectoryImport, error) {
+	imp, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	allowed := false
+	for _, st := range from {
+		if imp.Status == st {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%w: cannot move a %s import to %s", ErrImportState, imp.Status, to)
+	}
+
+	s.stopRunner(id)
+	fields := map[string]interface{}{"status": to}
+	if to == ImportRunning {
+		fields["error"] = ""
+		fields["finished_at"] = nil
+	}
+	// The worker is aborted but not waited for, so only write if the status
+	// is still one we may leave.
+	ok, err := s.repo.UpdateFieldsInStatus(id, from, fields)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: import status changed concurrently", ErrImportState)
+	}
+	s.logger.Info("directory import status changed", zap.Uint("import_id", id), zap.String("from", imp.Status), zap.String("to", to))
+	imp, err = s.repo.GetByID(id)
+	if err == nil {
+		s.publish(imp)
+	}
+	return imp, err
+}
+
+// launch runs an import as a background job. Cancelling the job pauses the
+// import.
+func (s *DirectoryImportService) launch(imp *model.DirectoryImport) {
+	id := imp.ID
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.runners[id]; ok {
+		s.jobs.Abort(prev)
+	}
+	job, err := s.jobs.Submit(JobSpec{
+		Kind:      JobDirectoryImport,
+		CreatedBy: imp.StartedBy,
+		Params:    map[string]uint{"import_id": id},
+		Cancel: func() error {
+			_, err := s.Pause(id)
+			return err
+		},
+	}, func(ctx context.Context, p *JobProgress) (interface{}, error) {
+		defer func() {
+			s.mu.Lock()
+			// Only remove our own entry; a newer job may have replaced it.
+			if s.runners[id] == p.ID() {
+				delete(s.runners, id)
+			}
+			s.mu.Unlock()
+		}()
+		err := s.run(ctx, id, p)
+		imp, gerr := s.repo.GetByID(id)
+		if gerr != nil {
+			return nil, err
+		}
+		return imp, err
+	})
+	if err != nil {
+		s.logger.Error("failed to start directory import job", zap.Uint("import_id", id), zap.Error(err))
+		return
+	}
+	s.runners[id] = job.ID
+}
+
+func (s *DirectoryImportService) stopRunner(id uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if jobID, ok := s.runners[id]; ok {
+		s.jobs.Abort(jobID)
+		delete(s.runners, id)
+	}
+}
+
+// run walks the remaining departments of an import page by page. It returns
+// the error the import failed with, or nil when paused or stopped.
+func (s *DirectoryImportService) run(ctx context.Context, id uint, p *JobProgress) error {
+	imp, err := s.repo.GetByID(id)
+	if err != nil {
+		s.logger.Error("directory import not found", zap.Uint("import_id", id), zap.Error(err))
+		return err
+	}
+
+	if imp.Departments == "" {
+		p.SetMessage("syncing departments")
+		depts, err := s.plan(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return s.fail(imp, fmt.Errorf("sync departments: %w", err))
+		}
+		b, _ := json.Marshal(depts)
+		imp.Departments = string(b)
+		imp.DepartmentTotal = len(depts)
+		if err := s.repo.SaveProgress(imp); err != nil {
+			return s.fail(imp, err)
+		}
+		s.publish(imp)
+	}
+
+	var depts []string
+	if err := json.Unmarshal([]byte(imp.Departments), &depts); err != nil {
+		return s.fail(imp, fmt.Errorf("invalid department list: %w", err))
+	}
+	p.SetTotal(imp.DepartmentTotal)
+	p.SetDone(imp.DepartmentDone)
+	failedIDs := decodeIDs(imp.FailedIDs)
+	failedDepts := decodeIDs(imp.FailedDepartments)
+
+	for imp.DepartmentDone < len(depts) {
+		if ctx.Err() != nil {
+			return nil
+		}
+		deptID := depts[imp.DepartmentDone]
+		p.SetMessage("department " + deptID)
+		infos, next, err := s.larkClient.ListDepartmentUsers(ctx, deptID, imp.PageToken)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			// Skip the department; its users may still be reached through
+			// another department they belong to.
+			s.logger.Warn("failed to list department users",
+				zap.Uint("import_id", id), zap.String("department_id", deptID), zap.Error(err))
+			failedDepts = append(failedDepts, deptID)
+			imp.FailedDepartments = encodeIDs(failedDepts)
+			imp.DepartmentDone++
+			imp.PageToken = ""
+		} else {
+			for _, openID := range s.importPage(ctx, imp, infos) {
+				if len(failedIDs) < maxImportFailedIDs {
+					failedIDs = append(failedIDs, openID)
+				}
+			}
+			imp.FailedIDs = encodeIDs(failedIDs)
+			imp.PageToken = next
+			if next == "" {
+				imp.DepartmentDone++
+			}
+		}
+		if err := s.repo.SaveProgress(imp); err != nil {
+			return s.fail(imp, err)
+		}
+		p.SetDone(imp.DepartmentDone)
+		if ctx.Err() != nil {
+			return nil // paused or stopped; the status was already published
+		}
+		s.publish(imp)
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	// A pause may land after the check above; it wins.
+	now := time.Now()
+	ok, err := s.repo.UpdateFieldsInStatus(id, []string{ImportRunning}, map[string]interface{}{
+		"status":      ImportCompleted,
+		"finished_at": now,
+	})
+	if err != nil {
+		s.logger.Error("failed to complete directory import", zap.Uint("import_id", id), zap.Error(err))
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	imp.Status = ImportCompleted
+	imp.FinishedAt = &now
+	s.publish(imp)
+	s.logger.Info("directory import completed", zap.Uint("import_id", id),
+		zap.Int("imported", imp.Imported), zap.Int("skipped", imp.Skipped), zap.Int("failed", imp.Failed))
+	return nil
+}
+
+// plan syncs the department tree and returns the departments to walk: the
+// root, for users directly under it, followed by every known department.
+func (s *DirectoryImportService) plan(ctx context.Context) ([]string, error) {
+	if _, err := s.departments.Sync(ctx); err != nil {
+		return nil, err
+	}
+	list, err := s.departments.List()
+	if err != nil {
+		return nil, err
+	}
+	depts := make([]string, 0, len(list)+1)
+	depts = append(depts, larkbot.RootDepartmentID)
+	for _, d := range list {
+		depts = append(depts, d.OpenDepartmentID)
+	}
+	return depts, nil
+}
+
+// importPage saves the users of one page and returns the open_ids that
+// failed. Users already saved by this import through another department,
+// and users who have left, are skipped.
+func (s *DirectoryImportService) importPage(ctx context.Context, imp *model.DirectoryImport, infos []*larkbot.UserInfo) []string {
+	ids := make([]string, 0, len(infos))
+	for _, info := range infos {
+		ids = append(ids, info.OpenID)
+	}
+	done, err := s.userRepo.UpdatedSince(ids, imp.StartedAt)
+	if err != nil {
+		s.logger.Warn("failed to check imported users", zap.Uint("import_id", imp.ID), zap.Error(err))
+	}
+
+	var failed []string
+	for _, info := range infos {
+		if info.Resigned || done[info.OpenID] {
+			imp.Skipped++
+			continue
+		}
+		if err := s.users.importProfile(ctx, info); err != nil {
+			s.logger.Warn("failed to import user", zap.String("open_id", info.OpenID), zap.Error(err))
+			imp.Failed++
+			failed = append(failed, info.OpenID)
+			continue
+		}
+		imp.Imported++
+	}
+	return failed
+}
+
+// fail marks an import as failed and returns err.
+func (s *DirectoryImportService) fail(imp *model.DirectoryImport, err error) error {
+	s.logger.Error("directory import failed", zap.Uint("import_id", imp.ID), zap.Error(err))
+	now := time.Now()
+	ok, uerr := s.repo.UpdateFieldsInStatus(imp.ID, []string{ImportRunning}, map[string]interface{}{
+		"status":      ImportFailed,
+		"error":       err.Error(),
+		"finished_at": now,
+	})
+	if uerr != nil {
+		s.logger.Error("failed to update directory import", zap.Uint("import_id", imp.ID), zap.Error(uerr))
+		return err
+	}
+	if !ok {
+		return err // paused meanwhile; keep that status
+	}
+	imp.Status = ImportFailed
+	imp.Error = err.Error()
+	imp.FinishedAt = &now
+	s.publish(imp)
+	return err
+}
+
+func (s *DirectoryImportService) publish(imp *model.DirectoryImport) {
+	s.bus.Publish(broadcast.TopicDirectoryImport, "", broadcast.DirectoryImportEvent{
+		ID:              imp.ID,
+		Status:          imp.Status,
+		DepartmentTotal: imp.DepartmentTotal,
+		DepartmentDone:  imp.DepartmentDone,
+		Imported:        imp.Imported,
+		Skipped:         imp.Skipped,
+		Failed:          imp.Failed,
+		Error:           imp.Error,
+	})
+}
+
+func decodeIDs(raw string) []string {
+	var ids []string
+	if raw != "" {
+		_ = json.Unmarshal([]byte(raw), &ids)
+	}
+	return ids
+}
+
+func encodeIDs(ids []string) string {
+	if len(ids) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(ids)
+	return string(b)
+}
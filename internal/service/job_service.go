@@ -0,0 +1,477 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"lark-robot/internal/broadcast"
+	"lark-robot/internal/model"
+	"lark-robot/internal/repository"
+)
+
+// Job statuses.
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job kinds.
+const (
+	JobUserSync        = "user_sync"
+	JobChatSync        = "chat_sync"
+	JobAuditExport     = "audit_export"
+	JobCampaignSend    = "campaign_send"
+	JobCampaignRecall  = "campaign_recall"
+	JobDirectoryImport = "directory_import"
+)
+
+// jobSaveInterval is how often the progress of a running job is saved and
+// published; the final state is always saved.
+const jobSaveInterval = time.Second
+
+// ErrJobState is returned when an action is not allowed in the job's current status.
+var ErrJobState = errors.New("invalid job state")
+
+// JobConfig configures where job files are kept and for how long jobs are.
+type JobConfig struct {
+	FileDir   string        // directory for files produced by exports
+	Retention time.Duration // finished jobs and their files are deleted after this long; 0 keeps them
+}
+
+// JobSpec describes a job to start.
+type JobSpec struct {
+	Kind      string
+	CreatedBy string
+	Params    interface{} // stored as JSON
+	// Exclusive rejects the job while another job of the same kind is running.
+	Exclusive bool
+	// Cancel, if set, is called instead of cancelling the job's context when
+	// a user cancels it. Jobs whose owner keeps its own state, such as a
+	// campaign, use it to go through the owner, which stops the job with Abort.
+	Cancel func() error
+}
+
+// JobFunc does the work of a job. It should return soon after ctx is
+// cancelled; the result is stored as JSON even if it fails.
+type JobFunc func(ctx context.Context, p *JobProgress) (interface{}, error)
+
+// JobService runs long operations in the background and keeps a persisted
+// record of their status, progress and result, published on the bus as they
+// change.
+type JobService struct {
+	repo   *repository.JobRepo
+	bus    *broadcast.Bus
+	cfg    JobConfig
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	running  map[uint]*runningJob
+	stopping bool
+}
+
+type runningJob struct {
+	kind     string
+	cancel   context.CancelFunc
+	onCancel func() error
+	done     chan struct{}
+}
+
+func NewJobService(repo *repository.JobRepo, bus *broadcast.Bus, cfg JobConfig, logger *zap.Logger) *JobService {
+	return &JobService{
+		repo:    repo,
+		bus:     bus,
+		cfg:     cfg,
+		logger:  logger,
+		running: make(map[uint]*runningJob),
+	}
+}
+
+// Get returns a job by ID.
+func (s *JobService) Get(id uint) (*model.Job, error) {
+	return s.repo.GetByID(id)
+}
+
+// List returns a page of jobs, newest first.
+func (s *JobService) List(q repository.JobQuery) ([]model.Job, int64, error) {
+	return s.repo.List(q)
+}
+
+// Submit records a job and starts it in the background. It returns as soon
+// as the job is recorded.
+func (s *JobService) Submit(spec JobSpec, fn JobFunc) (*model.Job, error) {
+	params := ""
+	if spec.Params != nil {
+		b, err := json.Marshal(spec.Params)
+		if err != nil {
+			return nil, err
+		}
+		params = string(b)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopping {
+		return nil, fmt.Errorf("%w: shutting down", ErrJobState)
+	}
+	if spec.Exclusive {
+		for id, r := range s.running {
+			if r.kind == spec.Kind {
+				return nil, fmt.Errorf("%w: %s job %d is already running", ErrJobState, spec.Kind, id)
+			}
+		}
+	}
+
+	job := &model.Job{
+		Kind:      spec.Kind,
+		Status:    JobRunning,
+		Params:    params,
+		CreatedBy: spec.CreatedBy,
+		StartedAt: time.Now(),
+	}
+	if err := s.repo.Create(job); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &runningJob{kind: spec.Kind, cancel: cancel, onCancel: spec.Cancel, done: make(chan struct{})}
+	s.running[job.ID] = r
+
+	p := &JobProgress{svc: s, job: *job}
+	go s.run(ctx, r, p, fn)
+	s.logger.Info("job started", zap.Uint("job_id", job.ID), zap.String("kind", job.Kind), zap.String("created_by", job.CreatedBy))
+	s.publish(job)
+	return job, nil
+}
+
+// Cancel asks a running job to stop. The job ends as cancelled once its
+// work returns.
+func (s *JobService) Cancel(id uint) (*model.Job, error) {
+	s.mu.Lock()
+	r, ok := s.running[id]
+	s.mu.Unlock()
+	if !ok {
+		job, err := s.repo.GetByID(id)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: cannot cancel a %s job", ErrJobState, job.Status)
+	}
+
+	if r.onCancel != nil {
+		if err := r.onCancel(); err != nil {
+			return nil, err
+		}
+	} else {
+		r.cancel()
+	}
+	// Give the job a moment to wind down so the caller sees the final status
+	select {
+	case <-r.done:
+	case <-time.After(2 * time.Second):
+	}
+	return s.repo.GetByID(id)
+}
+
+// Abort cancels a running job's context without going through its Cancel
+// hook. Owners use it to stop the jobs they started.
+func (s *JobService) Abort(id uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.running[id]; ok {
+		r.cancel()
+	}
+}
+
+// AbortAndWait is Abort, then waits for the job's work to return.
+func (s *JobService) AbortAndWait(id uint) {
+	s.mu.Lock()
+	r, ok := s.running[id]
+	if ok {
+		r.cancel()
+	}
+	s.mu.Unlock()
+	if ok {
+		<-r.done
+	}
+}
+
+// File returns the path of the file a finished job produced.
+func (s *JobService) File(id uint) (*model.Job, string, error) {
+	job, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, "", err
+	}
+	if job.Status != JobSucceeded || job.File == "" {
+		return job, "", fmt.Errorf("%w: the job has no file", ErrJobState)
+	}
+	return job, job.File, nil
+}
+
+// FailInterrupted marks jobs left running by a previous process as failed.
+// Call it before anything resumes its own work as new jobs.
+func (s *JobService) FailInterrupted() error {
+	n, err := s.repo.FailRunning("interrupted by restart", time.Now())
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		s.logger.Info("marked interrupted jobs as failed", zap.Int64("count", n))
+	}
+	return nil
+}
+
+// Cleanup deletes jobs that finished longer ago than the retention, with
+// their files.
+func (s *JobService) Cleanup() {
+	if s.cfg.Retention <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-s.cfg.Retention)
+	jobs, err := s.repo.ListFinishedBefore(cutoff)
+	if err != nil {
+		s.logger.Error("failed to list old jobs", zap.Error(err))
+		return
+	}
+	for _, job := range jobs {
+		s.removeFiles(job.ID)
+	}
+	n, err := s.repo.DeleteFinishedBefore(cutoff)
+	if err != nil {
+		s.logger.Error("failed to delete old jobs", zap.Error(err))
+		return
+	}
+	if n > 0 {
+		s.logger.Info("deleted old jobs", zap.Int64("count", n))
+	}
+}
+
+// Stop cancels all running jobs. Jobs that end because of it are recorded as
+// failed, so they are not mistaken for jobs a user cancelled.
+func (s *JobService) Stop() {
+	s.mu.Lock()
+	s.stopping = true
+	var done []chan struct{}
+	for _, r := range s.running {
+		r.cancel()
+		done = append(done, r.done)
+	}
+	s.mu.Unlock()
+
+	timeout := time.After(5 * time.Second)
+	for _, d := range done {
+		select {
+		case <-d:
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func (s *JobService) run(ctx context.Context, r *runningJob, p *JobProgress, fn JobFunc) {
+	id := p.job.ID
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, id)
+		s.mu.Unlock()
+		r.cancel()
+		close(r.done)
+	}()
+
+	var result interface{}
+	var err error
+	func() {
+		defer func() {
+			if v := recover(); v != nil {
+				err = fmt.Errorf("panic: %v", v)
+				s.logger.Error("job panicked", zap.Uint("job_id", id), zap.Any("panic", v), zap.Stack("stack"))
+			}
+		}()
+		result, err = fn(ctx, p)
+	}()
+
+	s.mu.Lock()
+	stopping := s.stopping
+	s.mu.Unlock()
+
+	status := JobSucceeded
+	switch {
+	case ctx.Err() != nil && stopping:
+		status, err = JobFailed, errors.New("interrupted by shutdown")
+	case ctx.Err() != nil:
+		status = JobCancelled
+	case err != nil:
+		status = JobFailed
+	}
+	p.finish(status, result, err)
+	if status != JobSucceeded && p.file != "" {
+		s.removeFiles(id)
+	}
+}
+
+func (s *JobService) fileDir(id uint) string {
+	return filepath.Join(s.cfg.FileDir, fmt.Sprintf("job-%d", id))
+}
+
+func (s *JobService) removeFiles(id uint) {
+	if s.cfg.FileDir == "" {
+		return
+	}
+	if err := os.RemoveAll(s.fileDir(id)); err != nil {
+		s.logger.Warn("failed to remove job files", zap.Uint("job_id", id), zap.Error(err))
+	}
+}
+
+func (s *JobService) publish(job *model.Job) {
+	event := broadcast.JobEvent{
+		ID:        job.ID,
+		Kind:      job.Kind,
+		Status:    job.Status,
+		Total:     job.Total,
+		Done:      job.Done,
+		Message:   job.Message,
+		Error:     job.Error,
+		CreatedBy: job.CreatedBy,
+	}
+	if job.Result != "" {
+		event.Result = json.RawMessage(job.Result)
+	}
+	s.bus.Publish(broadcast.TopicJob, "", event)
+}
+
+// JobProgress is handed to a running job to report its progress. Updates
+// are saved and published at most once per jobSaveInterval. A nil
+// *JobProgress ignores updates, so work can also run outside a job.
+type JobProgress struct {
+	svc *JobService
+
+	mu    sync.Mutex
+	job   model.Job
+	saved time.Time
+	file  string
+}
+
+// ID returns the job's ID.
+func (p *JobProgress) ID() uint {
+	if p == nil {
+		return 0
+	}
+	return p.job.ID
+}
+
+// SetTotal sets the number of steps, 0 while unknown.
+func (p *JobProgress) SetTotal(total int) {
+	p.update(func(j *model.Job) { j.Total = total })
+}
+
+// SetDone sets the number of steps done.
+func (p *JobProgress) SetDone(done int) {
+	p.update(func(j *model.Job) { j.Done = done })
+}
+
+// Add counts n more steps as done.
+func (p *JobProgress) Add(n int) {
+	p.update(func(j *model.Job) { j.Done += n })
+}
+
+// SetMessage describes the current step.
+func (p *JobProgress) SetMessage(message string) {
+	if len(message) > 500 {
+		message = message[:500]
+	}
+	p.update(func(j *model.Job) { j.Message = message })
+}
+
+// CreateFile creates the file the job produces, which can be downloaded
+// once the job has succeeded. The file is removed if the job does not
+// succeed.
+func (p *JobProgress) CreateFile(name string) (*os.File, error) {
+	if p.svc.cfg.FileDir == "" {
+		return nil, errors.New("no directory configured for job files")
+	}
+	dir := p.svc.fileDir(p.job.ID)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, filepath.Base(name))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.file = path
+	p.mu.Unlock()
+	return f, nil
+}
+
+func (p *JobProgress) update(fn func(j *model.Job)) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	fn(&p.job)
+	if time.Since(p.saved) < jobSaveInterval {
+		p.mu.Unlock()
+		return
+	}
+	p.saved = time.Now()
+	job := p.job
+	p.mu.Unlock()
+
+	if err := p.svc.repo.UpdateFields(job.ID, map[string]interface{}{
+		"total":   job.Total,
+		"done":    job.Done,
+		"message": job.Message,
+	}); err != nil {
+		p.svc.logger.Warn("failed to save job progress", zap.Uint("job_id", job.ID), zap.Error(err))
+	}
+	p.svc.publish(&job)
+}
+
+func (p *JobProgress) finish(status string, result interface{}, err error) {
+	p.mu.Lock()
+	now := time.Now()
+	p.job.Status = status
+	p.job.FinishedAt = &now
+	if result != nil {
+		if b, merr := json.Marshal(result); merr == nil {
+			p.job.Result = string(b)
+		}
+	}
+	if err != nil {
+		p.job.Error = err.Error()
+	}
+	if status == JobSucceeded {
+		p.job.File = p.file
+	}
+	job := p.job
+	p.mu.Unlock()
+
+	if uerr := p.svc.repo.UpdateFields(job.ID, map[string]interface{}{
+		"status":      job.Status,
+		"total":       job.Total,
+		"done":        job.Done,
+		"message":     job.Message,
+		"result":      job.Result,
+		"error":       job.Error,
+		"file":        job.File,
+		"finished_at": now,
+	}); uerr != nil {
+		p.svc.logger.Error("failed to save job result", zap.Uint("job_id", job.ID), zap.Error(uerr))
+	}
+	p.svc.publish(&job)
+
+	fields := []zap.Field{zap.Uint("job_id", job.ID), zap.String("kind", job.Kind), zap.String("status", status)}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	p.svc.logger.Info("job finished", fields...)
+}
@@ -2,27 +2,35 @@ package service
 
 import (
 	"context"
+	"errors"
 	"io"
 	"time"
 
 	"go.uber.org/zap"
 
+	"lark-robot/internal/broadcast"
 	"lark-robot/internal/handler"
 	"lark-robot/internal/larkbot"
+	"lark-robot/internal/larkbot/content"
 	"lark-robot/internal/model"
 	"lark-robot/internal/repository"
 )
 
+// ErrNotIncoming is returned when marking a sent message as handled.
+var ErrNotIncoming = errors.New("only received messages can be marked as handled")
+
 type MessageService struct {
 	larkClient *larkbot.LarkClient
 	logRepo    *repository.MessageLogRepo
+	bus        *broadcast.Bus
 	logger     *zap.Logger
 }
 
-func NewMessageService(larkClient *larkbot.LarkClient, logRepo *repository.MessageLogRepo, logger *zap.Logger) *MessageService {
+func NewMessageService(larkClient *larkbot.LarkClient, logRepo *repository.MessageLogRepo, bus *broadcast.Bus, logger *zap.Logger) *MessageService {
 	return &MessageService{
 		larkClient: larkClient,
 		logRepo:    logRepo,
+		bus:        bus,
 		logger:     logger,
 	}
 }
@@ -57,32 +65,42 @@ func (s *MessageService) SendMessage(ctx context.Context, receiveID, receiveIDTy
 }
 
 // ReplyMessage replies to a specific message and logs it.
-func (s *MessageService) ReplyMessage(ctx context.Context, messageID, msgType, content string) (string, error) {
-	replyMsgID, err := s.larkClient.ReplyMessage(ctx, messageID, msgType, content)
+// When replyInThread is true the reply goes into the message's thread.
+func (s *MessageService) ReplyMessage(ctx context.Context, messageID, msgType, content string, replyInThread bool) (string, error) {
+	sent, err := s.larkClient.ReplyMessage(ctx, messageID, msgType, content, replyInThread)
 	if err != nil {
 		return "", err
 	}
 
 	// Look up the original message's chat info for logging
-	chatID := ""
+	chatID := sent.ChatID
 	chatType := ""
+	rootID := sent.RootID
 	original, err := s.logRepo.GetByMessageID(messageID)
 	if err == nil {
-		chatID = original.ChatID
+		if chatID == "" {
+			chatID = original.ChatID
+		}
 		chatType = original.ChatType
+		if rootID == "" {
+			rootID = threadRoot(original.RootID, messageID)
+		}
 	}
 
 	_ = s.logRepo.Create(&model.MessageLog{
-		MessageID: replyMsgID,
+		MessageID: sent.MessageID,
 		ChatID:    chatID,
 		ChatType:  chatType,
 		Direction: "out",
 		MsgType:   msgType,
 		Content:   content,
+		RootID:    rootID,
+		ParentID:  messageID,
+		ThreadID:  sent.ThreadID,
 		Source:    "manual",
 	})
 
-	return replyMsgID, nil
+	return sent.MessageID, nil
 }
 
 // LogIncomingMessage logs a received message and its handler result.
@@ -96,6 +114,11 @@ func (s *MessageService) LogIncomingMessage(msg *handler.IncomingMessage, result
 		Direction:  "in",
 		MsgType:    msg.MsgType,
 		Content:    msg.Content,
+		Summary:    msg.Payload.Summary(),
+		Mentions:   content.MarshalMentions(msg.Mentions),
+		RootID:     msg.RootID,
+		ParentID:   msg.ParentID,
+		ThreadID:   msg.ThreadID,
 		HandledBy:  handlerName,
 		Source:     "event",
 	})
@@ -108,17 +131,42 @@ func (s *MessageService) LogIncomingMessage(msg *handler.IncomingMessage, result
 			Direction: "out",
 			MsgType:   result.Reply.MsgType,
 			Content:   result.Reply.Content,
+			RootID:    threadRoot(msg.RootID, msg.MessageID),
+			ParentID:  msg.MessageID,
+			ThreadID:  msg.ThreadID,
 			HandledBy: handlerName,
 			Source:    "event",
 		})
 	}
 }
 
+// BackfillSummaries fills in the summary of message logs stored before
+// summaries were kept, so their conversations get a preview.
+func (s *MessageService) BackfillSummaries() {
+	filled, err := s.logRepo.BackfillSummaries()
+	if err != nil {
+		s.logger.Warn("failed to backfill message summaries", zap.Error(err))
+	}
+	if filled > 0 {
+		s.logger.Info("backfilled message summaries", zap.Int("count", filled))
+	}
+}
+
+// LogsSince returns logged messages created after since, oldest first.
+func (s *MessageService) LogsSince(chatID string, since time.Time, limit int) ([]model.MessageLog, error) {
+	return s.logRepo.ListSince(chatID, since, limit)
+}
+
 // GetLogs returns paginated message logs.
 func (s *MessageService) GetLogs(q repository.MessageLogQuery) ([]model.MessageLog, int64, error) {
 	return s.logRepo.List(q)
 }
 
+// ListThreads returns the messages of a chat grouped by reply thread.
+func (s *MessageService) ListThreads(chatID string, page, pageSize int) ([]repository.Thread, int64, error) {
+	return s.logRepo.ListThreads(chatID, page, pageSize)
+}
+
 // ListConversations returns all distinct conversations from message logs.
 // For p2p chats with missing sender names, it resolves them via the Lark API.
 func (s *MessageService) ListConversations(ctx context.Context) ([]repository.Conversation, error) {
@@ -141,14 +189,101 @@ func (s *MessageService) ListConversations(ctx context.Context) ([]repository.Co
 }
 
 // DeleteMessage recalls a message via the Lark API and marks it as recalled in local logs.
+// A logged message that Lark reports as not found was already recalled and is
+// treated as success; for any other message the not-found error is returned.
 func (s *MessageService) DeleteMessage(ctx context.Context, messageID string) error {
 	if err := s.larkClient.DeleteMessage(ctx, messageID); err != nil {
-		return err
+		if !larkbot.IsNotFound(err) {
+			return err
+		}
+		if _, logErr := s.logRepo.GetByMessageID(messageID); logErr != nil {
+			return err
+		}
 	}
 	_ = s.logRepo.RecallByMessageID(messageID)
 	return nil
 }
 
+// GetLog returns the log of a message by its message_id.
+func (s *MessageService) GetLog(messageID string) (*model.MessageLog, error) {
+	return s.logRepo.GetByMessageID(messageID)
+}
+
+// ChatOf returns the chat a logged message belongs to.
+func (s *MessageService) ChatOf(messageID string) (string, error) {
+	log, err := s.logRepo.GetByMessageID(messageID)
+	if err != nil {
+		return "", err
+	}
+	return log.ChatID, nil
+}
+
+// MarkHandled records that a received message has been handled by an operator
+// and notifies subscribers so other consoles can update.
+func (s *MessageService) MarkHandled(messageID, handledBy string) (*model.MessageLog, error) {
+	log, err := s.logRepo.GetByMessageID(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if log.Direction != "in" {
+		return nil, ErrNotIncoming
+	}
+	if err := s.logRepo.SetHandledBy(messageID, handledBy); err != nil {
+		return nil, err
+	}
+	log.HandledBy = handledBy
+
+	s.bus.Publish(broadcast.TopicHandled, log.ChatID, broadcast.MessageEvent{
+		ChatID:    log.ChatID,
+		ChatType:  log.ChatType,
+		HandledBy: handledBy,
+		MessageID: messageID,
+		CreatedAt: time.Now(),
+	})
+	return log, nil
+}
+
+// UpdateMessage edits a sent message. Interactive cards are patched; text and
+// post messages are updated in place. The edit is recorded as a new revision
+// in the message logs and broadcast to subscribers.
+func (s *MessageService) UpdateMessage(ctx context.Context, messageID, msgType, content string) (*model.MessageLog, error) {
+	var err error
+	if msgType == "interactive" {
+		err = s.larkClient.PatchCard(ctx, messageID, content)
+	} else {
+		err = s.larkClient.UpdateMessage(ctx, messageID, msgType, content)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log, err := s.logRepo.ApplyEdit(messageID, msgType, content)
+	if err != nil {
+		// The message was edited on Lark but is not in our logs (e.g. cleaned up)
+		s.logger.Warn("edited message has no local log", zap.String("message_id", messageID), zap.Error(err))
+		log = &model.MessageLog{MessageID: messageID, MsgType: msgType, Content: content}
+	}
+
+	s.bus.PublishMessage(broadcast.MessageEvent{
+		ID:        messageID,
+		ChatID:    log.ChatID,
+		ChatType:  log.ChatType,
+		Direction: log.Direction,
+		MsgType:   msgType,
+		Content:   content,
+		Edited:    true,
+		Revision:  log.Revision,
+		MessageID: messageID,
+		CreatedAt: time.Now(),
+	})
+	return log, nil
+}
+
+// ListRevisions returns the edit history of a message.
+func (s *MessageService) ListRevisions(messageID string) ([]model.MessageLog, error) {
+	return s.logRepo.ListRevisions(messageID)
+}
+
 // GetMessageResource downloads a resource (image/file) from a Lark message.
 func (s *MessageService) GetMessageResource(ctx context.Context, messageID, fileKey, resType string) (io.Reader, error) {
 	return s.larkClient.GetMessageResource(ctx, messageID, fileKey, resType)
@@ -167,7 +302,21 @@ func (s *MessageService) CleanupGroupLogs(days int) {
 	}
 }
 
+// QueueStats returns the state of the outbound send queue.
+func (s *MessageService) QueueStats() larkbot.QueueStats {
+	return s.larkClient.QueueStats()
+}
+
 // CountToday returns today's message count.
 func (s *MessageService) CountToday() (int64, error) {
 	return s.logRepo.CountToday()
 }
+
+// threadRoot returns the root message of a reply chain: the parent's root if it
+// has one, otherwise the parent itself.
+func threadRoot(parentRootID, parentID string) string {
+	if parentRootID != "" {
+		return parentRootID
+	}
+	return parentID
+}
@@ -10,32 +10,54 @@ import (
 
 type ReplyService struct {
 	repo           *repository.AutoReplyRuleRepo
+	groupRepo      *repository.GroupRepo
 	keywordHandler *handler.KeywordHandler
 	logger         *zap.Logger
 }
 
-func NewReplyService(repo *repository.AutoReplyRuleRepo, keywordHandler *handler.KeywordHandler, logger *zap.Logger) *ReplyService {
+func NewReplyService(repo *repository.AutoReplyRuleRepo, groupRepo *repository.GroupRepo, keywordHandler *handler.KeywordHandler, logger *zap.Logger) *ReplyService {
 	return &ReplyService{
 		repo:           repo,
+		groupRepo:      groupRepo,
 		keywordHandler: keywordHandler,
 		logger:         logger,
 	}
 }
 
 // ReloadRules loads enabled rules from the database and updates the KeywordHandler.
+// Group selectors are resolved to the groups they pick now, so rules must be
+// reloaded when groups or their metadata change.
 func (s *ReplyService) ReloadRules() error {
 	rules, err := s.repo.ListEnabled()
 	if err != nil {
 		return err
 	}
 	keywordRules := toKeywordRules(rules)
+	for i, r := range rules {
+		if r.GroupSelector == "" {
+			continue
+		}
+		keywordRules[i].Chats = map[string]bool{}
+		sel, err := model.ParseGroupSelector(r.GroupSelector)
+		if err != nil {
+			s.logger.Error("invalid group selector on auto-reply rule", zap.Uint("id", r.ID), zap.Error(err))
+			continue
+		}
+		groups, err := matchGroups(s.groupRepo, sel)
+		if err != nil {
+			return err
+		}
+		for _, g := range groups {
+			keywordRules[i].Chats[g.ChatID] = true
+		}
+	}
 	s.keywordHandler.UpdateRules(keywordRules)
 	s.logger.Info("reloaded auto-reply rules", zap.Int("count", len(keywordRules)))
 	return nil
 }
 
-func (s *ReplyService) List(page, pageSize int) ([]model.AutoReplyRule, int64, error) {
-	return s.repo.List(page, pageSize)
+func (s *ReplyService) List(page, pageSize int, chatIDs []string) ([]model.AutoReplyRule, int64, error) {
+	return s.repo.List(page, pageSize, chatIDs)
 }
 
 func (s *ReplyService) GetByID(id uint) (*model.AutoReplyRule, error) {
@@ -70,17 +92,29 @@ func (s *ReplyService) Toggle(id uint) error {
 	return s.ReloadRules()
 }
 
+// DisableForUser disables the enabled rules limited to any of chatIDs or
+// requiring openID to be mentioned, and returns them.
+func (s *ReplyService) DisableForUser(openID string, chatIDs []string) ([]model.AutoReplyRule, error) {
+	rules, err := s.repo.DisableForUser(openID, chatIDs)
+	if err != nil || len(rules) == 0 {
+		return rules, err
+	}
+	return rules, s.ReloadRules()
+}
+
 func toKeywordRules(rules []model.AutoReplyRule) []handler.KeywordRule {
 	result := make([]handler.KeywordRule, len(rules))
 	for i, r := range rules {
 		result[i] = handler.KeywordRule{
-			ID:          r.ID,
-			Keyword:     r.Keyword,
-			ReplyText:   r.ReplyText,
-			MatchMode:   r.MatchMode,
-			ChatID:      r.ChatID,
-			TriggerMode: r.TriggerMode,
-			Enabled:     r.Enabled,
+			ID:            r.ID,
+			Keyword:       r.Keyword,
+			ReplyText:     r.ReplyText,
+			MatchMode:     r.MatchMode,
+			ChatID:        r.ChatID,
+			TriggerMode:   r.TriggerMode,
+			MentionUser:   r.MentionUser,
+			ReplyInThread: r.ReplyInThread,
+			Enabled:       r.Enabled,
 		}
 	}
 	return result
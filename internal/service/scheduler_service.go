@@ -40,8 +40,8 @@ func (s *SchedulerService) LoadAndStartAll() error {
 	return nil
 }
 
-func (s *SchedulerService) List(page, pageSize int) ([]model.ScheduledTask, int64, error) {
-	return s.repo.List(page, pageSize)
+func (s *SchedulerService) List(page, pageSize int, chatIDs []string) ([]model.ScheduledTask, int64, error) {
+	return s.repo.List(page, pageSize, chatIDs)
 }
 
 func (s *SchedulerService) GetByID(id uint) (*model.ScheduledTask, error) {
@@ -81,6 +81,19 @@ func (s *SchedulerService) Toggle(id uint) error {
 	return s.scheduler.ReloadTask(task)
 }
 
+// DisableForChats disables the enabled tasks that send to any of chatIDs
+// and returns them.
+func (s *SchedulerService) DisableForChats(chatIDs []string) ([]model.ScheduledTask, error) {
+	tasks, err := s.repo.DisableByChatIDs(chatIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, task := range tasks {
+		s.scheduler.RemoveTask(task.ID)
+	}
+	return tasks, nil
+}
+
 // RunNow triggers a task immediately (for testing).
 func (s *SchedulerService) RunNow(ctx context.Context, id uint) error {
 	task, err := s.repo.GetByID(id)
@@ -92,6 +105,6 @@ func (s *SchedulerService) RunNow(ctx context.Context, id uint) error {
 
 // TaskCount returns total number of tasks.
 func (s *SchedulerService) TaskCount() (int64, error) {
-	_, total, err := s.repo.List(1, 1)
+	_, total, err := s.repo.List(1, 1, nil)
 	return total, err
 }
@@ -0,0 +1,353 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+
+	"lark-robot/internal/model"
+	"lark-robot/internal/repository"
+)
+
+// Token types, carried in the "typ" claim.
+const (
+	TokenAccess  = "access"  // sent in the Authorization header
+	TokenRefresh = "refresh" // exchanged for a new token pair
+	TokenStream  = "stream"  // short-lived, for ?token= on SSE, WebSocket and image URLs
+	TokenTOTP    = "totp"    // short-lived, proves a Lark SSO login until the TOTP code is entered
+)
+
+// totpTokenTTL is how long a Lark SSO login waits for its TOTP code.
+const totpTokenTTL = 5 * time.Minute
+
+var (
+	// ErrInvalidToken is returned for malformed, expired or wrongly signed tokens.
+	ErrInvalidToken = errors.New("invalid or expired token")
+	// ErrSessionRevoked is returned for tokens of a revoked session.
+	ErrSessionRevoked = errors.New("session has been revoked")
+)
+
+// TokenConfig configures token signing and lifetimes.
+type TokenConfig struct {
+	Secret          string
+	PreviousSecrets []string // still accepted for verification, so rotating the secret does not log everyone out
+	AccessTTL       time.Duration
+	RefreshTTL      time.Duration
+	StreamTTL       time.Duration
+}
+
+// Claims are the JWT claims of all token types. Subject is the username.
+type Claims struct {
+	jwt.RegisteredClaims
+	Type      string `json:"typ"`
+	SessionID string `json:"sid"`
+}
+
+// TokenPair is returned on login and refresh.
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // access token lifetime in seconds
+	SessionID    string `json:"session_id"`
+}
+
+// SessionService issues and verifies JWTs and tracks login sessions.
+// Revoked sessions are kept in memory until they expire, so checking an
+// access token does not touch the database.
+type SessionService struct {
+	repo   *repository.SessionRepo
+	cfg    TokenConfig
+	keys   map[string][]byte // key ID → secret
+	kid    string            // key ID of cfg.Secret
+	logger *zap.Logger
+
+	mu      sync.RWMutex
+	revoked map[string]time.Time // session ID → expiry
+}
+
+func NewSessionService(repo *repository.SessionRepo, cfg TokenConfig, logger *zap.Logger) *SessionService {
+	if cfg.AccessTTL <= 0 {
+		cfg.AccessTTL = 15 * time.Minute
+	}
+	if cfg.RefreshTTL <= 0 {
+		cfg.RefreshTTL = 7 * 24 * time.Hour
+	}
+	if cfg.StreamTTL <= 0 {
+		cfg.StreamTTL = 2 * time.Minute
+	}
+	if cfg.Secret == "" {
+		cfg.Secret = randomID()
+		logger.Warn("auth.secret is empty; using a random secret, all sessions end on restart")
+	}
+
+	s := &SessionService{
+		repo:    repo,
+		cfg:     cfg,
+		keys:    make(map[string][]byte),
+		kid:     keyID(cfg.Secret),
+		logger:  logger,
+		revoked: make(map[string]time.Time),
+	}
+	s.keys[s.kid] = []byte(cfg.Secret)
+	for _, secret := range cfg.PreviousSecrets {
+		if secret != "" {
+			s.keys[keyID(secret)] = []byte(secret)
+		}
+	}
+
+	revoked, err := repo.ListRevoked()
+	if err != nil {
+		logger.Error("failed to load revoked sessions", zap.Error(err))
+	}
+	for _, session := range revoked {
+		s.revoked[session.ID] = session.ExpiresAt
+	}
+	return s
+}
+
+// Create starts a session for user and returns its first token pair.
+func (s *SessionService) Create(user *model.AdminUser, method, ip, userAgent string) (*TokenPair, error) {
+	now := time.Now()
+	session := &model.Session{
+		ID:          randomID(),
+		AdminUserID: user.ID,
+		Username:    user.Username,
+		Method:      method,
+		IP:          ip,
+		UserAgent:   truncate(userAgent, 500),
+		RefreshJTI:  randomID(),
+		LastUsedAt:  now,
+		ExpiresAt:   now.Add(s.cfg.RefreshTTL),
+	}
+	if err := s.repo.Create(session); err != nil {
+		return nil, err
+	}
+	return s.issue(user.Username, session.ID, session.RefreshJTI, now)
+}
+
+// Refresh exchanges a refresh token for a new pair. Each refresh token works
+// once; presenting one that was already exchanged revokes the whole session,
+// since it means the token was copied.
+func (s *SessionService) Refresh(refreshToken string) (*TokenPair, error) {
+	claims, err := s.Parse(refreshToken, TokenRefresh)
+	if err != nil {
+		return nil, err
+	}
+	session, err := s.repo.GetByID(claims.SessionID)
+	if err != nil || !session.Active() {
+		return nil, ErrSessionRevoked
+	}
+
+	now := time.Now()
+	newJTI := randomID()
+	ok, err := s.repo.Rotate(session.ID, claims.ID, newJTI, now, now.Add(s.cfg.RefreshTTL))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		s.logger.Warn("refresh token reused, revoking session",
+			zap.String("session_id", session.ID), zap.String("username", session.Username))
+		s.Revoke(session.ID)
+		return nil, ErrSessionRevoked
+	}
+	return s.issue(session.Username, session.ID, newJTI, now)
+}
+
+// StreamToken issues a short-lived token for URLs that cannot carry headers.
+func (s *SessionService) StreamToken(username, sessionID string) (string, int, error) {
+	now := time.Now()
+	token, err := s.sign(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   username,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.cfg.StreamTTL)),
+		},
+		Type:      TokenStream,
+		SessionID: sessionID,
+	})
+	return token, int(s.cfg.StreamTTL.Seconds()), err
+}
+
+// TOTPToken issues a short-lived token proving that username passed the Lark
+// SSO step of a login that still needs its TOTP code. It belongs to no session.
+func (s *SessionService) TOTPToken(username string) (string, error) {
+	now := time.Now()
+	return s.sign(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   username,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(totpTokenTTL)),
+		},
+		Type: TokenTOTP,
+	})
+}
+
+// ParseTOTPToken verifies a token from TOTPToken and returns its username.
+func (s *SessionService) ParseTOTPToken(token string) (string, error) {
+	claims, err := s.verify(token)
+	if err != nil || claims.Type != TokenTOTP || claims.Subject == "" {
+		return "", ErrInvalidToken
+	}
+	return claims.Subject, nil
+}
+
+// Parse verifies a token of the given type and that its session is not revoked.
+func (s *SessionService) Parse(token, typ string) (*Claims, error) {
+	claims, err := s.verify(token)
+	if err != nil || claims.Type != typ || claims.Subject == "" || claims.SessionID == "" {
+		return nil, ErrInvalidToken
+	}
+	if s.isRevoked(claims.SessionID) {
+		return nil, ErrSessionRevoked
+	}
+	return claims, nil
+}
+
+// verify checks a token's signature and expiry and returns its claims.
+func (s *SessionService) verify(token string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := s.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		return key, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// Get returns a session by ID.
+func (s *SessionService) Get(id string) (*model.Session, error) {
+	return s.repo.GetByID(id)
+}
+
+// List returns active sessions of a user, or of all users when userID is 0.
+func (s *SessionService) List(userID uint) ([]model.Session, error) {
+	return s.repo.ListActive(userID)
+}
+
+// Revoke ends a session. Its tokens stop working immediately.
+func (s *SessionService) Revoke(id string) error {
+	session, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.Revoke(id, time.Now()); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.revoked[id] = session.ExpiresAt
+	s.mu.Unlock()
+	return nil
+}
+
+// RevokeUser ends all sessions of a user, e.g. after a password change.
+func (s *SessionService) RevokeUser(userID uint) error {
+	ids, err := s.repo.RevokeUser(userID, time.Now())
+	if err != nil {
+		return err
+	}
+	expiry := time.Now().Add(s.cfg.RefreshTTL)
+	s.mu.Lock()
+	for _, id := range ids {
+		s.revoked[id] = expiry
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Cleanup deletes expired sessions and forgets expired revocations.
+func (s *SessionService) Cleanup() {
+	now := time.Now()
+	n, err := s.repo.DeleteExpiredBefore(now)
+	if err != nil {
+		s.logger.Error("failed to delete expired sessions", zap.Error(err))
+	}
+	s.mu.Lock()
+	for id, expiry := range s.revoked {
+		if expiry.Before(now) {
+			delete(s.revoked, id)
+		}
+	}
+	s.mu.Unlock()
+	if n > 0 {
+		s.logger.Info("deleted expired sessions", zap.Int64("count", n))
+	}
+}
+
+func (s *SessionService) isRevoked(id string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.revoked[id]
+	return ok
+}
+
+func (s *SessionService) issue(username, sessionID, refreshJTI string, now time.Time) (*TokenPair, error) {
+	access, err := s.sign(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   username,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.cfg.AccessTTL)),
+		},
+		Type:      TokenAccess,
+		SessionID: sessionID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := s.sign(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        refreshJTI,
+			Subject:   username,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.cfg.RefreshTTL)),
+		},
+		Type:      TokenRefresh,
+		SessionID: sessionID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    int(s.cfg.AccessTTL.Seconds()),
+		SessionID:    sessionID,
+	}, nil
+}
+
+func (s *SessionService) sign(claims Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = s.kid
+	return token.SignedString(s.keys[s.kid])
+}
+
+// keyID identifies a secret in the JWT header without revealing it.
+func keyID(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:4])
+}
+
+func randomID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"lark-robot/internal/model"
+	"lark-robot/internal/repository"
+)
+
+func newTestSessionService(t *testing.T, cfg TokenConfig) *SessionService {
+	t.Helper()
+	if cfg.Secret == "" {
+		cfg.Secret = "test-secret"
+	}
+	return NewSessionService(repository.NewSessionRepo(newTestDB(t)), cfg, zap.NewNop())
+}
+
+func TestSessionRefreshRotation(t *testing.T) {
+	user := &model.AdminUser{ID: 1, Username: "alice"}
+	tests := []struct {
+		name string
+		// run refreshes and revokes, returning the access token that should
+		// (or should not) still work and the error of the last step
+		run        func(s *SessionService, first *TokenPair) (string, error)
+		wantErr    error
+		wantAccess error
+	}{
+		{
+			name: "refresh rotates the pair",
+			run: func(s *SessionService, first *TokenPair) (string, error) {
+				next, err := s.Refresh(first.RefreshToken)
+				if err != nil {
+					return "", err
+				}
+				_, err = s.Refresh(next.RefreshToken)
+				return next.AccessToken, err
+			},
+		},
+		{
+			name: "reused refresh token revokes the session",
+			run: func(s *SessionService, first *TokenPair) (string, error) {
+				next, err := s.Refresh(first.RefreshToken)
+				if err != nil {
+					return "", err
+				}
+				_, err = s.Refresh(first.RefreshToken)
+				return next.AccessToken, err
+			},
+			wantErr:    ErrSessionRevoked,
+			wantAccess: ErrSessionRevoked,
+		},
+		{
+			name: "revoked session cannot refresh",
+			run: func(s *SessionService, first *TokenPair) (string, error) {
+				if err := s.Revoke(first.SessionID); err != nil {
+					return "", err
+				}
+				_, err := s.Refresh(first.RefreshToken)
+				return first.AccessToken, err
+			},
+			wantErr:    ErrSessionRevoked,
+			wantAccess: ErrSessionRevoked,
+		},
+		{
+			name: "revoking a user ends its sessions",
+			run: func(s *SessionService, first *TokenPair) (string, error) {
+				if err := s.RevokeUser(user.ID); err != nil {
+					return "", err
+				}
+				_, err := s.Refresh(first.RefreshToken)
+				return first.AccessToken, err
+			},
+			wantErr:    ErrSessionRevoked,
+			wantAccess: ErrSessionRevoked,
+		},
+		{
+			name: "access token is not a refresh token",
+			run: func(s *SessionService, first *TokenPair) (string, error) {
+				_, err := s.Refresh(first.AccessToken)
+				return first.AccessToken, err
+			},
+			wantErr: ErrInvalidToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSessionService(t, TokenConfig{})
+			first, err := s.Create(user, "password", "127.0.0.1", "test")
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			access, err := tt.run(s, first)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if access == "" {
+				return
+			}
+			if _, err := s.Parse(access, TokenAccess); !errors.Is(err, tt.wantAccess) {
+				t.Errorf("Parse(access) error = %v, want %v", err, tt.wantAccess)
+			}
+		})
+	}
+}
+
+func TestSessionParse(t *testing.T) {
+	s := newTestSessionService(t, TokenConfig{Secret: "old"})
+	pair, err := s.Create(&model.AdminUser{ID: 1, Username: "alice"}, "password", "", "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	stream, _, err := s.StreamToken("alice", pair.SessionID)
+	if err != nil {
+		t.Fatalf("StreamToken: %v", err)
+	}
+	expired := newTestSessionService(t, TokenConfig{Secret: "old", AccessTTL: time.Nanosecond})
+	stale, err := expired.Create(&model.AdminUser{ID: 1, Username: "alice"}, "password", "", "")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	rotated := newTestSessionService(t, TokenConfig{Secret: "new", PreviousSecrets: []string{"old"}})
+	other := newTestSessionService(t, TokenConfig{Secret: "other"})
+
+	tests := []struct {
+		name    string
+		s       *SessionService
+		token   string
+		typ     string
+		wantErr error
+	}{
+		{"access", s, pair.AccessToken, TokenAccess, nil},
+		{"refresh", s, pair.RefreshToken, TokenRefresh, nil},
+		{"stream", s, stream, TokenStream, nil},
+		{"wrong type", s, pair.AccessToken, TokenRefresh, ErrInvalidToken},
+		{"stream is not access", s, stream, TokenAccess, ErrInvalidToken},
+		{"expired", expired, stale.AccessToken, TokenAccess, ErrInvalidToken},
+		{"previous secret still verifies", rotated, pair.AccessToken, TokenAccess, nil},
+		{"unknown secret", other, pair.AccessToken, TokenAccess, ErrInvalidToken},
+		{"garbage", s, "not-a-token", TokenAccess, ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.s.Parse(tt.token, tt.typ); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Parse() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
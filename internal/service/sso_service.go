@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"lark-robot/internal/larkbot"
+	"lark-robot/internal/model"
+	"lark-robot/internal/repository"
+)
+
+// ErrSSODenied is returned when a Lark user is not allowed to log in.
+var ErrSSODenied = errors.New("this Lark account is not allowed to log in")
+
+// LarkSSOPolicy decides which Lark users may log in to the admin console.
+type LarkSSOPolicy struct {
+	AllowedUsers       []string // open_ids
+	AllowedDepartments []string // open_department_ids; direct members only
+	DefaultRole        string   // role of accounts created on first login
+}
+
+// SSOService logs admin users in with Lark OAuth.
+//
+// A Lark user may log in when an admin user is linked to their open_id, or
+// when they match the allowlist; in that case an account with the default role
+// is created on first login. Accounts created this way are re-checked against
+// the allowlist on every login, so removing someone from an allowed department
+// revokes their access. Accounts linked by an admin are not.
+type SSOService struct {
+	larkClient *larkbot.LarkClient
+	repo       *repository.AdminUserRepo
+	policy     LarkSSOPolicy
+	logger     *zap.Logger
+}
+
+func NewSSOService(larkClient *larkbot.LarkClient, repo *repository.AdminUserRepo, policy LarkSSOPolicy, logger *zap.Logger) *SSOService {
+	if !model.ValidRole(policy.DefaultRole) {
+		policy.DefaultRole = model.RoleViewer
+	}
+	return &SSOService{larkClient: larkClient, repo: repo, policy: policy, logger: logger}
+}
+
+// AuthorizeURL returns the Lark authorization page URL.
+func (s *SSOService) AuthorizeURL(redirectURI, state string) string {
+	return s.larkClient.AuthorizeURL(redirectURI, state)
+}
+
+// Login exchanges an OAuth code and returns the admin user to log in as.
+func (s *SSOService) Login(ctx context.Context, code string) (*model.AdminUser, error) {
+	info, err := s.larkClient.ExchangeOAuthCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.repo.GetByLarkOpenID(info.OpenID)
+	linked := err == nil
+	if linked && user.Source != model.SourceLark {
+		// Linked by an admin: the account itself decides
+		return s.finishLogin(user)
+	}
+
+	if !s.allowed(ctx, info.OpenID) {
+		s.logger.Warn("lark sso login denied", zap.String("open_id", info.OpenID), zap.String("name", info.Name))
+		return nil, ErrSSODenied
+	}
+	if linked {
+		return s.finishLogin(user)
+	}
+
+	user, err = s.provision(info)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Info("created admin user from lark sso",
+		zap.String("username", user.Username), zap.String("open_id", info.OpenID), zap.String("role", user.Role))
+	return s.finishLogin(user)
+}
+
+func (s *SSOService) finishLogin(user *model.AdminUser) (*model.AdminUser, error) {
+	if !user.Enabled {
+		return nil, ErrAccountDisabled
+	}
+	now := time.Now()
+	user.LastLoginAt = &now
+	if err := s.repo.UpdateLastLogin(user.ID, now); err != nil {
+		s.logger.Warn("failed to record admin login", zap.String("username", user.Username), zap.Error(err))
+	}
+	return user, nil
+}
+
+// allowed checks the open_id and the user's departments against the allowlist.
+func (s *SSOService) allowed(ctx context.Context, openID string) bool {
+	for _, id := range s.policy.AllowedUsers {
+		if id == openID {
+			return true
+		}
+	}
+	if len(s.policy.AllowedDepartments) == 0 {
+		return false
+	}
+	info, err := s.larkClient.GetUserInfo(ctx, openID)
+	if err != nil {
+		s.logger.Warn("failed to get departments for lark sso", zap.String("open_id", openID), zap.Error(err))
+		return false
+	}
+	var deptIDs []string
+	json.Unmarshal([]byte(info.DepartmentIDs), &deptIDs)
+	for _, allowed := range s.policy.AllowedDepartments {
+		for _, id := range deptIDs {
+			if id == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// provision creates an account for a Lark user. It has no usable password.
+func (s *SSOService) provision(info *larkbot.OAuthUser) (*model.AdminUser, error) {
+	hash, err := unusablePasswordHash()
+	if err != nil {
+		return nil, err
+	}
+	user := &model.AdminUser{
+		Username:     s.username(info),
+		DisplayName:  info.Name,
+		PasswordHash: hash,
+		Role:         s.policy.DefaultRole,
+		LarkOpenID:   info.OpenID,
+		Source:       model.SourceLark,
+		Enabled:      true,
+	}
+	return user, s.repo.Create(user)
+}
+
+// username picks the email's local part when it is free, else the open_id.
+func (s *SSOService) username(info *larkbot.OAuthUser) string {
+	if i := strings.Index(info.Email, "@"); i > 0 {
+		if name := info.Email[:i]; !s.repo.UsernameExists(name) {
+			return name
+		}
+	}
+	return info.OpenID
+}
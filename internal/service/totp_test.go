@@ -0,0 +1,97 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"lark-robot/internal/model"
+	"lark-robot/internal/repository"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B SHA-1 vectors, truncated to 6 digits
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(key, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("totpCode(T=%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1234567890, 0)
+	step := now.Unix() / totpPeriod
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", secret, totpCode(key, step), 0, step, true},
+		{"previous step within skew", secret, totpCode(key, step-1), 0, step - 1, true},
+		{"next step within skew", secret, totpCode(key, step+1), 0, step + 1, true},
+		{"outside skew", secret, totpCode(key, step-2), 0, 0, false},
+		{"already used", secret, totpCode(key, step), step, 0, false},
+		{"newer than last use", secret, totpCode(key, step), step - 1, step, true},
+		{"wrong code", secret, "000000", 0, 0, false},
+		{"wrong length", secret, totpCode(key, step)[:5], 0, 0, false},
+		{"invalid secret", "not base32!", totpCode(key, step), 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := verifyTOTP(tt.secret, tt.code, now, tt.lastStep)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("verifyTOTP() = %d, %v; want %d, %v", gotStep, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestAdminUserVerifyTOTPRejectsReplay(t *testing.T) {
+	s := NewAdminUserService(repository.NewAdminUserRepo(newTestDB(t)), zap.NewNop())
+	secret, err := newTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &model.AdminUser{Username: "alice", Role: model.RoleAdmin, Enabled: true, TOTPSecret: secret, TOTPEnabled: true}
+	if err := s.Create(user, "password123"); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	key, _ := totpEncoding.DecodeString(secret)
+	step := time.Now().Unix() / totpPeriod
+	code := totpCode(key, step)
+
+	// A second copy of the user, as a concurrent login would have loaded it
+	stale := *user
+	tests := []struct {
+		name string
+		user *model.AdminUser
+		code string
+		want bool
+	}{
+		{"first use", user, code, true},
+		{"same code again", user, code, false},
+		{"same code from a stale copy", &stale, code, false},
+		{"next code, padded with spaces", user, " " + totpCode(key, step+1) + " ", true},
+	}
+	for _, tt := range tests {
+		if got := s.VerifyTOTP(tt.user, tt.code); got != tt.want {
+			t.Errorf("%s: VerifyTOTP() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
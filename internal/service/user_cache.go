@@ -0,0 +1,198 @@
+package service
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"lark-robot/internal/larkbot"
+)
+
+// UserCacheConfig bounds the in-memory user info cache.
+type UserCacheConfig struct {
+	Size int           // maximum number of users kept; 0 disables the cache
+	TTL  time.Duration // how long an entry is served before it is looked up again
+}
+
+// UserCacheStats is a snapshot of the user info cache.
+type UserCacheStats struct {
+	Size          int     `json:"size"`
+	Capacity      int     `json:"capacity"`
+	TTLSeconds    int     `json:"ttl_seconds"`
+	Hits          uint64  `json:"hits"`
+	Misses        uint64  `json:"misses"`
+	HitRate       float64 `json:"hit_rate"`
+	Evictions     uint64  `json:"evictions"`     // dropped to stay within capacity
+	Expirations   uint64  `json:"expirations"`   // dropped because they were older than the TTL
+	Invalidations uint64  `json:"invalidations"` // dropped by syncs and contact events
+	Shared        uint64  `json:"shared"`        // lookups that waited for a concurrent one for the same user
+}
+
+// userCache is an LRU of user info whose entries expire after a TTL.
+type userCache struct {
+	cfg UserCacheConfig
+
+	mu            sync.Mutex
+	order         *list.List // front is the most recently used
+	entries       map[string]*list.Element
+	hits          uint64
+	misses        uint64
+	evictions     uint64
+	expirations   uint64
+	invalidations uint64
+	shared        uint64
+
+	flightMu sync.Mutex
+	flights  map[string]*userLookup
+}
+
+type userCacheEntry struct {
+	info    *larkbot.UserInfo
+	expires time.Time
+}
+
+// userLookup is a lookup in progress that other callers for the same user
+// wait for instead of starting their own.
+type userLookup struct {
+	done  chan struct{}
+	info  *larkbot.UserInfo
+	err   error
+	stale bool // invalidated while running, so the result is not cached
+}
+
+func newUserCache(cfg UserCacheConfig) *userCache {
+	return &userCache{
+		cfg:     cfg,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		flights: make(map[string]*userLookup),
+	}
+}
+
+// get returns a cached entry that has not expired.
+func (c *userCache) get(openID string, now time.Time) (*larkbot.UserInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[openID]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	entry := el.Value.(*userCacheEntry)
+	if !now.Before(entry.expires) {
+		c.order.Remove(el)
+		delete(c.entries, openID)
+		c.expirations++
+		c.misses++
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	c.hits++
+	return entry.info, true
+}
+
+// set stores info, evicting the least recently used entries beyond capacity.
+func (c *userCache) set(info *larkbot.UserInfo, now time.Time) {
+	if c.cfg.Size <= 0 || info == nil || info.OpenID == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &userCacheEntry{info: info, expires: now.Add(c.cfg.TTL)}
+	if el, ok := c.entries[info.OpenID]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[info.OpenID] = c.order.PushFront(entry)
+	for c.order.Len() > c.cfg.Size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*userCacheEntry).info.OpenID)
+		c.evictions++
+	}
+}
+
+// invalidate drops a user, so the next lookup reads it again.
+func (c *userCache) invalidate(openID string) {
+	c.flightMu.Lock()
+	if f, ok := c.flights[openID]; ok {
+		f.stale = true
+	}
+	c.flightMu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[openID]; ok {
+		c.order.Remove(el)
+		delete(c.entries, openID)
+		c.invalidations++
+	}
+}
+
+// clear drops every user, including the results of lookups still running.
+func (c *userCache) clear() {
+	c.flightMu.Lock()
+	for _, f := range c.flights {
+		f.stale = true
+	}
+	c.flightMu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidations += uint64(len(c.entries))
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+// lookup runs fn for openID unless a lookup for it is already running, in
+// which case it waits for that one and shares its result. A successful
+// result is cached unless the user was invalidated while fn ran; the flight
+// ends and the result is stored under flightMu, so an invalidation either
+// marks the flight stale or runs after the result is stored and drops it.
+func (c *userCache) lookup(openID string, fn func() (*larkbot.UserInfo, error)) (*larkbot.UserInfo, error) {
+	c.flightMu.Lock()
+	if f, ok := c.flights[openID]; ok {
+		c.flightMu.Unlock()
+		c.mu.Lock()
+		c.shared++
+		c.mu.Unlock()
+		<-f.done
+		return f.info, f.err
+	}
+	f := &userLookup{done: make(chan struct{})}
+	c.flights[openID] = f
+	c.flightMu.Unlock()
+
+	defer close(f.done)
+	defer func() {
+		c.flightMu.Lock()
+		defer c.flightMu.Unlock()
+		delete(c.flights, openID)
+		if f.err == nil && !f.stale {
+			c.set(f.info, time.Now())
+		}
+	}()
+	f.info, f.err = fn()
+	return f.info, f.err
+}
+
+func (c *userCache) stats() UserCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := UserCacheStats{
+		Size:          c.order.Len(),
+		Capacity:      c.cfg.Size,
+		TTLSeconds:    int(c.cfg.TTL.Seconds()),
+		Hits:          c.hits,
+		Misses:        c.misses,
+		Evictions:     c.evictions,
+		Expirations:   c.expirations,
+		Invalidations: c.invalidations,
+		Shared:        c.shared,
+	}
+	if total := c.hits + c.misses; total > 0 {
+		stats.HitRate = float64(c.hits) / float64(total)
+	}
+	return stats
+}
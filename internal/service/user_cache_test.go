@@ -0,0 +1,184 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"lark-robot/internal/larkbot"
+)
+
+func TestUserCacheEviction(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	type op struct {
+		kind   string // "set", "get" or "invalidate"
+		openID string
+		after  time.Duration // since now
+	}
+	tests := []struct {
+		name      string
+		size      int
+		ops       []op
+		wantKeys  []string // present at the end, checked without expiring anything
+		wantStats UserCacheStats
+	}{
+		{
+			name:      "within capacity",
+			size:      2,
+			ops:       []op{{"set", "a", 0}, {"set", "b", 0}},
+			wantKeys:  []string{"a", "b"},
+			wantStats: UserCacheStats{Size: 2},
+		},
+		{
+			name:      "least recently set is evicted",
+			size:      2,
+			ops:       []op{{"set", "a", 0}, {"set", "b", 0}, {"set", "c", 0}},
+			wantKeys:  []string{"b", "c"},
+			wantStats: UserCacheStats{Size: 2, Evictions: 1},
+		},
+		{
+			name:      "get refreshes recency",
+			size:      2,
+			ops:       []op{{"set", "a", 0}, {"set", "b", 0}, {"get", "a", 0}, {"set", "c", 0}},
+			wantKeys:  []string{"a", "c"},
+			wantStats: UserCacheStats{Size: 2, Hits: 1, Evictions: 1},
+		},
+		{
+			name:      "set of a cached user does not evict",
+			size:      2,
+			ops:       []op{{"set", "a", 0}, {"set", "b", 0}, {"set", "a", 0}},
+			wantKeys:  []string{"a", "b"},
+			wantStats: UserCacheStats{Size: 2},
+		},
+		{
+			name:      "expired entry is dropped on get",
+			size:      2,
+			ops:       []op{{"set", "a", 0}, {"get", "a", time.Minute}},
+			wantStats: UserCacheStats{Misses: 1, Expirations: 1},
+		},
+		{
+			name:      "entry is served until the TTL",
+			size:      2,
+			ops:       []op{{"set", "a", 0}, {"get", "a", time.Minute - time.Second}},
+			wantKeys:  []string{"a"},
+			wantStats: UserCacheStats{Size: 1, Hits: 1},
+		},
+		{
+			name:      "invalidate",
+			size:      2,
+			ops:       []op{{"set", "a", 0}, {"invalidate", "a", 0}, {"get", "a", 0}},
+			wantStats: UserCacheStats{Misses: 1, Invalidations: 1},
+		},
+		{
+			name:      "disabled cache keeps nothing",
+			size:      0,
+			ops:       []op{{"set", "a", 0}, {"get", "a", 0}},
+			wantStats: UserCacheStats{Misses: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newUserCache(UserCacheConfig{Size: tt.size, TTL: time.Minute})
+			for _, o := range tt.ops {
+				switch o.kind {
+				case "set":
+					c.set(&larkbot.UserInfo{OpenID: o.openID}, now.Add(o.after))
+				case "get":
+					c.get(o.openID, now.Add(o.after))
+				case "invalidate":
+					c.invalidate(o.openID)
+				}
+			}
+			if len(c.entries) != len(tt.wantKeys) {
+				t.Errorf("entries = %d, want %v", len(c.entries), tt.wantKeys)
+			}
+			for _, key := range tt.wantKeys {
+				if _, ok := c.entries[key]; !ok {
+					t.Errorf("%s was evicted", key)
+				}
+			}
+			got := c.stats()
+			got.Capacity, got.TTLSeconds, got.HitRate = 0, 0, 0
+			if got != tt.wantStats {
+				t.Errorf("stats = %+v, want %+v", got, tt.wantStats)
+			}
+		})
+	}
+}
+
+func TestUserCacheLookup(t *testing.T) {
+	tests := []struct {
+		name string
+		// during runs while the lookup is in flight
+		during     func(c *userCache)
+		err        error
+		wantCached bool
+	}{
+		{"result is cached", nil, nil, true},
+		{"errors are not cached", nil, errors.New("boom"), false},
+		{"invalidated while running", func(c *userCache) { c.invalidate("a") }, nil, false},
+		{"cleared while running", func(c *userCache) { c.clear() }, nil, false},
+		{"other user invalidated", func(c *userCache) { c.invalidate("b") }, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newUserCache(UserCacheConfig{Size: 10, TTL: time.Minute})
+			c.lookup("a", func() (*larkbot.UserInfo, error) {
+				if tt.during != nil {
+					tt.during(c)
+				}
+				return &larkbot.UserInfo{OpenID: "a"}, tt.err
+			})
+			if _, ok := c.get("a", time.Now()); ok != tt.wantCached {
+				t.Errorf("cached = %v, want %v", ok, tt.wantCached)
+			}
+		})
+	}
+}
+
+func TestUserCacheLookupShared(t *testing.T) {
+	c := newUserCache(UserCacheConfig{Size: 10, TTL: time.Minute})
+	release := make(chan struct{})
+	started := make(chan struct{})
+	calls := 0
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.lookup("a", func() (*larkbot.UserInfo, error) {
+			calls++
+			close(started)
+			<-release
+			return &larkbot.UserInfo{OpenID: "a", Name: "Alice"}, nil
+		})
+	}()
+	<-started
+	const waiters = 3
+	results := make(chan *larkbot.UserInfo, waiters)
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			info, _ := c.lookup("a", func() (*larkbot.UserInfo, error) {
+				t.Error("second lookup ran while the first was in flight")
+				return nil, nil
+			})
+			results <- info
+		}()
+	}
+	for c.stats().Shared < waiters {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(results)
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+	for info := range results {
+		if info == nil || info.Name != "Alice" {
+			t.Errorf("shared result = %+v, want Alice", info)
+		}
+	}
+}
@@ -2,54 +2,62 @@ package service
 
 import (
 	"context"
-	"sync"
+	"encoding/json"
 	"time"
 
 	"go.uber.org/zap"
 
+	"lark-robot/internal/broadcast"
 	"lark-robot/internal/larkbot"
 	"lark-robot/internal/model"
 	"lark-robot/internal/repository"
 )
 
 type UserService struct {
-	larkClient *larkbot.LarkClient
-	repo       *repository.UserRepo
-	logger     *zap.Logger
-	cache      map[string]*larkbot.UserInfo
-	cacheMu    sync.RWMutex
+	larkClient  *larkbot.LarkClient
+	repo        *repository.UserRepo
+	departments *DepartmentService
+	bus         *broadcast.Bus
+	logger      *zap.Logger
+	cache       *userCache
 }
 
-func NewUserService(larkClient *larkbot.LarkClient, repo *repository.UserRepo, logger *zap.Logger) *UserService {
+func NewUserService(larkClient *larkbot.LarkClient, repo *repository.UserRepo, departments *DepartmentService, bus *broadcast.Bus, cacheCfg UserCacheConfig, logger *zap.Logger) *UserService {
 	return &UserService{
-		larkClient: larkClient,
-		repo:       repo,
-		logger:     logger,
-		cache:      make(map[string]*larkbot.UserInfo),
+		larkClient:  larkClient,
+		repo:        repo,
+		departments: departments,
+		bus:         bus,
+		logger:      logger,
+		cache:       newUserCache(cacheCfg),
 	}
 }
 
 // GetUserInfo resolves user info with three-level lookup:
 // in-memory cache -> database -> Lark API.
+// Concurrent lookups of the same uncached user share one database read and
+// API call.
 func (s *UserService) GetUserInfo(ctx context.Context, openID string) (*larkbot.UserInfo, error) {
 	if openID == "" {
 		return &larkbot.UserInfo{OpenID: "", Name: "未知"}, nil
 	}
 
 	// 1. In-memory cache
-	s.cacheMu.RLock()
-	if info, ok := s.cache[openID]; ok {
-		s.cacheMu.RUnlock()
+	if info, ok := s.cache.get(openID, time.Now()); ok {
 		return info, nil
 	}
-	s.cacheMu.RUnlock()
+	return s.cache.lookup(openID, func() (*larkbot.UserInfo, error) {
+		return s.loadUserInfo(ctx, openID)
+	})
+}
 
+// loadUserInfo reads a user from the database, or from the Lark API if it is
+// not known yet.
+func (s *UserService) loadUserInfo(ctx context.Context, openID string) (*larkbot.UserInfo, error) {
 	// 2. Database
 	dbUser, err := s.repo.GetByOpenID(openID)
 	if err == nil && dbUser.Name != "" {
-		info := userToInfo(dbUser)
-		s.setCache(info)
-		return info, nil
+		return userToInfo(dbUser), nil
 	}
 
 	// 3. Lark API
@@ -62,11 +70,12 @@ func (s *UserService) GetUserInfo(ctx context.Context, openID string) (*larkbot.
 
 	// Persist to database
 	now := time.Now()
+	s.resolveDepartments(ctx, info)
 	if upsertErr := s.repo.Upsert(infoToUser(info, now)); upsertErr != nil {
 		s.logger.Warn("failed to upsert user", zap.Error(upsertErr))
+	} else {
+		s.departments.SetUserDepartments(openID, info.DepartmentIDs)
 	}
-
-	s.setCache(info)
 	return info, nil
 }
 
@@ -95,9 +104,9 @@ func (s *UserService) ListUsers(q repository.UserQuery) ([]model.User, int64, er
 	return s.repo.List(q)
 }
 
-// GetUser returns a user by open_id.
+// GetUser returns a user by open_id, including users who left.
 func (s *UserService) GetUser(openID string) (*model.User, error) {
-	return s.repo.GetByOpenID(openID)
+	return s.repo.GetIncludingLeft(openID)
 }
 
 // UserCount returns the total number of users.
@@ -113,7 +122,13 @@ func (s *UserService) SyncUser(ctx context.Context, openID string) (*model.User,
 
 // SyncUserForce always syncs, ignoring the cooldown.
 func (s *UserService) SyncUserForce(ctx context.Context, openID string) (*model.User, error) {
-	return s.syncUser(ctx, openID, true)
+	user, err := s.syncUser(ctx, openID, true)
+	event := broadcast.UserSyncEvent{OpenID: openID, Total: 1, Synced: 1}
+	if err != nil {
+		event.Synced, event.Failed = 0, 1
+	}
+	s.bus.Publish(broadcast.TopicUserSync, "", event)
+	return user, err
 }
 
 func (s *UserService) syncUser(ctx context.Context, openID string, force bool) (*model.User, error) {
@@ -124,6 +139,9 @@ func (s *UserService) syncUser(ctx context.Context, openID string, force bool) (
 				return existing, nil
 			}
 		}
+	} else {
+		// Drop the cached info first, so a failed sync does not leave it in place
+		s.cache.invalidate(openID)
 	}
 
 	info, err := s.larkClient.GetUserInfo(ctx, openID)
@@ -132,13 +150,54 @@ func (s *UserService) syncUser(ctx context.Context, openID string, force bool) (
 	}
 
 	now := time.Now()
+	s.resolveDepartments(ctx, info)
 	user := infoToUser(info, now)
 	if err := s.repo.Upsert(user); err != nil {
 		return nil, err
 	}
-	s.setCache(info)
+	if info.Resigned {
+		if _, err := s.MarkLeft(openID, now); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := s.repo.Restore(openID); err != nil {
+			return nil, err
+		}
+		s.departments.SetUserDepartments(openID, info.DepartmentIDs)
+		s.cache.set(info, now)
+	}
 
-	return s.repo.GetByOpenID(openID)
+	return s.repo.GetIncludingLeft(openID)
+}
+
+// MarkLeft records that a user left the organization: the row is
+// soft-deleted, department memberships are dropped and the cached info is
+// evicted. It reports false if the user is unknown or already marked.
+func (s *UserService) MarkLeft(openID string, at time.Time) (bool, error) {
+	s.cache.invalidate(openID)
+	marked, err := s.repo.MarkLeft(openID, at)
+	if err != nil || !marked {
+		return marked, err
+	}
+	s.departments.SetUserDepartments(openID, "")
+	return true, nil
+}
+
+// importProfile saves a user found by the directory import. Unlike a sync it
+// keeps first_seen and last_seen, and new users are not marked as seen.
+func (s *UserService) importProfile(ctx context.Context, info *larkbot.UserInfo) error {
+	s.resolveDepartments(ctx, info)
+	user := infoToUser(info, time.Now())
+	user.LastSeen = time.Time{}
+	if err := s.repo.UpsertProfile(user); err != nil {
+		return err
+	}
+	if _, err := s.repo.Restore(info.OpenID); err != nil {
+		return err
+	}
+	s.departments.SetUserDepartments(info.OpenID, info.DepartmentIDs)
+	s.cache.invalidate(info.OpenID)
+	return nil
 }
 
 // SyncResult holds the result of a batch user sync.
@@ -150,8 +209,9 @@ type SyncResult struct {
 	FailedIDs []string `json:"failed_ids,omitempty"`
 }
 
-// SyncAllUsers re-fetches info from Lark API for all known users.
-func (s *UserService) SyncAllUsers(ctx context.Context) (*SyncResult, error) {
+// SyncAllUsers re-fetches info from Lark API for all known users, reporting
+// to p when run as a job.
+func (s *UserService) SyncAllUsers(ctx context.Context, p *JobProgress) (*SyncResult, error) {
 	// Collect all open_ids by paginating through the database
 	var allOpenIDs []string
 	page := 1
@@ -173,25 +233,25 @@ func (s *UserService) SyncAllUsers(ctx context.Context) (*SyncResult, error) {
 		page++
 	}
 
-	return s.syncByIDs(allOpenIDs, false)
+	return s.syncByIDs(ctx, allOpenIDs, false, p)
 }
 
 // SyncByIDs syncs a specific list of users by their open_ids.
 // If force is true, it bypasses the 1-hour cooldown.
-func (s *UserService) SyncByIDs(ctx context.Context, openIDs []string, force bool) (*SyncResult, error) {
-	return s.syncByIDs(openIDs, force)
+func (s *UserService) SyncByIDs(ctx context.Context, openIDs []string, force bool, p *JobProgress) (*SyncResult, error) {
+	return s.syncByIDs(ctx, openIDs, force, p)
 }
 
-// syncByIDs is the shared implementation for batch syncing.
-func (s *UserService) syncByIDs(openIDs []string, force bool) (*SyncResult, error) {
+// syncByIDs is the shared implementation for batch syncing. Once ctx is
+// cancelled the remaining users are left alone, and the partial result is
+// returned with the context's error.
+func (s *UserService) syncByIDs(ctx context.Context, openIDs []string, force bool, p *JobProgress) (*SyncResult, error) {
 	result := &SyncResult{Total: len(openIDs)}
+	p.SetTotal(len(openIDs))
 	if len(openIDs) == 0 {
 		return result, nil
 	}
 
-	// Use a detached context so sync continues even if the HTTP request times out
-	syncCtx := context.Background()
-
 	// Sync concurrently with limited workers
 	workers := 5
 	if len(openIDs) < workers {
@@ -199,9 +259,10 @@ func (s *UserService) syncByIDs(openIDs []string, force bool) (*SyncResult, erro
 	}
 
 	type syncRes struct {
-		ok  bool
-		err error
-		id  string
+		ok      bool
+		err     error
+		id      string
+		skipped bool // not attempted because the sync was cancelled
 	}
 
 	ch := make(chan string, len(openIDs))
@@ -212,14 +273,24 @@ func (s *UserService) syncByIDs(openIDs []string, force bool) (*SyncResult, erro
 			for openID := range ch {
 				var r syncRes
 				r.id = openID
-				// Retry once on failure (handles transient rate limiting)
-				_, err := s.syncUser(syncCtx, openID, force)
-				if err != nil {
-					time.Sleep(500 * time.Millisecond)
-					_, err = s.syncUser(syncCtx, openID, force)
+				if ctx.Err() != nil {
+					r.skipped = true
+					results <- r
+					continue
+				}
+				// Rate-limited lookups are retried with backoff by the Lark client;
+				// retry once more on any transient error, e.g. a 5xx
+				_, err := s.syncUser(ctx, openID, force)
+				if larkbot.IsTransient(err) {
+					select {
+					case <-ctx.Done():
+					case <-time.After(500 * time.Millisecond):
+						_, err = s.syncUser(ctx, openID, force)
+					}
 				}
 				r.ok = err == nil
 				r.err = err
+				r.skipped = err != nil && ctx.Err() != nil
 				results <- r
 			}
 		}()
@@ -232,6 +303,10 @@ func (s *UserService) syncByIDs(openIDs []string, force bool) (*SyncResult, erro
 
 	for i := 0; i < len(openIDs); i++ {
 		r := <-results
+		if r.skipped {
+			continue
+		}
+		p.Add(1)
 		if r.ok {
 			result.Synced++
 		} else {
@@ -245,13 +320,39 @@ func (s *UserService) syncByIDs(openIDs []string, force bool) (*SyncResult, erro
 		zap.Int("synced", result.Synced),
 		zap.Int("failed", result.Failed),
 		zap.Int("total", result.Total))
-	return result, nil
+	s.bus.Publish(broadcast.TopicUserSync, "", broadcast.UserSyncEvent{
+		Total:  result.Total,
+		Synced: result.Synced,
+		Failed: result.Failed,
+	})
+	return result, ctx.Err()
+}
+
+// resolveDepartments fills in the department names of info from the local directory.
+func (s *UserService) resolveDepartments(ctx context.Context, info *larkbot.UserInfo) {
+	var ids []string
+	if info.DepartmentIDs == "" || json.Unmarshal([]byte(info.DepartmentIDs), &ids) != nil || len(ids) == 0 {
+		return
+	}
+	if b, err := json.Marshal(s.departments.Names(ctx, ids)); err == nil {
+		info.DepartmentNames = string(b)
+	}
+}
+
+// InvalidateUser drops the cached info of a user, so the next lookup reads
+// it from the database or the Lark API again.
+func (s *UserService) InvalidateUser(openID string) {
+	s.cache.invalidate(openID)
+}
+
+// InvalidateAll drops all cached user info.
+func (s *UserService) InvalidateAll() {
+	s.cache.clear()
 }
 
-func (s *UserService) setCache(info *larkbot.UserInfo) {
-	s.cacheMu.Lock()
-	s.cache[info.OpenID] = info
-	s.cacheMu.Unlock()
+// CacheStats returns the size and hit/miss counters of the user info cache.
+func (s *UserService) CacheStats() UserCacheStats {
+	return s.cache.stats()
 }
 
 func userToInfo(u *model.User) *larkbot.UserInfo {
@@ -274,6 +375,7 @@ func userToInfo(u *model.User) *larkbot.UserInfo {
 		DepartmentNames: u.DepartmentNames,
 		CustomAttrs:     u.CustomAttrs,
 		JoinTime:        u.JoinTime,
+		Frozen:          u.Frozen,
 	}
 }
 
@@ -297,6 +399,7 @@ func infoToUser(info *larkbot.UserInfo, now time.Time) *model.User {
 		DepartmentNames: info.DepartmentNames,
 		CustomAttrs:     info.CustomAttrs,
 		JoinTime:        info.JoinTime,
+		Frozen:          info.Frozen,
 		FirstSeen:       now,
 		LastSeen:        now,
 	}
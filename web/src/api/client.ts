@@ -14,12 +14,51 @@ api.interceptors.request.use((config) => {
   return config
 })
 
-// Response interceptor: redirect to login on 401
+const clearSession = () => {
+  localStorage.removeItem('token')
+  localStorage.removeItem('refresh_token')
+  localStorage.removeItem('user')
+  streamToken = ''
+  if (streamTimer) clearTimeout(streamTimer)
+}
+
+// Saves the tokens returned by login and refresh
+export const saveTokens = (data: { token: string; refresh_token?: string }) => {
+  localStorage.setItem('token', data.token)
+  if (data.refresh_token) localStorage.setItem('refresh_token', data.refresh_token)
+}
+
+// Access tokens are short-lived: on 401, exchange the refresh token once
+// (shared by concurrent requests) and retry; if that fails, go to login.
+let refreshing: Promise<boolean> | null = null
+const refreshTokens = () => {
+  if (!refreshing) {
+    const refreshToken = localStorage.getItem('refresh_token')
+    refreshing = (refreshToken
+      ? axios.post('/api/auth/refresh', { refresh_token: refreshToken }).then((res) => {
+          saveTokens(res.data)
+          localStorage.setItem('user', JSON.stringify(res.data.user))
+          return true
+        }, () => false)
+      : Promise.resolve(false)
+    ).finally(() => {
+      refreshing = null
+    })
+  }
+  return refreshing
+}
+
 api.interceptors.response.use(
   (response) => response,
-  (error) => {
+  async (error) => {
+    const config = error.config
+    if (error.response?.status === 401 && config && !config._retried && (await refreshTokens())) {
+      config._retried = true
+      config.headers.Authorization = `Bearer ${localStorage.getItem('token')}`
+      return api(config)
+    }
     if (error.response?.status === 401) {
-      localStorage.removeItem('token')
+      clearSession()
       if (window.location.pathname !== '/login') {
         window.location.href = '/login'
       }
@@ -30,11 +69,83 @@ api.interceptors.response.use(
 
 // Auth helper
 export const getToken = () => localStorage.getItem('token') || ''
-export const logout = () => {
-  localStorage.removeItem('token')
+export const logout = async () => {
+  try {
+    await api.post('/logout')
+  } catch {
+    // the session is gone either way
+  }
+  clearSession()
   window.location.href = '/login'
 }
 
+// Stream tokens authenticate URLs that cannot carry a header: the SSE stream
+// and image links. They expire after a couple of minutes, so one is kept
+// cached and renewed in the background.
+let streamToken = ''
+let streamTimer: ReturnType<typeof setTimeout> | null = null
+export const getStreamToken = () => streamToken
+export const fetchStreamToken = async () => {
+  const res = await api.post('/auth/stream-token')
+  streamToken = res.data.token
+  if (streamTimer) clearTimeout(streamTimer)
+  streamTimer = setTimeout(() => {
+    fetchStreamToken().catch(() => {})
+  }, res.data.expires_in * 750)
+  return streamToken
+}
+
+export type AdminRole = 'viewer' | 'operator' | 'admin'
+
+export interface AdminUser {
+  id: number
+  username: string
+  display_name: string
+  role: AdminRole
+  lark_open_id: string
+  source: 'local' | 'lark'
+  chat_scopes: string // JSON array of chat_ids; empty = all chats
+  enabled: boolean
+  totp_enabled: boolean
+  last_login_at: string | null
+  created_at: string
+}
+
+// The logged-in user, saved at login
+export const getCurrentUser = (): AdminUser | null => {
+  try {
+    return JSON.parse(localStorage.getItem('user') || 'null')
+  } catch {
+    return null
+  }
+}
+const roleLevel: Record<AdminRole, number> = { viewer: 1, operator: 2, admin: 3 }
+export const hasRole = (role: AdminRole) => {
+  const user = getCurrentUser()
+  return !!user && roleLevel[user.role] >= roleLevel[role]
+}
+export const getMe = () => api.get('/me')
+
+// Two-step verification (TOTP) of the current user
+export const setupTOTP = () => api.post('/me/totp/setup')
+export const enableTOTP = (code: string) => api.post('/me/totp', { code })
+export const disableTOTP = (code: string) => api.delete('/me/totp', { data: { code } })
+
+// Login sessions
+export interface LoginSession {
+  id: string
+  admin_user_id: number
+  username: string
+  method: 'password' | 'lark'
+  ip: string
+  user_agent: string
+  last_used_at: string
+  expires_at: string
+  created_at: string
+}
+export const getSessions = (params?: { all?: 1 }) => api.get('/sessions', { params })
+export const revokeSession = (id: string) => api.delete(`/sessions/${id}`)
+
 // Bot info
 export const getBotInfo = () => api.get('/bot/info')
 
@@ -53,6 +164,7 @@ export const replyMessage = (data: {
   message_id: string
   msg_type: string
   content: string
+  reply_in_thread?: boolean
 }) => api.post('/messages/reply', data)
 
 export const getMessageLogs = (params: {
@@ -62,19 +174,96 @@ export const getMessageLogs = (params: {
   chat_type?: string
   direction?: string
   source?: string
+  root_id?: string
+  thread_id?: string
+  view?: 'thread'
 }) => api.get('/messages/logs', { params })
 
+export const getThreads = (params: { chat_id: string; page?: number; page_size?: number }) =>
+  api.get('/messages/threads', { params })
+
 export const deleteMessage = (messageId: string) => api.delete(`/messages/${messageId}`)
 
+export const updateMessage = (messageId: string, data: { msg_type: string; content: string }) =>
+  api.put(`/messages/${messageId}`, data)
+
 export const getConversations = () => api.get('/messages/conversations')
 
 // Chats
-export const getChats = (params?: { page?: number; page_size?: number }) => api.get('/chats', { params })
-export const syncChats = () => api.post('/chats/sync')
+export const getChats = (params?: {
+  page?: number
+  page_size?: number
+  status?: '' | 'left' | 'all' // groups the bot is in by default
+  tag?: string
+  team?: string
+  environment?: string
+}) => api.get('/chats', { params })
+export interface ChatSyncResult {
+  total: number
+  synced: number
+}
+// Runs as a background job; follow it with waitForJob
+export const syncChats = () => api.post<{ data: Job }>('/chats/sync')
 export const leaveChat = (chatId: string) => api.post(`/chats/${chatId}/leave`)
 export const getChatMembers = (chatId: string, params?: { page_token?: string; page_size?: number }) =>
   api.get(`/chats/${chatId}/members`, { params })
 
+// Group metadata, managed locally and kept across syncs
+export interface GroupMeta {
+  tags: string[]
+  team: string
+  environment: string
+  notes: string
+  attributes: Record<string, string>
+}
+export const updateChatMeta = (chatId: string, data: GroupMeta) => api.put(`/chats/${chatId}/meta`, data)
+export interface GroupHistory {
+  id: number
+  chat_id: string
+  name: string
+  action: 'joined' | 'left' | 'removed'
+  actor: string
+  created_at: string
+}
+export const getChatHistory = (chatId: string) => api.get<{ data: GroupHistory[] }>(`/chats/${chatId}/history`)
+export interface GroupLabels {
+  tags: string[]
+  teams: string[]
+  environments: string[]
+  attribute_keys: string[]
+}
+export const getChatLabels = () => api.get<{ data: GroupLabels }>('/chats/labels')
+
+// Picks groups by metadata; every field that is set must match
+export interface GroupSelector {
+  tags?: string[]
+  team?: string
+  environment?: string
+  attributes?: Record<string, string>
+}
+export const matchChats = (selector: GroupSelector) =>
+  api.get('/chats/match', { params: { selector: JSON.stringify(selector) } })
+// parseSelector decodes a stored group_selector; empty means none
+export const parseSelector = (raw: string): GroupSelector | null => {
+  if (!raw) return null
+  try {
+    return JSON.parse(raw) as GroupSelector
+  } catch {
+    return null
+  }
+}
+// describeSelector renders a stored group_selector for tables
+export const describeSelector = (raw: string): string => {
+  const sel = parseSelector(raw)
+  if (!sel) return ''
+  const parts: string[] = []
+  if (sel.tags?.length) parts.push(`标签 ${sel.tags.join('+')}`)
+  if (sel.team) parts.push(`团队 ${sel.team}`)
+  if (sel.environment) parts.push(`环境 ${sel.environment}`)
+  for (const [k, v] of Object.entries(sel.attributes || {})) parts.push(`${k}=${v}`)
+  return parts.join('，')
+}
+
 // Auto-reply rules
 export const getAutoReplyRules = (params?: { page?: number; page_size?: number }) => api.get('/auto-reply-rules', { params })
 export const createAutoReplyRule = (data: {
@@ -83,6 +272,9 @@ export const createAutoReplyRule = (data: {
   match_mode?: string
   trigger_mode?: string
   chat_id?: string
+  group_selector?: GroupSelector | null
+  mention_user?: string
+  reply_in_thread?: boolean
   enabled?: boolean
 }) => api.post('/auto-reply-rules', data)
 export const updateAutoReplyRule = (id: number, data: {
@@ -91,24 +283,121 @@ export const updateAutoReplyRule = (id: number, data: {
   match_mode?: string
   trigger_mode?: string
   chat_id?: string
+  group_selector?: GroupSelector | null
+  mention_user?: string
+  reply_in_thread?: boolean
   enabled?: boolean
 }) => api.put(`/auto-reply-rules/${id}`, data)
 export const deleteAutoReplyRule = (id: number) => api.delete(`/auto-reply-rules/${id}`)
 export const toggleAutoReplyRule = (id: number) => api.post(`/auto-reply-rules/${id}/toggle`)
 
 // Users
-export const getUsers = (params?: { page?: number; page_size?: number; keyword?: string; sort_by?: string; sort_dir?: string }) =>
-  api.get('/users', { params })
+export const getUsers = (params?: {
+  page?: number
+  page_size?: number
+  keyword?: string
+  sort_by?: string
+  sort_dir?: string
+  department_id?: string
+  recursive?: 1
+  status?: 'left' | 'all'
+}) => api.get('/users', { params })
+export interface UserSyncResult {
+  total: number
+  synced: number
+  skipped: number
+  failed: number
+  failed_ids?: string[]
+}
+// Runs as a background job; follow it with waitForJob
 export const syncUsers = (openIds?: string[]) =>
-  api.post('/users/sync', openIds ? { open_ids: openIds } : {})
+  api.post<{ data: Job }>('/users/sync', openIds ? { open_ids: openIds } : {})
 export const getUserByOpenID = (openId: string) => api.get(`/users/${openId}`)
 
+// Directory import: every user of the Lark directory, walked department by department
+export interface DirectoryImport {
+  id: number
+  status: 'running' | 'paused' | 'completed' | 'failed'
+  department_total: number
+  department_done: number
+  imported: number
+  skipped: number
+  failed: number
+  failed_ids: string // JSON array of open_ids
+  failed_departments: string // JSON array of open_department_ids
+  error: string
+  started_by: string
+  started_at: string
+  finished_at: string | null
+}
+
+export const getDirectoryImport = () => api.get<{ data: DirectoryImport | null }>('/users/import')
+export const startDirectoryImport = () => api.post<{ data: DirectoryImport }>('/users/import')
+export const pauseDirectoryImport = (id: number) => api.post<{ data: DirectoryImport }>(`/users/import/${id}/pause`)
+export const resumeDirectoryImport = (id: number) => api.post<{ data: DirectoryImport }>(`/users/import/${id}/resume`)
+
+// User activity, rolled up from message logs
+export interface UserActivityReport {
+  open_id: string
+  since: string
+  days: number
+  messages: number
+  active_days: number
+  daily: { day: string; messages: number }[]
+  hours: number[]
+  busiest_hour: number
+  chats: { chat_id: string; name: string; messages: number }[]
+  responses: number
+  avg_response_seconds: number
+  as_of: string
+}
+export interface LeaderboardEntry {
+  open_id: string
+  name: string
+  avatar: string
+  messages: number
+  chats: number
+  active_days: number
+}
+export const getUserActivity = (openId: string, days = 30) =>
+  api.get<{ data: UserActivityReport }>(`/users/${openId}/activity`, { params: { days } })
+export const getUserLeaderboard = (params?: {
+  days?: number
+  by?: 'messages' | 'chats' | 'active_days'
+  limit?: number
+}) => api.get<{ data: LeaderboardEntry[]; as_of: string }>('/users/leaderboard', { params })
+
+// Departments (org chart)
+export interface Department {
+  id: number
+  open_department_id: string
+  department_id: string
+  parent_id: string
+  name: string
+  leader_user_id: string
+  chat_id: string
+  member_count: number
+  sort_order: number
+  path: string
+  synced_at: string
+}
+export interface DepartmentNode extends Department {
+  user_count: number
+  children: DepartmentNode[]
+}
+export const getDepartmentTree = () => api.get('/departments')
+export const getDepartment = (id: string) => api.get(`/departments/${id}`)
+export const getDepartmentUsers = (id: string, params?: { page?: number; page_size?: number; recursive?: 1 }) =>
+  api.get(`/departments/${id}/users`, { params })
+export const syncDepartments = () => api.post('/departments/sync', {}, { timeout: 120000 })
+
 // Scheduled tasks
 export const getScheduledTasks = (params?: { page?: number; page_size?: number }) => api.get('/scheduled-tasks', { params })
 export const createScheduledTask = (data: {
   name: string
   cron_expr: string
-  chat_id: string
+  chat_id?: string
+  group_selector?: GroupSelector | null // instead of chat_id
   msg_type?: string
   content: string
   enabled?: boolean
@@ -116,7 +405,8 @@ export const createScheduledTask = (data: {
 export const updateScheduledTask = (id: number, data: {
   name: string
   cron_expr: string
-  chat_id: string
+  chat_id?: string
+  group_selector?: GroupSelector | null // instead of chat_id
   msg_type?: string
   content: string
   enabled?: boolean
@@ -125,6 +415,125 @@ export const deleteScheduledTask = (id: number) => api.delete(`/scheduled-tasks/
 export const toggleScheduledTask = (id: number) => api.post(`/scheduled-tasks/${id}/toggle`)
 export const runScheduledTask = (id: number) => api.post(`/scheduled-tasks/${id}/run`)
 
+// Admin users
+export interface AdminUserForm {
+  username?: string
+  display_name: string
+  password?: string
+  role: AdminRole
+  lark_open_id: string
+  chat_scopes: string[]
+  enabled: boolean
+}
+export const getAdminUsers = () => api.get('/admin-users')
+export const createAdminUser = (data: AdminUserForm) => api.post('/admin-users', data)
+export const updateAdminUser = (id: number, data: AdminUserForm) => api.put(`/admin-users/${id}`, data)
+export const deleteAdminUser = (id: number) => api.delete(`/admin-users/${id}`)
+export const resetAdminUserTOTP = (id: number) => api.post(`/admin-users/${id}/totp/reset`)
+
+// API keys
+export interface APIKey {
+  id: number
+  name: string
+  prefix: string
+  role: 'viewer' | 'operator'
+  endpoints: string // JSON array of "METHOD /api/path" patterns
+  chat_scopes: string // JSON array of chat_ids; empty = all chats
+  created_by: string
+  last_used_at: string | null
+  expires_at: string | null
+  revoked_at: string | null
+  created_at: string
+}
+export interface APIKeyForm {
+  name: string
+  role: 'viewer' | 'operator'
+  endpoints: string[]
+  chat_scopes: string[]
+  expires_at?: string | null
+}
+export const getAPIKeys = () => api.get('/api-keys')
+export const createAPIKey = (data: APIKeyForm) => api.post('/api-keys', data)
+export const revokeAPIKey = (id: number) => api.delete(`/api-keys/${id}`)
+
+// Audit log
+export interface AuditEntry {
+  id: number
+  actor: string
+  actor_type: 'user' | 'api_key'
+  action: string
+  method: string
+  route: string
+  target_type: string
+  target_id: string
+  status: number
+  before: string
+  after: string
+  diff: string // JSON object: {"field": {"from": x, "to": y}}
+  ip: string
+  user_agent: string
+  created_at: string
+}
+export interface AuditFilters {
+  actor?: string
+  action?: string
+  target_type?: string
+  target_id?: string
+  from?: string
+  to?: string
+}
+export const getAuditEntries = (params: AuditFilters & { page?: number; page_size?: number }) =>
+  api.get('/audit', { params })
+export const exportAuditEntries = (params: AuditFilters & { format: 'csv' | 'jsonl' }) =>
+  api.get('/audit/export', { params, responseType: 'blob', timeout: 120000 })
+// Writes the export to a file in a background job, downloaded with downloadJobFile
+export const startAuditExport = (params: AuditFilters & { format: 'csv' | 'jsonl' }) =>
+  api.post<{ data: Job }>('/audit/export', null, { params })
+
+// Background jobs: syncs, exports and campaign runs
+export interface Job {
+  id: number
+  kind: 'user_sync' | 'chat_sync' | 'audit_export' | 'campaign_send' | 'campaign_recall' | 'directory_import'
+  status: 'running' | 'succeeded' | 'failed' | 'cancelled'
+  params: string // JSON
+  total: number // 0 while unknown
+  done: number
+  message: string
+  result: string // JSON, set when the job ends
+  error: string
+  created_by: string
+  started_at: string
+  finished_at: string | null
+}
+
+export const getJobs = (params?: { page?: number; page_size?: number; kind?: string; status?: string }) =>
+  api.get<{ data: Job[]; total: number }>('/jobs', { params })
+export const getJob = (id: number) => api.get<{ data: Job }>(`/jobs/${id}`)
+export const cancelJob = (id: number) => api.post<{ data: Job }>(`/jobs/${id}/cancel`)
+export const downloadJobFile = (id: number) =>
+  api.get(`/jobs/${id}/file`, { responseType: 'blob', timeout: 120000 })
+
+// waitForJob polls a job until it ends, calling onProgress on every poll.
+export const waitForJob = async (id: number, onProgress?: (job: Job) => void, interval = 1000): Promise<Job> => {
+  for (;;) {
+    const res = await getJob(id)
+    const job = res.data.data
+    onProgress?.(job)
+    if (job.status !== 'running') return job
+    await new Promise((resolve) => setTimeout(resolve, interval))
+  }
+}
+
+// jobResult parses the JSON result of a finished job.
+export const jobResult = <T>(job: Job): T | null => {
+  if (!job.result) return null
+  try {
+    return JSON.parse(job.result) as T
+  } catch {
+    return null
+  }
+}
+
 // Upload
 export const uploadImage = (file: File) => {
   const formData = new FormData()
@@ -1,4 +1,5 @@
 import { createRouter, createWebHistory } from 'vue-router'
+import { getStreamToken, fetchStreamToken } from '../api/client'
 
 const router = createRouter({
   history: createWebHistory(),
@@ -48,6 +49,31 @@ const router = createRouter({
       name: 'MessageLogs',
       component: () => import('../views/MessageLogs.vue'),
     },
+    {
+      path: '/admin-users',
+      name: 'AdminUsers',
+      component: () => import('../views/AdminUsers.vue'),
+    },
+    {
+      path: '/api-keys',
+      name: 'APIKeys',
+      component: () => import('../views/APIKeys.vue'),
+    },
+    {
+      path: '/audit',
+      name: 'AuditLogs',
+      component: () => import('../views/AuditLogs.vue'),
+    },
+    {
+      path: '/jobs',
+      name: 'Jobs',
+      component: () => import('../views/Jobs.vue'),
+    },
+    {
+      path: '/sessions',
+      name: 'Sessions',
+      component: () => import('../views/Sessions.vue'),
+    },
     {
       path: '/chat',
       name: 'Chat',
@@ -61,10 +87,16 @@ const router = createRouter({
   ],
 })
 
-// Navigation guard: redirect to login if not authenticated
-router.beforeEach((to, _from, next) => {
+// Navigation guard: redirect to login if not authenticated.
+// Pages render image links with the stream token, so make sure one is cached first.
+router.beforeEach(async (to, _from, next) => {
   const token = localStorage.getItem('token')
-  if (to.meta.public || token) {
+  if (to.meta.public) {
+    next()
+  } else if (token) {
+    if (!getStreamToken()) {
+      await fetchStreamToken().catch(() => {})
+    }
     next()
   } else {
     next('/login')